@@ -0,0 +1,136 @@
+package logpeck
+
+import "encoding/json"
+
+// AggregationResult is the output of an aggregator as senders read it.
+// Series maps series names, a measurement optionally followed by
+// ",tag=value" pairs, to the values of their aggregations at Timestamp,
+// epoch seconds. Tags are the other fields added to the output, such as the
+// agent [tags], they apply to all series
+type AggregationResult struct {
+	Timestamp int64
+	Series    map[string]map[string]float64
+	Tags      map[string]interface{}
+}
+
+func NewAggregationResult(timestamp int64) *AggregationResult {
+	return &AggregationResult{
+		Timestamp: timestamp,
+		Series:    make(map[string]map[string]float64),
+		Tags:      make(map[string]interface{}),
+	}
+}
+
+// Fields returns the result as the fields passed through the task to its
+// sender, series values are map[string]float64 and "timestamp" an int64
+func (r *AggregationResult) Fields() map[string]interface{} {
+	fields := make(map[string]interface{}, len(r.Series)+len(r.Tags)+1)
+	for k, v := range r.Tags {
+		fields[k] = v
+	}
+	for series, values := range r.Series {
+		fields[series] = values
+	}
+	fields["timestamp"] = r.Timestamp
+	return fields
+}
+
+// ParseAggregationResult reads an aggregator output from fields. It accepts
+// any integer type for "timestamp" and any number type for aggregation
+// values, such as results decoded from json. It returns false if fields is
+// not an aggregator output, without integer "timestamp" or without series
+func ParseAggregationResult(fields map[string]interface{}) (*AggregationResult, bool) {
+	timestamp, ok := toInt64(fields["timestamp"])
+	if !ok {
+		return nil, false
+	}
+	r := NewAggregationResult(timestamp)
+	for k, v := range fields {
+		if k == "timestamp" {
+			continue
+		}
+		if values, ok := toAggregationValues(v); ok {
+			r.Series[k] = values
+		} else {
+			r.Tags[k] = v
+		}
+	}
+	if len(r.Series) == 0 {
+		return nil, false
+	}
+	return r, true
+}
+
+// IsAggregationResult reports whether fields is an aggregator output
+func IsAggregationResult(fields map[string]interface{}) bool {
+	_, ok := ParseAggregationResult(fields)
+	return ok
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// toAggregationValues converts the aggregation values of a series
+func toAggregationValues(v interface{}) (map[string]float64, bool) {
+	switch m := v.(type) {
+	case map[string]float64:
+		return m, true
+	case map[string]int64:
+		values := make(map[string]float64, len(m))
+		for k, n := range m {
+			values[k] = float64(n)
+		}
+		return values, true
+	case map[string]int:
+		values := make(map[string]float64, len(m))
+		for k, n := range m {
+			values[k] = float64(n)
+		}
+		return values, true
+	case map[string]interface{}:
+		if len(m) == 0 {
+			return nil, false
+		}
+		values := make(map[string]float64, len(m))
+		for k, n := range m {
+			f, ok := toFloat64(n)
+			if !ok {
+				return nil, false
+			}
+			values[k] = f
+		}
+		return values, true
+	}
+	return nil, false
+}
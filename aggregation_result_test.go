@@ -0,0 +1,69 @@
+package logpeck
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseAggregationResult(*testing.T) {
+	for _, fields := range []map[string]interface{}{
+		{"api_cost": map[string]float64{"cnt": 1}},
+		{"timestamp": 1500000000.0, "api_cost": map[string]float64{"cnt": 1}},
+		{"timestamp": int64(1500000000)},
+		{"timestamp": int64(1500000000), "req": map[string]interface{}{"path": "/"}},
+	} {
+		if IsAggregationResult(fields) {
+			panic(fields)
+		}
+	}
+
+	result, ok := ParseAggregationResult(map[string]interface{}{
+		"timestamp": 1500000000,
+		"api_cost":  map[string]int64{"cnt": 3},
+		"api_size":  map[string]int{"max": 7},
+		"dc":        "eu",
+	})
+	if !ok || result.Timestamp != 1500000000 || result.Series["api_cost"]["cnt"] != 3 ||
+		result.Series["api_size"]["max"] != 7 || result.Tags["dc"] != "eu" {
+		panic(result)
+	}
+
+	// results decoded from json
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(`{"timestamp": 1500000000, "api_cost": {"cnt": 3, "avg": 1.5}}`))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		panic(err)
+	}
+	result, ok = ParseAggregationResult(fields)
+	if !ok || result.Series["api_cost"]["avg"] != 1.5 {
+		panic(result)
+	}
+
+	fields = result.Fields()
+	if _, ok := fields["timestamp"].(int64); !ok {
+		panic(fields)
+	}
+	if _, ok := fields["api_cost"].(map[string]float64); !ok {
+		panic(fields)
+	}
+}
+
+func TestAggregationResultSenders(*testing.T) {
+	config, _ := NewInfluxDbSenderConfig([]byte(`{"Hosts":"127.0.0.1:8086","Database":"db","Precision":"s"}`))
+	sender, _ := NewInfluxDbSender(&SenderConfig{Name: "influxdb", Config: config})
+	sender.host = "h1"
+	result, _ := ParseAggregationResult(map[string]interface{}{
+		"timestamp": int64(1500000000), "api_cost": map[string]int64{"cnt": 3}, "dc": "eu",
+	})
+	if line := sender.toInfluxdbLine(result); line != "api_cost,host=h1,dc=eu cnt=3.000 1500000000\n" {
+		panic(line)
+	}
+
+	alerter, _ := NewAlerter("test", &AlertConfig{Rules: []AlertRule{{Name: "many", Measurement: "api_cost", Aggregation: "cnt", Threshold: 2}}})
+	alerter.notify = func(*Alert) {}
+	if alerts := alerter.Evaluate(result.Fields()); len(alerts) != 1 {
+		panic(alerts)
+	}
+}
@@ -1,8 +1,13 @@
 package logpeck
 
 import (
+	"errors"
 	log "github.com/Sirupsen/logrus"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,7 +33,16 @@ var FormatTime map[string]string = map[string]string{
 type AggregatorConfig struct {
 	Enable   bool               `json:Enable`
 	Interval int64              `json:"Interval"`
+	Window   int64              `json:"Window"`
 	Options  []AggregatorOption `json:"Options"`
+
+	// Percentile is the interpolation method of percentiles, "nearest"
+	// (nearest rank, default) or "linear"
+	Percentile string `json:"Percentile"`
+
+	// Intervals runs one aggregation per interval over the same logs,
+	// series are tagged with "interval"
+	Intervals []int64 `json:"Intervals"`
 }
 
 type AggregatorOption struct {
@@ -38,12 +52,51 @@ type AggregatorOption struct {
 	Tags          []string `json:"Tags"`
 	Aggregations  []string `json:"Aggregations"`
 	Timestamp     string   `json:"Timestamp"`
+	TopKField     string   `json:"TopKField"`
+	TopK          int      `json:"TopK"`
+	Unit          string   `json:"Unit"`
+
+	TagRules map[string]TagRule `json:"TagRules"`
+}
+
+type topkBucket struct {
+	field string
+	k     int
+	ss    *SpaceSaving
+}
+
+type windowSlot struct {
+	sampleTime int64
+	buckets    map[string]map[string][]float64
 }
 
 type Aggregator struct {
 	config   AggregatorConfig
 	buckets  map[string]map[string][]float64
+	topks    map[string]*topkBucket
+	slots    []windowSlot
 	postTime int64
+
+	intervalTag string
+	tagRules    []map[string]*tagNormalizer
+
+	// dropped counts the Target values which couldn't be parsed
+	dropped int64
+}
+
+// Validate checks the parts of config which can't be used as is
+func (p *AggregatorConfig) Validate() error {
+	for _, option := range p.Options {
+		if _, err := newTagNormalizers(option.TagRules); err != nil {
+			return err
+		}
+		if option.Unit != "" {
+			if _, _, ok := lookupUnit(option.Unit); !ok {
+				return errors.New("unknown Unit " + option.Unit + " of Target " + option.Target)
+			}
+		}
+	}
+	return nil
 }
 
 func NewAggregator(config *AggregatorConfig) *Aggregator {
@@ -51,11 +104,116 @@ func NewAggregator(config *AggregatorConfig) *Aggregator {
 	aggregator := &Aggregator{
 		config:   *config,
 		buckets:  make(map[string]map[string][]float64),
+		topks:    make(map[string]*topkBucket),
 		postTime: 0,
 	}
+	for _, option := range config.Options {
+		normalizers, err := newTagNormalizers(option.TagRules)
+		if err != nil {
+			log.Errorf("[Aggregator] TagRules error, err[%s]", err)
+		}
+		aggregator.tagRules = append(aggregator.tagRules, normalizers)
+	}
 	return aggregator
 }
 
+// IsSliding reports whether each dump covers the last Window seconds
+// instead of the last Interval only
+func (p *Aggregator) IsSliding() bool {
+	return p.config.Window > p.config.Interval
+}
+
+// slide keeps the buckets of the last Window seconds and returns them merged
+func (p *Aggregator) slide() map[string]map[string][]float64 {
+	slotNum := (p.config.Window + p.config.Interval - 1) / p.config.Interval
+	p.slots = append(p.slots, windowSlot{sampleTime: p.postTime, buckets: p.buckets})
+	for len(p.slots) > 0 && p.slots[0].sampleTime <= p.postTime-slotNum {
+		p.slots = p.slots[1:]
+	}
+	merged := make(map[string]map[string][]float64)
+	for _, slot := range p.slots {
+		for bucketName, bucketTag_value := range slot.buckets {
+			if _, ok := merged[bucketName]; !ok {
+				merged[bucketName] = make(map[string][]float64)
+			}
+			for bucketTag, values := range bucketTag_value {
+				merged[bucketName][bucketTag] = append(merged[bucketName][bucketTag], values...)
+			}
+		}
+	}
+	return merged
+}
+
+// NewAggregators returns one aggregator per configured interval
+func NewAggregators(config *AggregatorConfig) []*Aggregator {
+	if len(config.Intervals) == 0 {
+		return []*Aggregator{NewAggregator(config)}
+	}
+	aggregators := []*Aggregator{}
+	for _, interval := range config.Intervals {
+		c := *config
+		c.Interval = interval
+		aggregator := NewAggregator(&c)
+		aggregator.intervalTag = ",interval=" + strconv.FormatInt(interval, 10) + "s"
+		aggregators = append(aggregators, aggregator)
+	}
+	return aggregators
+}
+
+// countTarget names the series of options without Target
+const countTarget string = "count"
+
+func (p *AggregatorOption) bucketName() string {
+	return p.PreMeasurment + "_" + p.Measurment + "_" + p.Target
+}
+
+// aggregations returns the aggregations of the option, options without
+// Target only support "cnt"
+func (p *AggregatorOption) aggregations() []string {
+	if p.Target == "" {
+		return []string{"cnt"}
+	}
+	return p.Aggregations
+}
+
+func hasAggregation(aggregations []string, name string) bool {
+	for _, aggregation := range aggregations {
+		if aggregation == name {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeTagValue escapes a tag value the way influxdb line protocol does
+func escapeTagValue(value string) string {
+	return strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ").Replace(value)
+}
+
+func (p *Aggregator) recordTopK(option *AggregatorOption, bucketTag string, fields map[string]interface{}) {
+	if option.TopKField == "" || !hasAggregation(option.Aggregations, "topk") {
+		return
+	}
+	value, ok := fields[option.TopKField].(string)
+	if !ok {
+		return
+	}
+	bucket, ok := p.topks[bucketTag]
+	if !ok {
+		k := option.TopK
+		if k <= 0 {
+			k = 10
+		}
+		capacity := k * 10
+		if capacity < 100 {
+			capacity = 100
+		}
+		bucket = &topkBucket{field: option.TopKField, k: k, ss: NewSpaceSaving(capacity)}
+		p.topks[bucketTag] = bucket
+	}
+	bucket.ss.Offer(value)
+}
+
 func getSampleTime(ts int64, interval int64) int64 {
 	return ts / interval
 }
@@ -73,13 +231,22 @@ func (p *Aggregator) IsDeadline(timestamp int64) bool {
 	return false
 }
 
+// DroppedTotal returns the Target values which couldn't be parsed
+func (p *Aggregator) DroppedTotal() int64 {
+	return atomic.LoadInt64(&p.dropped)
+}
+
 func (p *Aggregator) Record(fields map[string]interface{}) int64 {
 	var now int64
 	for i := 0; i < len(p.config.Options); i++ {
 		tags := p.config.Options[i].Tags
 		target := p.config.Options[i].Target
 		timestamp := p.config.Options[i].Timestamp
-		bucketName := p.config.Options[i].PreMeasurment + "_" + p.config.Options[i].Measurment + "_" + target
+		bucketName := p.config.Options[i].bucketName()
+		if target == "" {
+			// count lines only
+			target = countTarget
+		}
 		bucketTag := ""
 		if p.config.Options[i].PreMeasurment != "" {
 			bucketTag += p.config.Options[i].PreMeasurment + "_"
@@ -109,139 +276,162 @@ func (p *Aggregator) Record(fields map[string]interface{}) int64 {
 			}
 		}
 
-		if target == "" {
-			log.Error("[Record] Target is error: Target is null")
-			return time.Now().Unix()
-		}
+		tagRules := p.tagRules[i]
 		for i := 0; i < len(tags); i++ {
 			tags_tmp, ok := fields[tags[i]].(string)
 			if !ok {
 				log.Debug("[Record] Fields[tag] format error: Fields[tag] must be a string")
 			} else {
+				if n, ok := tagRules[tags[i]]; ok {
+					tags_tmp = n.Normalize(tags_tmp)
+				}
 				bucketTag += "," + tags[i] + "=" + tags_tmp
 			}
 		}
+		p.recordTopK(&p.config.Options[i], bucketTag, fields)
 
-		aggValue, ok := fields[target].(string)
-		if !ok {
-			log.Error("[Record] Fields[aggValue] format error: Fields[aggValue] must be a string")
-			return now
-		}
 		if _, ok := p.buckets[bucketName]; !ok {
 			p.buckets[bucketName] = make(map[string][]float64)
 		}
-		aggValueFloat64, err := strconv.ParseFloat(aggValue, 64)
+		if p.config.Options[i].Target == "" {
+			p.buckets[bucketName][bucketTag] = append(p.buckets[bucketName][bucketTag], 1)
+			continue
+		}
+		aggValue, ok := fields[target].(string)
+		if !ok {
+			log.Debug("[Record] Fields[aggValue] format error: Fields[aggValue] must be a string")
+			continue
+		}
+		aggValueFloat64, err := ParseValueWithUnit(aggValue, p.config.Options[i].Unit)
 		if err != nil {
-			log.Debug("[Record] target:%v can't use strconv.ParseFloat", aggValue)
-			p.buckets[bucketName][bucketTag] = append(p.buckets[bucketName][bucketTag], -1)
-		} else {
-			p.buckets[bucketName][bucketTag] = append(p.buckets[bucketName][bucketTag], aggValueFloat64)
+			log.Debugf("[Record] target:%v can't be parsed, %s", aggValue, err)
+			atomic.AddInt64(&p.dropped, 1)
+			continue
 		}
+		p.buckets[bucketName][bucketTag] = append(p.buckets[bucketName][bucketTag], aggValueFloat64)
 	}
 	return now
 }
 
-func quickSort(values []float64, left, right int64) {
-	temp := values[left]
-	p := left
-	i, j := left, right
-	for i <= j {
-		for j >= p && values[j] >= temp {
-			j--
-		}
-		if j >= p {
-			values[p] = values[j]
-			p = j
-		}
-		for i <= p && values[i] <= temp {
-			i++
+const (
+	PercentileNearest = "nearest"
+	PercentileLinear  = "linear"
+)
+
+// getPercentile returns the percentile of sorted values, proportion in [0, 100]
+func getPercentile(sorted []float64, proportion float64, method string) float64 {
+	cnt := len(sorted)
+	if cnt == 0 {
+		return 0
+	}
+	if method == PercentileLinear {
+		pos := proportion / 100 * float64(cnt-1)
+		lower := int(math.Floor(pos))
+		if lower >= cnt-1 {
+			return sorted[cnt-1]
 		}
-		if i <= p {
-			values[p] = values[i]
-			p = i
+		if lower < 0 {
+			return sorted[0]
 		}
+		return sorted[lower] + (pos-float64(lower))*(sorted[lower+1]-sorted[lower])
 	}
-	values[p] = temp
-
-	if p-left > 1 {
-		quickSort(values, left, p-1)
+	rank := int(math.Ceil(proportion / 100 * float64(cnt)))
+	if rank < 1 {
+		rank = 1
 	}
-	if right-p > 1 {
-		quickSort(values, p+1, right)
+	if rank > cnt {
+		rank = cnt
 	}
+	return sorted[rank-1]
 }
 
-func getAggregation(targetValue []float64, aggregations []string) map[string]float64 {
+// getAggregation computes aggregations of targetValue. With no value, "cnt"
+// and "sum" are 0 while the other aggregations are absent from the result
+func getAggregation(targetValue []float64, aggregations []string, percentileMethod string) map[string]float64 {
 	aggregationResults := map[string]float64{}
-	cnt := int64(len(targetValue))
-	avg := float64(0)
+	cnt := len(targetValue)
+	sort.Float64s(targetValue)
 	sum := float64(0)
-	min := float64(0)
-	max := float64(0)
-	if cnt > 0 {
-		min = targetValue[0]
-		max = targetValue[0]
-	}
-	quickSort(targetValue, int64(0), int64(len(targetValue)-1))
 	for _, value := range targetValue {
 		sum += value
-		if value > max {
-			max = value
-		}
-		if value < min {
-			min = value
-		}
 	}
-	avg = sum / float64(cnt)
-	for i := 0; i < len(aggregations); i++ {
-		switch aggregations[i] {
+	for _, aggregation := range aggregations {
+		switch aggregation {
 		case "cnt":
-			aggregationResults["cnt"] = float64(len(targetValue))
+			aggregationResults["cnt"] = float64(cnt)
 		case "sum":
 			aggregationResults["sum"] = sum
 		case "avg":
-			aggregationResults["avg"] = avg
+			if cnt > 0 {
+				aggregationResults["avg"] = sum / float64(cnt)
+			}
 		case "min":
-			aggregationResults["min"] = min
+			if cnt > 0 {
+				aggregationResults["min"] = targetValue[0]
+			}
 		case "max":
-			aggregationResults["max"] = max
+			if cnt > 0 {
+				aggregationResults["max"] = targetValue[cnt-1]
+			}
 		default:
-			if aggregations[i][0] == 'p' {
-				proportion, err := strconv.ParseInt(aggregations[i][1:], 10, 64)
-				if err != nil {
-					panic(aggregations[i])
-				}
-				index := cnt*proportion/100 - 1
-				if cnt*proportion/100-1 < 0 {
-					index = 0
-				}
-				percentile := targetValue[index]
-				aggregationResults[aggregations[i]] = percentile
+			if len(aggregation) < 2 || aggregation[0] != 'p' {
+				continue
+			}
+			proportion, err := strconv.ParseFloat(aggregation[1:], 64)
+			if err != nil || proportion < 0 || proportion > 100 {
+				log.Debugf("[Aggregator] invalid aggregation %s", aggregation)
+				continue
+			}
+			if cnt > 0 {
+				aggregationResults[aggregation] = getPercentile(targetValue, proportion, percentileMethod)
 			}
 		}
 	}
 	return aggregationResults
 }
 
+// Flush dumps the samples recorded since the last dump at timestamp, nil if
+// there are none
+func (p *Aggregator) Flush(timestamp int64) map[string]interface{} {
+	if len(p.buckets) == 0 && len(p.topks) == 0 {
+		return nil
+	}
+	return p.Dump(timestamp)
+}
+
 func (p *Aggregator) Dump(timestamp int64) map[string]interface{} {
-	fields := map[string]interface{}{}
+	result := NewAggregationResult(timestamp)
 	log.Debug("[Dump] bucket is : %v", p.buckets)
 	//now := strconv.FormatInt(timestamp, 10)
-	for bucketName, bucketTag_value := range p.buckets {
+	buckets := p.buckets
+	if p.IsSliding() {
+		buckets = p.slide()
+	}
+	for bucketName, bucketTag_value := range buckets {
 		aggregations := []string{}
 		for i := 0; i < len(p.config.Options); i++ {
-			if p.config.Options[i].PreMeasurment+"_"+p.config.Options[i].Measurment+"_"+p.config.Options[i].Target == bucketName {
-				aggregations = p.config.Options[i].Aggregations
+			if p.config.Options[i].bucketName() == bucketName {
+				aggregations = p.config.Options[i].aggregations()
 				break
 			}
 		}
 		for bucketTag, targetValue := range bucketTag_value {
-			fields[bucketTag] = getAggregation(targetValue, aggregations)
+			result.Series[bucketTag+p.intervalTag] = getAggregation(targetValue, aggregations, p.config.Percentile)
+		}
+	}
+	for bucketTag, bucket := range p.topks {
+		for rank, c := range bucket.ss.Top(bucket.k) {
+			result.Series[bucketTag+p.intervalTag+","+bucket.field+"="+escapeTagValue(c.Value)] = map[string]float64{
+				"topk_cnt":   float64(c.Count),
+				"topk_error": float64(c.Error),
+				"topk_rank":  float64(rank + 1),
+			}
 		}
 	}
-	fields["timestamp"] = timestamp
+	fields := result.Fields()
 	p.postTime = getSampleTime(timestamp, p.config.Interval)
 	p.buckets = map[string]map[string][]float64{}
+	p.topks = map[string]*topkBucket{}
 	log.Debug("[Dump] fields is : %v", fields)
 	return fields
 }
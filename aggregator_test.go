@@ -2,6 +2,7 @@ package logpeck
 
 import (
 	log "github.com/Sirupsen/logrus"
+	"math"
 	"strconv"
 	"testing"
 )
@@ -109,7 +110,7 @@ func TestDump(*testing.T) {
 	if a["avg"] != 4.5 {
 		log.Panicf("%#v", a)
 	}
-	if a["p99"] != 8 {
+	if a["p99"] != 9 {
 		panic(a)
 	}
 	if a["p50"] != 4 {
@@ -119,3 +120,256 @@ func TestDump(*testing.T) {
 		panic(dump)
 	}
 }
+
+func TestTopK(*testing.T) {
+	test := AggregatorOption{
+		Measurment:   "_default",
+		Tags:         []string{"host"},
+		Aggregations: []string{"cnt", "topk"},
+		Target:       "cost",
+		TopKField:    "url",
+		TopK:         2,
+	}
+	aggregatorConfig := AggregatorConfig{
+		Enable:   true,
+		Interval: int64(30),
+		Options:  []AggregatorOption{test},
+	}
+	aggregator := NewAggregator(&aggregatorConfig)
+	urls := []string{"/a", "/b", "/a", "/c", "/a", "/b", "/d b"}
+	for _, url := range urls {
+		aggregator.Record(map[string]interface{}{"host": "h1", "cost": "1", "url": url})
+	}
+	dump := aggregator.Dump(int64(30))
+	a := dump["cost,host=h1,url=/a"].(map[string]float64)
+	if a["topk_cnt"] != 3 || a["topk_rank"] != 1 {
+		panic(dump)
+	}
+	b := dump["cost,host=h1,url=/b"].(map[string]float64)
+	if b["topk_cnt"] != 2 || b["topk_rank"] != 2 {
+		panic(dump)
+	}
+	if _, ok := dump["cost,host=h1,url=/c"]; ok {
+		panic(dump)
+	}
+	if len(aggregator.topks) != 0 {
+		panic(aggregator.topks)
+	}
+
+	ss := NewSpaceSaving(2)
+	for _, v := range []string{"x", "x", "y", "z"} {
+		ss.Offer(v)
+	}
+	top := ss.Top(1)
+	if len(top) != 1 || top[0].Value != "x" || top[0].Count != 2 {
+		panic(top)
+	}
+}
+
+func TestSlidingWindow(*testing.T) {
+	test := AggregatorOption{
+		Measurment:   "_default",
+		Aggregations: []string{"cnt", "max"},
+		Target:       "cost",
+		Timestamp:    "time",
+	}
+	aggregatorConfig := AggregatorConfig{
+		Enable:   true,
+		Interval: int64(10),
+		Window:   int64(30),
+		Options:  []AggregatorOption{test},
+	}
+	aggregator := NewAggregator(&aggregatorConfig)
+	var dump map[string]interface{}
+	for ts := int64(0); ts <= 50; ts += 5 {
+		fields := map[string]interface{}{"cost": strconv.FormatInt(ts, 10), "time": strconv.FormatInt(ts, 10)}
+		now := aggregator.Record(fields)
+		if aggregator.IsDeadline(now) {
+			dump = aggregator.Dump(now)
+		}
+	}
+	// the dump at 50 covers the last 3 intervals
+	a := dump["cost"].(map[string]float64)
+	if a["cnt"] != 6 || a["max"] != 50 {
+		panic(dump)
+	}
+	if len(aggregator.slots) != 3 {
+		panic(aggregator.slots)
+	}
+}
+
+func TestMultipleIntervals(*testing.T) {
+	test := AggregatorOption{
+		Measurment:   "_default",
+		Aggregations: []string{"cnt"},
+		Target:       "cost",
+		Timestamp:    "time",
+	}
+	aggregatorConfig := AggregatorConfig{
+		Enable:    true,
+		Options:   []AggregatorOption{test},
+		Intervals: []int64{10, 60},
+	}
+	aggregators := NewAggregators(&aggregatorConfig)
+	if len(aggregators) != 2 {
+		panic(aggregators)
+	}
+	dumps := map[string]float64{}
+	for ts := int64(0); ts <= 60; ts += 5 {
+		fields := map[string]interface{}{"cost": "1", "time": strconv.FormatInt(ts, 10)}
+		for _, aggregator := range aggregators {
+			now := aggregator.Record(fields)
+			if aggregator.IsDeadline(now) {
+				for k, v := range aggregator.Dump(now) {
+					if k != "timestamp" {
+						dumps[k] += v.(map[string]float64)["cnt"]
+					}
+				}
+			}
+		}
+	}
+	if dumps["cost,interval=10s"] != 13 || dumps["cost,interval=60s"] != 13 {
+		panic(dumps)
+	}
+}
+
+func TestGetPercentile(*testing.T) {
+	if v := getPercentile([]float64{1, 2}, 50, PercentileLinear); v != 1.5 {
+		panic(v)
+	}
+	if v := getPercentile([]float64{1, 2}, 50, PercentileNearest); v != 1 {
+		panic(v)
+	}
+	if v := getPercentile([]float64{1, 2}, 51, PercentileNearest); v != 2 {
+		panic(v)
+	}
+	values := []float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	if v := getPercentile(values, 99, PercentileNearest); v != 9 {
+		panic(v)
+	}
+	if v := getPercentile(values, 0, PercentileNearest); v != 0 {
+		panic(v)
+	}
+	if v := getPercentile(values, 90, PercentileLinear); math.Abs(v-8.1) > 1e-9 {
+		panic(v)
+	}
+	if v := getPercentile(values, 100, PercentileLinear); v != 9 {
+		panic(v)
+	}
+	if v := getPercentile([]float64{}, 50, PercentileLinear); v != 0 {
+		panic(v)
+	}
+}
+
+func TestGetAggregationEmpty(*testing.T) {
+	a := getAggregation([]float64{}, []string{"cnt", "sum", "avg", "min", "max", "p99", "pxx", "p", ""}, PercentileNearest)
+	if len(a) != 2 || a["cnt"] != 0 || a["sum"] != 0 {
+		panic(a)
+	}
+
+	values := make([]float64, 100000)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	a = getAggregation(values, []string{"min", "max", "avg", "p50"}, PercentileNearest)
+	if a["min"] != 0 || a["max"] != 99999 || a["avg"] != 49999.5 || a["p50"] != 49999 {
+		panic(a)
+	}
+}
+
+func TestCountWithoutTarget(*testing.T) {
+	test := AggregatorOption{
+		PreMeasurment: "nginx",
+		Measurment:    "api",
+		Tags:          []string{"level"},
+		Aggregations:  []string{"cnt", "avg"},
+	}
+	aggregatorConfig := AggregatorConfig{
+		Enable:   true,
+		Interval: int64(30),
+		Options:  []AggregatorOption{test},
+	}
+	aggregator := NewAggregator(&aggregatorConfig)
+	for _, level := range []string{"ERROR", "INFO", "ERROR"} {
+		aggregator.Record(map[string]interface{}{"api": "/login", "level": level})
+	}
+	dump := aggregator.Dump(int64(30))
+	a := dump["nginx_/login_count,level=ERROR"].(map[string]float64)
+	if len(a) != 1 || a["cnt"] != 2 {
+		panic(dump)
+	}
+	b := dump["nginx_/login_count,level=INFO"].(map[string]float64)
+	if b["cnt"] != 1 {
+		panic(dump)
+	}
+}
+
+func TestTagRules(*testing.T) {
+	test := AggregatorOption{
+		Measurment:   "_default",
+		Tags:         []string{"url"},
+		Aggregations: []string{"cnt"},
+		TagRules: map[string]TagRule{
+			"url": {
+				Lowercase: true,
+				Replace:   []TagReplace{{Pattern: "/[0-9]+", Replacement: "/:id"}},
+				Allow:     []string{"/user/:id", "/login"},
+			},
+		},
+	}
+	aggregatorConfig := AggregatorConfig{
+		Enable:   true,
+		Interval: int64(30),
+		Options:  []AggregatorOption{test},
+	}
+	if err := aggregatorConfig.Validate(); err != nil {
+		panic(err)
+	}
+	aggregator := NewAggregator(&aggregatorConfig)
+	for _, url := range []string{"/user/1", "/User/22", "/LOGIN", "/admin", "/x"} {
+		aggregator.Record(map[string]interface{}{"url": url})
+	}
+	dump := aggregator.Dump(int64(30))
+	if dump["count,url=/user/:id"].(map[string]float64)["cnt"] != 2 ||
+		dump["count,url=/login"].(map[string]float64)["cnt"] != 1 ||
+		dump["count,url=other"].(map[string]float64)["cnt"] != 2 {
+		panic(dump)
+	}
+
+	aggregatorConfig.Options[0].TagRules["url"] = TagRule{Replace: []TagReplace{{Pattern: "("}}}
+	if err := aggregatorConfig.Validate(); err == nil {
+		panic("invalid pattern")
+	}
+}
+
+func TestAggregatorUnit(*testing.T) {
+	aggregatorConfig := AggregatorConfig{
+		Enable:   true,
+		Interval: int64(30),
+		Options: []AggregatorOption{{
+			Measurment:   "_default",
+			Target:       "cost",
+			Aggregations: []string{"cnt", "max"},
+			Unit:         "ms",
+		}},
+	}
+	if err := aggregatorConfig.Validate(); err != nil {
+		panic(err)
+	}
+	aggregator := NewAggregator(&aggregatorConfig)
+	for _, cost := range []string{"3.4s", "12", "slow", "5 parsecs"} {
+		aggregator.Record(map[string]interface{}{"cost": cost})
+	}
+	dump := aggregator.Dump(int64(30))
+	if b := dump["cost"].(map[string]float64); b["cnt"] != 2 || b["max"] != 3400 {
+		panic(dump)
+	}
+	if aggregator.DroppedTotal() != 2 {
+		panic(aggregator.DroppedTotal())
+	}
+
+	aggregatorConfig.Options[0].Unit = "parsecs"
+	if err := aggregatorConfig.Validate(); err == nil {
+		panic("unknown unit")
+	}
+}
@@ -0,0 +1,239 @@
+package logpeck
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	AlertFormatJson      = "json"
+	AlertFormatSlack     = "slack"
+	AlertFormatPagerDuty = "pagerduty"
+)
+
+const pagerDutyEventsUrl string = "https://events.pagerduty.com/v2/enqueue"
+
+// alertMaxPosts is how many alerts of a task are posted at once, alerts
+// fired while as many posts are pending are dropped
+const alertMaxPosts = 4
+
+type AlertConfig struct {
+	Rules   []AlertRule      `json:"Rules"`
+	Webhook AlertWebhook     `json:"Webhook"`
+	Http    HttpClientConfig `json:"Http"`
+}
+
+// AlertRule fires when Aggregation of the series Measurement (with Tags)
+// compares true with Threshold, cnt and sum are accumulated over Window seconds
+type AlertRule struct {
+	Name        string            `json:"Name"`
+	Measurement string            `json:"Measurement"`
+	Tags        map[string]string `json:"Tags"`
+	Aggregation string            `json:"Aggregation"`
+	Operator    string            `json:"Operator"`
+	Threshold   float64           `json:"Threshold"`
+	Window      int64             `json:"Window"`
+	Cooldown    int64             `json:"Cooldown"`
+}
+
+type AlertWebhook struct {
+	Url        string `json:"Url"`
+	Format     string `json:"Format"`
+	RoutingKey string `json:"RoutingKey"`
+}
+
+type Alert struct {
+	Task      string
+	Rule      string
+	Series    string
+	Value     float64
+	Threshold float64
+	Timestamp int64
+}
+
+type alertPoint struct {
+	timestamp int64
+	value     float64
+}
+
+type Alerter struct {
+	task   string
+	config AlertConfig
+	points map[string][]alertPoint
+	fired  map[string]int64
+	notify func(alert *Alert)
+	client *http.Client
+	posts  chan struct{}
+}
+
+func NewAlerter(task string, config *AlertConfig) (*Alerter, error) {
+	alerter := &Alerter{
+		task:   task,
+		config: *config,
+		points: make(map[string][]alertPoint),
+		fired:  make(map[string]int64),
+		posts:  make(chan struct{}, alertMaxPosts),
+	}
+	alerter.notify = alerter.post
+	if alerter.IsEnable() {
+		client, err := NewHttpClient(&config.Http, 5*time.Second)
+		if err != nil {
+			return alerter, err
+		}
+		alerter.client = client
+	}
+	return alerter, nil
+}
+
+func (p *Alerter) IsEnable() bool {
+	return len(p.config.Rules) > 0
+}
+
+func (r *AlertRule) match(series string) bool {
+	parts := strings.Split(series, ",")
+	if r.Measurement != "" && parts[0] != r.Measurement {
+		return false
+	}
+	for k, v := range r.Tags {
+		found := false
+		for _, tag := range parts[1:] {
+			if tag == k+"="+v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *AlertRule) compare(value float64) bool {
+	switch r.Operator {
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	case "==":
+		return value == r.Threshold
+	default:
+		return value > r.Threshold
+	}
+}
+
+// Evaluate checks the rules against an aggregator dump and notifies the
+// alerts which are not in cooldown
+func (p *Alerter) Evaluate(results map[string]interface{}) []Alert {
+	var alerts []Alert
+	result, ok := ParseAggregationResult(results)
+	if !ok {
+		return nil
+	}
+	timestamp := result.Timestamp
+	for i := range p.config.Rules {
+		rule := &p.config.Rules[i]
+		for series, aggregations := range result.Series {
+			if !rule.match(series) {
+				continue
+			}
+			value, ok := aggregations[rule.Aggregation]
+			if !ok {
+				continue
+			}
+			key := rule.Name + "#" + series
+			if rule.Window > 0 && (rule.Aggregation == "cnt" || rule.Aggregation == "sum") {
+				value = p.accumulate(key, timestamp, value, rule.Window)
+			}
+			if !rule.compare(value) {
+				continue
+			}
+			if last, ok := p.fired[key]; ok && timestamp-last < rule.Cooldown {
+				continue
+			}
+			p.fired[key] = timestamp
+			alert := Alert{
+				Task:      p.task,
+				Rule:      rule.Name,
+				Series:    series,
+				Value:     value,
+				Threshold: rule.Threshold,
+				Timestamp: timestamp,
+			}
+			log.Infof("[Alerter] Fire alert %#v", alert)
+			p.notify(&alert)
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+func (p *Alerter) accumulate(key string, timestamp int64, value float64, window int64) float64 {
+	points := append(p.points[key], alertPoint{timestamp: timestamp, value: value})
+	for len(points) > 0 && timestamp-points[0].timestamp >= window {
+		points = points[1:]
+	}
+	p.points[key] = points
+	sum := float64(0)
+	for _, point := range points {
+		sum += point.value
+	}
+	return sum
+}
+
+func (p *Alerter) post(alert *Alert) {
+	url := p.config.Webhook.Url
+	summary := fmt.Sprintf("[logpeck] %s: %s on %s, %s value %g, threshold %g",
+		alert.Task, alert.Rule, GetHost(), alert.Series, alert.Value, alert.Threshold)
+	var body interface{}
+	switch strings.ToLower(p.config.Webhook.Format) {
+	case AlertFormatSlack:
+		body = map[string]interface{}{"text": summary}
+	case AlertFormatPagerDuty:
+		if url == "" {
+			url = pagerDutyEventsUrl
+		}
+		body = map[string]interface{}{
+			"routing_key":  p.config.Webhook.RoutingKey,
+			"event_action": "trigger",
+			"dedup_key":    alert.Task + "#" + alert.Rule + "#" + alert.Series,
+			"payload": map[string]interface{}{
+				"summary":  summary,
+				"source":   GetHost(),
+				"severity": "error",
+			},
+		}
+	default:
+		body = alert
+	}
+	if url == "" {
+		return
+	}
+	raw_data, err := json.Marshal(body)
+	if err != nil {
+		log.Errorf("[Alerter] Marshal alert error, err[%s]", err)
+		return
+	}
+	select {
+	case p.posts <- struct{}{}:
+	default:
+		log.Warnf("[Alerter] Too many alerts posting, drop alert %s of %s", alert.Rule, alert.Series)
+		return
+	}
+	go func() {
+		defer func() { <-p.posts }()
+		resp, err := p.client.Post(url, "application/json", bytes.NewBuffer(raw_data))
+		if err != nil {
+			log.Infof("[Alerter] Post alert error, err[%s]", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
@@ -0,0 +1,101 @@
+package logpeck
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAlerter(*testing.T) {
+	config := AlertConfig{
+		Rules: []AlertRule{
+			{
+				Name:        "TooManyErrors",
+				Measurement: "http_cost",
+				Tags:        map[string]string{"level": "ERROR"},
+				Aggregation: "cnt",
+				Threshold:   100,
+				Window:      300,
+				Cooldown:    600,
+			},
+		},
+	}
+	alerter, err := NewAlerter("test", &config)
+	if err != nil {
+		panic(err)
+	}
+	var notified []Alert
+	alerter.notify = func(alert *Alert) {
+		notified = append(notified, *alert)
+	}
+
+	dump := func(ts int64, cnt float64) map[string]interface{} {
+		return map[string]interface{}{
+			"timestamp":                         ts,
+			"http_cost,level=ERROR":             map[string]float64{"cnt": cnt},
+			"http_cost,level=INFO":              map[string]float64{"cnt": 1000},
+			"other_cost,level=ERROR,host=local": map[string]float64{"cnt": 1000},
+		}
+	}
+	if alerts := alerter.Evaluate(dump(60, 60)); len(alerts) != 0 {
+		panic(alerts)
+	}
+	alerts := alerter.Evaluate(dump(120, 60))
+	if len(alerts) != 1 || alerts[0].Value != 120 || alerts[0].Series != "http_cost,level=ERROR" {
+		panic(alerts)
+	}
+	// cooldown
+	if alerts := alerter.Evaluate(dump(180, 60)); len(alerts) != 0 {
+		panic(alerts)
+	}
+	if alerts := alerter.Evaluate(dump(780, 200)); len(alerts) != 1 {
+		panic(alerts)
+	}
+	if len(notified) != 2 {
+		panic(notified)
+	}
+}
+
+func TestAlerterPost(*testing.T) {
+	var received int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		<-release
+	}))
+	defer server.Close()
+	config := AlertConfig{
+		Rules:   []AlertRule{{Name: "many", Measurement: "api_cost", Aggregation: "cnt", Threshold: 2}},
+		Webhook: AlertWebhook{Url: server.URL},
+	}
+	alerter, err := NewAlerter("test", &config)
+	if err != nil {
+		panic(err)
+	}
+	// posts beyond alertMaxPosts are dropped while the webhook hangs
+	for i := 0; i < alertMaxPosts+2; i++ {
+		alerter.post(&Alert{Task: "test", Rule: "many", Series: "api_cost"})
+	}
+	for atomic.LoadInt32(&received) < alertMaxPosts {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&received); n != alertMaxPosts {
+		panic(n)
+	}
+	close(release)
+	for len(alerter.posts) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	alerter.post(&Alert{Task: "test", Rule: "many", Series: "api_cost"})
+	for atomic.LoadInt32(&received) != alertMaxPosts+1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	config.Http = HttpClientConfig{TLS: TLSConfig{Enable: true, CAFile: "/nonexistent/ca.pem"}}
+	if _, err := NewAlerter("test", &config); err == nil {
+		panic("bad TLS config accepted")
+	}
+}
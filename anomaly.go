@@ -0,0 +1,109 @@
+package logpeck
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"math"
+)
+
+type AnomalyConfig struct {
+	Enable       bool         `json:"Enable"`
+	Aggregations []string     `json:"Aggregations"`
+	Window       int          `json:"Window"`
+	MinPoints    int          `json:"MinPoints"`
+	Sensitivity  float64      `json:"Sensitivity"`
+	Sender       SenderConfig `json:"Sender"`
+}
+
+// AnomalyDetector keeps the last Window points of every aggregated series,
+// a point deviating more than Sensitivity standard deviations from the
+// moving average is reported as an anomaly
+type AnomalyDetector struct {
+	task    string
+	config  AnomalyConfig
+	history map[string][]float64
+}
+
+func NewAnomalyDetector(task string, config *AnomalyConfig) *AnomalyDetector {
+	detector := &AnomalyDetector{
+		task:    task,
+		config:  *config,
+		history: make(map[string][]float64),
+	}
+	if detector.config.Window <= 0 {
+		detector.config.Window = 30
+	}
+	if detector.config.MinPoints <= 0 {
+		detector.config.MinPoints = 10
+	}
+	if detector.config.MinPoints > detector.config.Window {
+		detector.config.MinPoints = detector.config.Window
+	}
+	if detector.config.Sensitivity <= 0 {
+		detector.config.Sensitivity = 3
+	}
+	return detector
+}
+
+func (p *AnomalyDetector) IsEnable() bool {
+	return p.config.Enable
+}
+
+func meanStddev(values []float64) (float64, float64) {
+	sum := float64(0)
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	variance := float64(0)
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+// Detect checks an aggregator dump and returns anomaly events
+func (p *AnomalyDetector) Detect(results map[string]interface{}) []map[string]interface{} {
+	var anomalies []map[string]interface{}
+	result, ok := ParseAggregationResult(results)
+	if !ok {
+		return nil
+	}
+	timestamp := result.Timestamp
+	for series, aggregations := range result.Series {
+		for _, aggregation := range p.config.Aggregations {
+			value, ok := aggregations[aggregation]
+			if !ok {
+				continue
+			}
+			key := series + "#" + aggregation
+			history := p.history[key]
+			if len(history) >= p.config.MinPoints {
+				mean, stddev := meanStddev(history)
+				if stddev > 0 {
+					score := (value - mean) / stddev
+					if math.Abs(score) > p.config.Sensitivity {
+						anomaly := map[string]interface{}{
+							"Type":        "anomaly",
+							"Task":        p.task,
+							"Series":      series,
+							"Aggregation": aggregation,
+							"Value":       value,
+							"Mean":        mean,
+							"Stddev":      stddev,
+							"Score":       score,
+							"timestamp":   timestamp,
+						}
+						log.Infof("[AnomalyDetector] Anomaly %v", anomaly)
+						anomalies = append(anomalies, anomaly)
+					}
+				}
+			}
+			history = append(history, value)
+			if len(history) > p.config.Window {
+				history = history[len(history)-p.config.Window:]
+			}
+			p.history[key] = history
+		}
+	}
+	return anomalies
+}
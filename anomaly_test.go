@@ -0,0 +1,57 @@
+package logpeck
+
+import (
+	"testing"
+)
+
+func TestAnomalyDetector(*testing.T) {
+	detector := NewAnomalyDetector("test", &AnomalyConfig{
+		Enable:       true,
+		Aggregations: []string{"avg"},
+		Window:       10,
+		MinPoints:    5,
+		Sensitivity:  3,
+	})
+	dump := func(ts int64, avg float64) map[string]interface{} {
+		return map[string]interface{}{
+			"timestamp":       ts,
+			"http_cost,api=a": map[string]float64{"avg": avg, "cnt": 1},
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if anomalies := detector.Detect(dump(int64(i), float64(100+i%2))); len(anomalies) != 0 {
+			panic(anomalies)
+		}
+	}
+	anomalies := detector.Detect(dump(10, 200))
+	if len(anomalies) != 1 || anomalies[0]["Series"] != "http_cost,api=a" || anomalies[0]["Value"] != float64(200) {
+		panic(anomalies)
+	}
+}
+
+func TestPeckTaskAnomalyTaskSender(*testing.T) {
+	h, err := NewHarness([]byte(`{
+		"Name": "AnomalyLog",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Anomaly": {"Enable": true, "Aggregations": ["avg"], "Window": 10, "MinPoints": 5, "Sensitivity": 3},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &eventSender{}
+	h.Task.sender = sender
+	for i := 0; i < 6; i++ {
+		avg := float64(100 + i%2)
+		if i == 5 {
+			avg = 200
+		}
+		h.Task.processAggregation(map[string]interface{}{
+			"timestamp":       int64(i),
+			"http_cost,api=a": map[string]float64{"avg": avg},
+		})
+	}
+	if len(sender.events) != 7 || sender.events[5]["Type"] != "anomaly" {
+		panic(sender.events)
+	}
+}
@@ -0,0 +1,145 @@
+package logpeck
+
+import (
+	"encoding/json"
+	"github.com/go-zoo/bone"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// APIPrefix is the path prefix of the versioned management API, the
+// unversioned paths are kept for existing clients
+const APIPrefix = "/api/v1"
+
+// apiRoute is a management handler. Request and Response are the types of
+// the json body and response, nil for none or a text response
+type apiRoute struct {
+	Method   string
+	Path     string
+	Summary  string
+	Query    []string
+	Request  interface{}
+	Response interface{}
+	Handler  http.HandlerFunc
+}
+
+func apiRoutes(pecker *Pecker) []apiRoute {
+	task := PeckTaskConfig{}
+	return []apiRoute{
+		{"POST", "/peck_task/add", "Add a task", []string{"strict"}, task, nil, NewAddTaskHandler(pecker)},
+		{"POST", "/peck_task/update", "Update a task", []string{"strict", "dry_run"}, task, nil, NewUpdateTaskHandler(pecker)},
+		{"POST", "/peck_task/start", "Start a task", nil, task, nil, NewStartTaskHandler(pecker)},
+		{"POST", "/peck_task/stop", "Stop a task", nil, task, nil, NewStopTaskHandler(pecker)},
+		{"POST", "/peck_task/reset", "Make a stopped task skip to the end of its log", nil, task, nil, NewResetTaskHandler(pecker)},
+		{"POST", "/peck_task/remove", "Remove a task", nil, task, nil, NewRemoveTaskHandler(pecker)},
+		{"POST", "/peck_task/list", "List task configs and stats", []string{"selector"}, ListQuery{}, map[string]interface{}{}, NewListTaskHandler(pecker)},
+		{"POST", "/peck_task/test", "Test a task config on the next lines of its log", []string{"strict"}, task, []map[string]interface{}{}, NewTestTaskHandler()},
+		{"POST", "/peck_task/replay", "Replay a log range through a task", nil, ReplayConfig{}, nil, NewReplayTaskHandler(pecker)},
+		{"POST", "/peck_task/rename", "Rename a task", nil, RenameConfig{}, nil, NewRenameTaskHandler(pecker)},
+		{"GET", "/peck_task/offsets", "List the read offsets of logs", nil, nil, []OffsetStat{}, NewListOffsetsHandler(pecker)},
+		{"POST", "/peck_task/offset", "Set the read offset of a log", nil, OffsetConfig{}, nil, NewSetOffsetHandler(pecker)},
+		{"GET", "/peck_task/capture", "Get the captured samples of a task", []string{"name"}, nil, CaptureStat{}, NewGetCaptureHandler(pecker)},
+		{"POST", "/peck_task/capture", "Capture samples of a task", nil, CaptureConfig{}, nil, NewSetCaptureHandler(pecker)},
+		{"POST", "/peck_task/dual_write", "Start or stop the dual-write of a task", nil, DualWriteConfig{}, nil, NewDualWriteHandler(pecker)},
+		{"POST", "/peck_task/trace", "Trace a marker line through a task", nil, TraceConfig{}, TraceReport{}, NewTraceHandler(pecker)},
+		{"GET", "/peck_task/profile", "Get the field profiles of a task", []string{"name"}, nil, ProfileStat{}, NewProfileHandler(pecker)},
+		{"GET", "/peck_task/schema", "Get the JSON Schema of task configs", nil, nil, map[string]interface{}{}, NewTaskSchemaHandler()},
+		{"POST", "/listpath", "List files of a directory", []string{"path"}, nil, []string{}, NewListPathHandler()},
+		{"GET", "/version", "Get the agent version", nil, nil, VersionInfo{}, NewVersionHandler()},
+		{"POST", "/version", "Get the agent version", nil, nil, VersionInfo{}, NewVersionHandler()},
+		{"GET", "/healthz", "Check that the agent is alive", nil, nil, nil, NewHealthzHandler()},
+		{"GET", "/readyz", "Check that the agent is ready", nil, nil, ReadyReport{}, NewReadyzHandler(pecker)},
+		{"GET", "/metrics/tasks", "Get task metrics in Prometheus text format", nil, nil, nil, NewTaskMetricsHandler(pecker)},
+		{"GET", "/stats/stream", "Stream the changes of task stats as server-sent events", []string{"name"}, nil, nil, NewStatsStreamHandler(pecker)},
+		{"GET", "/stats/history", "Get the stats history of tasks", []string{"name", "since"}, nil, map[string][]StatsSample{}, NewStatsHistoryHandler(pecker)},
+		{"GET", "/db/snapshot", "Download a database snapshot", nil, nil, nil, NewSnapshotHandler(pecker)},
+		{"GET", "/db/recovery", "Get how a corrupt database was recovered", nil, nil, DBRecovery{}, NewDBRecoveryHandler(pecker)},
+		{"GET", "/agent/runtime", "Get the runtime settings of the agent", nil, nil, RuntimeConfig{}, NewGetRuntimeHandler(pecker)},
+		{"POST", "/agent/runtime", "Change the runtime settings of the agent", nil, RuntimeConfig{}, nil, NewSetRuntimeHandler(pecker)},
+	}
+}
+
+// RegisterAPI registers the management handlers under APIPrefix and at
+// their unversioned paths, and the OpenAPI document of APIPrefix
+func RegisterAPI(mux *bone.Mux, pecker *Pecker) {
+	for _, route := range apiRoutes(pecker) {
+		mux.Register(route.Method, APIPrefix+route.Path, route.Handler)
+		mux.Register(route.Method, route.Path, route.Handler)
+	}
+	mux.Get(APIPrefix+"/openapi.json", NewOpenAPIHandler())
+}
+
+func NewOpenAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "OpenAPIHandler")
+		raw, err := json.MarshalIndent(OpenAPIDocument(), "", "  ")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Get OpenAPI document failed, " + err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(raw)
+	}
+}
+
+func textContent() map[string]interface{} {
+	return map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+}
+
+// OpenAPIDocument returns the OpenAPI 3.1 document of the API under
+// APIPrefix, generated from the routes and their types
+func OpenAPIDocument() map[string]interface{} {
+	b := newSchemaBuilder("#/components/schemas/")
+	paths := make(map[string]interface{})
+	for _, route := range apiRoutes(nil) {
+		operation := map[string]interface{}{
+			"summary":     route.Summary,
+			"operationId": strings.ToLower(route.Method) + strings.NewReplacer("/", "_").Replace(route.Path),
+		}
+		if len(route.Query) > 0 {
+			var parameters []interface{}
+			for _, name := range route.Query {
+				parameters = append(parameters, map[string]interface{}{
+					"name": name, "in": "query", "schema": map[string]interface{}{"type": "string"},
+				})
+			}
+			operation["parameters"] = parameters
+		}
+		if route.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"content": map[string]interface{}{"application/json": map[string]interface{}{
+					"schema": b.schema(reflect.TypeOf(route.Request)),
+				}},
+			}
+		}
+		success := map[string]interface{}{"description": "Success", "content": textContent()}
+		if route.Response != nil {
+			success["content"] = map[string]interface{}{"application/json": map[string]interface{}{
+				"schema": b.schema(reflect.TypeOf(route.Response)),
+			}}
+		}
+		operation["responses"] = map[string]interface{}{
+			"200": success,
+			"400": map[string]interface{}{"description": "Bad request", "content": textContent()},
+			"406": map[string]interface{}{"description": "Failed", "content": textContent()},
+		}
+		path, ok := paths[APIPrefix+route.Path].(map[string]interface{})
+		if !ok {
+			path = make(map[string]interface{})
+			paths[APIPrefix+route.Path] = path
+		}
+		path[strings.ToLower(route.Method)] = operation
+	}
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":   "logpeck management API",
+			"version": VersionString,
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": b.definitions},
+	}
+}
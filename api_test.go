@@ -0,0 +1,52 @@
+package logpeck
+
+import (
+	"encoding/json"
+	"github.com/go-zoo/bone"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestOpenAPIDocument(*testing.T) {
+	doc := OpenAPIDocument()
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		panic(err)
+	}
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for _, ref := range regexp.MustCompile(`"#/components/schemas/(\w+)"`).FindAllSubmatch(raw, -1) {
+		if schemas[string(ref[1])] == nil {
+			panic("missing schema " + string(ref[1]))
+		}
+	}
+	paths := doc["paths"].(map[string]interface{})
+	for _, route := range apiRoutes(nil) {
+		path, ok := paths[APIPrefix+route.Path].(map[string]interface{})
+		if !ok || path[map[string]string{"GET": "get", "POST": "post"}[route.Method]] == nil {
+			panic("missing path " + route.Method + " " + route.Path)
+		}
+	}
+	add := paths[APIPrefix+"/peck_task/add"].(map[string]interface{})["post"].(map[string]interface{})
+	body, _ := json.Marshal(add["requestBody"])
+	if string(body) != `{"content":{"application/json":{"schema":{"$ref":"#/components/schemas/PeckTaskConfig"}}}}` {
+		panic(string(body))
+	}
+}
+
+func TestRegisterAPI(*testing.T) {
+	mux := bone.New()
+	RegisterAPI(mux, nil)
+	for _, path := range []string{"/version", APIPrefix + "/version", APIPrefix + "/openapi.json"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			panic(path)
+		}
+		var v map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package logpeck
+
+import (
+	"bufio"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// BackfillConfig makes a task read the existing content of LogPath once,
+// at most Rate lines per second, besides tailing new lines
+type BackfillConfig struct {
+	Enable bool  `json:"Enable"`
+	Rate   int64 `json:"Rate"`
+}
+
+// Backfiller reads a file up to its size when started, it is independent
+// of the live tail of the file
+type Backfiller struct {
+	path    string
+	rate    int64
+	process func(content string)
+	// bufferSize is the size of the read buffer, 0 for the default
+	bufferSize int
+	// from and to bound the bytes read, to is the file size if negative
+	from int64
+	to   int64
+
+	size  int64
+	read  int64
+	start time.Time
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func NewBackfiller(path string, config *BackfillConfig, process func(content string)) *Backfiller {
+	return &Backfiller{
+		path:    path,
+		rate:    config.Rate,
+		process: process,
+		to:      -1,
+	}
+}
+
+// NewRangeBackfiller reads the lines of path between the offsets from and
+// to, unthrottled
+func NewRangeBackfiller(path string, from, to int64, process func(content string)) *Backfiller {
+	return &Backfiller{
+		path:    path,
+		process: process,
+		from:    from,
+		to:      to,
+	}
+}
+
+// Start reads the file in background, finish is called if the whole file
+// has been read
+func (p *Backfiller) Start(finish func()) error {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	p.size = info.Size()
+	if p.to >= 0 && p.to < p.size {
+		p.size = p.to
+	}
+	if p.from > 0 {
+		if _, err := f.Seek(p.from, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+		p.size -= p.from
+	}
+	p.start = time.Now()
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		defer f.Close()
+		log.Infof("[Backfiller %s] Start backfill %d bytes", p.path, p.size)
+		if p.run(f) {
+			log.Infof("[Backfiller %s] Backfill finished", p.path)
+			finish()
+		}
+	}()
+	return nil
+}
+
+func (p *Backfiller) run(f io.Reader) bool {
+	limiter := NewRateLimiter(p.rate)
+	size := p.bufferSize
+	if size <= 0 {
+		size = defaultReadBufferSize
+	}
+	reader := bufio.NewReaderSize(io.LimitReader(f, p.size), size)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if !limiter.Wait(p.stop) {
+				return false
+			}
+			atomic.AddInt64(&p.read, int64(len(line)))
+			if line[len(line)-1] == '\n' {
+				line = line[:len(line)-1]
+			}
+			p.process(line)
+		}
+		if err != nil {
+			return err == io.EOF
+		}
+	}
+}
+
+func (p *Backfiller) Stop() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	p.stop = nil
+}
+
+// Progress returns the percent of the file read, and the estimated time
+// to read the rest
+func (p *Backfiller) Progress() (float64, time.Duration) {
+	if p.start.IsZero() {
+		return 0, -1
+	}
+	read := atomic.LoadInt64(&p.read)
+	if p.size <= 0 {
+		return 100, 0
+	}
+	percent := float64(read) * 100 / float64(p.size)
+	if read == 0 {
+		return percent, -1
+	}
+	elapsed := time.Since(p.start)
+	eta := time.Duration(float64(elapsed) * float64(p.size-read) / float64(read))
+	return percent, eta
+}
@@ -0,0 +1,68 @@
+package logpeck
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackfiller(*testing.T) {
+	f, err := ioutil.TempFile("", "backfill")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("line 1\nline 2\nline 3\nline 4")
+	f.Close()
+
+	var mu sync.Mutex
+	var lines []string
+	backfiller := NewBackfiller(f.Name(), &BackfillConfig{Enable: true, Rate: 50}, func(content string) {
+		mu.Lock()
+		lines = append(lines, content)
+		mu.Unlock()
+	})
+	if percent, eta := backfiller.Progress(); percent != 0 || eta != -1 {
+		panic(percent)
+	}
+	finished := make(chan struct{})
+	if err := backfiller.Start(func() { close(finished) }); err != nil {
+		panic(err)
+	}
+	// appended lines are left to the live tail
+	f, _ = os.OpenFile(f.Name(), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("\nline 5\n")
+	f.Close()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		panic("backfill not finished")
+	}
+	backfiller.Stop()
+	if len(lines) != 4 || lines[0] != "line 1" || lines[3] != "line 4" {
+		panic(lines)
+	}
+	if percent, eta := backfiller.Progress(); percent != 100 || eta != 0 {
+		panic(percent)
+	}
+}
+
+func TestBackfillerStop(*testing.T) {
+	f, err := ioutil.TempFile("", "backfill")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("line 1\nline 2\nline 3\n")
+	f.Close()
+
+	backfiller := NewBackfiller(f.Name(), &BackfillConfig{Enable: true, Rate: 1}, func(string) {})
+	backfiller.Start(func() { panic("backfill should be stopped") })
+	time.Sleep(10 * time.Millisecond)
+	backfiller.Stop()
+	if percent, eta := backfiller.Progress(); percent <= 0 || percent >= 100 || eta <= 0 {
+		panic(percent)
+	}
+}
@@ -0,0 +1,104 @@
+package logpeck
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Batcher buffers items and hands them to flush when Size items are
+// buffered or every Interval
+type Batcher struct {
+	size     int
+	interval time.Duration
+	flush    func(items []interface{})
+
+	mu    sync.Mutex
+	items []interface{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func NewBatcher(size int, interval time.Duration, flush func(items []interface{})) *Batcher {
+	if size <= 0 {
+		size = 100
+	}
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &Batcher{
+		size:     size,
+		interval: interval,
+		flush:    flush,
+	}
+}
+
+func (p *Batcher) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		return
+	}
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.safeFlush()
+			case <-stop:
+				return
+			}
+		}
+	}(p.stop, p.done)
+}
+
+// Stop ends the flush loop and flushes the buffered items
+func (p *Batcher) Stop() {
+	p.mu.Lock()
+	stop, done := p.stop, p.done
+	p.stop, p.done = nil, nil
+	p.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	p.Flush()
+}
+
+func (p *Batcher) Add(item interface{}) {
+	p.mu.Lock()
+	p.items = append(p.items, item)
+	if len(p.items) < p.size {
+		p.mu.Unlock()
+		return
+	}
+	items := p.items
+	p.items = nil
+	p.mu.Unlock()
+	p.flush(items)
+}
+
+// safeFlush keeps the flush loop alive when flush panics
+func (p *Batcher) safeFlush() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("[Batcher] Flush panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	p.Flush()
+}
+
+func (p *Batcher) Flush() {
+	p.mu.Lock()
+	items := p.items
+	p.items = nil
+	p.mu.Unlock()
+	if len(items) > 0 {
+		p.flush(items)
+	}
+}
@@ -0,0 +1,24 @@
+package logpeck
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatcher(*testing.T) {
+	var batches [][]interface{}
+	batcher := NewBatcher(3, time.Hour, func(items []interface{}) {
+		batches = append(batches, items)
+	})
+	batcher.Start()
+	for i := 0; i < 7; i++ {
+		batcher.Add(i)
+	}
+	if len(batches) != 2 || len(batches[1]) != 3 {
+		panic(batches)
+	}
+	batcher.Stop()
+	if len(batches) != 3 || len(batches[2]) != 1 || batches[2][0] != 6 {
+		panic(batches)
+	}
+}
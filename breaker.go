@@ -0,0 +1,205 @@
+package logpeck
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+var ErrBreakerOpen error = errors.New("circuit breaker open")
+
+// breakerTrialWait is how often a request held back by a half-open breaker
+// checks whether the trial request closed it
+const breakerTrialWait = 100 * time.Millisecond
+
+// Breaker stops requests to a backend for Cooldown after Threshold
+// consecutive failures, then lets one trial request decide whether to close
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+func (p *Breaker) Allow(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.state {
+	case BreakerOpen:
+		if now.Sub(p.openedAt) < p.cooldown {
+			return false
+		}
+		p.state = BreakerHalfOpen
+		p.trial = true
+		return true
+	case BreakerHalfOpen:
+		if p.trial {
+			return false
+		}
+		p.trial = true
+		return true
+	}
+	return true
+}
+
+func (p *Breaker) Success() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = BreakerClosed
+	p.failures = 0
+	p.trial = false
+}
+
+func (p *Breaker) Failure(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures++
+	p.trial = false
+	if p.state == BreakerHalfOpen || p.failures >= p.threshold {
+		p.state = BreakerOpen
+		p.openedAt = now
+	}
+}
+
+// Wait returns how long a request has to wait before the breaker may let
+// it through, 0 if it may be sent now
+func (p *Breaker) Wait(now time.Time) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.state {
+	case BreakerOpen:
+		if wait := p.cooldown - now.Sub(p.openedAt); wait > 0 {
+			return wait
+		}
+	case BreakerHalfOpen:
+		if p.trial {
+			return breakerTrialWait
+		}
+	}
+	return 0
+}
+
+// Release lets another trial request when the trial request was canceled
+func (p *Breaker) Release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.trial = false
+}
+
+func (p *Breaker) State() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// breakerTransport keeps one Breaker per backend host, transport errors
+// and 5xx responses are failures
+type breakerTransport struct {
+	next      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func newBreakerTransport(next http.RoundTripper, threshold int, cooldown time.Duration) *breakerTransport {
+	return &breakerTransport{
+		next:      next,
+		threshold: threshold,
+		cooldown:  cooldown,
+		breakers:  make(map[string]*Breaker),
+	}
+}
+
+func (p *breakerTransport) breaker(host string) *Breaker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	breaker, ok := p.breakers[host]
+	if !ok {
+		breaker = NewBreaker(p.threshold, p.cooldown)
+		p.breakers[host] = breaker
+	}
+	return breaker
+}
+
+func (p *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	breaker := p.breaker(req.URL.Host)
+	if !breaker.Allow(time.Now()) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrBreakerOpen
+	}
+	resp, err := p.next.RoundTrip(req)
+	if err != nil && errors.Is(req.Context().Err(), context.Canceled) {
+		// canceled by the sender, says nothing about the backend. Timeouts
+		// are deadlines, they are failures
+		breaker.Release()
+		return resp, err
+	}
+	if err != nil || resp.StatusCode >= 500 {
+		breaker.Failure(time.Now())
+	} else {
+		breaker.Success()
+	}
+	return resp, err
+}
+
+// Open reports whether the breaker of host holds requests back now
+func (p *breakerTransport) Open(host string) bool {
+	p.mu.Lock()
+	breaker, ok := p.breakers[host]
+	p.mu.Unlock()
+	return ok && breaker.Wait(time.Now()) > 0
+}
+
+// hold waits until the breaker of host may let a request through, it
+// returns ctx.Err() if ctx is done first
+func (p *breakerTransport) hold(ctx context.Context, host string) error {
+	breaker := p.breaker(host)
+	for {
+		wait := breaker.Wait(time.Now())
+		if wait <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// States returns the breaker state of each backend host
+func (p *breakerTransport) States() map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	states := make(map[string]string)
+	for host, breaker := range p.breakers {
+		states[displayHost(host)] = breaker.State()
+	}
+	return states
+}
@@ -0,0 +1,141 @@
+package logpeck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreaker(*testing.T) {
+	now := time.Now()
+	breaker := NewBreaker(2, time.Minute)
+	breaker.Failure(now)
+	if !breaker.Allow(now) || breaker.State() != BreakerClosed {
+		panic(breaker.State())
+	}
+	breaker.Failure(now)
+	if breaker.Allow(now.Add(time.Second)) || breaker.State() != BreakerOpen {
+		panic(breaker.State())
+	}
+	// one trial after cooldown
+	if !breaker.Allow(now.Add(time.Minute)) || breaker.Allow(now.Add(time.Minute)) {
+		panic(breaker.State())
+	}
+	breaker.Failure(now.Add(time.Minute))
+	if breaker.Allow(now.Add(time.Minute+time.Second)) || breaker.State() != BreakerOpen {
+		panic(breaker.State())
+	}
+	if !breaker.Allow(now.Add(2 * time.Minute)) {
+		panic(breaker.State())
+	}
+	breaker.Success()
+	if !breaker.Allow(now.Add(2*time.Minute)) || breaker.State() != BreakerClosed {
+		panic(breaker.State())
+	}
+}
+
+func TestBreakerTransport(*testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := mustHttpClient(&HttpClientConfig{BreakerThreshold: 2}, time.Second)
+	stat := newHttpStat(client)
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+	if requests != 2 {
+		panic(requests)
+	}
+	for _, state := range stat.BreakerStates() {
+		if state != BreakerOpen {
+			panic(state)
+		}
+	}
+	if len(stat.BreakerStates()) != 1 {
+		panic(stat.BreakerStates())
+	}
+}
+
+func TestBreakerHold(*testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	client := mustHttpClient(&HttpClientConfig{BreakerThreshold: 1, BreakerCooldown: 1}, time.Second)
+	stat := newHttpStat(client)
+	post := func(ctx context.Context) (*http.Response, error) {
+		return stat.post(ctx, client, server.URL, "text/plain", strings.NewReader("event"))
+	}
+	resp, err := post(context.Background())
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		panic(err)
+	}
+	resp.Body.Close()
+	// held back while the breaker is open
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err := post(ctx); stat.observe(err) == nil || stat.DroppedTotal() != 1 {
+		panic(err)
+	}
+	start := time.Now()
+	resp, err = post(context.Background())
+	if err != nil || resp.StatusCode != http.StatusOK || time.Since(start) < 500*time.Millisecond {
+		panic(err)
+	}
+	resp.Body.Close()
+	if atomic.LoadInt32(&requests) != 2 {
+		panic(requests)
+	}
+}
+
+func TestBreakerTransportTimeout(*testing.T) {
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	transport := newBreakerTransport(http.DefaultTransport, 1, 50*time.Millisecond)
+	client := &http.Client{Transport: transport, Timeout: 100 * time.Millisecond}
+	breaker := transport.breaker(server.Listener.Addr().String())
+	if _, err := client.Get(server.URL); err == nil || breaker.State() != BreakerOpen {
+		panic(breaker.State())
+	}
+
+	// a timed out trial opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	if _, err := client.Get(server.URL); err == nil || breaker.State() != BreakerOpen {
+		panic(breaker.State())
+	}
+
+	// a canceled trial lets another one
+	time.Sleep(60 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := client.Do(req); err == nil || breaker.State() != BreakerHalfOpen {
+		panic(breaker.State())
+	}
+	if !breaker.Allow(time.Now()) {
+		panic("trial not released")
+	}
+}
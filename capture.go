@@ -0,0 +1,70 @@
+package logpeck
+
+import (
+	"sync"
+	"time"
+)
+
+// CapturedLine is a raw line seen by a task, before the filter
+type CapturedLine struct {
+	Timestamp int64
+	Line      string
+}
+
+// CapturedEvent is an event a task handed to its sender
+type CapturedEvent struct {
+	Timestamp int64
+	Event     map[string]interface{}
+}
+
+// SampleCapture keeps the last size raw lines and events of a task, up to
+// twice as many are held so that trimming is amortized
+type SampleCapture struct {
+	size int
+
+	mu     sync.Mutex
+	lines  []CapturedLine
+	events []CapturedEvent
+}
+
+func NewSampleCapture(size int) *SampleCapture {
+	return &SampleCapture{size: size}
+}
+
+func (p *SampleCapture) AddLine(line string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lines = append(p.lines, CapturedLine{Timestamp: now.Unix(), Line: line})
+	if len(p.lines) >= 2*p.size {
+		p.lines = append(p.lines[:0:0], p.lines[len(p.lines)-p.size:]...)
+	}
+}
+
+// AddEvent keeps a copy of event, senders may change it after Send
+func (p *SampleCapture) AddEvent(event map[string]interface{}, now time.Time) {
+	copied := make(map[string]interface{}, len(event))
+	for k, v := range event {
+		copied[k] = v
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, CapturedEvent{Timestamp: now.Unix(), Event: copied})
+	if len(p.events) >= 2*p.size {
+		p.events = append(p.events[:0:0], p.events[len(p.events)-p.size:]...)
+	}
+}
+
+// Samples returns the captured lines and events, oldest first
+func (p *SampleCapture) Samples() ([]CapturedLine, []CapturedEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lines := p.lines
+	if len(lines) > p.size {
+		lines = lines[len(lines)-p.size:]
+	}
+	events := p.events
+	if len(events) > p.size {
+		events = events[len(events)-p.size:]
+	}
+	return append([]CapturedLine{}, lines...), append([]CapturedEvent{}, events...)
+}
@@ -0,0 +1,49 @@
+package logpeck
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSampleCapture(*testing.T) {
+	capture := NewSampleCapture(3)
+	now := time.Unix(100, 0)
+	for _, line := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		capture.AddLine(line, now)
+	}
+	event := map[string]interface{}{"k": "v"}
+	capture.AddEvent(event, now)
+	event["k"] = "changed"
+	lines, events := capture.Samples()
+	if len(lines) != 3 || lines[0].Line != "e" || lines[2].Line != "g" || lines[0].Timestamp != 100 {
+		panic(lines)
+	}
+	if len(events) != 1 || events[0].Event["k"] != "v" {
+		panic(events)
+	}
+}
+
+func TestPeckTaskCapture(*testing.T) {
+	h, err := NewHarness([]byte(`{
+		"Name": "CaptureLog",
+		"Keywords": "keep",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &eventSender{}
+	h.Task.sender = sender
+	h.Feed("before keep")
+	h.Task.SetCapture(10)
+	h.Feed("drop", "keep me")
+	lines, events := h.Task.Capture().Samples()
+	if len(lines) != 2 || lines[0].Line != "drop" || len(events) != 1 || events[0].Event["_Log"] != "keep me" {
+		panic(lines)
+	}
+	h.Task.SetCapture(0)
+	if h.Task.Capture() != nil || len(sender.events) != 2 {
+		panic(sender.events)
+	}
+}
@@ -0,0 +1,135 @@
+package logpeck
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	CharsetUTF8   string = "utf-8"
+	CharsetGBK    string = "gbk"
+	CharsetLatin1 string = "latin1"
+	CharsetAuto   string = "auto"
+)
+
+// CharsetConfig converts lines to valid UTF-8 before filtering and
+// extraction. Charset is the encoding of the log, "utf-8" (default), "gbk",
+// "latin1" or "auto" which keeps valid UTF-8 lines and decodes the others as
+// GBK, or as Latin-1 if they are not GBK either. Bytes which can not be
+// decoded are replaced by Replacement, "�" by default, Strip removes them
+type CharsetConfig struct {
+	Charset     string `json:"Charset"`
+	Replacement string `json:"Replacement"`
+	Strip       bool   `json:"Strip"`
+}
+
+type CharsetConverter struct {
+	config CharsetConfig
+	enable bool
+}
+
+func NewCharsetConverter(config *CharsetConfig) (*CharsetConverter, error) {
+	converter := &CharsetConverter{config: *config}
+	converter.enable = config.Charset != "" || config.Replacement != "" || config.Strip
+	switch strings.ToLower(config.Charset) {
+	case "", "utf8", CharsetUTF8:
+		converter.config.Charset = CharsetUTF8
+	case "gb2312", "cp936", CharsetGBK:
+		converter.config.Charset = CharsetGBK
+	case "iso-8859-1", CharsetLatin1:
+		converter.config.Charset = CharsetLatin1
+	case CharsetAuto:
+		converter.config.Charset = CharsetAuto
+	default:
+		return nil, errors.New("Charset error: unknown charset " + config.Charset)
+	}
+	if converter.config.Strip {
+		converter.config.Replacement = ""
+	} else if converter.config.Replacement == "" {
+		converter.config.Replacement = string(utf8.RuneError)
+	}
+	return converter, nil
+}
+
+func (p *CharsetConverter) IsEnable() bool {
+	return p.enable
+}
+
+// Convert returns content as valid UTF-8
+func (p *CharsetConverter) Convert(content string) string {
+	switch p.config.Charset {
+	case CharsetGBK:
+		return decodeGBK(content, p.config.Replacement)
+	case CharsetLatin1:
+		return decodeLatin1(content)
+	case CharsetAuto:
+		if utf8.ValidString(content) {
+			return content
+		}
+		if isGBK(content) {
+			return decodeGBK(content, p.config.Replacement)
+		}
+		return decodeLatin1(content)
+	}
+	return strings.ToValidUTF8(content, p.config.Replacement)
+}
+
+func decodeLatin1(content string) string {
+	var b strings.Builder
+	b.Grow(len(content) * 2)
+	for i := 0; i < len(content); i++ {
+		b.WriteRune(rune(content[i]))
+	}
+	return b.String()
+}
+
+// gbkRune returns the rune of the GBK bytes at the start of s and their
+// length, 0 if they are not a character
+func gbkRune(s string) (rune, int) {
+	c := s[0]
+	if c < 0x80 {
+		return rune(c), 1
+	}
+	if c == 0x80 || c == 0xff || len(s) < 2 || s[1] < 0x40 || s[1] == 0x7f || s[1] == 0xff {
+		return 0, 0
+	}
+	r := gbkTable[int(c-0x81)*191+int(s[1]-0x40)]
+	if r == 0 {
+		return 0, 0
+	}
+	return rune(r), 2
+}
+
+func isGBK(content string) bool {
+	for i := 0; i < len(content); {
+		_, n := gbkRune(content[i:])
+		if n == 0 {
+			return false
+		}
+		i += n
+	}
+	return true
+}
+
+func decodeGBK(content string, replacement string) string {
+	var b strings.Builder
+	b.Grow(len(content) * 3 / 2)
+	invalid := false
+	for i := 0; i < len(content); {
+		r, n := gbkRune(content[i:])
+		if n == 0 {
+			// a run of invalid bytes is replaced once like strings.ToValidUTF8
+			if !invalid {
+				b.WriteString(replacement)
+			}
+			invalid = true
+			i++
+			continue
+		}
+		invalid = false
+		b.WriteRune(r)
+		i += n
+	}
+	return b.String()
+}
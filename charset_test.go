@@ -0,0 +1,40 @@
+package logpeck
+
+import (
+	"testing"
+)
+
+func TestCharsetConverter(*testing.T) {
+	if _, err := NewCharsetConverter(&CharsetConfig{Charset: "ebcdic"}); err == nil {
+		panic("unknown charset accepted")
+	}
+	gbk := "\xc4\xe3\xba\xc3 logpeck"
+	cases := []struct {
+		config   CharsetConfig
+		in, want string
+	}{
+		{CharsetConfig{Charset: "gbk"}, gbk, "你好 logpeck"},
+		{CharsetConfig{Charset: "GB2312"}, "a\xc4\xe3\xffb", "a你�b"},
+		{CharsetConfig{Charset: "latin1"}, "caf\xe9", "café"},
+		{CharsetConfig{Charset: "auto"}, "已经是 utf-8", "已经是 utf-8"},
+		{CharsetConfig{Charset: "auto"}, gbk, "你好 logpeck"},
+		{CharsetConfig{Charset: "auto"}, "caf\xe9", "café"},
+		{CharsetConfig{Strip: true}, "ok\xff\xfe!", "ok!"},
+		{CharsetConfig{Replacement: "?"}, "ok\xff\xfe!", "ok?!"},
+	}
+	for _, c := range cases {
+		converter, err := NewCharsetConverter(&c.config)
+		if err != nil {
+			panic(err)
+		}
+		if !converter.IsEnable() {
+			panic(c.config)
+		}
+		if got := converter.Convert(c.in); got != c.want {
+			panic(got)
+		}
+	}
+	if converter, _ := NewCharsetConverter(&CharsetConfig{}); converter.IsEnable() {
+		panic("converter enabled without config")
+	}
+}
@@ -1,36 +1,62 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/go-zoo/bone"
 	"github.com/opera/logpeck"
 	"net/http"
-	"strings"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 func main() {
 	configFile := flag.String("config", "./logpeckd.conf", "Config file path")
+	snapshot := flag.String("restore", "", "Restore the database from this snapshot before start")
+	checkConfig := flag.Bool("check-config", false, "Check the config file and exit")
+	schema := flag.Bool("schema", false, "Print the JSON Schema of task configs and exit")
 	flag.Parse()
 
-	logpeck.InitConfig(configFile)
-	switch strings.ToLower(logpeck.Config.LogLevel) {
-	case "error":
-		log.SetLevel(log.ErrorLevel)
-	case "warning":
-		log.SetLevel(log.ErrorLevel)
-	case "info":
-		log.SetLevel(log.InfoLevel)
-	case "debug":
-		log.SetLevel(log.DebugLevel)
-	default:
+	if *schema {
+		os.Stdout.Write(logpeck.TaskConfigSchemaJSON())
+		return
+	}
+
+	if !logpeck.InitConfig(configFile) {
+		os.Exit(1)
+	}
+	if *checkConfig {
+		fmt.Printf("%s: config ok\n", *configFile)
+		return
+	}
+	if err := logpeck.SetLogLevel(logpeck.Config.LogLevel); err != nil {
 		fmt.Println("unkown log level, use info level")
 		log.SetLevel(log.InfoLevel)
 	}
+	if logpeck.Config.LogFile != "" {
+		file, err := os.OpenFile(logpeck.Config.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			panic(err)
+		}
+		defer file.Close()
+		log.SetOutput(file)
+		log.SetFormatter(&log.TextFormatter{DisableColors: true})
+	}
 	log.Infof("[LogPeckD] LogPeck(%s) Start %+v", logpeck.VersionString, logpeck.Config)
+	if err := logpeck.ApplyResourceLimits(&logpeck.Config.Resources); err != nil {
+		panic(err)
+	}
+	logpeck.ApplyReadConfig(&logpeck.Config.Read)
 
+	if *snapshot != "" {
+		if err := logpeck.RestoreDB(*snapshot, logpeck.Config.DatabaseFile); err != nil {
+			panic(err)
+		}
+	}
 	err := logpeck.OpenDB(logpeck.Config.DatabaseFile)
 	if err != nil {
 		panic(err)
@@ -43,27 +69,34 @@ func main() {
 		panic(p_err)
 	}
 	pecker.Start()
+	if err := pecker.SyncSelfLogTask(&logpeck.Config); err != nil {
+		log.Errorf("[LogPeckD] Self log task error, %s", err)
+	}
+
+	if logpeck.Config.ReleaseFeed != "" {
+		go logpeck.RunReleaseCheck(context.Background(), logpeck.Config.ReleaseFeed)
+	}
 
 	mux := bone.New()
-	mux.Post("/peck_task/add", logpeck.NewAddTaskHandler(pecker))
-	mux.Post("/peck_task/update", logpeck.NewUpdateTaskHandler(pecker))
-	mux.Post("/peck_task/start", logpeck.NewStartTaskHandler(pecker))
-	mux.Post("/peck_task/stop", logpeck.NewStopTaskHandler(pecker))
-	mux.Post("/peck_task/remove", logpeck.NewRemoveTaskHandler(pecker))
-	mux.Post("/peck_task/list", logpeck.NewListTaskHandler(pecker))
-	mux.Post("/peck_task/test", logpeck.NewTestTaskHandler())
-	mux.Post("/listpath", logpeck.NewListPathHandler())
-	mux.Post("/version", logpeck.NewVersionHandler())
+	logpeck.RegisterAPI(mux, pecker)
 
 	//	mux.Get("/pecker_stat", http.HandlerFunc(handler.Get))
 
-	log.Infof("[LogPeckD] Logpeck start serving on port %d ...\n", logpeck.Config.Port)
-	address := fmt.Sprintf(":%d", logpeck.Config.Port)
+	log.Infof("[LogPeckD] Logpeck start serving on %s ...\n", logpeck.Config.Address())
+	address := logpeck.Config.Address()
 	s := &http.Server{
 		Addr:         address,
 		Handler:      mux,
 		ReadTimeout:  2 * time.Second,
 		WriteTimeout: 3 * time.Second,
 	}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		log.Infof("[LogPeckD] Receive %s, stop all tasks", sig)
+		pecker.Stop()
+		s.Shutdown(context.Background())
+	}()
 	s.ListenAndServe()
 }
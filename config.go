@@ -1,17 +1,69 @@
 package logpeck
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/BurntSushi/toml"
+	sjson "github.com/bitly/go-simplejson"
+	"net"
 	"os"
+	"strings"
 )
 
 type LogPeckConfig struct {
-	Port          int32         `toml:"port"`
+	Port int32 `toml:"port"`
+	// Listen is the address of the API, e.g. "127.0.0.1:7117", it overrides
+	// port
+	Listen        string        `toml:"listen"`
 	LogLevel      string        `toml:"log_level"`
+	LogFile       string        `toml:"log_file"`
 	MaxTaskNum    int32         `toml:"max_task_num"`
 	DatabaseFile  string        `toml:"database_file"`
 	PeckTaskLimit PeckTaskLimit `toml:"peck_task_limit"`
+
+	// Fields are templated fields added to the events of all tasks
+	Fields map[string]string `toml:"fields"`
+	// StrictTaskConfig rejects task configs of the API with unknown fields
+	StrictTaskConfig bool `toml:"strict_task_config"`
+	// TaskDefaults is a json or yaml task config merged into the task
+	// configs of the API, values set by the task are kept
+	TaskDefaults string `toml:"task_defaults"`
+	// Senders are json sender configs by name, task configs use one with
+	// its name as Sender
+	Senders map[string]string `toml:"senders"`
+	// Tags are constant fields added to the events and aggregations of all
+	// tasks, fields of the same name set by tasks are kept
+	Tags map[string]interface{} `toml:"tags"`
+
+	SelfLog SelfLogConfig `toml:"self_log"`
+
+	// ReleaseFeed is fetched hourly to flag an outdated agent
+	ReleaseFeed string `toml:"release_feed"`
+
+	LoadGovernor LoadGovernorConfig `toml:"load_governor"`
+	Resources    ResourceConfig     `toml:"resources"`
+	Read         ReadConfig         `toml:"read"`
+	Ready        ReadyConfig        `toml:"ready"`
+	Reap         ReapConfig         `toml:"reap"`
+}
+
+// ReapConfig closes the tails of the files of glob and directory LogPaths
+// which were not written for IdleTimeout seconds, they are tailed again
+// once written. The saved offsets of files not seen for OffsetRetention
+// seconds are removed. 0 disables either
+type ReapConfig struct {
+	IdleTimeout     int64 `toml:"idle_timeout"`
+	OffsetRetention int64 `toml:"offset_retention"`
+}
+
+// SelfLogConfig enables the built-in task shipping the agent log (LogFile)
+// with Sender, a json sender config as in task configs
+type SelfLogConfig struct {
+	Enable bool   `toml:"enable"`
+	Level  string `toml:"level"`
+	Sender string `toml:"sender"`
 }
 
 type PeckTaskLimit struct {
@@ -22,15 +74,220 @@ type PeckTaskLimit struct {
 var Config LogPeckConfig
 
 func InitConfig(file *string) bool {
-	Config = LogPeckConfig{
+	config, err := LoadConfig(*file)
+	Config = config
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parse config fail: %s.\n", err)
+		return false
+	}
+	return true
+}
+
+// LoadConfig parses and validates the agent config file, unknown keys are
+// errors
+func LoadConfig(file string) (LogPeckConfig, error) {
+	config := LogPeckConfig{
 		Port:         7117,
 		MaxTaskNum:   16,
 		DatabaseFile: "logpeck.db",
 	}
+	meta, err := toml.DecodeFile(file, &config)
+	if err != nil {
+		return config, err
+	}
+	var errs []error
+	for _, key := range meta.Undecoded() {
+		errs = append(errs, fmt.Errorf("unknown key %s", key))
+	}
+	if err := config.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return config, errors.Join(errs...)
+}
 
-	if _, err := toml.DecodeFile(*file, &Config); err != nil {
-		fmt.Fprintf(os.Stderr, "Parse config fail: %s.\n", err)
-		return false
+// Address is the address the API listens on
+func (c *LogPeckConfig) Address() string {
+	if c.Listen != "" {
+		return c.Listen
 	}
-	return true
+	return fmt.Sprintf(":%d", c.Port)
+}
+
+// Validate checks the values of the config
+func (c *LogPeckConfig) Validate() error {
+	var errs []error
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("listen error: %s", err))
+		}
+	} else if c.Port <= 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port error: %d", c.Port))
+	}
+	if _, ok := selfLogLevels[strings.ToLower(c.LogLevel)]; !ok && c.LogLevel != "" {
+		errs = append(errs, fmt.Errorf("log_level error: %s", c.LogLevel))
+	}
+	if c.DatabaseFile == "" {
+		errs = append(errs, errors.New("database_file is required"))
+	}
+	if c.MaxTaskNum <= 0 {
+		errs = append(errs, fmt.Errorf("max_task_num error: %d", c.MaxTaskNum))
+	}
+	if c.PeckTaskLimit.MaxLinesPerSec < 0 || c.PeckTaskLimit.MaxBytesPerSec < 0 {
+		errs = append(errs, errors.New("peck_task_limit must not be negative"))
+	}
+	if c.SelfLog.Enable {
+		if _, err := NewSelfLogTaskConfig(c.LogFile, &c.SelfLog); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Resources.MemoryLimit != "" {
+		if _, err := ParseValueWithUnit(c.Resources.MemoryLimit, "B"); err != nil {
+			errs = append(errs, fmt.Errorf("memory_limit error: %s", err))
+		}
+	}
+	if c.Read.BufferSize < 0 || c.Read.MaxLineSize < 0 || c.Read.PollInterval < 0 {
+		errs = append(errs, errors.New("read sizes and poll_interval must not be negative"))
+	}
+	if c.Ready.MinSenders < 0 {
+		errs = append(errs, fmt.Errorf("min_senders error: %d", c.Ready.MinSenders))
+	}
+	if c.Reap.IdleTimeout < 0 || c.Reap.OffsetRetention < 0 {
+		errs = append(errs, errors.New("reap idle_timeout and offset_retention must not be negative"))
+	}
+	for name, sender := range c.Senders {
+		if _, err := parseSenderConfig(sender); err != nil {
+			errs = append(errs, fmt.Errorf("senders %s error: %s", name, err))
+		}
+	}
+	if c.TaskDefaults != "" {
+		if err := c.validateTaskDefaults(); err != nil {
+			errs = append(errs, fmt.Errorf("task_defaults error: %s", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func parseSenderConfig(sender string) (SenderConfig, error) {
+	raw, err := json.Marshal(map[string]json.RawMessage{"Sender": json.RawMessage(sender)})
+	if err != nil {
+		return SenderConfig{}, err
+	}
+	j, err := sjson.NewJson(raw)
+	if err != nil {
+		return SenderConfig{}, err
+	}
+	config, err := GetSenderConfig(j)
+	if err != nil {
+		return config, err
+	}
+	if _, ok := senderConfigTypes[strings.ToLower(config.Name)]; !ok {
+		return config, errors.New("sender name error: " + config.Name)
+	}
+	return config, nil
+}
+
+func (c *LogPeckConfig) validateTaskDefaults() error {
+	defaults, err := decodeTaskConfig([]byte(c.TaskDefaults))
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{"Name", "LogPath"} {
+		if _, ok := defaults[key]; ok {
+			return errors.New(key + " can't have a default")
+		}
+	}
+	raw, err := c.ExpandTaskConfig([]byte(`{"Name": "task_defaults"}`))
+	if err != nil {
+		return err
+	}
+	return (&PeckTaskConfig{}).UnmarshalStrict(raw)
+}
+
+// decodeTaskConfig decodes a json or yaml task config, keeping numbers as
+// they are
+func decodeTaskConfig(raw []byte) (map[string]interface{}, error) {
+	if !isJSONObject(raw) {
+		var err error
+		if raw, err = YAMLToJSON(raw); err != nil {
+			return nil, err
+		}
+	}
+	var config map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ExpandTaskConfig returns the task config raw with TaskDefaults merged in
+// and the Senders given by name replaced by their config
+func (c *LogPeckConfig) ExpandTaskConfig(raw []byte) ([]byte, error) {
+	if c.TaskDefaults == "" && len(c.Senders) == 0 {
+		return raw, nil
+	}
+	config, err := decodeTaskConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	if c.TaskDefaults != "" {
+		defaults, err := decodeTaskConfig([]byte(c.TaskDefaults))
+		if err != nil {
+			return nil, fmt.Errorf("task_defaults error: %s", err)
+		}
+		mergeTaskDefaults(config, defaults)
+	}
+	if err := c.expandSenders(config); err != nil {
+		return nil, err
+	}
+	return json.Marshal(config)
+}
+
+// mergeTaskDefaults sets the values of defaults missing in config, sections
+// set by both are merged except Sender, which is taken as a whole
+func mergeTaskDefaults(config, defaults map[string]interface{}) {
+	for key, value := range defaults {
+		current, ok := config[key]
+		if !ok || current == nil {
+			config[key] = value
+			continue
+		}
+		section, ok := current.(map[string]interface{})
+		sectionDefaults, isSection := value.(map[string]interface{})
+		if ok && isSection && key != "Sender" {
+			mergeTaskDefaults(section, sectionDefaults)
+		}
+	}
+}
+
+// expandSenders replaces each Sender of config which is a name, including
+// the ones of sections like Anomaly or Health
+func (c *LogPeckConfig) expandSenders(config map[string]interface{}) error {
+	for key, value := range config {
+		if name, ok := value.(string); ok && key == "Sender" {
+			sender, err := c.senderConfig(name)
+			if err != nil {
+				return err
+			}
+			config[key] = sender
+		} else if section, ok := value.(map[string]interface{}); ok {
+			if err := c.expandSenders(section); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *LogPeckConfig) senderConfig(name string) (map[string]interface{}, error) {
+	sender, ok := c.Senders[name]
+	if !ok {
+		return nil, errors.New("unknown sender " + name)
+	}
+	config, err := decodeTaskConfig([]byte(sender))
+	if err != nil {
+		return nil, fmt.Errorf("senders %s error: %s", name, err)
+	}
+	return config, nil
 }
@@ -0,0 +1,79 @@
+package logpeck
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// ConfigChange is a top level config section changed by an update
+type ConfigChange struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// ConfigDiff is what an update of a task would change. Restart tells a
+// running task would be restarted, ResetsAggregation that the state of a
+// changed Aggregator, Dedup or Correlate is flushed early and starts over.
+// ResetsOffset tells the updated LogPath is read from its end
+type ConfigDiff struct {
+	Name    string
+	Changes []ConfigChange
+
+	Restart           bool
+	ResetsOffset      bool
+	ResetsAggregation bool
+}
+
+func configSections(config *PeckTaskConfig) (map[string]interface{}, error) {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	sections := make(map[string]interface{})
+	err = json.Unmarshal(raw, &sections)
+	return sections, err
+}
+
+// DiffPeckTaskConfig compares the sections of two configs of a task, running
+// tells whether the task is started
+func DiffPeckTaskConfig(old, updated *PeckTaskConfig, running bool) (*ConfigDiff, error) {
+	oldSections, err := configSections(old)
+	if err != nil {
+		return nil, err
+	}
+	newSections, err := configSections(updated)
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	for field := range newSections {
+		fields = append(fields, field)
+	}
+	for field := range oldSections {
+		if _, ok := newSections[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	diff := &ConfigDiff{Name: updated.Name, Changes: []ConfigChange{}}
+	for _, field := range fields {
+		if !reflect.DeepEqual(oldSections[field], newSections[field]) {
+			diff.Changes = append(diff.Changes, ConfigChange{
+				Field: field,
+				Old:   oldSections[field],
+				New:   newSections[field],
+			})
+		}
+	}
+	diff.ResetsOffset = old.LogPath != updated.LogPath
+	if len(diff.Changes) > 0 && running {
+		diff.Restart = true
+		diff.ResetsAggregation = (old.Aggregator.Enable && !reflect.DeepEqual(old.Aggregator, updated.Aggregator)) ||
+			(old.Dedup.Enable && !reflect.DeepEqual(old.Dedup, updated.Dedup)) ||
+			(old.Correlate.Enable && !reflect.DeepEqual(old.Correlate, updated.Correlate))
+	}
+	return diff, nil
+}
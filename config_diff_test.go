@@ -0,0 +1,51 @@
+package logpeck
+
+import (
+	"testing"
+)
+
+func TestDiffPeckTaskConfig(*testing.T) {
+	old := &PeckTaskConfig{}
+	if err := old.Unmarshal([]byte(`{"Name":"nginx","LogPath":"/var/log/nginx.log","Keywords":"GET","Aggregator":{"Enable":true,"Interval":60}}`)); err != nil {
+		panic(err)
+	}
+	updated := &PeckTaskConfig{}
+	if err := updated.Unmarshal([]byte(`{"Name":"nginx","LogPath":"/var/log/nginx.log","Keywords":"POST","Aggregator":{"Enable":true,"Interval":60}}`)); err != nil {
+		panic(err)
+	}
+
+	diff, err := DiffPeckTaskConfig(old, updated, true)
+	if err != nil {
+		panic(err)
+	}
+	if len(diff.Changes) != 1 || diff.Changes[0].Field != "Keywords" || diff.Changes[0].Old != "GET" ||
+		!diff.Restart || diff.ResetsOffset || diff.ResetsAggregation {
+		panic(diff)
+	}
+	updated.Aggregator.Interval = 30
+	diff, err = DiffPeckTaskConfig(old, updated, true)
+	if err != nil {
+		panic(err)
+	}
+	if len(diff.Changes) != 2 || !diff.ResetsAggregation {
+		panic(diff)
+	}
+	updated.Aggregator.Interval = 60
+
+	diff, err = DiffPeckTaskConfig(old, old, true)
+	if err != nil {
+		panic(err)
+	}
+	if len(diff.Changes) != 0 || diff.Restart {
+		panic(diff)
+	}
+
+	updated.LogPath = "/var/log/nginx/access.log"
+	diff, err = DiffPeckTaskConfig(old, updated, false)
+	if err != nil {
+		panic(err)
+	}
+	if len(diff.Changes) != 2 || diff.Restart || !diff.ResetsOffset || diff.ResetsAggregation {
+		panic(diff)
+	}
+}
@@ -0,0 +1,127 @@
+package logpeck
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(*testing.T) {
+	config, err := LoadConfig("logpeckd.conf")
+	if err != nil {
+		panic(err)
+	}
+	if config.Address() != ":7117" {
+		panic(config.Address())
+	}
+
+	file := "test_logpeckd.conf"
+	defer os.Remove(file)
+	os.WriteFile(file, []byte(`
+listen = "127.0.0.1:7118"
+log_level = "info"
+database_file = "/tmp/logpeck.db"
+`), 0644)
+	config, err = LoadConfig(file)
+	if err != nil {
+		panic(err)
+	}
+	if config.Address() != "127.0.0.1:7118" || config.MaxTaskNum != 16 {
+		panic(config)
+	}
+
+	os.WriteFile(file, []byte(`
+listen = "7118"
+log_level = "verbose"
+max_task_num = 0
+databse_file = "/tmp/logpeck.db"
+
+[self_log]
+enable = true
+`), 0644)
+	_, err = LoadConfig(file)
+	if err == nil {
+		panic("bad config accepted")
+	}
+	for _, problem := range []string{"listen error", "log_level error", "max_task_num error", "unknown key databse_file", "self_log requires log_file"} {
+		if !strings.Contains(err.Error(), problem) {
+			panic(err)
+		}
+	}
+
+	os.WriteFile(file, []byte(`
+task_defaults = '{"Sender": "es", "Read": {"BufferSize": 4096}, "Extractor": {"Name": "json"}}'
+
+[senders]
+es = '{"Name": "ElasticSearch", "Config": {"Hosts": ["127.0.0.1:9200"], "Index": "logpeck", "Type": "log"}}'
+`), 0644)
+	if _, err = LoadConfig(file); err != nil {
+		panic(err)
+	}
+
+	os.WriteFile(file, []byte(`
+task_defaults = '{"Sender": "influx", "Raed": {"BufferSize": 4096}}'
+
+[senders]
+es = '{"Name": "ElasticSearch", "Config": {"Hosts": 9200}}'
+bad = '{"Name": "pigeon"}'
+`), 0644)
+	_, err = LoadConfig(file)
+	if err == nil {
+		panic("bad config accepted")
+	}
+	for _, problem := range []string{"senders es error", "senders bad error", "task_defaults error: unknown sender influx"} {
+		if !strings.Contains(err.Error(), problem) {
+			panic(err)
+		}
+	}
+	os.WriteFile(file, []byte(`
+task_defaults = '{"Raed": {"BufferSize": 4096}}'
+`), 0644)
+	if _, err = LoadConfig(file); err == nil || !strings.Contains(err.Error(), "unknown fields: Raed") {
+		panic(err)
+	}
+}
+
+func TestExpandTaskConfig(*testing.T) {
+	config := LogPeckConfig{
+		TaskDefaults: `{"Sender": "es", "Read": {"BufferSize": 4096, "MaxLineSize": 1024}}`,
+		Senders: map[string]string{
+			"es":    `{"Name": "ElasticSearch", "Config": {"Hosts": ["127.0.0.1:9200"], "Index": "logpeck", "Type": "log"}}`,
+			"kafka": `{"Name": "kafka", "Config": {"Brokers": ["127.0.0.1:9092"], "Topic": "logpeck"}}`,
+		},
+	}
+	raw, err := config.ExpandTaskConfig([]byte(`{"Name": "nginx", "Read": {"MaxLineSize": 2048}, "Health": {"Sender": "kafka"}}`))
+	if err != nil {
+		panic(err)
+	}
+	var task PeckTaskConfig
+	if err := task.UnmarshalStrict(raw); err != nil {
+		panic(err)
+	}
+	if task.Sender.Name != "ElasticSearch" || task.Health.Sender.Name != "kafka" ||
+		task.Read.BufferSize != 4096 || task.Read.MaxLineSize != 2048 {
+		panic(string(raw))
+	}
+
+	// a sender of the task is taken as a whole
+	raw, err = config.ExpandTaskConfig([]byte(`Name: nginx
+Sender:
+  Name: kafka
+  Config:
+    Brokers: ["127.0.0.1:9092"]
+    Topic: logpeck
+`))
+	if err != nil {
+		panic(err)
+	}
+	task = PeckTaskConfig{}
+	if err := task.UnmarshalStrict(raw); err != nil || task.Sender.Name != "kafka" {
+		panic(fmt.Sprint(string(raw), err))
+	}
+
+	if _, err := config.ExpandTaskConfig([]byte(`{"Name": "nginx", "Sender": "influx"}`)); err == nil {
+		panic("unknown sender")
+	}
+}
@@ -0,0 +1,131 @@
+package logpeck
+
+import (
+	"time"
+)
+
+type CorrelateConfig struct {
+	Enable      bool   `json:"Enable"`
+	KeyField    string `json:"KeyField"`
+	StatusField string `json:"StatusField"`
+	EndField    string `json:"EndField"`
+	Timeout     int64  `json:"Timeout"`
+	MaxSessions int    `json:"MaxSessions"`
+}
+
+type session struct {
+	start  time.Time
+	last   time.Time
+	count  int64
+	status string
+	fields map[string]interface{}
+}
+
+// Correlator merges events with the same KeyField value into one event,
+// a session is finished when EndField shows up or after Timeout seconds
+// without new events
+type Correlator struct {
+	config     CorrelateConfig
+	timeout    time.Duration
+	sessions   map[string]*session
+	lastExpire time.Time
+}
+
+func NewCorrelator(config *CorrelateConfig) *Correlator {
+	correlator := &Correlator{
+		config:   *config,
+		timeout:  time.Duration(config.Timeout) * time.Second,
+		sessions: make(map[string]*session),
+	}
+	if correlator.timeout <= 0 {
+		correlator.timeout = 30 * time.Second
+	}
+	if correlator.config.MaxSessions <= 0 {
+		correlator.config.MaxSessions = 10000
+	}
+	return correlator
+}
+
+func (p *Correlator) IsEnable() bool {
+	return p.config.Enable && p.config.KeyField != ""
+}
+
+func (p *Correlator) finish(key string, s *session) map[string]interface{} {
+	delete(p.sessions, key)
+	s.fields[p.config.KeyField] = key
+	s.fields["event_count"] = s.count
+	s.fields["duration_ms"] = int64(s.last.Sub(s.start) / time.Millisecond)
+	s.fields["start_ms"] = s.start.UnixNano() / 1000000
+	if p.config.StatusField != "" {
+		s.fields[p.config.StatusField] = s.status
+	}
+	return s.fields
+}
+
+// Correlate returns the combined events finished at time now, events
+// without KeyField are returned unchanged
+func (p *Correlator) Correlate(fields map[string]interface{}, now time.Time) []map[string]interface{} {
+	results := p.Expire(now)
+	key, ok := fields[p.config.KeyField].(string)
+	if !ok || key == "" {
+		if fields != nil {
+			results = append(results, fields)
+		}
+		return results
+	}
+	s, ok := p.sessions[key]
+	if !ok {
+		if len(p.sessions) >= p.config.MaxSessions {
+			results = append(results, p.evictOldest())
+		}
+		s = &session{start: now, fields: make(map[string]interface{})}
+		p.sessions[key] = s
+	}
+	s.last = now
+	s.count++
+	for k, v := range fields {
+		s.fields[k] = v
+	}
+	if status, ok := fields[p.config.StatusField].(string); ok && status != "" {
+		s.status = status
+	}
+	if end, ok := fields[p.config.EndField].(string); ok && end != "" {
+		results = append(results, p.finish(key, s))
+	}
+	return results
+}
+
+func (p *Correlator) evictOldest() map[string]interface{} {
+	var oldestKey string
+	var oldest *session
+	for k, s := range p.sessions {
+		if oldest == nil || s.last.Before(oldest.last) {
+			oldestKey, oldest = k, s
+		}
+	}
+	return p.finish(oldestKey, oldest)
+}
+
+// Flush finishes all sessions
+func (p *Correlator) Flush() []map[string]interface{} {
+	var results []map[string]interface{}
+	for k, s := range p.sessions {
+		results = append(results, p.finish(k, s))
+	}
+	return results
+}
+
+// Expire returns the sessions timed out at time now
+func (p *Correlator) Expire(now time.Time) []map[string]interface{} {
+	var results []map[string]interface{}
+	if now.Sub(p.lastExpire) < time.Second {
+		return results
+	}
+	p.lastExpire = now
+	for k, s := range p.sessions {
+		if now.Sub(s.last) >= p.timeout {
+			results = append(results, p.finish(k, s))
+		}
+	}
+	return results
+}
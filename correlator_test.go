@@ -0,0 +1,43 @@
+package logpeck
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCorrelator(*testing.T) {
+	correlator := NewCorrelator(&CorrelateConfig{
+		Enable:      true,
+		KeyField:    "request_id",
+		StatusField: "status",
+		EndField:    "end",
+		Timeout:     10,
+	})
+	now := time.Unix(100, 0)
+	if results := correlator.Correlate(map[string]interface{}{"request_id": "r1", "url": "/a", "status": "start"}, now); len(results) != 0 {
+		panic(results)
+	}
+	now = now.Add(200 * time.Millisecond)
+	if results := correlator.Correlate(map[string]interface{}{"request_id": "r2"}, now); len(results) != 0 {
+		panic(results)
+	}
+	results := correlator.Correlate(map[string]interface{}{"msg": "no key"}, now)
+	if len(results) != 1 || results[0]["msg"] != "no key" {
+		panic(results)
+	}
+	now = now.Add(300 * time.Millisecond)
+	results = correlator.Correlate(map[string]interface{}{"request_id": "r1", "status": "200", "end": "true"}, now)
+	if len(results) != 1 {
+		panic(results)
+	}
+	r1 := results[0]
+	if r1["event_count"] != int64(2) || r1["duration_ms"] != int64(500) || r1["status"] != "200" || r1["url"] != "/a" {
+		panic(r1)
+	}
+
+	now = now.Add(20 * time.Second)
+	results = correlator.Expire(now)
+	if len(results) != 1 || results[0]["request_id"] != "r2" || results[0]["event_count"] != int64(1) {
+		panic(results)
+	}
+}
@@ -0,0 +1,135 @@
+package logpeck
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultDedupCountField string = "repeat_count"
+
+// dedupExpireInterval is how often a running task sends the repeat counts of
+// the finished windows
+const dedupExpireInterval = time.Second
+
+type DedupConfig struct {
+	Enable     bool     `json:"Enable"`
+	Fields     []string `json:"Fields"`
+	Window     int64    `json:"Window"`
+	CountField string   `json:"CountField"`
+}
+
+type dedupEntry struct {
+	first time.Time
+	count int64
+	last  map[string]interface{}
+}
+
+// Deduplicator suppresses events with the same key fields during Window
+// seconds after the first one, the last suppressed event is emitted with the
+// repeat count when the window expires
+type Deduplicator struct {
+	config DedupConfig
+	window time.Duration
+
+	mu         sync.Mutex
+	entries    map[uint64]*dedupEntry
+	lastExpire time.Time
+}
+
+func NewDeduplicator(config *DedupConfig) *Deduplicator {
+	dedup := &Deduplicator{
+		config:  *config,
+		window:  time.Duration(config.Window) * time.Second,
+		entries: make(map[uint64]*dedupEntry),
+	}
+	if dedup.config.CountField == "" {
+		dedup.config.CountField = defaultDedupCountField
+	}
+	if dedup.window <= 0 {
+		dedup.window = time.Minute
+	}
+	return dedup
+}
+
+func (p *Deduplicator) IsEnable() bool {
+	return p.config.Enable
+}
+
+func (p *Deduplicator) hash(fields map[string]interface{}) uint64 {
+	keys := p.config.Fields
+	if len(keys) == 0 {
+		keys = make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	h := fnv.New64a()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%v\x00", k, fields[k])
+	}
+	return h.Sum64()
+}
+
+// Dedup returns the events which should be sent at time now
+func (p *Deduplicator) Dedup(fields map[string]interface{}, now time.Time) []map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	results := p.expire(now)
+	if fields == nil {
+		return results
+	}
+	key := p.hash(fields)
+	if entry, ok := p.entries[key]; ok {
+		entry.count++
+		entry.last = fields
+		return results
+	}
+	p.entries[key] = &dedupEntry{first: now}
+	fields[p.config.CountField] = int64(1)
+	return append(results, fields)
+}
+
+// Flush returns the summary events of all windows, finished or not
+func (p *Deduplicator) Flush() []map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var results []map[string]interface{}
+	for key, entry := range p.entries {
+		if entry.count > 0 {
+			entry.last[p.config.CountField] = entry.count
+			results = append(results, entry.last)
+		}
+		delete(p.entries, key)
+	}
+	return results
+}
+
+// Expire returns the summary events of windows which are finished
+func (p *Deduplicator) Expire(now time.Time) []map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.expire(now)
+}
+
+func (p *Deduplicator) expire(now time.Time) []map[string]interface{} {
+	var results []map[string]interface{}
+	if now.Sub(p.lastExpire) < time.Second {
+		return results
+	}
+	p.lastExpire = now
+	for key, entry := range p.entries {
+		if now.Sub(entry.first) < p.window {
+			continue
+		}
+		if entry.count > 0 {
+			entry.last[p.config.CountField] = entry.count
+			results = append(results, entry.last)
+		}
+		delete(p.entries, key)
+	}
+	return results
+}
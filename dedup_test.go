@@ -0,0 +1,154 @@
+package logpeck
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDedup(*testing.T) {
+	dedup := NewDeduplicator(&DedupConfig{
+		Enable: true,
+		Fields: []string{"msg"},
+		Window: 10,
+	})
+	now := time.Unix(100, 0)
+	results := dedup.Dedup(map[string]interface{}{"msg": "error", "id": "1"}, now)
+	if len(results) != 1 || results[0]["repeat_count"] != int64(1) {
+		panic(results)
+	}
+	for i := 0; i < 5; i++ {
+		now = now.Add(time.Second)
+		results = dedup.Dedup(map[string]interface{}{"msg": "error", "id": "2"}, now)
+		if len(results) != 0 {
+			panic(results)
+		}
+	}
+	results = dedup.Dedup(map[string]interface{}{"msg": "other"}, now)
+	if len(results) != 1 || results[0]["msg"] != "other" {
+		panic(results)
+	}
+
+	now = now.Add(10 * time.Second)
+	results = dedup.Expire(now)
+	if len(results) != 1 || results[0]["repeat_count"] != int64(5) || results[0]["id"] != "2" {
+		panic(results)
+	}
+	results = dedup.Dedup(map[string]interface{}{"msg": "error"}, now)
+	if len(results) != 1 || results[0]["repeat_count"] != int64(1) {
+		panic(results)
+	}
+}
+
+func TestPeckTaskDedupExpire(*testing.T) {
+	h, err := NewHarness([]byte(`{
+		"Name": "DedupLog",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Dedup": {"Enable": true, "Fields": ["_Log"], "Window": 1},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &countSender{events: make(chan map[string]interface{}, 10)}
+	h.Task.sender = sender
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := h.Task.Start(ctx); err != nil {
+		panic(err)
+	}
+	for i := 0; i < 3; i++ {
+		h.Task.ProcessLine("error", "")
+	}
+	for _, count := range []int64{1, 2} {
+		select {
+		case event := <-sender.events:
+			if event["repeat_count"] != count {
+				panic(event)
+			}
+		case <-time.After(3 * time.Second):
+			panic("repeat count not sent without new lines")
+		}
+	}
+}
+
+type countSender struct {
+	events chan map[string]interface{}
+}
+
+func (p *countSender) Start(ctx context.Context) error { return nil }
+func (p *countSender) Stop() error                     { return nil }
+func (p *countSender) Send(fields map[string]interface{}) {
+	p.events <- fields
+}
+
+func TestPeckTaskDedupExpireConcurrent(*testing.T) {
+	h, err := NewHarness([]byte(`{
+		"Name": "DedupLog",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Dedup": {"Enable": true, "Fields": ["_Log"], "Window": 1},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &serialSender{}
+	h.Task.sender = sender
+	done := make(chan struct{})
+	end := time.Now().Add(200 * time.Millisecond)
+	go func() {
+		defer close(done)
+		for time.Now().Before(end) {
+			h.Task.ProcessLine("error", "")
+			runtime.Gosched()
+		}
+	}()
+	for now := time.Now(); time.Now().Before(end); {
+		now = now.Add(time.Second)
+		h.Task.sendExpiredDedup(now)
+		time.Sleep(2 * time.Millisecond)
+	}
+	<-done
+	if atomic.LoadInt32(&sender.overlapped) != 0 || len(sender.events) == 0 {
+		panic("sends not serialized")
+	}
+}
+
+// serialSender records whether Send was called concurrently, like
+// eventSender it is not safe for concurrent use
+type serialSender struct {
+	eventSender
+	sending    int32
+	overlapped int32
+}
+
+func (p *serialSender) Send(fields map[string]interface{}) {
+	if atomic.AddInt32(&p.sending, 1) > 1 {
+		atomic.StoreInt32(&p.overlapped, 1)
+	}
+	time.Sleep(time.Millisecond)
+	p.eventSender.Send(fields)
+	atomic.AddInt32(&p.sending, -1)
+}
+
+func TestPeckTaskDedupExpirePanic(*testing.T) {
+	h, err := NewHarness([]byte(`{
+		"Name": "DedupLog",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Dedup": {"Enable": true, "Fields": ["_Log"], "Window": 1},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	h.Task.sender = &eventSender{}
+	h.Task.ProcessLine("boom", "")
+	h.Task.ProcessLine("boom", "")
+	h.Task.sender = &panicSender{}
+	h.Task.sendExpiredDedup(time.Now().Add(2 * time.Second))
+	if failed, _ := h.Task.Failure(); !failed {
+		panic("panic of an expired dedup send did not fail the task")
+	}
+}
@@ -0,0 +1,89 @@
+package logpeck
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DirectoryConfig pecks the files in the directory LogPath, and in its sub
+// directories if Recursive. Include and Exclude are glob patterns, matched
+// against the path relative to LogPath if they have a "/", against the file
+// name otherwise. A file is pecked if it matches an Include pattern (all
+// files if Include is empty) and no Exclude pattern, an excluded directory
+// is not walked. All tasks of a LogPath have the same Directory
+type DirectoryConfig struct {
+	Enable    bool     `json:"Enable"`
+	Recursive bool     `json:"Recursive"`
+	Include   []string `json:"Include"`
+	Exclude   []string `json:"Exclude"`
+}
+
+func (c *DirectoryConfig) Validate(logPath string) error {
+	if !c.Enable {
+		return nil
+	}
+	if IsGlobPath(logPath) {
+		return errors.New("Directory error: LogPath must be a directory, not a glob pattern")
+	}
+	for _, pattern := range append(append([]string{}, c.Include...), c.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return errors.New("Directory error: " + pattern + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// matchDirPattern reports whether the file at rel, relative to the
+// directory, matches one of patterns
+func matchDirPattern(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := filepath.Base(rel)
+		if strings.Contains(pattern, "/") {
+			name = filepath.ToSlash(rel)
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Files returns the files to peck in the directory root, sorted
+func (c *DirectoryConfig) Files(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			// unreadable entries are skipped
+			return nil
+		}
+		if path == root {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		if d.IsDir() {
+			if !c.Recursive || matchDirPattern(c.Exclude, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || matchDirPattern(c.Exclude, rel) {
+			return nil
+		}
+		if len(c.Include) == 0 || matchDirPattern(c.Include, rel) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	sort.Strings(files)
+	return files, err
+}
@@ -0,0 +1,92 @@
+package logpeck
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDirectoryFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.txt", "app/c.log", "app/old/d.log", "tmp/e.log"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+	for _, c := range []struct {
+		config   DirectoryConfig
+		expected []string
+	}{
+		{DirectoryConfig{Enable: true}, []string{"a.log", "b.txt"}},
+		{DirectoryConfig{Enable: true, Recursive: true, Include: []string{"*.log"}}, []string{"a.log", "app/c.log", "app/old/d.log", "tmp/e.log"}},
+		{DirectoryConfig{Enable: true, Recursive: true, Include: []string{"*.log"}, Exclude: []string{"tmp", "app/old"}}, []string{"a.log", "app/c.log"}},
+		{DirectoryConfig{Enable: true, Recursive: true, Include: []string{"app/*.log"}}, []string{"app/c.log"}},
+	} {
+		files, err := c.config.Files(dir)
+		if err != nil {
+			panic(err)
+		}
+		for i := range files {
+			files[i], _ = filepath.Rel(dir, files[i])
+		}
+		if !reflect.DeepEqual(files, c.expected) {
+			panic(files)
+		}
+	}
+	if files, err := (&DirectoryConfig{Enable: true}).Files(filepath.Join(dir, "missing")); err != nil || len(files) != 0 {
+		panic(err)
+	}
+	if err := (&DirectoryConfig{Enable: true}).Validate(dir + "/*.log"); err == nil {
+		panic("glob directory accepted")
+	}
+	if err := (&DirectoryConfig{Enable: true, Include: []string{"["}}).Validate(dir); err == nil {
+		panic("bad pattern accepted")
+	}
+}
+
+func TestLogTaskDirectory(t *testing.T) {
+	interval := globScanInterval
+	globScanInterval = 50 * time.Millisecond
+	defer func() { globScanInterval = interval }()
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "app"), 0755)
+
+	h, err := NewHarness([]byte(`{
+		"Name": "DirLog",
+		"LogPath": "` + dir + `",
+		"Directory": {"Enable": true, "Recursive": true, "Include": ["*.log"]},
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &chanSender{lines: make(chan interface{}, 10)}
+	h.Task.sender = sender
+	task := NewLogTask(dir)
+	task.SetDirectory(&h.Task.Config.Directory)
+	task.AddPeckTask(h.Task)
+	if err := task.Start(context.Background()); err != nil {
+		panic(err)
+	}
+	defer task.Stop()
+	for i := 0; i < 100 && !task.Pending(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	os.WriteFile(filepath.Join(dir, "app", "a.log"), []byte("line a\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "app", "a.txt"), []byte("ignored\n"), 0644)
+	select {
+	case line := <-sender.lines:
+		if line != "line a" {
+			panic(line)
+		}
+	case <-time.After(3 * time.Second):
+		panic("line of new file not read")
+	}
+	stats := task.FileStats()
+	if len(stats) != 1 || stats[filepath.Join(dir, "app", "a.log")].LinesTotal != 1 {
+		panic(stats)
+	}
+}
@@ -0,0 +1,90 @@
+package logpeck
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DryRunEvent is an event a task would have sent, Sender is "Sender",
+// "Anomaly", "Health", "Schema" or "Alert"
+type DryRunEvent struct {
+	Sender string
+	Event  map[string]interface{}
+}
+
+// DryRun records the events of a task instead of sending them, it stands
+// for all senders and the alert webhook of the task
+type DryRun struct {
+	mu     sync.Mutex
+	events []DryRunEvent
+}
+
+type dryRunSender struct {
+	run  *DryRun
+	name string
+}
+
+func (p *dryRunSender) Start(ctx context.Context) error { return nil }
+func (p *dryRunSender) Stop() error                     { return nil }
+func (p *dryRunSender) Send(fields map[string]interface{}) {
+	p.run.record(p.name, fields)
+}
+func (p *dryRunSender) SendAck(fields map[string]interface{}) error {
+	p.run.record(p.name, fields)
+	return nil
+}
+
+func (p *DryRun) record(sender string, fields map[string]interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, DryRunEvent{Sender: sender, Event: fields})
+}
+
+// Take returns the events recorded since the previous call
+func (p *DryRun) Take() []DryRunEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	events := p.events
+	p.events = nil
+	return events
+}
+
+// NewDryRunPeckTask builds a running task from config which never sends:
+// no sender is created and the events it would send are recorded in the
+// returned DryRun
+func NewDryRunPeckTask(config *PeckTaskConfig) (*PeckTask, *DryRun, error) {
+	run := &DryRun{}
+	c := *config
+	targets := map[*SenderConfig]string{
+		&c.Sender:         "Sender",
+		&c.Anomaly.Sender: "Anomaly",
+		&c.Health.Sender:  "Health",
+		&c.Schema.Sender:  "Schema",
+	}
+	task, err := newPeckTask(&c, nil, func(senderConfig *SenderConfig) (Sender, error) {
+		return &dryRunSender{run: run, name: targets[senderConfig]}, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	task.alerter.notify = func(alert *Alert) {
+		run.record("Alert", map[string]interface{}{
+			"Task":      alert.Task,
+			"Rule":      alert.Rule,
+			"Series":    alert.Series,
+			"Value":     alert.Value,
+			"Threshold": alert.Threshold,
+			"Timestamp": alert.Timestamp,
+		})
+	}
+	task.setStop(false)
+	return task, run, nil
+}
+
+// Flush sends what the task holds in its correlator, dedup and aggregators
+// as if it was stopped now
+func (p *DryRun) Flush(task *PeckTask) []DryRunEvent {
+	task.flush(time.Now())
+	return p.Take()
+}
@@ -0,0 +1,43 @@
+package logpeck
+
+import (
+	"testing"
+)
+
+func TestDryRunPeckTask(*testing.T) {
+	config := &PeckTaskConfig{}
+	err := config.Unmarshal([]byte(`{
+		"Name": "DryRunLog",
+		"LogPath": "/tmp/dry_run.log",
+		"Extractor": {"Name": "text", "Config": {"Fields": [{"Name": "ts", "Value": "$1"}, {"Name": "cost", "Value": "$2"}]}},
+		"Sender": {"Name": "influxdb", "Config": {"Hosts": "127.0.0.1:1", "Database": "db"}},
+		"Aggregator": {"Enable": true, "Interval": 60, "Options": [
+			{"PreMeasurment": "api", "Measurment": "_default", "Target": "cost", "Aggregations": ["cnt"], "Timestamp": "ts"}
+		]}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	task, run, err := NewDryRunPeckTask(config)
+	if err != nil {
+		panic(err)
+	}
+	if _, ok := task.sender.(*dryRunSender); !ok {
+		panic(task.sender)
+	}
+	task.Process("1 10")
+	task.Process("2 20")
+	if sent := run.Take(); len(sent) != 0 {
+		panic(sent)
+	}
+	task.Process("60 30")
+	sent := run.Take()
+	if len(sent) != 1 || sent[0].Sender != "Sender" || sent[0].Event["api_cost"] == nil {
+		panic(sent)
+	}
+	task.Process("61 40")
+	sent = run.Flush(task)
+	if len(sent) != 1 || sent[0].Event["api_cost"] == nil {
+		panic(sent)
+	}
+}
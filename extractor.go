@@ -11,6 +11,8 @@ const (
 	ExTypeLua  = "lua"
 	ExTypeJson = "json"
 	ExTypeText = "text"
+
+	ExTypeLogrus = "logrus"
 )
 
 type Extractor interface {
@@ -18,6 +20,13 @@ type Extractor interface {
 	Close()
 }
 
+// ExtractorDiagnoser is implemented by extractors which can explain an
+// extraction in test mode, it returns the configured fields not found in
+// content and the part of content no field was extracted from
+type ExtractorDiagnoser interface {
+	Diagnose(content string, fields map[string]interface{}) (missing []string, remainder string)
+}
+
 func NewExtractorConfig(configStr string) (ExtractorConfig, error) {
 	c := ExtractorConfig{}
 	j, err := sjson.NewJson([]byte(configStr))
@@ -37,6 +46,7 @@ func NewExtractorConfig(configStr string) (ExtractorConfig, error) {
 		c.Config, err = NewJsonExtractorConfig(jbyte)
 	case ExTypeText:
 		c.Config, err = NewTextExtractorConfig(jbyte)
+	case ExTypeLogrus:
 	default:
 		err = errors.New("extractor name error: " + c.Name)
 	}
@@ -53,6 +63,8 @@ func NewExtractor(c ExtractorConfig) (e Extractor, err error) {
 		e, err = NewJsonExtractor(c.Config)
 	case ExTypeText:
 		e, err = NewTextExtractor(c.Config)
+	case ExTypeLogrus:
+		e, err = NewLogrusExtractor(c.Config)
 	default:
 		err = errors.New("extractor name error: " + c.Name)
 	}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	sjson "github.com/bitly/go-simplejson"
+	"sort"
+	"strings"
 )
 
 type JsonExtractorConfig struct {
@@ -71,12 +73,46 @@ func (je JsonExtractor) Extract(content string) (map[string]interface{}, error)
 				}
 				break
 			}
-			tmp = tmp[key[i]].(map[string]interface{})
+			next, ok := tmp[key[i]].(map[string]interface{})
+			if !ok {
+				value = fmt.Sprintf("unknown type %v", tmp[key[i]])
+				break
+			}
+			tmp = next
 		}
 		fields[field] = value
 	}
 	return fields, nil
 }
 
+// Diagnose reports fields of unknown type as missing, the remainder is the
+// json of the top level keys no field was read from
+func (je JsonExtractor) Diagnose(content string, fields map[string]interface{}) ([]string, string) {
+	var missing []string
+	used := make(map[string]bool)
+	for field := range je.fields {
+		if v, ok := fields[field].(string); !ok || strings.HasPrefix(v, "unknown type") {
+			missing = append(missing, field)
+		}
+		used[SplitString(field, ".")[0]] = true
+	}
+	sort.Strings(missing)
+	var mContent map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &mContent); err != nil {
+		return missing, content
+	}
+	rest := make(map[string]interface{})
+	for k, v := range mContent {
+		if !used[k] {
+			rest[k] = v
+		}
+	}
+	if len(rest) == 0 {
+		return missing, ""
+	}
+	remainder, _ := json.Marshal(rest)
+	return missing, string(remainder)
+}
+
 func (je JsonExtractor) Close() {
 }
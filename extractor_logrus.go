@@ -0,0 +1,65 @@
+package logpeck
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// LogrusExtractor parses lines written by the logrus text formatter, e.g.
+// `time="..." level=info msg="[Pecker] Start"`. Besides the key/value pairs
+// of the line, the "[Component]" prefix of msg is split into component and
+// message
+type LogrusExtractor struct {
+}
+
+func NewLogrusExtractor(config interface{}) (LogrusExtractor, error) {
+	return LogrusExtractor{}, nil
+}
+
+func (le LogrusExtractor) Extract(content string) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	rest := strings.TrimSpace(content)
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return map[string]interface{}{"_Log": content}, errors.New("logrus format error")
+		}
+		key := rest[:eq]
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return map[string]interface{}{"_Log": content}, err
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else if end := strings.IndexByte(rest, ' '); end >= 0 {
+			value = rest[:end]
+			rest = rest[end:]
+		} else {
+			value = rest
+			rest = ""
+		}
+		fields[key] = value
+		rest = strings.TrimLeft(rest, " ")
+	}
+	if msg, ok := fields["msg"].(string); ok {
+		delete(fields, "msg")
+		fields["message"] = msg
+		if strings.HasPrefix(msg, "[") {
+			if end := strings.IndexByte(msg, ']'); end > 0 {
+				component := strings.Fields(msg[1:end])
+				if len(component) > 0 {
+					fields["component"] = component[0]
+				}
+				fields["message"] = strings.TrimSpace(msg[end+1:])
+			}
+		}
+	}
+	return fields, nil
+}
+
+func (le LogrusExtractor) Close() {
+}
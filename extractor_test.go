@@ -156,3 +156,44 @@ func TestJsonExtractor(*testing.T) {
 	}
 	fmt.Printf("[Extract] %#v\n", m)
 }
+
+func TestLogrusExtractor(*testing.T) {
+	config, err := NewExtractorConfig(`{"Name":"logrus"}`)
+	if err != nil {
+		panic(err)
+	}
+	extractor, err := NewExtractor(config)
+	if err != nil {
+		panic(err)
+	}
+	fields, err := extractor.Extract(`time="2017-01-02T15:04:05+08:00" level=warning msg="[PeckTask nginx] Stop error, err[\"x\"]" task=nginx`)
+	if err != nil {
+		panic(err)
+	}
+	if fields["level"] != "warning" || fields["component"] != "PeckTask" ||
+		fields["message"] != `Stop error, err["x"]` || fields["task"] != "nginx" ||
+		fields["time"] != "2017-01-02T15:04:05+08:00" {
+		panic(fields)
+	}
+	if _, err := extractor.Extract("not a logrus line"); err == nil {
+		panic("plain line should fail")
+	}
+
+	taskConfig, err := NewSelfLogTaskConfig("/var/log/logpeckd.log", &SelfLogConfig{
+		Level:  "error",
+		Sender: `{"Name":"ElasticSearch","Config":{"Hosts":["127.0.0.1:9200"],"Index":"logpeck","Type":"log"}}`,
+	})
+	if err != nil {
+		panic(err)
+	}
+	task, err := NewPeckTask(taskConfig, nil)
+	if err != nil {
+		panic(err)
+	}
+	if !task.filter.Drop(`level=warning msg="x"`) || task.filter.Drop(`level=error msg="x"`) {
+		panic(taskConfig.Keywords)
+	}
+	if _, err := NewSelfLogTaskConfig("/var/log/logpeckd.log", &SelfLogConfig{Level: "trace", Sender: "{}"}); err == nil {
+		panic("unknown level should fail")
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	log "github.com/Sirupsen/logrus"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 type TextExtractorConfig struct {
@@ -37,11 +39,11 @@ func NewTextExtractor(config interface{}) (TextExtractor, error) {
 	}
 	log.Info(c.Fields)
 	for _, f := range c.Fields {
-		if f.Value[0] != '$' {
+		if len(f.Value) == 0 || f.Value[0] != '$' {
 			return e, errors.New("field format error: " + f.Value)
 		}
 		pos, err := strconv.Atoi(f.Value[1:])
-		if err != nil {
+		if err != nil || pos < 1 {
 			return e, errors.New("field format error: " + f.Value)
 		}
 		e.fields[f.Name] = pos
@@ -65,5 +67,25 @@ func (te TextExtractor) Extract(content string) (map[string]interface{}, error)
 	return fields, nil
 }
 
+func (te TextExtractor) Diagnose(content string, fields map[string]interface{}) ([]string, string) {
+	arr := SplitString(content, te.config.Delimiters)
+	used := make(map[int]bool)
+	var missing []string
+	for k, v := range te.fields {
+		if _, ok := fields[k]; !ok {
+			missing = append(missing, k)
+		}
+		used[v] = true
+	}
+	sort.Strings(missing)
+	var rest []string
+	for i, part := range arr {
+		if !used[i+1] {
+			rest = append(rest, part)
+		}
+	}
+	return missing, strings.Join(rest, " ")
+}
+
 func (te TextExtractor) Close() {
 }
@@ -0,0 +1,53 @@
+package logpeck
+
+import (
+	"strings"
+)
+
+// FieldSelectConfig limits the fields of events sent by a task. Include
+// lists the only fields kept, Exclude the fields removed, a name ending with
+// "*" matches the fields starting with the rest of it
+type FieldSelectConfig struct {
+	Include []string `json:"Include"`
+	Exclude []string `json:"Exclude"`
+}
+
+type FieldSelector struct {
+	config FieldSelectConfig
+}
+
+func NewFieldSelector(config *FieldSelectConfig) *FieldSelector {
+	return &FieldSelector{config: *config}
+}
+
+func (p *FieldSelector) IsEnable() bool {
+	return len(p.config.Include) > 0 || len(p.config.Exclude) > 0
+}
+
+func matchFieldName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, pattern[:len(pattern)-1]) {
+				return true
+			}
+		} else if pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns a copy of event with the selected fields
+func (p *FieldSelector) Select(event map[string]interface{}) map[string]interface{} {
+	selected := make(map[string]interface{}, len(event))
+	for k, v := range event {
+		if len(p.config.Include) > 0 && !matchFieldName(p.config.Include, k) {
+			continue
+		}
+		if matchFieldName(p.config.Exclude, k) {
+			continue
+		}
+		selected[k] = v
+	}
+	return selected
+}
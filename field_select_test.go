@@ -0,0 +1,25 @@
+package logpeck
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldSelector(*testing.T) {
+	event := map[string]interface{}{"user": "u1", "password": "x", "http_code": 200, "http_path": "/", "_Log": "line"}
+	if NewFieldSelector(&FieldSelectConfig{}).IsEnable() {
+		panic("enabled without config")
+	}
+	selector := NewFieldSelector(&FieldSelectConfig{Include: []string{"user", "http_*"}, Exclude: []string{"http_path"}})
+	got := selector.Select(event)
+	if !reflect.DeepEqual(got, map[string]interface{}{"user": "u1", "http_code": 200}) {
+		panic(got)
+	}
+	if len(event) != 5 {
+		panic(event)
+	}
+	got = NewFieldSelector(&FieldSelectConfig{Exclude: []string{"password", "_*"}}).Select(event)
+	if !reflect.DeepEqual(got, map[string]interface{}{"user": "u1", "http_code": 200, "http_path": "/"}) {
+		panic(got)
+	}
+}
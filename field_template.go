@@ -0,0 +1,87 @@
+package logpeck
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var fieldTemplateVar *regexp.Regexp = regexp.MustCompile(`\$\{(\w+)\}|\{(\w+)\}`)
+
+// FieldTemplate computes a field value at send time. "${NAME}" is replaced
+// by the environment variable NAME when the template is parsed, "{name}" by
+// the event field name or one of the builtins "host", "host_prefix" and
+// "task" when the event is sent
+type FieldTemplate struct {
+	literals []string
+	vars     []string
+}
+
+func NewFieldTemplate(template string) *FieldTemplate {
+	template = fieldTemplateVar.ReplaceAllStringFunc(template, func(m string) string {
+		if strings.HasPrefix(m, "$") {
+			return os.Getenv(m[2 : len(m)-1])
+		}
+		return m
+	})
+	t := &FieldTemplate{}
+	last := 0
+	for _, loc := range fieldTemplateVar.FindAllStringSubmatchIndex(template, -1) {
+		t.literals = append(t.literals, template[last:loc[0]])
+		t.vars = append(t.vars, template[loc[4]:loc[5]])
+		last = loc[1]
+	}
+	t.literals = append(t.literals, template[last:])
+	return t
+}
+
+func (t *FieldTemplate) Execute(fields map[string]interface{}, builtins map[string]string) string {
+	result := t.literals[0]
+	for i, name := range t.vars {
+		if v, ok := fields[name]; ok {
+			result += fmt.Sprintf("%v", v)
+		} else {
+			result += builtins[name]
+		}
+		result += t.literals[i+1]
+	}
+	return result
+}
+
+// FieldTemplates sets templated fields on every event a task sends
+type FieldTemplates struct {
+	templates map[string]*FieldTemplate
+	builtins  map[string]string
+}
+
+func NewFieldTemplates(task string, templates map[string]string) *FieldTemplates {
+	host := GetHost()
+	p := &FieldTemplates{
+		templates: make(map[string]*FieldTemplate),
+		builtins: map[string]string{
+			"host":        host,
+			"host_prefix": strings.FieldsFunc(host+".", func(r rune) bool { return r == '.' || r == '-' })[0],
+			"task":        task,
+		},
+	}
+	for name, template := range templates {
+		p.templates[name] = NewFieldTemplate(template)
+	}
+	return p
+}
+
+func (p *FieldTemplates) IsEnable() bool {
+	return len(p.templates) > 0
+}
+
+// Apply sets the templated fields, they replace event fields of the same name
+func (p *FieldTemplates) Apply(fields map[string]interface{}) {
+	values := make(map[string]string, len(p.templates))
+	for name, template := range p.templates {
+		values[name] = template.Execute(fields, p.builtins)
+	}
+	for name, value := range values {
+		fields[name] = value
+	}
+}
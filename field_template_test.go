@@ -0,0 +1,27 @@
+package logpeck
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFieldTemplates(*testing.T) {
+	os.Setenv("LOGPECK_TEST_ENV", "prod")
+	templates := NewFieldTemplates("nginx", map[string]string{
+		"env":     "${LOGPECK_TEST_ENV}",
+		"cluster": "{host_prefix}",
+		"source":  "{task}/{module}:{missing}",
+	})
+	templates.builtins["host_prefix"] = "web"
+	fields := map[string]interface{}{"module": "api", "env": "dev"}
+	templates.Apply(fields)
+	if fields["env"] != "prod" || fields["cluster"] != "web" || fields["source"] != "nginx/api:" {
+		panic(fields)
+	}
+	if NewFieldTemplates("t", nil).IsEnable() {
+		panic("enabled without templates")
+	}
+	if v := NewFieldTemplate("plain").Execute(nil, nil); v != "plain" {
+		panic(v)
+	}
+}
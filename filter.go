@@ -50,3 +50,27 @@ func (p *PeckFilter) Drop(str string) bool {
 	SplitString("", "")
 	return false
 }
+
+// DropReason returns the keywords dropping str, the include keywords
+// ("a|b") when none of them is found or the exclude keyword ("^c") found,
+// empty if str is kept
+func (p *PeckFilter) DropReason(str string) string {
+	if p.have_incl {
+		found := false
+		for _, f := range p.incl {
+			if strings.Contains(str, f) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return strings.Join(p.incl, "|")
+		}
+	}
+	for _, f := range p.excl {
+		if strings.Contains(str, f) {
+			return "^" + f
+		}
+	}
+	return ""
+}
@@ -65,3 +65,19 @@ func TestDrop(*testing.T) {
 		panic(filter)
 	}
 }
+
+func TestDropReason(*testing.T) {
+	filter := NewPeckFilter("hello|world|^debug")
+	if reason := filter.DropReason("hello there"); reason != "" {
+		panic(reason)
+	}
+	if reason := filter.DropReason("bye"); reason != "hello|world" {
+		panic(reason)
+	}
+	if reason := filter.DropReason("hello debug"); reason != "^debug" {
+		panic(reason)
+	}
+	if reason := NewPeckFilter("").DropReason("anything"); reason != "" {
+		panic(reason)
+	}
+}
@@ -0,0 +1,63 @@
+package logpeck
+
+import (
+	"testing"
+)
+
+func FuzzTextExtractor(f *testing.F) {
+	f.Add(" ", "$1", "GET /index.html 200")
+	f.Add("|", "$3", "a|b|c|d")
+	f.Add("", "$0", "")
+	f.Add(",", "", "x,y")
+	f.Fuzz(func(t *testing.T, delimiters, value, content string) {
+		extractor, err := NewTextExtractor(TextExtractorConfig{
+			Delimiters: delimiters,
+			Fields:     []PeckField{{Name: "f", Value: value}},
+		})
+		if err != nil {
+			return
+		}
+		if fields, err := extractor.Extract(content); err == nil && fields == nil {
+			t.Fatal("nil fields without error")
+		}
+	})
+}
+
+func FuzzJsonExtractor(f *testing.F) {
+	f.Add("k1", `{"k1":"v1"}`)
+	f.Add("k2.1", `{"k2":{"1":2}}`)
+	f.Add("k2.1.x", `{"k2":{"1":"v"}}`)
+	f.Add("", `[1,2]`)
+	f.Add("a..b", `{"a":null}`)
+	f.Fuzz(func(t *testing.T, field, content string) {
+		extractor, err := NewJsonExtractor(JsonExtractorConfig{
+			Fields: []PeckField{{Name: field}},
+		})
+		if err != nil {
+			return
+		}
+		if fields, err := extractor.Extract(content); err == nil && fields == nil {
+			t.Fatal("nil fields without error")
+		}
+	})
+}
+
+func FuzzPeckTaskConfigUnmarshal(f *testing.F) {
+	f.Add(`{"Name":"TestLog"}`)
+	f.Add(`{"Name":"TestLog","LogPath":"test.log","Extractor":{"Name":"text","Config":{"Fields":[{"Name":"a","Value":"$1"}]}}}`)
+	f.Add(`{"Name":"TestLog","Sender":{"Name":"elasticsearch","Config":{"Hosts":["127.0.0.1:9200"],"Index":"i"}}}`)
+	f.Add(`{"Name":"TestLog","Aggregator":{"Enable":true,"Interval":30,"Options":[{"Target":"cost","Aggregations":["p99"]}]}}`)
+	f.Add(`{"Name":1,"Extractor":[],"Sender":"x","Test":{"TestNum":"a"}}`)
+	f.Fuzz(func(t *testing.T, config string) {
+		var c PeckTaskConfig
+		if err := c.Unmarshal([]byte(config)); err != nil {
+			return
+		}
+		if err := c.Aggregator.Validate(); err != nil {
+			return
+		}
+		if _, err := NewExtractor(c.Extractor); err != nil {
+			return
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package logpeck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LoadGovernorConfig slows tailing down to ThrottledLinesPerSec per log
+// while the host is loaded: the 1 minute load average per CPU is above
+// MaxLoad or the iowait percent is above MaxIowait
+type LoadGovernorConfig struct {
+	Enable               bool    `toml:"enable"`
+	MaxLoad              float64 `toml:"max_load"`
+	MaxIowait            float64 `toml:"max_iowait"`
+	ThrottledLinesPerSec int64   `toml:"throttled_lines_per_sec"`
+}
+
+// loadGovernorInterval is how often the host load is sampled
+var loadGovernorInterval = time.Second
+
+// LoadGovernor samples the host load and tells the log tasks to slow down,
+// the agent must not compete with the service it observes
+type LoadGovernor struct {
+	config LoadGovernorConfig
+
+	throttled  int32
+	lastIowait uint64
+	lastTotal  uint64
+}
+
+func NewLoadGovernor(config *LoadGovernorConfig) *LoadGovernor {
+	governor := &LoadGovernor{config: *config}
+	if governor.config.MaxLoad <= 0 {
+		governor.config.MaxLoad = 1
+	}
+	if governor.config.MaxIowait <= 0 {
+		governor.config.MaxIowait = 20
+	}
+	if governor.config.ThrottledLinesPerSec <= 0 {
+		governor.config.ThrottledLinesPerSec = 100
+	}
+	return governor
+}
+
+func (p *LoadGovernor) IsEnable() bool {
+	return p != nil && p.config.Enable
+}
+
+// Throttled reports whether tailing should slow down
+func (p *LoadGovernor) Throttled() bool {
+	return p.IsEnable() && atomic.LoadInt32(&p.throttled) != 0
+}
+
+// Rate is the lines per second of a log while throttled
+func (p *LoadGovernor) Rate() int64 {
+	return p.config.ThrottledLinesPerSec
+}
+
+// Update throttles when load (per CPU) or iowait (percent) is too high
+func (p *LoadGovernor) Update(load, iowait float64) {
+	throttled := int32(0)
+	if load > p.config.MaxLoad || iowait > p.config.MaxIowait {
+		throttled = 1
+	}
+	if atomic.SwapInt32(&p.throttled, throttled) != throttled {
+		if throttled != 0 {
+			log.Warnf("[LoadGovernor] Host loaded, load %.2f iowait %.1f%%, throttle tailing", load, iowait)
+		} else {
+			log.Infof("[LoadGovernor] Host load back to normal, load %.2f iowait %.1f%%", load, iowait)
+		}
+	}
+}
+
+// Run samples the host load until ctx is done
+func (p *LoadGovernor) Run(ctx context.Context) {
+	ticker := time.NewTicker(loadGovernorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.sample(); err != nil {
+				log.Infof("[LoadGovernor] Sample load error, err[%s]", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *LoadGovernor) sample() error {
+	load, err := readLoadAvg()
+	if err != nil {
+		return err
+	}
+	iowait, total, err := readCPUTimes()
+	if err != nil {
+		return err
+	}
+	percent := 0.0
+	if p.lastTotal != 0 && total > p.lastTotal {
+		percent = float64(iowait-p.lastIowait) * 100 / float64(total-p.lastTotal)
+	}
+	p.lastIowait, p.lastTotal = iowait, total
+	p.Update(load/float64(runtime.NumCPU()), percent)
+	return nil
+}
+
+// readLoadAvg returns the 1 minute load average
+func readLoadAvg() (float64, error) {
+	raw, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(raw))
+	if len(fields) == 0 {
+		return 0, errors.New("loadavg format error")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// readCPUTimes returns the iowait and total jiffies of all CPUs
+func readCPUTimes() (iowait, total uint64, err error) {
+	raw, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	line := strings.SplitN(string(raw), "\n", 2)[0]
+	fields := strings.Fields(line)
+	if len(fields) < 6 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("stat format error: %s", line)
+	}
+	for i, field := range fields[1:] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		total += value
+		if i == 4 {
+			iowait = value
+		}
+	}
+	return iowait, total, nil
+}
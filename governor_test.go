@@ -0,0 +1,44 @@
+package logpeck
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLoadGovernor(*testing.T) {
+	governor := NewLoadGovernor(&LoadGovernorConfig{Enable: true, MaxLoad: 2, ThrottledLinesPerSec: 10})
+	governor.Update(1.5, 5)
+	if governor.Throttled() {
+		panic("load under threshold")
+	}
+	governor.Update(1.5, 30)
+	if !governor.Throttled() {
+		panic("iowait over threshold")
+	}
+
+	task := NewLogTask("./test.log")
+	task.governor = governor
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if !task.throttle(context.Background()) {
+			panic("throttle canceled")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		panic(elapsed)
+	}
+	governor.Update(2.5, 0)
+	governor.Update(0.5, 0)
+	if governor.Throttled() || !task.throttle(context.Background()) || task.limiter != nil {
+		panic("governor should release")
+	}
+
+	var disabled *LoadGovernor
+	if disabled.Throttled() {
+		panic("nil governor never throttles")
+	}
+	if err := NewLoadGovernor(&LoadGovernorConfig{}).sample(); err != nil {
+		panic(err)
+	}
+}
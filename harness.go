@@ -0,0 +1,173 @@
+package logpeck
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockRequest is a request received by a MockBackend
+type MockRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Body   []byte
+}
+
+// MockBackend is a local http server recording every request, it stands
+// for ElasticSearch, InfluxDb and the other http backends in tests
+type MockBackend struct {
+	Server *httptest.Server
+
+	mu       sync.Mutex
+	requests []MockRequest
+	status   int
+}
+
+func NewMockBackend() *MockBackend {
+	p := &MockBackend{status: http.StatusOK}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+	return p
+}
+
+func (p *MockBackend) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	p.mu.Lock()
+	p.requests = append(p.requests, MockRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Body:   body,
+	})
+	status := p.status
+	p.mu.Unlock()
+	w.WriteHeader(status)
+	w.Write([]byte("{}"))
+}
+
+// SetStatus sets the response status of following requests
+func (p *MockBackend) SetStatus(status int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status = status
+}
+
+// Host returns the "host:port" of the backend, as used in sender Hosts
+func (p *MockBackend) Host() string {
+	return strings.TrimPrefix(p.Server.URL, "http://")
+}
+
+func (p *MockBackend) URL() string {
+	return p.Server.URL
+}
+
+func (p *MockBackend) Close() {
+	p.Server.Close()
+}
+
+func (p *MockBackend) Requests() []MockRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]MockRequest(nil), p.requests...)
+}
+
+// Documents decodes the json objects posted to the backend, one per line
+// of each POST body
+func (p *MockBackend) Documents() []map[string]interface{} {
+	var docs []map[string]interface{}
+	for _, req := range p.Requests() {
+		if req.Method != http.MethodPost {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(req.Body))
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			doc := map[string]interface{}{}
+			if err := json.Unmarshal(scanner.Bytes(), &doc); err == nil {
+				docs = append(docs, doc)
+			}
+		}
+	}
+	return docs
+}
+
+// InfluxLines returns the line protocol lines written to the backend
+func (p *MockBackend) InfluxLines() []string {
+	var lines []string
+	for _, req := range p.Requests() {
+		if req.Path != "/write" {
+			continue
+		}
+		for _, line := range strings.Split(string(req.Body), "\n") {
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+// WaitDocuments waits until n documents are received, it returns the
+// documents received before timeout
+func (p *MockBackend) WaitDocuments(n int, timeout time.Duration) []map[string]interface{} {
+	deadline := time.Now().Add(timeout)
+	for {
+		docs := p.Documents()
+		if len(docs) >= n || time.Now().After(deadline) {
+			return docs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Harness runs a task without pecker, database or log file, lines are fed
+// directly into its pipeline
+type Harness struct {
+	Config PeckTaskConfig
+	Task   *PeckTask
+}
+
+func NewHarness(config []byte) (*Harness, error) {
+	h := &Harness{}
+	if err := h.Config.Unmarshal(config); err != nil {
+		return nil, err
+	}
+	task, err := NewPeckTask(&h.Config, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := task.Start(context.Background()); err != nil {
+		return nil, err
+	}
+	h.Task = task
+	return h, nil
+}
+
+func (h *Harness) Feed(lines ...string) {
+	for _, line := range lines {
+		h.Task.Process(line)
+	}
+}
+
+// Close stops the task, flushing buffered events
+func (h *Harness) Close() error {
+	return h.Task.Stop()
+}
+
+// SyntheticLines formats n lines, format gets the line index as the only
+// argument, e.g. "GET /api/%d 200"
+func SyntheticLines(format string, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf(format, i)
+	}
+	return lines
+}
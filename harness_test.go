@@ -0,0 +1,64 @@
+package logpeck
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHarnessElasticSearch(*testing.T) {
+	es := NewMockBackend()
+	defer es.Close()
+	h, err := NewHarness([]byte(`{
+		"Name": "HarnessES",
+		"LogPath": "/tmp/harness.log",
+		"Keywords": "GET",
+		"Extractor": {"Name": "text", "Config": {"Fields": [{"Name": "path", "Value": "$2"}, {"Name": "status", "Value": "$3"}]}},
+		"Sender": {"Name": "elasticsearch", "Config": {"Hosts": ["` + es.Host() + `"], "Index": "access", "Type": "log", "Types": {"status": "long"}}}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	h.Feed(SyntheticLines("GET /api/%d 200", 3)...)
+	h.Feed("POST /api/3 500")
+	h.Close()
+
+	docs := es.WaitDocuments(3, time.Second)
+	if len(docs) != 3 || docs[2]["path"] != "/api/2" || docs[0]["status"] != float64(200) {
+		panic(docs)
+	}
+	for _, req := range es.Requests() {
+		if req.Method == "POST" && req.Path != "/access/log" {
+			panic(req.Path)
+		}
+	}
+}
+
+func TestHarnessInfluxDb(*testing.T) {
+	influx := NewMockBackend()
+	defer influx.Close()
+	h, err := NewHarness([]byte(`{
+		"Name": "HarnessInflux",
+		"LogPath": "/tmp/harness.log",
+		"Extractor": {"Name": "text", "Config": {"Fields": [{"Name": "ts", "Value": "$1"}, {"Name": "cost", "Value": "$2"}]}},
+		"Sender": {"Name": "influxdb", "Config": {"Hosts": "` + influx.Host() + `", "Database": "db"}},
+		"Aggregator": {"Enable": true, "Interval": 60, "Options": [
+			{"PreMeasurment": "api", "Measurment": "_default", "Target": "cost", "Aggregations": ["cnt", "avg"], "Timestamp": "ts"}
+		]}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	// the line crossing the interval is counted in the dumped interval
+	h.Feed("1 10", "2 20", "60 30")
+	h.Close()
+
+	lines := influx.InfluxLines()
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "api_cost,host=") ||
+		!strings.Contains(lines[0], "avg=20.000") || !strings.Contains(lines[0], "cnt=3.000") {
+		panic(lines)
+	}
+	if influx.Requests()[0].Query != "db=db&precision=n" {
+		panic(influx.Requests()[0].Query)
+	}
+}
@@ -0,0 +1,123 @@
+package logpeck
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// HealthConfig marks a task degraded when its sender fails continuously for
+// FailureDuration seconds, and optionally stops it. Degraded and recovered
+// events are sent with Sender
+type HealthConfig struct {
+	Enable          bool         `json:"Enable"`
+	FailureDuration int64        `json:"FailureDuration"`
+	StopOnFailure   bool         `json:"StopOnFailure"`
+	Sender          SenderConfig `json:"Sender"`
+}
+
+var healthCheckInterval = time.Second
+
+// healthStat tracks since when a sender fails continuously
+type healthStat struct {
+	failingSince int64
+}
+
+func (p *healthStat) fail(now time.Time) {
+	atomic.CompareAndSwapInt64(&p.failingSince, 0, now.UnixNano())
+}
+
+func (p *healthStat) succeed() {
+	atomic.StoreInt64(&p.failingSince, 0)
+}
+
+// FailingSince returns when the current run of failures started, zero if
+// the last send succeeded
+func (p *healthStat) FailingSince() time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	since := atomic.LoadInt64(&p.failingSince)
+	if since == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, since)
+}
+
+// healthTransport records the outcome of every request of a http sender,
+// transport errors and 5xx responses are failures
+type healthTransport struct {
+	next   http.RoundTripper
+	health *healthStat
+}
+
+func (p *healthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := p.next.RoundTrip(req)
+	if err != nil && req.Context().Err() != nil {
+		return resp, err
+	}
+	if err != nil || resp.StatusCode >= 500 {
+		p.health.fail(time.Now())
+	} else {
+		p.health.succeed()
+	}
+	return resp, err
+}
+
+// HealthMonitor checks the sender health of a task and reports when it
+// becomes degraded or recovers
+type HealthMonitor struct {
+	task   string
+	config HealthConfig
+
+	degraded int32
+}
+
+func NewHealthMonitor(task string, config *HealthConfig) *HealthMonitor {
+	monitor := &HealthMonitor{
+		task:   task,
+		config: *config,
+	}
+	if monitor.config.FailureDuration <= 0 {
+		monitor.config.FailureDuration = 300
+	}
+	return monitor
+}
+
+func (p *HealthMonitor) IsEnable() bool {
+	return p.config.Enable
+}
+
+func (p *HealthMonitor) Degraded() bool {
+	return atomic.LoadInt32(&p.degraded) != 0
+}
+
+// Check compares failingSince with FailureDuration, it returns the event to
+// notify when the task became degraded or recovered, nil otherwise
+func (p *HealthMonitor) Check(failingSince, now time.Time) map[string]interface{} {
+	failing := !failingSince.IsZero() &&
+		now.Sub(failingSince) >= time.Duration(p.config.FailureDuration)*time.Second
+	if failing == p.Degraded() {
+		return nil
+	}
+	event := map[string]interface{}{
+		"Task":      p.task,
+		"Host":      GetHost(),
+		"Timestamp": now.UnixNano() / int64(time.Millisecond),
+	}
+	if failing {
+		atomic.StoreInt32(&p.degraded, 1)
+		event["Event"] = "degraded"
+		event["FailingSince"] = failingSince.Unix()
+		event["_Log"] = fmt.Sprintf("[logpeck] %s: sender failing since %s on %s",
+			p.task, failingSince.Format(time.RFC3339), GetHost())
+	} else {
+		atomic.StoreInt32(&p.degraded, 0)
+		event["Event"] = "recovered"
+		event["_Log"] = fmt.Sprintf("[logpeck] %s: sender recovered on %s", p.task, GetHost())
+	}
+	log.Infof("[HealthMonitor] %s", event["_Log"])
+	return event
+}
@@ -0,0 +1,66 @@
+package logpeck
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHealthMonitor(*testing.T) {
+	monitor := NewHealthMonitor("Task", &HealthConfig{Enable: true, FailureDuration: 60})
+	now := time.Now()
+	if event := monitor.Check(time.Time{}, now); event != nil {
+		panic(event)
+	}
+	if event := monitor.Check(now.Add(-30*time.Second), now); event != nil {
+		panic(event)
+	}
+	event := monitor.Check(now.Add(-60*time.Second), now)
+	if event == nil || event["Event"] != "degraded" || !monitor.Degraded() {
+		panic(event)
+	}
+	if event := monitor.Check(now.Add(-90*time.Second), now); event != nil {
+		panic(event)
+	}
+	event = monitor.Check(time.Time{}, now)
+	if event == nil || event["Event"] != "recovered" || monitor.Degraded() {
+		panic(event)
+	}
+}
+
+func TestHealthStopOnFailure(*testing.T) {
+	interval := healthCheckInterval
+	healthCheckInterval = 10 * time.Millisecond
+	defer func() { healthCheckInterval = interval }()
+
+	es := NewMockBackend()
+	defer es.Close()
+	es.SetStatus(http.StatusServiceUnavailable)
+	h, err := NewHarness([]byte(`{
+		"Name": "HealthES",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "elasticsearch", "Config": {"Hosts": ["` + es.Host() + `"], "Index": "health", "Type": "log"}},
+		"Health": {"Enable": true, "FailureDuration": 1, "StopOnFailure": true}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	events := &chanSender{lines: make(chan interface{}, 1)}
+	h.Task.healthSender = events
+	h.Feed("hello")
+	if h.Task.FailingSince().IsZero() {
+		panic("sender should be failing")
+	}
+	select {
+	case line := <-events.lines:
+		if line == nil {
+			panic("degraded event has no message")
+		}
+	case <-time.After(3 * time.Second):
+		panic("no degraded event")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !h.Task.health.Degraded() || !h.Task.IsStop() {
+		panic("degraded task should be stopped")
+	}
+}
@@ -0,0 +1,200 @@
+package logpeck
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+const (
+	HostSelectRandom     = "random"
+	HostSelectRoundRobin = "roundrobin"
+	HostSelectWeighted   = "weighted"
+	HostSelectSticky     = "sticky"
+)
+
+// HostSelector picks the backend host of each request, Fail reports a
+// request to host failed. Hosts for which the function set by Skip returns
+// true are not picked while another host is available
+type HostSelector interface {
+	Select() (string, error)
+	Fail(host string)
+	Skip(skip func(host string) bool)
+}
+
+func NewHostSelector(strategy string, hosts []string, weights map[string]int) (HostSelector, error) {
+	switch strategy {
+	case "", HostSelectRandom:
+		return &randomSelector{hosts: hosts}, nil
+	case HostSelectRoundRobin:
+		return &roundRobinSelector{hosts: hosts}, nil
+	case HostSelectWeighted:
+		return newWeightedSelector(hosts, weights), nil
+	case HostSelectSticky:
+		return &stickySelector{hosts: hosts}, nil
+	}
+	return nil, errors.New("Unknown host selection: " + strategy)
+}
+
+var errNoCandidates error = errors.New("none candidates")
+
+// hostSkip is the Skip of the selectors
+type hostSkip struct {
+	skip func(host string) bool
+}
+
+func (p *hostSkip) Skip(skip func(host string) bool) {
+	p.skip = skip
+}
+
+func (p *hostSkip) available(host string) bool {
+	return p.skip == nil || !p.skip(host)
+}
+
+type randomSelector struct {
+	hostSkip
+	hosts []string
+}
+
+func (p *randomSelector) Select() (string, error) {
+	if len(p.hosts) <= 0 {
+		return "", errNoCandidates
+	}
+	var candidates []string
+	for _, host := range p.hosts {
+		if p.available(host) {
+			candidates = append(candidates, host)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.hosts
+	}
+	return candidates[rand.Intn(len(candidates))], nil
+}
+
+func (p *randomSelector) Fail(host string) {
+}
+
+type roundRobinSelector struct {
+	hostSkip
+	mu    sync.Mutex
+	hosts []string
+	next  int
+}
+
+func (p *roundRobinSelector) Select() (string, error) {
+	if len(p.hosts) <= 0 {
+		return "", errNoCandidates
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	skipped := 0
+	for ; skipped < len(p.hosts); skipped++ {
+		if p.available(p.hosts[(p.next+skipped)%len(p.hosts)]) {
+			break
+		}
+	}
+	if skipped == len(p.hosts) {
+		skipped = 0
+	}
+	host := p.hosts[(p.next+skipped)%len(p.hosts)]
+	p.next = (p.next + skipped + 1) % len(p.hosts)
+	return host, nil
+}
+
+func (p *roundRobinSelector) Fail(host string) {
+}
+
+// weightedSelector is the smooth weighted round robin of nginx, hosts
+// without a weight have weight 1
+type weightedSelector struct {
+	hostSkip
+	mu      sync.Mutex
+	hosts   []string
+	weights []int
+	current []int
+	total   int
+}
+
+func newWeightedSelector(hosts []string, weights map[string]int) *weightedSelector {
+	p := &weightedSelector{
+		hosts:   hosts,
+		weights: make([]int, len(hosts)),
+		current: make([]int, len(hosts)),
+	}
+	for i, host := range hosts {
+		weight, ok := weights[host]
+		if !ok {
+			weight = 1
+		}
+		if weight < 0 {
+			weight = 0
+		}
+		p.weights[i] = weight
+		p.total += weight
+	}
+	return p
+}
+
+func (p *weightedSelector) Select() (string, error) {
+	if p.total <= 0 {
+		return "", errNoCandidates
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	all := true
+	for i, host := range p.hosts {
+		if p.weights[i] > 0 && p.available(host) {
+			all = false
+			break
+		}
+	}
+	best, total := -1, 0
+	for i, host := range p.hosts {
+		if p.weights[i] <= 0 || !all && !p.available(host) {
+			continue
+		}
+		p.current[i] += p.weights[i]
+		total += p.weights[i]
+		if best < 0 || p.current[i] > p.current[best] {
+			best = i
+		}
+	}
+	p.current[best] -= total
+	return p.hosts[best], nil
+}
+
+func (p *weightedSelector) Fail(host string) {
+}
+
+// stickySelector keeps using one host until a request to it fails, then
+// moves to the next one
+type stickySelector struct {
+	hostSkip
+	mu      sync.Mutex
+	hosts   []string
+	current int
+}
+
+func (p *stickySelector) Select() (string, error) {
+	if len(p.hosts) <= 0 {
+		return "", errNoCandidates
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := 0; i < len(p.hosts); i++ {
+		if current := (p.current + i) % len(p.hosts); p.available(p.hosts[current]) {
+			p.current = current
+			break
+		}
+	}
+	return p.hosts[p.current], nil
+}
+
+func (p *stickySelector) Fail(host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.hosts) > 0 && p.hosts[p.current] == host {
+		p.current = (p.current + 1) % len(p.hosts)
+	}
+}
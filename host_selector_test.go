@@ -0,0 +1,74 @@
+package logpeck
+
+import (
+	"testing"
+)
+
+func TestHostSelector(*testing.T) {
+	hosts := []string{"a", "b", "c"}
+	{
+		selector, _ := NewHostSelector(HostSelectRoundRobin, hosts, nil)
+		seq := ""
+		for i := 0; i < 4; i++ {
+			host, _ := selector.Select()
+			seq += host
+		}
+		if seq != "abca" {
+			panic(seq)
+		}
+	}
+	{
+		selector, _ := NewHostSelector(HostSelectWeighted, hosts, map[string]int{"a": 3, "c": 0})
+		count := map[string]int{}
+		for i := 0; i < 8; i++ {
+			host, _ := selector.Select()
+			count[host]++
+		}
+		if count["a"] != 6 || count["b"] != 2 || count["c"] != 0 {
+			panic(count)
+		}
+	}
+	{
+		selector, _ := NewHostSelector(HostSelectSticky, hosts, nil)
+		first, _ := selector.Select()
+		second, _ := selector.Select()
+		selector.Fail("c")
+		third, _ := selector.Select()
+		selector.Fail("a")
+		fourth, _ := selector.Select()
+		if first != "a" || second != "a" || third != "a" || fourth != "b" {
+			panic(first + second + third + fourth)
+		}
+	}
+	{
+		selector, _ := NewHostSelector("", hosts, nil)
+		if host, err := selector.Select(); err != nil || host == "" {
+			panic(err)
+		}
+		empty, _ := NewHostSelector(HostSelectRandom, nil, nil)
+		if _, err := empty.Select(); err == nil {
+			panic(err)
+		}
+	}
+	if _, err := NewHostSelector("first", hosts, nil); err == nil {
+		panic(err)
+	}
+}
+
+func TestHostSelectorSkip(*testing.T) {
+	hosts := []string{"a", "b", "c"}
+	for _, strategy := range []string{HostSelectRandom, HostSelectRoundRobin, HostSelectWeighted, HostSelectSticky} {
+		selector, _ := NewHostSelector(strategy, hosts, nil)
+		skipped := map[string]bool{"a": true, "c": true}
+		selector.Skip(func(host string) bool { return skipped[host] })
+		for i := 0; i < 6; i++ {
+			if host, _ := selector.Select(); host != "b" {
+				panic(strategy + " " + host)
+			}
+		}
+		skipped["b"] = true
+		if host, err := selector.Select(); err != nil || host == "" {
+			panic(strategy)
+		}
+	}
+}
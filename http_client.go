@@ -0,0 +1,287 @@
+package logpeck
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// HttpClientConfig tunes the connection pool and timeouts of http based
+// senders, timeouts are in milliseconds
+type HttpClientConfig struct {
+	MaxIdleConnsPerHost int    `json:"MaxIdleConnsPerHost"`
+	KeepAlive           int64  `json:"KeepAlive"`
+	IdleConnTimeout     int64  `json:"IdleConnTimeout"`
+	ConnectTimeout      int64  `json:"ConnectTimeout"`
+	RequestTimeout      int64  `json:"RequestTimeout"`
+	Proxy               string `json:"Proxy"`
+	BreakerThreshold    int    `json:"BreakerThreshold"`
+	BreakerCooldown     int64  `json:"BreakerCooldown"`
+
+	TLS TLSConfig `json:"TLS"`
+}
+
+// TLSConfig makes senders talk https to their hosts. CAFile verifies the
+// backends instead of the system roots, CertFile and KeyFile are the
+// client certificate for mutual TLS
+type TLSConfig struct {
+	Enable             bool   `json:"Enable"`
+	CAFile             string `json:"CAFile"`
+	CertFile           string `json:"CertFile"`
+	KeyFile            string `json:"KeyFile"`
+	ServerName         string `json:"ServerName"`
+	InsecureSkipVerify bool   `json:"InsecureSkipVerify"`
+}
+
+// Load reads the certificates of the config
+func (c *TLSConfig) Load() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("TLS CertFile and KeyFile must be set together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if c.CAFile != "" {
+		raw, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, errors.New("TLS CAFile has no certificate: " + c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// Scheme is the url scheme of senders addressing hosts, "https" with TLS
+func (c *HttpClientConfig) Scheme() string {
+	if c.TLS.Enable {
+		return "https"
+	}
+	return "http"
+}
+
+func (c *HttpClientConfig) Validate() error {
+	if c.TLS.Enable {
+		if _, err := c.TLS.Load(); err != nil {
+			return err
+		}
+	}
+	if c.Proxy == "" {
+		return nil
+	}
+	proxy, err := url.Parse(c.Proxy)
+	if err != nil {
+		return err
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return errors.New("Proxy scheme not supported: " + c.Proxy)
+	}
+	return nil
+}
+
+// NewHttpClient returns the client a sender shares between all its
+// requests, so connections to the backends are kept alive and reused.
+// timeout is the request timeout when RequestTimeout is not configured.
+// Without Proxy, HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honored. It fails
+// if the TLS certificates or Proxy can not be loaded
+func NewHttpClient(config *HttpClientConfig, timeout time.Duration) (*http.Client, error) {
+	maxIdleConnsPerHost := config.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = 16
+	}
+	keepAlive := time.Duration(config.KeepAlive) * time.Second
+	if keepAlive <= 0 {
+		keepAlive = 30 * time.Second
+	}
+	idleConnTimeout := time.Duration(config.IdleConnTimeout) * time.Second
+	if idleConnTimeout <= 0 {
+		idleConnTimeout = 90 * time.Second
+	}
+	connectTimeout := time.Duration(config.ConnectTimeout) * time.Millisecond
+	if connectTimeout <= 0 {
+		connectTimeout = 3 * time.Second
+	}
+	if config.RequestTimeout > 0 {
+		timeout = time.Duration(config.RequestTimeout) * time.Millisecond
+	}
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: keepAlive,
+	}
+	proxy := http.ProxyFromEnvironment
+	if config.Proxy != "" {
+		proxyUrl, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(proxyUrl)
+	}
+	transport := &http.Transport{
+		// unix sockets are local, never proxied
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			if _, ok := unixSocketPath(req.URL.Host); ok {
+				return nil, nil
+			}
+			return proxy(req)
+		},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if path, ok := unixSocketPath(addr); ok {
+				return dialer.DialContext(ctx, "unix", path)
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if config.TLS.Enable {
+		tlsConfig, err := config.TLS.Load()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	if config.BreakerThreshold < 0 {
+		return &http.Client{Transport: transport, Timeout: timeout}, nil
+	}
+	threshold := config.BreakerThreshold
+	if threshold == 0 {
+		threshold = 5
+	}
+	cooldown := time.Duration(config.BreakerCooldown) * time.Second
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	return &http.Client{Transport: newBreakerTransport(transport, threshold, cooldown), Timeout: timeout}, nil
+}
+
+// post is client.Post bound to ctx and sent with do, the request is
+// aborted when ctx is canceled
+func (p *httpStat) post(ctx context.Context, client *http.Client, url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return p.do(client, req)
+}
+
+// httpStat counts the request timeouts of a http sender, and reports the
+// breaker states of its client
+type httpStat struct {
+	timeouts int64
+	dropped  int64
+	// hold makes do wait while the breaker of the backend is open
+	hold     bool
+	breakers *breakerTransport
+	metrics  *metricsTransport
+	*healthStat
+}
+
+// newHttpStat wraps the transport of client to track its health and
+// responses
+func newHttpStat(client *http.Client) httpStat {
+	breakers, _ := client.Transport.(*breakerTransport)
+	health := &healthStat{}
+	metrics := newMetricsTransport(client.Transport)
+	client.Transport = &healthTransport{next: metrics, health: health}
+	return httpStat{hold: true, breakers: breakers, metrics: metrics, healthStat: health}
+}
+
+// do sends req with client. While the breaker of its backend is open, req
+// is held back instead of rejected, and so is the reader of the log, the
+// lines not read yet stay in the log until the backend recovers
+func (p *httpStat) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	for {
+		if p.hold && p.breakers != nil {
+			if err := p.breakers.hold(req.Context(), req.URL.Host); err != nil {
+				return nil, &url.Error{Op: req.Method, URL: req.URL.String(), Err: err}
+			}
+		}
+		resp, err := client.Do(req)
+		if !p.hold || !errors.Is(err, ErrBreakerOpen) || req.GetBody == nil {
+			return resp, err
+		}
+		// another request took the trial of the half-open breaker
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return resp, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+}
+
+// observe counts err if it is a timeout or a rejection of an open
+// breaker, and returns it
+func (p *httpStat) observe(err error) error {
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		atomic.AddInt64(&p.timeouts, 1)
+	}
+	if e, ok := err.(*url.Error); ok && (e.Err == ErrBreakerOpen || e.Err == context.Canceled) {
+		p.drop()
+	}
+	return err
+}
+
+// accepted returns an error if resp asks to send the request again (429 or
+// 5xx). Other rejections are counted as drops, sending again would not help
+func (p *httpStat) accepted(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("response status %s", resp.Status)
+	case resp.StatusCode >= 300:
+		p.drop()
+	}
+	return nil
+}
+
+// drop counts an event the sender gave up
+func (p *httpStat) drop() {
+	atomic.AddInt64(&p.dropped, 1)
+}
+
+func (p *httpStat) DroppedTotal() int64 {
+	return atomic.LoadInt64(&p.dropped)
+}
+
+func (p *httpStat) TimeoutTotal() int64 {
+	return atomic.LoadInt64(&p.timeouts)
+}
+
+func (p *httpStat) BackendStats() map[string]BackendStat {
+	if p.metrics == nil {
+		return nil
+	}
+	return p.metrics.BackendStats()
+}
+
+func (p *httpStat) BreakerStates() map[string]string {
+	if p.breakers == nil {
+		return nil
+	}
+	return p.breakers.States()
+}
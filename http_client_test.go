@@ -0,0 +1,332 @@
+package logpeck
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func mustHttpClient(config *HttpClientConfig, timeout time.Duration) *http.Client {
+	client, err := NewHttpClient(config, timeout)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func TestNewHttpClient(*testing.T) {
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := mustHttpClient(&HttpClientConfig{}, 0)
+	if client.Transport.(*breakerTransport).next.(*http.Transport).MaxIdleConnsPerHost != 16 {
+		panic(client.Transport)
+	}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			panic(err)
+		}
+		// a connection goes back to the pool once its body is read
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if atomic.LoadInt32(&conns) != 1 {
+		panic(conns)
+	}
+}
+
+func TestHttpClientTimeout(*testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := mustHttpClient(&HttpClientConfig{RequestTimeout: 50}, 10*time.Second)
+	stat := httpStat{}
+	_, err := client.Get(server.URL)
+	if stat.observe(err) == nil {
+		panic(err)
+	}
+	stat.observe(errors.New("refused"))
+	if stat.TimeoutTotal() != 1 {
+		panic(stat.TimeoutTotal())
+	}
+}
+
+func TestHttpClientProxy(*testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	config := HttpClientConfig{Proxy: proxy.URL}
+	if err := config.Validate(); err != nil {
+		panic(err)
+	}
+	resp, err := mustHttpClient(&config, time.Second).Get("http://backend.invalid:9200/index")
+	if err != nil {
+		panic(err)
+	}
+	resp.Body.Close()
+	if proxied != "http://backend.invalid:9200/index" {
+		panic(proxied)
+	}
+	if err := (&HttpClientConfig{Proxy: "ftp://proxy"}).Validate(); err == nil {
+		panic(err)
+	}
+}
+
+// serveSocks5 accepts one SOCKS5 connection without authentication and
+// connects it to the requested address, which is sent to target
+func serveSocks5(listener net.Listener, target chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	buf := make([]byte, 262)
+	// greeting: version, methods
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	io.ReadFull(conn, buf[:buf[1]])
+	conn.Write([]byte{5, 0})
+	// request: version, CONNECT, reserved, address type
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 3:
+		io.ReadFull(conn, buf[:1])
+		n := buf[0]
+		io.ReadFull(conn, buf[:n])
+		host = string(buf[:n])
+	}
+	io.ReadFull(conn, buf[:2])
+	addr := net.JoinHostPort(host, strconv.Itoa(int(buf[0])<<8|int(buf[1])))
+	target <- addr
+	backend, err := net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer backend.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(backend, conn)
+	io.Copy(conn, backend)
+}
+
+func TestHttpClientSocks5Proxy(*testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+	target := make(chan string, 1)
+	go serveSocks5(listener, target)
+
+	config := HttpClientConfig{Proxy: "socks5://" + listener.Addr().String()}
+	if err := config.Validate(); err != nil {
+		panic(err)
+	}
+	resp, err := mustHttpClient(&config, time.Second).Get(server.URL)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "ok" || <-target != strings.TrimPrefix(server.URL, "http://") {
+		panic(string(body))
+	}
+}
+
+func TestHttpStatBackends(*testing.T) {
+	codes := []int{200, 201, 404, 429, 503}
+	var n int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(codes[atomic.AddInt32(&n, 1)-1])
+	}))
+	defer server.Close()
+
+	client := mustHttpClient(&HttpClientConfig{BreakerThreshold: -1}, time.Second)
+	stat := newHttpStat(client)
+	for range codes {
+		resp, err := client.Post(server.URL, "text/plain", strings.NewReader("12345"))
+		if err != nil {
+			panic(err)
+		}
+		resp.Body.Close()
+	}
+	client.Get("http://127.0.0.1:1")
+	host := strings.TrimPrefix(server.URL, "http://")
+	backend := stat.BackendStats()[host]
+	if backend.Requests != 5 || backend.Status2xx != 2 || backend.Status4xx != 1 || backend.Status429 != 1 ||
+		backend.Status5xx != 1 || backend.BytesSent != 25 || backend.AvgLatencyMs <= 0 {
+		panic(backend)
+	}
+	if stat.BackendStats()["127.0.0.1:1"].Errors != 1 {
+		panic(stat.BackendStats())
+	}
+
+	var out strings.Builder
+	WriteSenderMetrics(&out, map[string]map[string]BackendStat{"t": stat.BackendStats()})
+	if !strings.Contains(out.String(), `logpeck_sender_responses_total{task="t",backend="`+host+`",code="429"} 1`) ||
+		!strings.Contains(out.String(), `logpeck_sender_bytes_total{task="t",backend="`+host+`"} 25`) {
+		panic(out.String())
+	}
+}
+
+// writeTestCert writes a PEM certificate and key signed by parent (self
+// signed if nil) to dir
+func writeTestCert(dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		panic(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(dir+"/"+name+".pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(dir+"/"+name+"-key.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestHttpClientMutualTLS(*testing.T) {
+	dir, _ := ioutil.TempDir("", "logpeck_tls")
+	defer os.RemoveAll(dir)
+	ca, caKey := writeTestCert(dir, "ca", nil, nil, true)
+	writeTestCert(dir, "server", ca, caKey, false)
+	writeTestCert(dir, "client", ca, caKey, false)
+
+	serverCert, err := tls.LoadX509KeyPair(dir+"/server.pem", dir+"/server-key.pem")
+	if err != nil {
+		panic(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	config := HttpClientConfig{TLS: TLSConfig{Enable: true, CAFile: dir + "/ca.pem"}}
+	if err := config.Validate(); err != nil {
+		panic(err)
+	}
+	if _, err := mustHttpClient(&config, time.Second).Get(server.URL); err == nil {
+		panic("request without client certificate accepted")
+	}
+	config.TLS.CertFile = dir + "/client.pem"
+	if err := config.Validate(); err == nil {
+		panic("CertFile without KeyFile")
+	}
+	if _, err := NewHttpClient(&config, time.Second); err == nil {
+		panic("client built without KeyFile")
+	}
+	if _, err := NewChatSender(&SenderConfig{Name: "chat", Config: ChatConfig{Url: server.URL, Http: config}}); err == nil {
+		panic("sender built without KeyFile")
+	}
+	config.TLS.KeyFile = dir + "/client-key.pem"
+	if err := config.Validate(); err != nil || config.Scheme() != "https" {
+		panic(err)
+	}
+	resp, err := mustHttpClient(&config, time.Second).Get(server.URL)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "client" {
+		panic(string(body))
+	}
+}
+
+func TestHttpClientUnixSocket(*testing.T) {
+	dir, _ := ioutil.TempDir("", "logpeck_unix")
+	defer os.RemoveAll(dir)
+	listener, err := net.Listen("unix", dir+"/backend.sock")
+	if err != nil {
+		panic(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	host := SocketHost("unix://" + dir + "/backend.sock")
+	if SocketHost("127.0.0.1:8086") != "127.0.0.1:8086" || displayHost(host) != "unix://"+dir+"/backend.sock" {
+		panic(host)
+	}
+	client := mustHttpClient(&HttpClientConfig{Proxy: "http://127.0.0.1:1"}, time.Second)
+	stat := newHttpStat(client)
+	resp, err := client.Get("http://" + host + "/write")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "/write" || stat.BackendStats()["unix://"+dir+"/backend.sock"].Status2xx != 1 {
+		panic(string(body))
+	}
+}
@@ -8,14 +8,34 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// statsStreamKeepalive is how long a quiet stats stream waits before a
+// keepalive comment
+var statsStreamKeepalive = 15 * time.Second
+
 func logRequest(r *http.Request, prefix string) {
 	r_str, _ := httputil.DumpRequest(r, true)
 	log.Infof("[Handler] [%s] req_len[%d] req[%s]", prefix, len(r_str), r_str)
 }
 
+// unmarshalTaskConfig parses the task config of a request expanded with the
+// task_defaults and senders of the agent config, rejecting unknown fields
+// with ?strict=true or strict_task_config
+func unmarshalTaskConfig(r *http.Request, config *PeckTaskConfig, raw []byte) error {
+	raw, err := Config.ExpandTaskConfig(raw)
+	if err != nil {
+		return err
+	}
+	if strict, _ := strconv.ParseBool(r.URL.Query().Get("strict")); strict || Config.StrictTaskConfig {
+		return config.UnmarshalStrict(raw)
+	}
+	return config.Unmarshal(raw)
+}
+
 func NewAddTaskHandler(pecker *Pecker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logRequest(r, "AddTaskHandler")
@@ -23,7 +43,7 @@ func NewAddTaskHandler(pecker *Pecker) http.HandlerFunc {
 
 		var config PeckTaskConfig
 		raw, _ := ioutil.ReadAll(r.Body)
-		err := config.Unmarshal(raw)
+		err := unmarshalTaskConfig(r, &config, raw)
 		if err != nil {
 			log.Infof("[Handler] Parse PeckTaskConfig error, %s", err)
 			w.WriteHeader(http.StatusBadRequest)
@@ -53,7 +73,7 @@ func NewUpdateTaskHandler(pecker *Pecker) http.HandlerFunc {
 
 		var config PeckTaskConfig
 		raw, _ := ioutil.ReadAll(r.Body)
-		err := config.Unmarshal(raw)
+		err := unmarshalTaskConfig(r, &config, raw)
 		if err != nil {
 			log.Infof("[Handler] Parse PeckTaskConfig error, %s", err)
 			w.WriteHeader(http.StatusBadRequest)
@@ -61,6 +81,24 @@ func NewUpdateTaskHandler(pecker *Pecker) http.HandlerFunc {
 			return
 		}
 
+		if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+			diff, err := pecker.DiffPeckTask(&config)
+			if err != nil {
+				w.WriteHeader(http.StatusNotAcceptable)
+				w.Write([]byte("Update failed, " + err.Error()))
+				return
+			}
+			jsonStr, err := json.Marshal(diff)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Update failed, " + err.Error()))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(jsonStr)
+			return
+		}
+
 		err = pecker.UpdatePeckTask(&config)
 		if err != nil {
 			w.WriteHeader(http.StatusNotAcceptable)
@@ -139,6 +177,35 @@ func NewStopTaskHandler(pecker *Pecker) http.HandlerFunc {
 	}
 }
 
+func NewResetTaskHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "ResetTaskHandler")
+		defer r.Body.Close()
+
+		var config PeckTaskConfig
+		raw, _ := ioutil.ReadAll(r.Body)
+		err := config.Unmarshal(raw)
+		if err != nil {
+			log.Infof("[Handler] Reset PeckTask error, %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Bad Request, " + err.Error()))
+			return
+		}
+
+		err = pecker.ResetPeckTask(&config)
+		if err != nil {
+			log.Infof("[Handler] Reset PeckTask error, %s", err.Error())
+			w.WriteHeader(http.StatusNotAcceptable)
+			w.Write([]byte("Reset failed, " + err.Error()))
+			return
+		}
+		log.Infof("[Handler] Reset Success: %s", raw)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Reset Success"))
+	}
+}
+
 func NewRemoveTaskHandler(pecker *Pecker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logRequest(r, "RemoveTaskHandler")
@@ -172,24 +239,68 @@ func NewListTaskHandler(pecker *Pecker) http.HandlerFunc {
 		logRequest(r, "ListTaskHandler")
 		defer r.Body.Close()
 
-		configs, err := pecker.ListPeckTask()
+		var query ListQuery
+		raw, _ := ioutil.ReadAll(r.Body)
+		if len(strings.TrimSpace(string(raw))) > 0 {
+			if err := json.Unmarshal(raw, &query); err != nil {
+				log.Infof("[Handler] Parse ListQuery error, %s", err)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("Bad Request, %s in %v", err, string(raw[:]))))
+				return
+			}
+		}
+		if selector := r.URL.Query().Get("selector"); selector != "" {
+			query.Selector = selector
+		}
+		if err := query.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Bad Request, " + err.Error()))
+			return
+		}
+		var selector *LabelSelector
+		if query.Selector != "" {
+			var err error
+			if selector, err = ParseLabelSelector(query.Selector); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Bad Request, " + err.Error()))
+				return
+			}
+		}
+
+		configs, err := pecker.ListPeckTask(selector)
 		if err != nil {
 			w.WriteHeader(http.StatusNotAcceptable)
 			w.Write([]byte("List PeckTask failed, " + err.Error()))
 			return
 		}
-		stats, err := pecker.ListTaskStats()
+		stats, err := pecker.ListTaskStats(selector)
 		if err != nil {
 			w.WriteHeader(http.StatusNotAcceptable)
 			w.Write([]byte("List PeckTask failed, " + err.Error()))
 			return
 		}
+		configs, stats, total := query.Page(configs, stats)
 		res := make(map[string]interface{})
-		res["configs"] = configs
-		res["stats"] = stats
+		res["total"] = total
+		res["agent"] = GetVersionInfo()
+		if recovery := pecker.db.Recovery(); recovery != nil {
+			res["recovery"] = recovery
+		}
+		if res["configs"], err = query.Project(configs); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("List PeckTask failed, " + err.Error()))
+			return
+		}
+		if res["stats"], err = query.Project(stats); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("List PeckTask failed, " + err.Error()))
+			return
+		}
 		jsonStr, jErr := json.Marshal(res)
 		if jErr != nil {
-			panic(jErr)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("List PeckTask failed, " + jErr.Error()))
+			return
 		}
 		log.Infof("[Handler] List Success: %s", jsonStr)
 
@@ -198,6 +309,64 @@ func NewListTaskHandler(pecker *Pecker) http.HandlerFunc {
 	}
 }
 
+func NewReplayTaskHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "ReplayTaskHandler")
+		defer r.Body.Close()
+
+		var config ReplayConfig
+		raw, _ := ioutil.ReadAll(r.Body)
+		err := json.Unmarshal(raw, &config)
+		if err != nil {
+			log.Infof("[Handler] Parse ReplayConfig error, %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Bad Request, %s in %v", err, string(raw[:]))))
+			return
+		}
+
+		err = pecker.ReplayPeckTask(&config)
+		if err != nil {
+			log.Infof("[Handler] Replay PeckTask error, %s", err)
+			w.WriteHeader(http.StatusNotAcceptable)
+			w.Write([]byte("Replay failed, " + err.Error()))
+			return
+		}
+		log.Infof("[Handler] Replay Started: %s", raw)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Replay Started"))
+	}
+}
+
+func NewRenameTaskHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "RenameTaskHandler")
+		defer r.Body.Close()
+
+		var config RenameConfig
+		raw, _ := ioutil.ReadAll(r.Body)
+		err := json.Unmarshal(raw, &config)
+		if err != nil {
+			log.Infof("[Handler] Parse RenameConfig error, %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Bad Request, %s in %v", err, string(raw[:]))))
+			return
+		}
+
+		err = pecker.RenamePeckTask(&config)
+		if err != nil {
+			log.Infof("[Handler] Rename PeckTask error, %s", err)
+			w.WriteHeader(http.StatusNotAcceptable)
+			w.Write([]byte("Rename failed, " + err.Error()))
+			return
+		}
+		log.Infof("[Handler] Rename Success: %s", raw)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Rename Success"))
+	}
+}
+
 func NewTestTaskHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logRequest(r, "TestTaskHandler")
@@ -205,7 +374,7 @@ func NewTestTaskHandler() http.HandlerFunc {
 
 		var config PeckTaskConfig
 		raw, _ := ioutil.ReadAll(r.Body)
-		err := config.Unmarshal(raw)
+		err := unmarshalTaskConfig(r, &config, raw)
 		if err != nil {
 			log.Infof("[Handler] Parse PeckTaskConfig error, %s", err)
 			w.WriteHeader(http.StatusBadRequest)
@@ -222,7 +391,9 @@ func NewTestTaskHandler() http.HandlerFunc {
 		}
 		jsonStr, jErr := json.Marshal(results)
 		if jErr != nil {
-			panic(jErr)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("test failed, " + jErr.Error()))
+			return
 		}
 		log.Infof("[Handler] Test Success: %s", jsonStr)
 		w.WriteHeader(http.StatusOK)
@@ -248,6 +419,7 @@ func NewListPathHandler() http.HandlerFunc {
 		if index == -1 {
 			w.WriteHeader(http.StatusNotAcceptable)
 			w.Write([]byte("Path should be absolute"))
+			return
 		}
 		dir := path[0 : index+1]
 		file_prefix := path[index+1:]
@@ -271,7 +443,9 @@ func NewListPathHandler() http.HandlerFunc {
 
 		jsonStr, err := json.Marshal(names)
 		if err != nil {
-			panic(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
 		}
 		log.Infof("[Handler] List path Success: %s", jsonStr)
 
@@ -283,7 +457,390 @@ func NewListPathHandler() http.HandlerFunc {
 func NewVersionHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logRequest(r, "VersionHandler")
+		jsonStr, err := json.Marshal(GetVersionInfo())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Get version failed, " + err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonStr)
+	}
+}
+
+// NewDBRecoveryHandler returns how the database was recovered, null if it
+// was not corrupt
+func NewDBRecoveryHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "DBRecoveryHandler")
+		jsonStr, err := json.Marshal(pecker.db.Recovery())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Get recovery failed, " + err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonStr)
+	}
+}
+
+// NewHealthzHandler answers while the process serves requests
+func NewHealthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}
+}
+
+// NewReadyzHandler answers 503 when a readiness check fails
+func NewReadyzHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := pecker.Readiness(&Config.Ready)
+		jsonStr, err := json.Marshal(report)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Get readiness failed, " + err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if report.Ready {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			log.Warnf("[Handler] Not ready: %s", jsonStr)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(jsonStr)
+	}
+}
+
+func NewTaskMetricsHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(http.StatusOK)
+		WritePrometheusMetrics(w, pecker.GetAggregations())
+		WriteSenderMetrics(w, pecker.GetBackendStats())
+	}
+}
+
+func NewStatsHistoryHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "StatsHistoryHandler")
+		var since int64
+		if value := r.URL.Query().Get("since"); value != "" {
+			var err error
+			if since, err = strconv.ParseInt(value, 10, 64); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Bad Request, " + err.Error()))
+				return
+			}
+		}
+		history := pecker.GetStatsHistory(r.URL.Query().Get("name"), since)
+		jsonStr, err := json.Marshal(history)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Get stats history failed, " + err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonStr)
+	}
+}
+
+// NewSnapshotHandler streams a database snapshot
+func NewSnapshotHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "SnapshotHandler")
+		defer r.Body.Close()
+
+		// a large database takes longer than the write timeout of the server
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="logpeck.db"`)
+		n, err := pecker.WriteSnapshot(w)
+		if err != nil {
+			log.Errorf("[Handler] Snapshot error after %d bytes, %s", n, err)
+			if n == 0 {
+				w.Header().Del("Content-Disposition")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Snapshot failed, " + err.Error()))
+			}
+		}
+	}
+}
+
+func NewListOffsetsHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "ListOffsetsHandler")
+		jsonStr, err := json.Marshal(pecker.ListOffsets())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("List offsets failed, " + err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonStr)
+	}
+}
+
+func NewSetCaptureHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "SetCaptureHandler")
+		defer r.Body.Close()
+
+		var config CaptureConfig
+		raw, _ := ioutil.ReadAll(r.Body)
+		err := json.Unmarshal(raw, &config)
+		if err != nil {
+			log.Infof("[Handler] Parse CaptureConfig error, %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Bad Request, %s in %v", err, string(raw[:]))))
+			return
+		}
+
+		if err := pecker.SetCapture(&config); err != nil {
+			w.WriteHeader(http.StatusNotAcceptable)
+			w.Write([]byte("Set capture failed, " + err.Error()))
+			return
+		}
+		log.Infof("[Handler] Set capture Success: %s", raw)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Set capture Success"))
+	}
+}
+
+func NewDualWriteHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "DualWriteHandler")
+		defer r.Body.Close()
+
+		var config DualWriteConfig
+		raw, _ := ioutil.ReadAll(r.Body)
+		err := json.Unmarshal(raw, &config)
+		if err != nil {
+			log.Infof("[Handler] Parse DualWriteConfig error, %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Bad Request, %s in %v", err, string(raw[:]))))
+			return
+		}
+
+		if err := pecker.SetDualWrite(&config); err != nil {
+			w.WriteHeader(http.StatusNotAcceptable)
+			w.Write([]byte("Set dual-write failed, " + err.Error()))
+			return
+		}
+		log.Infof("[Handler] Set dual-write Success: %s", raw)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Set dual-write Success"))
+	}
+}
+
+func NewGetCaptureHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "GetCaptureHandler")
+		stat, err := pecker.GetCapture(r.URL.Query().Get("name"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotAcceptable)
+			w.Write([]byte("Get capture failed, " + err.Error()))
+			return
+		}
+		jsonStr, err := json.Marshal(stat)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Get capture failed, " + err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonStr)
+	}
+}
+
+func NewTraceHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "TraceHandler")
+		defer r.Body.Close()
+
+		var config TraceConfig
+		raw, _ := ioutil.ReadAll(r.Body)
+		err := json.Unmarshal(raw, &config)
+		if err != nil {
+			log.Infof("[Handler] Parse TraceConfig error, %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Bad Request, %s in %v", err, string(raw[:]))))
+			return
+		}
+
+		// the trace may outlive the write timeout of the server
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		report, err := pecker.TracePeckTask(&config)
+		if err != nil {
+			w.WriteHeader(http.StatusNotAcceptable)
+			w.Write([]byte("Trace failed, " + err.Error()))
+			return
+		}
+		jsonStr, err := json.Marshal(report)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Trace failed, " + err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(VersionString))
+		w.Write(jsonStr)
+	}
+}
+
+func NewProfileHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "ProfileHandler")
+		stat, err := pecker.GetProfile(r.URL.Query().Get("name"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotAcceptable)
+			w.Write([]byte("Get profile failed, " + err.Error()))
+			return
+		}
+		jsonStr, err := json.Marshal(stat)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Get profile failed, " + err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonStr)
+	}
+}
+
+func NewSetOffsetHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "SetOffsetHandler")
+		defer r.Body.Close()
+
+		var config OffsetConfig
+		raw, _ := ioutil.ReadAll(r.Body)
+		err := json.Unmarshal(raw, &config)
+		if err != nil {
+			log.Infof("[Handler] Parse OffsetConfig error, %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Bad Request, %s in %v", err, string(raw[:]))))
+			return
+		}
+
+		if err := pecker.SetOffset(&config); err != nil {
+			w.WriteHeader(http.StatusNotAcceptable)
+			w.Write([]byte("Set offset failed, " + err.Error()))
+			return
+		}
+		log.Infof("[Handler] Set offset Success: %s", raw)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Set offset Success"))
+	}
+}
+
+func NewGetRuntimeHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "GetRuntimeHandler")
+		jsonStr, err := json.Marshal(pecker.Runtime())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Get runtime failed, " + err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonStr)
+	}
+}
+
+func NewSetRuntimeHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "SetRuntimeHandler")
+		defer r.Body.Close()
+
+		var config RuntimeConfig
+		raw, _ := ioutil.ReadAll(r.Body)
+		err := json.Unmarshal(raw, &config)
+		if err != nil {
+			log.Infof("[Handler] Parse RuntimeConfig error, %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Bad Request, %s in %v", err, string(raw[:]))))
+			return
+		}
+
+		if err := pecker.SetRuntime(&config); err != nil {
+			w.WriteHeader(http.StatusNotAcceptable)
+			w.Write([]byte("Set runtime failed, " + err.Error()))
+			return
+		}
+		log.Infof("[Handler] Set runtime Success: %s", raw)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Set runtime Success"))
+	}
+}
+
+func NewTaskSchemaHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "TaskSchemaHandler")
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(TaskConfigSchemaJSON())
+	}
+}
+
+// NewStatsStreamHandler streams the changes of task stats as server-sent
+// events, the first event has the stats of all tasks
+func NewStatsStreamHandler(pecker *Pecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRequest(r, "StatsStreamHandler")
+		var names []string
+		if value := r.URL.Query().Get("name"); value != "" {
+			names = strings.Split(value, ",")
+		}
+		// the stream outlives the write timeout of the server
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{})
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		rc.Flush()
+
+		ch := pecker.SubscribeStats()
+		defer pecker.UnsubscribeStats(ch)
+		differ := NewStatsDiffer(names)
+		keepalive := time.NewTicker(statsStreamKeepalive)
+		defer keepalive.Stop()
+		for {
+			select {
+			case stats := <-ch:
+				delta, ok := differ.Diff(stats, time.Now())
+				if !ok {
+					continue
+				}
+				data, err := json.Marshal(delta)
+				if err != nil {
+					log.Errorf("[Handler] Stats stream error, %s", err)
+					return
+				}
+				if _, err := fmt.Fprintf(w, "event: stats\ndata: %s\n\n", data); err != nil {
+					return
+				}
+				keepalive.Reset(statsStreamKeepalive)
+			case <-keepalive.C:
+				if _, err := w.Write([]byte(": keepalive\n\n")); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			rc.Flush()
+		}
 	}
 }
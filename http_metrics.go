@@ -0,0 +1,106 @@
+package logpeck
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BackendStat is the requests of a sender to a backend host. Status4xx
+// does not include Status429, Errors are requests without response
+type BackendStat struct {
+	Requests     int64
+	Status2xx    int64
+	Status4xx    int64
+	Status429    int64
+	Status5xx    int64
+	Errors       int64
+	BytesSent    int64
+	AvgLatencyMs float64
+}
+
+type backendCounters struct {
+	requests  int64
+	status2xx int64
+	status4xx int64
+	status429 int64
+	status5xx int64
+	errors    int64
+	bytes     int64
+	latency   int64
+}
+
+// metricsTransport counts the responses, bytes and latency of every
+// request by backend host
+type metricsTransport struct {
+	next http.RoundTripper
+
+	mu       sync.Mutex
+	backends map[string]*backendCounters
+}
+
+func newMetricsTransport(next http.RoundTripper) *metricsTransport {
+	return &metricsTransport{
+		next:     next,
+		backends: make(map[string]*backendCounters),
+	}
+}
+
+func (p *metricsTransport) backend(host string) *backendCounters {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	counters, ok := p.backends[host]
+	if !ok {
+		counters = &backendCounters{}
+		p.backends[host] = counters
+	}
+	return counters
+}
+
+func (p *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := p.next.RoundTrip(req)
+	counters := p.backend(displayHost(req.URL.Host))
+	atomic.AddInt64(&counters.requests, 1)
+	atomic.AddInt64(&counters.latency, int64(time.Since(start)))
+	if req.ContentLength > 0 {
+		atomic.AddInt64(&counters.bytes, req.ContentLength)
+	}
+	switch {
+	case err != nil:
+		atomic.AddInt64(&counters.errors, 1)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		atomic.AddInt64(&counters.status429, 1)
+	case resp.StatusCode >= 500:
+		atomic.AddInt64(&counters.status5xx, 1)
+	case resp.StatusCode >= 400:
+		atomic.AddInt64(&counters.status4xx, 1)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		atomic.AddInt64(&counters.status2xx, 1)
+	}
+	return resp, err
+}
+
+func (p *metricsTransport) BackendStats() map[string]BackendStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make(map[string]BackendStat, len(p.backends))
+	for host, counters := range p.backends {
+		stat := BackendStat{
+			Requests:  atomic.LoadInt64(&counters.requests),
+			Status2xx: atomic.LoadInt64(&counters.status2xx),
+			Status4xx: atomic.LoadInt64(&counters.status4xx),
+			Status429: atomic.LoadInt64(&counters.status429),
+			Status5xx: atomic.LoadInt64(&counters.status5xx),
+			Errors:    atomic.LoadInt64(&counters.errors),
+			BytesSent: atomic.LoadInt64(&counters.bytes),
+		}
+		if stat.Requests > 0 {
+			latency := time.Duration(atomic.LoadInt64(&counters.latency) / stat.Requests)
+			stat.AvgLatencyMs = float64(latency) / float64(time.Millisecond)
+		}
+		stats[host] = stat
+	}
+	return stats
+}
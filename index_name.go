@@ -0,0 +1,130 @@
+package logpeck
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxIndexNameBytes is the longest index name ElasticSearch accepts
+const maxIndexNameBytes = 255
+
+// indexIllegalChars can not appear in ElasticSearch index names
+const indexIllegalChars = `\/*?"<>| ,#:`
+
+// IndexTemplate generates ElasticSearch index names. "%{+layout}" is
+// replaced by the send time formatted with the Go time layout, "{name}" by
+// the event field name or one of the builtins "host" and "host_prefix".
+// Names are lowercased
+type IndexTemplate struct {
+	template *FieldTemplate
+	builtins map[string]string
+}
+
+func NewIndexTemplate(index string) (*IndexTemplate, error) {
+	t := &IndexTemplate{template: NewFieldTemplate(index)}
+	host := GetHost()
+	t.builtins = map[string]string{
+		"host":        host,
+		"host_prefix": strings.FieldsFunc(host+".", func(r rune) bool { return r == '.' || r == '-' })[0],
+	}
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// validate rejects templates whose literal parts make invalid names
+func (t *IndexTemplate) validate() error {
+	literals := strings.Join(t.template.literals, "")
+	if literals == "" && len(t.template.vars) == 0 {
+		return errors.New("Index error: empty index name")
+	}
+	for _, literal := range t.template.literals {
+		literal = expandIndexDate(literal, time.Time{})
+		if i := strings.IndexAny(literal, indexIllegalChars); i >= 0 {
+			return fmt.Errorf("Index error: %s has illegal character %q", literal, literal[i])
+		}
+	}
+	if first := t.template.literals[0]; strings.ContainsAny(first[:min(len(first), 1)], "-_+") {
+		return fmt.Errorf("Index error: %s must not start with %q", first, first[0])
+	}
+	if name := literals; name == "." || name == ".." {
+		return fmt.Errorf("Index error: %s is not a valid name", name)
+	}
+	return nil
+}
+
+// IsStatic reports whether the template does not depend on event fields
+func (t *IndexTemplate) IsStatic() bool {
+	return len(t.template.vars) == 0
+}
+
+// HasDate reports whether the template depends on the send time
+func (t *IndexTemplate) HasDate() bool {
+	for _, literal := range t.template.literals {
+		if strings.Contains(literal, "%{+") {
+			return true
+		}
+	}
+	return false
+}
+
+// Execute returns the index name of fields sent at now. Field values are
+// lowercased and their illegal characters replaced, an error is returned if
+// the name is still invalid
+func (t *IndexTemplate) Execute(fields map[string]interface{}, now time.Time) (string, error) {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(expandIndexDate(t.template.literals[0], now)))
+	for i, name := range t.template.vars {
+		value, ok := t.builtins[name]
+		if v, found := fields[name]; found {
+			value, ok = fmt.Sprint(v), true
+		}
+		if !ok {
+			return "", fmt.Errorf("Index error: field %s not found", name)
+		}
+		b.WriteString(SanitizeIndexName(value))
+		b.WriteString(strings.ToLower(expandIndexDate(t.template.literals[i+1], now)))
+	}
+	name := strings.TrimLeft(b.String(), "-_+")
+	if len(name) > maxIndexNameBytes {
+		name = name[:maxIndexNameBytes]
+		for !utf8.ValidString(name) {
+			name = name[:len(name)-1]
+		}
+	}
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("Index error: %q is not a valid name", b.String())
+	}
+	return name, nil
+}
+
+// SanitizeIndexName lowercases name and replaces the characters which can
+// not appear in index names by "_"
+func SanitizeIndexName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(indexIllegalChars, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, strings.ToLower(name))
+}
+
+// expandIndexDate replaces the "%{+layout}" of s by now formatted with
+// layout
+func expandIndexDate(s string, now time.Time) string {
+	for {
+		l := strings.Index(s, "%{+")
+		if l < 0 {
+			return s
+		}
+		r := strings.Index(s[l:], "}")
+		if r < 0 {
+			return s
+		}
+		s = s[:l] + now.Format(s[l+3:l+r]) + s[l+r+1:]
+	}
+}
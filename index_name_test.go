@@ -0,0 +1,47 @@
+package logpeck
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndexTemplate(*testing.T) {
+	for _, index := range []string{"", "a/b", "-logs", "logs-%{+15:04}", ".."} {
+		if _, err := NewIndexTemplate(index); err == nil {
+			panic(index)
+		}
+	}
+
+	now := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+	index, err := NewIndexTemplate("Logs-{app}-%{+2006.01.02}")
+	if err != nil {
+		panic(err)
+	}
+	if index.IsStatic() || !index.HasDate() {
+		panic(index)
+	}
+	name, err := index.Execute(map[string]interface{}{"app": "Web Server/API"}, now)
+	if err != nil || name != "logs-web_server_api-2017.07.14" {
+		panic(name)
+	}
+	if _, err := index.Execute(map[string]interface{}{}, now); err == nil {
+		panic("missing field accepted")
+	}
+
+	index, _ = NewIndexTemplate("{app}")
+	if name, _ = index.Execute(map[string]interface{}{"app": "_Nginx"}, now); name != "nginx" {
+		panic(name)
+	}
+	if name, err = index.Execute(map[string]interface{}{"app": "__"}, now); err == nil {
+		panic(name)
+	}
+	if name, _ = index.Execute(map[string]interface{}{"app": strings.Repeat("é", 200)}, now); len(name) != 254 {
+		panic(len(name))
+	}
+
+	index, _ = NewIndexTemplate("logs-{host}")
+	if name, _ = index.Execute(nil, now); name != "logs-"+SanitizeIndexName(GetHost()) {
+		panic(name)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package logpeck
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode of a file, 0 if unknown
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,10 @@
+package logpeck
+
+import (
+	"os"
+)
+
+// fileInode returns 0, files have no inode on windows
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
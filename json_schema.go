@@ -0,0 +1,143 @@
+package logpeck
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// senderConfigTypes and extractorConfigTypes are the Config types parsed
+// by GetSenderConfig and NewExtractorConfig, nil if no Config is parsed
+var senderConfigTypes = map[string]interface{}{
+	SenderTypeES:         ElasticSearchConfig{},
+	SenderTypeInfluxDb:   InfluxDbConfig{},
+	SenderTypeKafka:      KafkaConfig{},
+	SenderTypePrometheus: nil,
+	SenderTypeDatadog:    DatadogConfig{},
+	SenderTypeOtlp:       OtlpConfig{},
+	SenderTypeZabbix:     ZabbixConfig{},
+	SenderTypeSyslog:     SyslogConfig{},
+	SenderTypeGelf:       GelfConfig{},
+	SenderTypeFluentd:    FluentdConfig{},
+	SenderTypeLumberjack: LumberjackConfig{},
+	SenderTypeEmail:      EmailConfig{},
+	SenderTypeChat:       ChatConfig{},
+}
+
+var extractorConfigTypes = map[string]interface{}{
+	ExTypeText:   TextExtractorConfig{},
+	ExTypeJson:   JsonExtractorConfig{},
+	ExTypeLua:    LuaExtractorConfig{},
+	ExTypeLogrus: nil,
+}
+
+type schemaBuilder struct {
+	// prefix is the path of definitions in the document
+	prefix      string
+	definitions map[string]interface{}
+}
+
+func newSchemaBuilder(prefix string) *schemaBuilder {
+	b := &schemaBuilder{prefix: prefix, definitions: make(map[string]interface{})}
+	b.definitions["SenderConfig"] = b.variants(senderConfigTypes)
+	b.definitions["ExtractorConfig"] = b.variants(extractorConfigTypes)
+	return b
+}
+
+// TaskConfigSchema returns the JSON Schema (draft-07) of task configs,
+// generated from the config types. Sender and extractor Config are
+// validated by Name
+func TaskConfigSchema() map[string]interface{} {
+	b := newSchemaBuilder("#/definitions/")
+	root := b.object(reflect.TypeOf(PeckTaskConfig{}))
+	root["$schema"] = "http://json-schema.org/draft-07/schema#"
+	root["title"] = "logpeck task config"
+	root["required"] = []string{"Name"}
+	root["definitions"] = b.definitions
+	return root
+}
+
+// variants is the schema of a Name and a Config whose type depends on Name
+func (b *schemaBuilder) variants(types map[string]interface{}) map[string]interface{} {
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var cases []interface{}
+	for _, name := range names {
+		if types[name] == nil {
+			continue
+		}
+		cases = append(cases, map[string]interface{}{
+			"if":   map[string]interface{}{"properties": map[string]interface{}{"Name": map[string]interface{}{"const": name}}},
+			"then": map[string]interface{}{"properties": map[string]interface{}{"Config": b.schema(reflect.TypeOf(types[name]))}},
+		})
+	}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"Name":   map[string]interface{}{"enum": names},
+			"Config": map[string]interface{}{"type": "object"},
+		},
+		"required":             []string{"Name"},
+		"additionalProperties": false,
+		"allOf":                cases,
+	}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Implements(unmarshalerType) || reflect.PtrTo(t).Implements(unmarshalerType) {
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		ref := map[string]interface{}{"$ref": b.prefix + t.Name()}
+		if _, ok := b.definitions[t.Name()]; !ok {
+			b.definitions[t.Name()] = nil
+			b.definitions[t.Name()] = b.object(t)
+		}
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	fields := make(map[string]reflect.StructField)
+	jsonFields(t, fields, nil)
+	properties := make(map[string]interface{}, len(fields))
+	for name, f := range fields {
+		properties[name] = b.schema(f.Type)
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// TaskConfigSchemaJSON returns TaskConfigSchema as indented JSON
+func TaskConfigSchemaJSON() []byte {
+	raw, _ := json.MarshalIndent(TaskConfigSchema(), "", "  ")
+	return append(raw, '\n')
+}
@@ -0,0 +1,44 @@
+package logpeck
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestTaskConfigSchema(*testing.T) {
+	version := GetVersionInfo()
+	for _, name := range version.Senders {
+		if _, ok := senderConfigTypes[name]; !ok {
+			panic("no schema of sender " + name)
+		}
+	}
+	for _, name := range version.Extractors {
+		if _, ok := extractorConfigTypes[name]; !ok {
+			panic("no schema of extractor " + name)
+		}
+	}
+
+	raw := TaskConfigSchemaJSON()
+	definitions := TaskConfigSchema()["definitions"].(map[string]interface{})
+	for _, ref := range regexp.MustCompile(`"#/definitions/(\w+)"`).FindAllSubmatch(raw, -1) {
+		if definitions[string(ref[1])] == nil {
+			panic("missing definition " + string(ref[1]))
+		}
+	}
+	for _, field := range []string{`"Delivery"`, `"SequenceField"`, `"IdField"`, `"MessageField"`, `"Delimiters"`} {
+		if !bytes.Contains(raw, []byte(field)) {
+			panic(field)
+		}
+	}
+
+	// doc/task_config.schema.json is generated with logpeckd -schema
+	published, err := os.ReadFile("doc/task_config.schema.json")
+	if err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(published, raw) {
+		panic("doc/task_config.schema.json is outdated, regenerate it with logpeckd -schema")
+	}
+}
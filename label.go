@@ -0,0 +1,79 @@
+package logpeck
+
+import (
+	"fmt"
+	"strings"
+)
+
+type labelRequirement struct {
+	key      string
+	value    string
+	operator string
+}
+
+// LabelSelector selects tasks by their Labels. A selector is a comma
+// separated list of requirements, all of them must match:
+// "key=value", "key!=value", "key" (key exists) and "!key" (key missing)
+type LabelSelector struct {
+	requirements []labelRequirement
+}
+
+func ParseLabelSelector(selector string) (*LabelSelector, error) {
+	p := &LabelSelector{}
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var r labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			r = labelRequirement{key: parts[0], value: parts[1], operator: "!="}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(term, "=", 2)
+			r = labelRequirement{key: parts[0], value: parts[1], operator: "="}
+		case strings.HasPrefix(term, "!"):
+			r = labelRequirement{key: term[1:], operator: "!"}
+		default:
+			r = labelRequirement{key: term, operator: ""}
+		}
+		r.key = strings.TrimSpace(r.key)
+		r.value = strings.TrimSpace(r.value)
+		if r.key == "" {
+			return nil, fmt.Errorf("Label selector error: %s", term)
+		}
+		p.requirements = append(p.requirements, r)
+	}
+	return p, nil
+}
+
+// Matches reports whether labels satisfy all requirements, an empty or nil
+// selector matches everything
+func (p *LabelSelector) Matches(labels map[string]string) bool {
+	if p == nil {
+		return true
+	}
+	for _, r := range p.requirements {
+		value, ok := labels[r.key]
+		switch r.operator {
+		case "=":
+			if !ok || value != r.value {
+				return false
+			}
+		case "!=":
+			if ok && value == r.value {
+				return false
+			}
+		case "!":
+			if ok {
+				return false
+			}
+		default:
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
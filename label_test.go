@@ -0,0 +1,38 @@
+package logpeck
+
+import (
+	"testing"
+)
+
+func TestLabelSelector(*testing.T) {
+	labels := map[string]string{"team": "infra", "env": "prod"}
+	cases := map[string]bool{
+		"":                         true,
+		"team=infra":               true,
+		"team=infra, env=prod":     true,
+		"team=web":                 false,
+		"env!=dev":                 true,
+		"env!=prod":                false,
+		"team":                     true,
+		"service":                  false,
+		"!service":                 true,
+		"!team":                    false,
+		"team=infra,service=nginx": false,
+	}
+	for selector, match := range cases {
+		s, err := ParseLabelSelector(selector)
+		if err != nil {
+			panic(err)
+		}
+		if s.Matches(labels) != match {
+			panic(selector)
+		}
+	}
+	if _, err := ParseLabelSelector("=infra"); err == nil {
+		panic("selector without key should fail")
+	}
+	var all *LabelSelector
+	if !all.Matches(nil) {
+		panic("nil selector should match")
+	}
+}
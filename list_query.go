@@ -0,0 +1,105 @@
+package logpeck
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	SortByName       = "name"
+	SortByLag        = "lag"
+	SortByThroughput = "throughput"
+)
+
+// ListQuery selects, sorts and pages the tasks of list APIs. Tasks are
+// filtered by a label Selector, sorted by SortBy ("name", "lag" or
+// "throughput"), then Limit tasks from Offset are returned. Fields keeps
+// only the named fields of configs and stats, Name is always kept
+type ListQuery struct {
+	Selector string   `json:"Selector"`
+	SortBy   string   `json:"SortBy"`
+	Desc     bool     `json:"Desc"`
+	Offset   int      `json:"Offset"`
+	Limit    int      `json:"Limit"`
+	Fields   []string `json:"Fields"`
+}
+
+func (p *ListQuery) Validate() error {
+	switch strings.ToLower(p.SortBy) {
+	case "", SortByName, SortByLag, SortByThroughput:
+	default:
+		return fmt.Errorf("SortBy error: %s", p.SortBy)
+	}
+	if p.Offset < 0 || p.Limit < 0 {
+		return fmt.Errorf("Offset and Limit must not be negative")
+	}
+	return nil
+}
+
+// Page sorts and pages stats, and returns the configs of the paged tasks in
+// the same order, with the number of tasks before paging
+func (p *ListQuery) Page(configs []PeckTaskConfig, stats []PeckTaskStat) ([]PeckTaskConfig, []PeckTaskStat, int) {
+	less := func(a, b *PeckTaskStat) bool { return a.Name < b.Name }
+	switch strings.ToLower(p.SortBy) {
+	case SortByLag:
+		less = func(a, b *PeckTaskStat) bool { return a.LagBytes < b.LagBytes }
+	case SortByThroughput:
+		less = func(a, b *PeckTaskStat) bool { return a.LinesPerSec < b.LinesPerSec }
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if p.Desc {
+			return less(&stats[j], &stats[i])
+		}
+		return less(&stats[i], &stats[j])
+	})
+
+	total := len(stats)
+	start, end := p.Offset, total
+	if start > total {
+		start = total
+	}
+	if p.Limit > 0 && start+p.Limit < end {
+		end = start + p.Limit
+	}
+	stats = stats[start:end]
+
+	byName := make(map[string]PeckTaskConfig)
+	for _, config := range configs {
+		byName[config.Name] = config
+	}
+	paged := []PeckTaskConfig{}
+	for _, stat := range stats {
+		if config, ok := byName[stat.Name]; ok {
+			paged = append(paged, config)
+		}
+	}
+	return paged, stats, total
+}
+
+// Project keeps only Fields of every item, all fields if Fields is empty
+func (p *ListQuery) Project(items interface{}) (interface{}, error) {
+	if len(p.Fields) == 0 {
+		return items, nil
+	}
+	raw, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(raw, &objects); err != nil {
+		return nil, err
+	}
+	projected := make([]map[string]interface{}, 0, len(objects))
+	for _, object := range objects {
+		item := map[string]interface{}{"Name": object["Name"]}
+		for _, field := range p.Fields {
+			if v, ok := object[field]; ok {
+				item[field] = v
+			}
+		}
+		projected = append(projected, item)
+	}
+	return projected, nil
+}
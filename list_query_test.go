@@ -0,0 +1,55 @@
+package logpeck
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListQueryPage(*testing.T) {
+	configs := []PeckTaskConfig{{Name: "a", LogPath: "/a"}, {Name: "b", LogPath: "/b"}, {Name: "c", LogPath: "/c"}}
+	stats := []PeckTaskStat{
+		{Name: "a", LagBytes: 10, LinesPerSec: 3},
+		{Name: "b", LagBytes: 30, LinesPerSec: 1},
+		{Name: "c", LagBytes: 20, LinesPerSec: 2},
+	}
+	query := &ListQuery{SortBy: "lag", Desc: true, Offset: 1, Limit: 1}
+	if err := query.Validate(); err != nil {
+		panic(err)
+	}
+	pagedConfigs, pagedStats, total := query.Page(configs, stats)
+	if total != 3 || len(pagedStats) != 1 || pagedStats[0].Name != "c" ||
+		len(pagedConfigs) != 1 || pagedConfigs[0].LogPath != "/c" {
+		panic(pagedStats)
+	}
+
+	query = &ListQuery{SortBy: "throughput", Offset: 5}
+	if _, pagedStats, _ = query.Page(configs, stats); len(pagedStats) != 0 {
+		panic(pagedStats)
+	}
+	if err := (&ListQuery{SortBy: "size"}).Validate(); err == nil {
+		panic("unknown SortBy should fail")
+	}
+
+	query = &ListQuery{Fields: []string{"LagBytes"}}
+	projected, err := query.Project(stats)
+	if err != nil {
+		panic(err)
+	}
+	items := projected.([]map[string]interface{})
+	if len(items) != 3 || len(items[0]) != 2 || items[0]["LagBytes"] == nil || items[0]["Name"] == nil {
+		panic(items)
+	}
+}
+
+func TestRateMeter(*testing.T) {
+	meter := &RateMeter{}
+	now := time.Now()
+	meter.Rate(now)
+	meter.Add(200)
+	if rate := meter.Rate(now.Add(500 * time.Millisecond)); rate != 0 {
+		panic(rate)
+	}
+	if rate := meter.Rate(now.Add(2 * time.Second)); rate != 100 || meter.Total() != 200 {
+		panic(rate)
+	}
+}
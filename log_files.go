@@ -0,0 +1,251 @@
+package logpeck
+
+import (
+	"context"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// globScanInterval is how often the files of a glob or directory LogPath
+// are listed again
+var globScanInterval = 5 * time.Second
+
+// IsGlobPath reports whether path is a glob pattern
+func IsGlobPath(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// FileStat is the reading of one file of a glob or directory LogPath
+type FileStat struct {
+	LinesTotal int64
+	BytesTotal int64
+	LagBytes   int64
+}
+
+// multiFile reports whether LogPath is a glob pattern or a directory, whose
+// files are tailed each by their own LogTask
+func (p *LogTask) multiFile() bool {
+	return p.dir != nil || IsGlobPath(p.LogPath)
+}
+
+// SetDirectory makes LogPath a directory whose files are pecked, the task
+// must be stopped
+func (p *LogTask) SetDirectory(config *DirectoryConfig) {
+	p.dir = nil
+	if config.Enable {
+		dir := *config
+		p.dir = &dir
+	}
+}
+
+// sameDirectory reports whether config sets the directory of the task
+func (p *LogTask) sameDirectory(config *DirectoryConfig) bool {
+	if !config.Enable {
+		return p.dir == nil
+	}
+	return p.dir != nil && reflect.DeepEqual(*p.dir, *config)
+}
+
+// ownsFile reports whether path is or was one of the files of a glob or
+// directory LogPath
+func (p *LogTask) ownsFile(path string) bool {
+	if p.dir != nil {
+		return strings.HasPrefix(path, strings.TrimSuffix(p.LogPath, "/")+"/")
+	}
+	ok, _ := filepath.Match(p.LogPath, path)
+	return ok
+}
+
+// logFiles returns the files of LogPath, LogPath itself if it is a file
+func (p *LogTask) logFiles() []string {
+	if !p.multiFile() {
+		return []string{p.LogPath}
+	}
+	files, _ := p.matchFiles()
+	return files
+}
+
+// matchFiles returns the files of a glob or directory LogPath
+func (p *LogTask) matchFiles() ([]string, error) {
+	if p.dir != nil {
+		return p.dir.Files(p.LogPath)
+	}
+	return filepath.Glob(p.LogPath)
+}
+
+// FileStats returns the stats of the tailed files of a glob or directory
+// LogPath, by path
+func (p *LogTask) FileStats() map[string]FileStat {
+	files := p.tailedFiles()
+	if len(files) == 0 {
+		return nil
+	}
+	stats := make(map[string]FileStat, len(files))
+	for path, file := range files {
+		stats[path] = FileStat{
+			LinesTotal: atomic.LoadInt64(&file.lines),
+			BytesTotal: atomic.LoadInt64(&file.bytes),
+			LagBytes:   file.Lag(),
+		}
+	}
+	return stats
+}
+
+// runGlob tails the files of LogPath until ctx is done. The files found
+// when the task starts resume at their saved offset or at their end, files
+// appearing later are read from the beginning
+func (p *LogTask) runGlob(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	defer p.stopFiles()
+	ticker := time.NewTicker(globScanInterval)
+	defer ticker.Stop()
+	for initial := true; ; initial = false {
+		p.scanGlob(ctx, initial)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scanGlob starts tailing the new files of LogPath, and reaps the stale
+// ones
+func (p *LogTask) scanGlob(ctx context.Context, initial bool) {
+	matches, err := p.matchFiles()
+	if err != nil {
+		log.Errorf("[LogTask %s] List files error, err[%s]", p.LogPath, err)
+		return
+	}
+	now := time.Now()
+	idle := time.Duration(Config.Reap.IdleTimeout) * time.Second
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	matched := make(map[string]bool, len(matches))
+	for _, path := range matches {
+		matched[path] = true
+		p.seen[path] = now
+		if _, ok := p.files[path]; ok {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if idle > 0 && now.Sub(info.ModTime()) >= idle {
+			offset, ok := p.saved[path]
+			if !ok && initial {
+				// it would be tailed from its end
+				p.saved[path] = LogOffset{LogPath: path, Inode: fileInode(info), Offset: info.Size()}
+				continue
+			}
+			if ok && offset.Inode == fileInode(info) && offset.Offset >= info.Size() {
+				continue
+			}
+		}
+		file := NewLogTask(path)
+		file.peckTasks = p.peckTasks
+		file.governor = p.governor
+		if offset, ok := p.saved[path]; ok {
+			file.offset = &offset
+		} else if !initial {
+			file.offset = &LogOffset{LogPath: path, Inode: fileInode(info)}
+		}
+		log.Infof("[LogTask %s] Tail %s", p.LogPath, path)
+		if err := file.Start(ctx); err != nil {
+			log.Errorf("[LogTask %s] Tail %s error, err[%s]", p.LogPath, path, err)
+			continue
+		}
+		p.files[path] = file
+	}
+	p.reap(now, matched)
+	pending := int32(0)
+	if len(matches) == 0 {
+		pending = 1
+	}
+	atomic.StoreInt32(&p.pending, pending)
+}
+
+// reap closes the tails of the files not written for the reap idle_timeout
+// and read to their end, or removed. The offsets of the files not seen for
+// the reap offset_retention are forgotten. p.mu must be held
+func (p *LogTask) reap(now time.Time, matched map[string]bool) {
+	if idle := time.Duration(Config.Reap.IdleTimeout) * time.Second; idle > 0 {
+		for path, file := range p.files {
+			info, err := os.Stat(path)
+			if err == nil && (now.Sub(info.ModTime()) < idle || file.Lag() > 0) {
+				continue
+			}
+			file.Stop()
+			if offset, ok := file.Offset(); ok {
+				p.saved[path] = offset
+			}
+			delete(p.files, path)
+			log.Infof("[LogTask %s] Close stale %s", p.LogPath, path)
+		}
+	}
+	retention := time.Duration(Config.Reap.OffsetRetention) * time.Second
+	if retention <= 0 {
+		return
+	}
+	for path := range p.saved {
+		if _, ok := p.files[path]; ok || matched[path] {
+			continue
+		}
+		seen, ok := p.seen[path]
+		if !ok {
+			p.seen[path] = now
+			continue
+		}
+		if now.Sub(seen) >= retention {
+			delete(p.saved, path)
+			delete(p.seen, path)
+			p.expired = append(p.expired, path)
+			log.Infof("[LogTask %s] Forget offset of %s", p.LogPath, path)
+		}
+	}
+}
+
+// TakeExpired returns the files whose offsets were forgotten since the
+// previous call, to remove them from the database
+func (p *LogTask) TakeExpired() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	expired := p.expired
+	p.expired = nil
+	return expired
+}
+
+// tailedFiles returns the tails of the files of LogPath
+func (p *LogTask) tailedFiles() map[string]*LogTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	files := make(map[string]*LogTask, len(p.files))
+	for path, file := range p.files {
+		files[path] = file
+	}
+	return files
+}
+
+// stopFiles stops tailing the files of LogPath, their offsets are kept to
+// resume
+func (p *LogTask) stopFiles() {
+	p.mu.Lock()
+	files := p.files
+	p.files = make(map[string]*LogTask)
+	p.mu.Unlock()
+	for path, file := range files {
+		file.Stop()
+		if offset, ok := file.Offset(); ok {
+			p.mu.Lock()
+			p.saved[path] = offset
+			p.mu.Unlock()
+		}
+	}
+	atomic.StoreInt32(&p.pending, 0)
+}
@@ -0,0 +1,66 @@
+package logpeck
+
+import (
+	"context"
+	log "github.com/Sirupsen/logrus"
+	"github.com/hpcloud/tail"
+	"github.com/hpcloud/tail/watch"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// linkDrainPolls bounds how many polls the previous target of a re-pointed
+// symlink is read for, a last line without newline is never read
+var linkDrainPolls = 40
+
+func isSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// followLink waits until the symlink LogPath is re-pointed away from path,
+// then lets t read path until it stops growing and stops t at its end, so
+// the lines written before the switch are not lost. It returns false if ctx
+// is done first
+func (p *LogTask) followLink(ctx context.Context, t *tail.Tail, path string) bool {
+	wait := func() bool {
+		timer := time.NewTimer(watch.POLL_DURATION)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for {
+		if !wait() {
+			return false
+		}
+		if target, err := filepath.EvalSymlinks(p.LogPath); err == nil && target != path {
+			log.Infof("[LogTask %s] Link re-pointed from %s to %s", p.LogPath, path, target)
+			break
+		}
+	}
+	size := int64(-1)
+	for i := 0; i < linkDrainPolls; i++ {
+		info, err := os.Stat(path)
+		if err != nil {
+			break
+		}
+		p.mu.Lock()
+		read := p.read
+		caughtUp := read != nil && read.Inode == fileInode(info) && read.Offset >= info.Size()
+		p.mu.Unlock()
+		if caughtUp && info.Size() == size {
+			break
+		}
+		size = info.Size()
+		if !wait() {
+			return false
+		}
+	}
+	t.StopAtEOF()
+	return true
+}
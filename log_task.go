@@ -1,26 +1,80 @@
 package logpeck
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/hpcloud/tail"
+	"io"
+	stdlog "log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	pendingMinBackoff = time.Second
+	pendingMaxBackoff = 30 * time.Second
 )
 
 type LogTask struct {
 	LogPath string
 
 	peckTasks map[string]*PeckTask
-	tail      *tail.Tail
 	stop      bool
 	errMsg    string
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	// pending is set while the task waits for LogPath to appear
+	pending int32
+	// atLeastOnce is set while a peck task needs confirmed delivery, the
+	// saved offset is then the committed one
+	atLeastOnce int32
+
+	// governor slows reading down while the host is loaded
+	governor *LoadGovernor
+	limiter  *RateLimiter
+
+	mu     sync.Mutex
+	tailer *tail.Tail
+	// path is the file tailed, the target of LogPath if it is a symlink
+	path string
+	// offset is where reading resumes, updated when tailing stops
+	offset *LogOffset
+	// committed is the end of the last line all peck tasks processed, read
+	// the end of the last line received from the tailer
+	committed *LogOffset
+	read      *LogOffset
+
+	// files tail the files of LogPath if it is a glob pattern or the
+	// directory dir, by path, saved are where they resume
+	files map[string]*LogTask
+	saved map[string]LogOffset
+	dir   *DirectoryConfig
+	// seen is when the files were last listed, expired are the files whose
+	// offsets were forgotten
+	seen    map[string]time.Time
+	expired []string
+
+	// lines and bytes count what was read
+	lines int64
+	bytes int64
 }
 
 func NewLogTask(path string) *LogTask {
 	task := &LogTask{
 		LogPath:   path,
 		peckTasks: make(map[string]*PeckTask),
-		tail:      nil,
 		stop:      true,
+		files:     make(map[string]*LogTask),
+		saved:     make(map[string]LogOffset),
+		seen:      make(map[string]time.Time),
 	}
 	return task
 }
@@ -30,13 +84,18 @@ func (p *LogTask) AddPeckTask(task *PeckTask) error {
 	return nil
 }
 
-func (p *LogTask) UpdatePeckTask(task *PeckTask) error {
-	if !task.IsStop() {
-		if err := p.peckTasks[task.Config.Name].Stop(); err != nil {
+func (p *LogTask) UpdatePeckTask(ctx context.Context, task *PeckTask) error {
+	if _, ok := p.peckTasks[task.Config.Name]; !ok {
+		return errors.New("Peck task not exist")
+	}
+	if old := p.peckTasks[task.Config.Name]; !task.IsStop() && !old.IsStop() {
+		if err := old.Handover(ctx, task); err != nil {
 			return err
 		}
 		p.peckTasks[task.Config.Name] = task
-		if err := task.Start(); err != nil {
+	} else if !task.IsStop() {
+		p.peckTasks[task.Config.Name] = task
+		if err := task.Start(ctx); err != nil {
 			return err
 		}
 	} else {
@@ -46,6 +105,9 @@ func (p *LogTask) UpdatePeckTask(task *PeckTask) error {
 }
 
 func (p *LogTask) RemovePeckTask(config *PeckTaskConfig) error {
+	if !p.Exist(config) {
+		return errors.New("Peck task not exist")
+	}
 	if !p.peckTasks[config.Name].IsStop() {
 		p.peckTasks[config.Name].Stop()
 	}
@@ -53,32 +115,34 @@ func (p *LogTask) RemovePeckTask(config *PeckTaskConfig) error {
 	return nil
 }
 
-func (p *LogTask) StartPeckTask(config *PeckTaskConfig) error {
+func (p *LogTask) StartPeckTask(ctx context.Context, config *PeckTaskConfig) error {
 	if !p.Exist(config) {
-		panic(config)
+		return errors.New("Peck task not exist")
 	}
-	if p.peckTasks[config.Name].IsStop() {
-		if err := p.peckTasks[config.Name].Start(); err != nil {
-			return err
-		}
-	} else {
-		panic(config)
+	if !p.peckTasks[config.Name].IsStop() {
+		return errors.New("Task already started")
 	}
-	return nil
+	return p.peckTasks[config.Name].Start(ctx)
 }
 
 func (p *LogTask) StopPeckTask(config *PeckTaskConfig) error {
 	if !p.Exist(config) {
-		panic(config)
+		return errors.New("Peck task not exist")
 	}
-	if !p.peckTasks[config.Name].IsStop() {
-		if err := p.peckTasks[config.Name].Stop(); err != nil {
-			return err
+	if p.peckTasks[config.Name].IsStop() {
+		return errors.New("Task already stopped")
+	}
+	return p.peckTasks[config.Name].Stop()
+}
+
+// Idle reports whether all peck tasks of the log are stopped
+func (p *LogTask) Idle() bool {
+	for _, task := range p.peckTasks {
+		if !task.IsStop() {
+			return false
 		}
-	} else {
-		panic(config)
 	}
-	return nil
+	return true
 }
 
 func (p *LogTask) Exist(config *PeckTaskConfig) bool {
@@ -94,51 +158,268 @@ func (p *LogTask) Empty() bool {
 	}
 }
 
-func peckLogBG(p *LogTask) {
+func peckLogBG(ctx context.Context, p *LogTask, lines chan *tail.Line, reopened <-chan struct{}, maxLine int) {
 	log.Infof("[LogTask %s] Start peck log", p.LogPath)
-	for content := range p.tail.Lines {
-		for name, task := range p.peckTasks {
-			// process log
-			log.Debugf("[LogTask %s] %s content[%s]", p.LogPath, name, content.Text)
-			task.Process(content.Text)
+	parts := &lineParts{max: maxLine}
+	peck := func(ready []linePart) bool {
+		for _, part := range ready {
+			if !p.peckLine(ctx, part) {
+				return false
+			}
+		}
+		return true
+	}
+	var held <-chan time.Time
+	for {
+		select {
+		case <-reopened:
+			if !peck(parts.end()) {
+				return
+			}
+			p.reopened()
+		case content, ok := <-lines:
+			if !ok {
+				peck(parts.end())
+				return
+			}
+			if !peck(parts.add(content)) {
+				return
+			}
+			held = nil
+			if parts.held != nil {
+				held = time.After(linePartWait)
+			}
+		case <-held:
+			if !peck(parts.end()) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// peckLine processes part with the peck tasks, it returns false if ctx is
+// done meanwhile
+func (p *LogTask) peckLine(ctx context.Context, part linePart) bool {
+	if !p.throttle(ctx) {
+		return false
+	}
+	var atLeastOnce int32
+	sequence := false
+	for _, task := range p.peckTasks {
+		if task.Config.Delivery == DeliveryAtLeastOnce {
+			atLeastOnce = 1
 		}
-		if p.stop {
-			break
+		sequence = sequence || task.Config.SequenceField != ""
+	}
+	atomic.StoreInt32(&p.atLeastOnce, atLeastOnce)
+	atomic.AddInt64(&p.lines, 1)
+	atomic.AddInt64(&p.bytes, part.n)
+	seq := ""
+	if start, ok := p.lineStart(part.n); ok && sequence {
+		seq = LineSequence(start)
+	}
+	for name, task := range p.peckTasks {
+		// process log
+		log.Debugf("[LogTask %s] %s content[%s]", p.LogPath, name, part.text)
+		task.ProcessFileLine(p.LogPath, part.text, seq)
+	}
+	if ctx.Err() == nil {
+		p.commit(part.n)
+	}
+	return true
+}
+
+// maxLineSize is the longest MaxLineSize of the peck tasks, lines are split
+// in parts of it while tailed, 0 if a task keeps lines whole
+func (p *LogTask) maxLineSize() int {
+	max := 0
+	for _, task := range p.peckTasks {
+		if task.read.MaxLineSize <= 0 {
+			return 0
+		}
+		if task.read.MaxLineSize > max {
+			max = task.read.MaxLineSize
 		}
 	}
+	return max
 }
 
-func (p *LogTask) Start() error {
+// throttle paces lines while the governor throttles, it returns false if
+// ctx is done meanwhile
+func (p *LogTask) throttle(ctx context.Context) bool {
+	if !p.governor.Throttled() {
+		p.limiter = nil
+		return true
+	}
+	if p.limiter == nil {
+		p.limiter = NewRateLimiter(p.governor.Rate())
+	}
+	return p.limiter.Wait(ctx.Done())
+}
+
+// Start tails the log until Stop is called or ctx is done. If LogPath does
+// not exist yet the task is pending until it appears, then it is read from
+// the beginning. A glob or directory LogPath tails each of its files
+func (p *LogTask) Start(ctx context.Context) error {
 	if !p.stop {
 		return errors.New("LogTask already started")
 	}
 	log.Infof("[LogTask %s] Start LogTask", p.LogPath)
-	if p.tail == nil {
-		tailConf := tail.Config{
-			ReOpen: true,
-			Poll:   true,
-			Follow: true,
-			Location: &tail.SeekInfo{
-				Offset: 0,
-				Whence: 2,
-			},
-		}
-		p.tail, _ = tail.TailFile(p.LogPath, tailConf)
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.done = make(chan struct{})
+	if p.multiFile() {
+		go p.runGlob(ctx, p.done)
+	} else {
+		go p.run(ctx, p.done)
 	}
-
-	go peckLogBG(p)
 	p.stop = false
 	return nil
 }
 
+func (p *LogTask) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	location := &tail.SeekInfo{Offset: 0, Whence: io.SeekEnd}
+	if _, err := os.Stat(p.LogPath); os.IsNotExist(err) {
+		if !p.waitLog(ctx) {
+			return
+		}
+		location.Whence = io.SeekStart
+	} else if offset := p.resumeOffset(); offset >= 0 {
+		log.Infof("[LogTask %s] Resume at offset %d", p.LogPath, offset)
+		location = &tail.SeekInfo{Offset: offset, Whence: io.SeekStart}
+	} else if info, err := os.Stat(p.LogPath); err == nil {
+		location = &tail.SeekInfo{Offset: info.Size(), Whence: io.SeekStart}
+	}
+	for p.tailFile(ctx, location) {
+		// the symlink LogPath was re-pointed, its new target is read
+		// from the beginning
+		location = &tail.SeekInfo{Offset: 0, Whence: io.SeekStart}
+	}
+}
+
+// tailFile tails LogPath from location, or its target if it is a symlink.
+// It returns true if the symlink was re-pointed and the previous target was
+// read to its end
+func (p *LogTask) tailFile(ctx context.Context, location *tail.SeekInfo) bool {
+	path, link := p.LogPath, isSymlink(p.LogPath)
+	if link {
+		if target, err := filepath.EvalSymlinks(p.LogPath); err == nil {
+			path = target
+		}
+	}
+	p.mu.Lock()
+	p.committed, p.read = nil, nil
+	if info, err := os.Stat(path); err == nil && location.Whence == io.SeekStart {
+		p.committed = &LogOffset{LogPath: p.LogPath, Inode: fileInode(info), Offset: location.Offset}
+		read := *p.committed
+		p.read = &read
+	}
+	p.mu.Unlock()
+	reopened, done := make(chan struct{}), make(chan struct{})
+	maxLine := p.maxLineSize()
+	tailConf := tail.Config{
+		ReOpen:      true,
+		Poll:        true,
+		Follow:      true,
+		Location:    location,
+		MaxLineSize: maxLine,
+		Logger:      &tailLogger{Logger: tail.DefaultLogger, reopened: reopened, done: done},
+	}
+	t, err := tail.TailFile(path, tailConf)
+	if err != nil {
+		log.Errorf("[LogTask %s] Tail error, err[%s]", p.LogPath, err)
+		return false
+	}
+	p.mu.Lock()
+	p.tailer = t
+	p.path = path
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		if offset, ok := p.readOffset(); ok {
+			p.offset = &offset
+		}
+		p.tailer = nil
+		p.mu.Unlock()
+		stopTail(t)
+	}()
+	defer close(done)
+	switched := make(chan bool, 1)
+	followCtx, cancel := context.WithCancel(ctx)
+	if link {
+		go func() { switched <- p.followLink(followCtx, t, path) }()
+	} else {
+		switched <- false
+	}
+	peckLogBG(ctx, p, t.Lines, reopened, maxLine)
+	cancel()
+	return <-switched && ctx.Err() == nil
+}
+
+// waitLog polls LogPath with backoff until it exists, it returns false if
+// ctx is done first
+func (p *LogTask) waitLog(ctx context.Context) bool {
+	log.Infof("[LogTask %s] Log not exist, pending", p.LogPath)
+	atomic.StoreInt32(&p.pending, 1)
+	defer atomic.StoreInt32(&p.pending, 0)
+	backoff := pendingMinBackoff
+	for {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+		if _, err := os.Stat(p.LogPath); err == nil {
+			log.Infof("[LogTask %s] Log appeared", p.LogPath)
+			return true
+		}
+		backoff *= 2
+		if backoff > pendingMaxBackoff {
+			backoff = pendingMaxBackoff
+		}
+	}
+}
+
+// tailLogger logs the messages of a tailer, and tells its reader when the
+// file was reopened. It runs on the tailer goroutine, so the reader gets
+// the notice after the last line of the previous file and before the first
+// line of the new one
+type tailLogger struct {
+	*stdlog.Logger
+	reopened chan<- struct{}
+	done     <-chan struct{}
+}
+
+func (l *tailLogger) Printf(format string, v ...interface{}) {
+	l.Logger.Printf(format, v...)
+	if strings.HasPrefix(format, "Successfully reopened") {
+		select {
+		case l.reopened <- struct{}{}:
+		case <-l.done:
+		}
+	}
+}
+
+// stopTail stops t, lines it is still sending are discarded
+func stopTail(t *tail.Tail) {
+	t.Kill(nil)
+	for range t.Lines {
+	}
+	t.Wait()
+}
+
 func (p *LogTask) Stop() error {
 	if p.stop {
 		return errors.New("LogTask already stopped")
 	}
 	log.Infof(" [LogTask %s] Stop LogTask", p.LogPath)
 	p.stop = true
-	p.tail.Stop()
-	p.tail = nil
+	p.cancel()
+	<-p.done
 	return nil
 }
 
@@ -146,6 +427,189 @@ func (p *LogTask) IsStop() bool {
 	return p.stop
 }
 
+// Lag returns how many bytes of LogPath are not read yet, 0 if the log is
+// not tailed
+func (p *LogTask) Lag() int64 {
+	if p.multiFile() {
+		lag := int64(0)
+		for _, file := range p.tailedFiles() {
+			lag += file.Lag()
+		}
+		return lag
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tailer == nil || p.read == nil {
+		return 0
+	}
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return 0
+	}
+	if fileInode(info) != p.read.Inode {
+		// rotated, the new file is read once the old one is
+		return info.Size()
+	}
+	if info.Size() < p.read.Offset {
+		return 0
+	}
+	return info.Size() - p.read.Offset
+}
+
+// resumeOffset returns the saved offset if LogPath is still the same file
+// and not truncated below it, -1 otherwise
+func (p *LogTask) resumeOffset() int64 {
+	p.mu.Lock()
+	offset := p.offset
+	p.mu.Unlock()
+	if offset == nil {
+		return -1
+	}
+	info, err := os.Stat(p.LogPath)
+	if err != nil || fileInode(info) != offset.Inode || info.Size() < offset.Offset {
+		return -1
+	}
+	return offset.Offset
+}
+
+// missedRange returns the bytes of LogPath which a task stopped at from did
+// not read, while the log was read up to to. If the log was rotated
+// meanwhile, the lines left in the old file are lost and the new file is
+// read from its beginning
+func (p *LogTask) missedRange(from, to LogOffset) (int64, int64, bool) {
+	info, err := os.Stat(p.LogPath)
+	if err != nil || fileInode(info) != to.Inode {
+		return 0, 0, false
+	}
+	start := from.Offset
+	if from.Inode != to.Inode {
+		log.Warnf("[LogTask %s] Log rotated while a task was stopped, its lines in the old file are lost", p.LogPath)
+		start = 0
+	}
+	return start, to.Offset, start < to.Offset
+}
+
+// tailOffset returns how far LogPath is read, p.mu must be held
+func (p *LogTask) tailOffset() (LogOffset, bool) {
+	if p.tailer == nil || p.read == nil {
+		return LogOffset{}, false
+	}
+	return *p.read, true
+}
+
+// LineSequence identifies the line starting at offset across restarts, as
+// "<host>-<inode>-<offset>"
+func LineSequence(offset LogOffset) string {
+	return fmt.Sprintf("%s-%d-%d", GetHost(), offset.Inode, offset.Offset)
+}
+
+// lineStart returns where the line of n bytes just read starts, which is the
+// committed offset as lines are processed in order, and moves the read
+// offset past it
+func (p *LogTask) lineStart(n int64) (LogOffset, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.read != nil {
+		p.read.Offset += n
+	}
+	if p.committed == nil {
+		return LogOffset{}, false
+	}
+	return *p.committed, true
+}
+
+// reopened moves the read and committed offsets to the beginning of the
+// file the tailer reopened after a rotation or a truncation, the lines of
+// the previous file were all received before
+func (p *LogTask) reopened() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.read, p.committed = nil, nil
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return
+	}
+	p.read = &LogOffset{LogPath: p.LogPath, Inode: fileInode(info)}
+	committed := *p.read
+	p.committed = &committed
+}
+
+// commit moves the committed offset past the processed line of n bytes
+func (p *LogTask) commit(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.committed != nil {
+		p.committed.Offset += n
+	}
+}
+
+// readOffset returns the committed offset if a peck task needs confirmed
+// delivery, the tail offset otherwise, p.mu must be held
+func (p *LogTask) readOffset() (LogOffset, bool) {
+	if atomic.LoadInt32(&p.atLeastOnce) != 0 && p.committed != nil {
+		return *p.committed, true
+	}
+	return p.tailOffset()
+}
+
+// Offset returns how far LogPath is read, or where reading resumes if the
+// log is not tailed
+func (p *LogTask) Offset() (LogOffset, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if offset, ok := p.readOffset(); ok {
+		return offset, true
+	}
+	if p.offset != nil {
+		return *p.offset, true
+	}
+	return LogOffset{}, false
+}
+
+// SetOffset sets where reading of offset.LogPath resumes, the task must be
+// stopped
+func (p *LogTask) SetOffset(offset *LogOffset) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.multiFile() {
+		p.saved[offset.LogPath] = *offset
+		return
+	}
+	p.offset = offset
+}
+
+// Offsets returns the offsets of the files of LogPath, as Offset does
+func (p *LogTask) Offsets() []LogOffset {
+	if !p.multiFile() {
+		if offset, ok := p.Offset(); ok {
+			return []LogOffset{offset}
+		}
+		return nil
+	}
+	p.mu.Lock()
+	offsets := make(map[string]LogOffset, len(p.saved))
+	for path, offset := range p.saved {
+		offsets[path] = offset
+	}
+	p.mu.Unlock()
+	for path, file := range p.tailedFiles() {
+		if offset, ok := file.Offset(); ok {
+			offsets[path] = offset
+		}
+	}
+	res := make([]LogOffset, 0, len(offsets))
+	for _, offset := range offsets {
+		res = append(res, offset)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].LogPath < res[j].LogPath })
+	return res
+}
+
+// Pending reports whether the task waits for LogPath to appear
+func (p *LogTask) Pending() bool {
+	return atomic.LoadInt32(&p.pending) != 0
+}
+
 func (p *LogTask) Close() error {
 	// NOT IMPLEMENT
 	return nil
@@ -1,8 +1,14 @@
 package logpeck
 
 import (
+	"context"
+	"errors"
 	log "github.com/Sirupsen/logrus"
 	"github.com/hpcloud/tail"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -34,3 +40,525 @@ func TestTailLog(*testing.T) {
 		time.Sleep(100 * time.Millisecond)
 	}
 }
+
+func TestLogTaskMissingPeckTask(*testing.T) {
+	task := NewLogTask(".test.log")
+	config := &PeckTaskConfig{Name: "missing", LogPath: ".test.log"}
+	if err := task.StartPeckTask(context.Background(), config); err == nil {
+		panic("start of missing task should fail")
+	}
+	if err := task.StopPeckTask(config); err == nil {
+		panic("stop of missing task should fail")
+	}
+	if err := task.RemovePeckTask(config); err == nil {
+		panic("remove of missing task should fail")
+	}
+}
+
+func TestLogTaskStopOnCancel(*testing.T) {
+	task := NewLogTask(".test.log")
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := task.Start(ctx); err != nil {
+		panic(err)
+	}
+	cancel()
+	select {
+	case <-task.done:
+	case <-time.After(time.Second):
+		panic("tail loop still running after cancel")
+	}
+	if err := task.Stop(); err != nil {
+		panic(err)
+	}
+}
+
+type chanSender struct {
+	lines chan interface{}
+}
+
+func (p *chanSender) Start(ctx context.Context) error { return nil }
+func (p *chanSender) Stop() error                     { return nil }
+func (p *chanSender) Send(fields map[string]interface{}) {
+	p.lines <- fields["_Log"]
+}
+
+// ackSender confirms events once accept is closed
+type ackSender struct {
+	chanSender
+	accept chan struct{}
+	tries  int32
+}
+
+func (p *ackSender) SendAck(fields map[string]interface{}) error {
+	atomic.AddInt32(&p.tries, 1)
+	select {
+	case <-p.accept:
+		p.Send(fields)
+		return nil
+	default:
+		return errors.New("not accepted")
+	}
+}
+
+func TestLogTaskAtLeastOnce(*testing.T) {
+	logName := ".ack.test.log"
+	if err := ioutil.WriteFile(logName, []byte("first\n"), 0644); err != nil {
+		panic(err)
+	}
+	defer os.Remove(logName)
+
+	config := `{
+		"Name": "AckLog",
+		"LogPath": "` + logName + `",
+		"Delivery": "at-least-once",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`
+	if _, err := NewHarness([]byte(config)); err == nil {
+		panic("at-least-once accepted for prometheus sender")
+	}
+	h, err := NewHarness([]byte(strings.Replace(config, "at-least-once", "at-most-once", 1)))
+	if err != nil {
+		panic(err)
+	}
+	h.Task.Config.Delivery = DeliveryAtLeastOnce
+	sender := &ackSender{chanSender: chanSender{lines: make(chan interface{}, 10)}, accept: make(chan struct{})}
+	h.Task.sender = sender
+	task := NewLogTask(logName)
+	task.AddPeckTask(h.Task)
+	info, _ := os.Stat(logName)
+	task.SetOffset(&LogOffset{LogPath: logName, Inode: fileInode(info), Offset: 0})
+	if err := task.Start(context.Background()); err != nil {
+		panic(err)
+	}
+	for deadline := time.Now().Add(3 * time.Second); atomic.LoadInt32(&sender.tries) < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			panic("event not sent again")
+		}
+	}
+	// the line is read but not confirmed
+	if offset, ok := task.Offset(); !ok || offset.Offset != 0 {
+		panic(offset)
+	}
+	close(sender.accept)
+	select {
+	case line := <-sender.lines:
+		if line != "first" {
+			panic(line)
+		}
+	case <-time.After(3 * time.Second):
+		panic("line not confirmed")
+	}
+	time.Sleep(50 * time.Millisecond)
+	task.Stop()
+	if offset, ok := task.Offset(); !ok || offset.Offset != info.Size() {
+		panic(offset)
+	}
+}
+
+// seqSender sends the sequence of events
+type seqSender struct {
+	chanSender
+}
+
+func (p *seqSender) Send(fields map[string]interface{}) {
+	p.lines <- fields["_seq"]
+}
+
+func TestLogTaskSequence(*testing.T) {
+	logName := ".seq.test.log"
+	if err := ioutil.WriteFile(logName, []byte("a\nbb\n"), 0644); err != nil {
+		panic(err)
+	}
+	defer os.Remove(logName)
+
+	h, err := NewHarness([]byte(`{
+		"Name": "SeqLog",
+		"LogPath": "` + logName + `",
+		"SequenceField": "_seq",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &seqSender{chanSender{lines: make(chan interface{}, 10)}}
+	h.Task.sender = sender
+	task := NewLogTask(logName)
+	task.AddPeckTask(h.Task)
+	info, _ := os.Stat(logName)
+	task.SetOffset(&LogOffset{LogPath: logName, Inode: fileInode(info), Offset: 0})
+	if err := task.Start(context.Background()); err != nil {
+		panic(err)
+	}
+	defer task.Stop()
+	for _, offset := range []int64{0, 2} {
+		select {
+		case seq := <-sender.lines:
+			if seq != LineSequence(LogOffset{Inode: fileInode(info), Offset: offset}) {
+				panic(seq)
+			}
+		case <-time.After(3 * time.Second):
+			panic("line not read")
+		}
+	}
+}
+
+func TestLogTaskPending(*testing.T) {
+	logName := ".pending.test.log"
+	os.Remove(logName)
+	defer os.Remove(logName)
+
+	h, err := NewHarness([]byte(`{
+		"Name": "PendingLog",
+		"LogPath": "` + logName + `",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &chanSender{lines: make(chan interface{}, 10)}
+	h.Task.sender = sender
+	task := NewLogTask(logName)
+	task.AddPeckTask(h.Task)
+	if err := task.Start(context.Background()); err != nil {
+		panic(err)
+	}
+	defer task.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if !task.Pending() {
+		panic("task should be pending")
+	}
+
+	// lines written before the file is noticed are not skipped
+	if err := ioutil.WriteFile(logName, []byte("first\n"), 0644); err != nil {
+		panic(err)
+	}
+	select {
+	case line := <-sender.lines:
+		if line != "first" {
+			panic(line)
+		}
+	case <-time.After(3 * time.Second):
+		panic("line of created log not read")
+	}
+	if task.Pending() {
+		panic("task still pending")
+	}
+}
+
+func TestLogTaskResumeOffset(*testing.T) {
+	logName := ".offset.test.log"
+	if err := ioutil.WriteFile(logName, []byte("skipped\nresumed\n"), 0644); err != nil {
+		panic(err)
+	}
+	defer os.Remove(logName)
+
+	h, err := NewHarness([]byte(`{
+		"Name": "OffsetLog",
+		"LogPath": "` + logName + `",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &chanSender{lines: make(chan interface{}, 10)}
+	h.Task.sender = sender
+	task := NewLogTask(logName)
+	task.AddPeckTask(h.Task)
+	info, _ := os.Stat(logName)
+	task.SetOffset(&LogOffset{LogPath: logName, Inode: fileInode(info), Offset: int64(len("skipped\n"))})
+	if err := task.Start(context.Background()); err != nil {
+		panic(err)
+	}
+	select {
+	case line := <-sender.lines:
+		if line != "resumed" {
+			panic(line)
+		}
+	case <-time.After(3 * time.Second):
+		panic("line after offset not read")
+	}
+	task.Stop()
+	offset, ok := task.Offset()
+	if !ok || offset.Offset != info.Size() {
+		panic(offset)
+	}
+
+	// a saved offset of another file is not resumed
+	task.SetOffset(&LogOffset{LogPath: logName, Inode: fileInode(info) + 1, Offset: 1})
+	if resume := task.resumeOffset(); resume != -1 {
+		panic(resume)
+	}
+}
+
+func TestLogTaskGlob(t *testing.T) {
+	interval := globScanInterval
+	globScanInterval = 50 * time.Millisecond
+	defer func() { globScanInterval = interval }()
+	dir := t.TempDir()
+	write := func(name, line string) {
+		f, err := os.OpenFile(dir+"/"+name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			panic(err)
+		}
+		f.WriteString(line + "\n")
+		f.Close()
+	}
+	write("a.log", "old a")
+	write("b.log", "old b")
+	write("b.txt", "ignored")
+
+	h, err := NewHarness([]byte(`{
+		"Name": "GlobLog",
+		"LogPath": "` + dir + `/*.log",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &chanSender{lines: make(chan interface{}, 10)}
+	h.Task.sender = sender
+	task := NewLogTask(dir + "/*.log")
+	task.AddPeckTask(h.Task)
+	info, _ := os.Stat(dir + "/b.log")
+	task.SetOffset(&LogOffset{LogPath: dir + "/b.log", Inode: fileInode(info), Offset: 0})
+	if err := task.Start(context.Background()); err != nil {
+		panic(err)
+	}
+	expect := func(lines ...string) {
+		got := map[interface{}]bool{}
+		for range lines {
+			select {
+			case line := <-sender.lines:
+				got[line] = true
+			case <-time.After(3 * time.Second):
+				panic("lines not read")
+			}
+		}
+		for _, line := range lines {
+			if !got[line] {
+				panic(line)
+			}
+		}
+	}
+	// b.log resumes at its saved offset, a.log at its end
+	expect("old b")
+	for len(task.tailedFiles()) < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, file := range task.tailedFiles() {
+		for {
+			file.mu.Lock()
+			tailer := file.tailer
+			file.mu.Unlock()
+			if tailer != nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	write("a.log", "new a")
+	expect("new a")
+
+	// a new file is read from the beginning
+	write("c.log", "first c")
+	expect("first c")
+	task.Stop()
+	offsets := task.Offsets()
+	if len(offsets) != 3 || offsets[2].LogPath != dir+"/c.log" || offsets[2].Offset != int64(len("first c\n")) {
+		panic(offsets)
+	}
+}
+
+func TestLogTaskReap(t *testing.T) {
+	interval, reap := globScanInterval, Config.Reap
+	globScanInterval = 50 * time.Millisecond
+	Config.Reap = ReapConfig{IdleTimeout: 60, OffsetRetention: 1}
+	defer func() { globScanInterval, Config.Reap = interval, reap }()
+	dir := t.TempDir()
+	path := dir + "/a.log"
+	old := time.Now().Add(-time.Hour)
+	ioutil.WriteFile(path, []byte("old\n"), 0644)
+	os.Chtimes(path, old, old)
+
+	h, err := NewHarness([]byte(`{
+		"Name": "ReapLog",
+		"LogPath": "` + dir + `/*.log",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &chanSender{lines: make(chan interface{}, 10)}
+	h.Task.sender = sender
+	task := NewLogTask(dir + "/*.log")
+	task.AddPeckTask(h.Task)
+	if err := task.Start(context.Background()); err != nil {
+		panic(err)
+	}
+	defer task.Stop()
+	waitFiles := func(n int) {
+		for i := 0; len(task.tailedFiles()) != n; i++ {
+			if i > 300 {
+				panic(task.tailedFiles())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// a stale file is not tailed until written
+	time.Sleep(200 * time.Millisecond)
+	waitFiles(0)
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString("new\n")
+	f.Close()
+	select {
+	case line := <-sender.lines:
+		if line != "new" {
+			panic(line)
+		}
+	case <-time.After(3 * time.Second):
+		panic("written stale file not tailed")
+	}
+
+	// it is closed again once stale, its offset is kept
+	os.Chtimes(path, old, old)
+	waitFiles(0)
+	if offsets := task.Offsets(); len(offsets) != 1 || offsets[0].Offset != int64(len("old\nnew\n")) {
+		panic(offsets)
+	}
+
+	// the offset of a removed file expires
+	os.Remove(path)
+	for i := 0; len(task.Offsets()) != 0; i++ {
+		if i > 300 {
+			panic(task.Offsets())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if expired := task.TakeExpired(); len(expired) != 1 || expired[0] != path {
+		panic(expired)
+	}
+}
+
+func TestLogTaskSymlink(t *testing.T) {
+	dir := t.TempDir()
+	link := dir + "/current"
+	write := func(name, line string) {
+		f, err := os.OpenFile(dir+"/"+name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			panic(err)
+		}
+		f.WriteString(line + "\n")
+		f.Close()
+	}
+	repoint := func(name string) {
+		os.Symlink(name, link+".tmp")
+		if err := os.Rename(link+".tmp", link); err != nil {
+			panic(err)
+		}
+	}
+	write("app-1.log", "old")
+	repoint("app-1.log")
+
+	h, err := NewHarness([]byte(`{
+		"Name": "LinkLog",
+		"LogPath": "` + link + `",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &chanSender{lines: make(chan interface{}, 10)}
+	h.Task.sender = sender
+	task := NewLogTask(link)
+	task.AddPeckTask(h.Task)
+	if err := task.Start(context.Background()); err != nil {
+		panic(err)
+	}
+	defer task.Stop()
+	expect := func(lines ...string) {
+		for _, expected := range lines {
+			select {
+			case line := <-sender.lines:
+				if line != expected {
+					panic(line)
+				}
+			case <-time.After(3 * time.Second):
+				panic("missing " + expected)
+			}
+		}
+	}
+	time.Sleep(300 * time.Millisecond)
+	write("app-1.log", "a1")
+	expect("a1")
+
+	// the lines written to the previous target before the switch are read
+	write("app-1.log", "a2")
+	write("app-2.log", "b1")
+	repoint("app-2.log")
+	write("app-1.log", "a3")
+	expect("a2", "a3", "b1")
+	write("app-2.log", "b2")
+	expect("b2")
+	info, _ := os.Stat(dir + "/app-2.log")
+	if offset, ok := task.Offset(); !ok || offset.LogPath != link || offset.Inode != fileInode(info) || offset.Offset != info.Size() {
+		panic(offset)
+	}
+}
+
+func TestLogTaskRotate(t *testing.T) {
+	logName := t.TempDir() + "/rotate.log"
+	if err := ioutil.WriteFile(logName, []byte("a\n"), 0644); err != nil {
+		panic(err)
+	}
+	h, err := NewHarness([]byte(`{
+		"Name": "RotateLog",
+		"LogPath": "` + logName + `",
+		"SequenceField": "_seq",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &seqSender{chanSender{lines: make(chan interface{}, 10)}}
+	h.Task.sender = sender
+	task := NewLogTask(logName)
+	task.AddPeckTask(h.Task)
+	old, _ := os.Stat(logName)
+	task.SetOffset(&LogOffset{LogPath: logName, Inode: fileInode(old), Offset: 0})
+	if err := task.Start(context.Background()); err != nil {
+		panic(err)
+	}
+	defer task.Stop()
+	expect := func(inode uint64, offset int64) {
+		select {
+		case seq := <-sender.lines:
+			if seq != LineSequence(LogOffset{Inode: inode, Offset: offset}) {
+				panic(seq)
+			}
+		case <-time.After(3 * time.Second):
+			panic("line not read")
+		}
+	}
+	expect(fileInode(old), 0)
+
+	os.Rename(logName, logName+".1")
+	ioutil.WriteFile(logName, []byte("bb\nc\n"), 0644)
+	info, _ := os.Stat(logName)
+	expect(fileInode(info), 0)
+	expect(fileInode(info), 3)
+	if offset, ok := task.Offset(); !ok || offset.Inode != fileInode(info) || offset.Offset != 5 {
+		panic(offset)
+	}
+	if lag := task.Lag(); lag != 0 {
+		panic(lag)
+	}
+}
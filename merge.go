@@ -0,0 +1,195 @@
+package logpeck
+
+import (
+	"container/heap"
+	"errors"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// MergeConfig orders the lines of the files of a glob or directory LogPath
+// by the time parsed from them, for applications writing one log over
+// several files. Timestamp is a regular expression whose first submatch (or
+// match) is the time, parsed with Format like "TimestampFormat" of influxdb.
+// Lines are held Window milliseconds (default 1000), lines of all files held
+// meanwhile are processed in time order. A line without time follows the
+// previous line of its file
+type MergeConfig struct {
+	Enable    bool   `json:"Enable"`
+	Timestamp string `json:"Timestamp"`
+	Format    string `json:"Format"`
+	Window    int64  `json:"Window"`
+}
+
+type mergeLine struct {
+	path    string
+	content string
+	seq     string
+	// time is the epoch milliseconds of the line, order its arrival
+	time  int64
+	order int64
+	due   time.Time
+}
+
+// mergeHeap orders lines by time, then by arrival
+type mergeHeap []*mergeLine
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].time != h[j].time {
+		return h[i].time < h[j].time
+	}
+	return h[i].order < h[j].order
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeLine)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	line := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return line
+}
+
+// Merger holds the lines of a task to process them in time order
+type Merger struct {
+	config    MergeConfig
+	timestamp *regexp.Regexp
+	window    time.Duration
+	process   func(path, content, seq string)
+
+	mu    sync.Mutex
+	lines mergeHeap
+	last  map[string]int64
+	order int64
+	// releaseMu keeps released lines in order
+	releaseMu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewMerger(config *MergeConfig) (*Merger, error) {
+	merger := &Merger{
+		config: *config,
+		window: time.Duration(config.Window) * time.Millisecond,
+		last:   make(map[string]int64),
+	}
+	if merger.window <= 0 {
+		merger.window = time.Second
+	}
+	if !config.Enable {
+		return merger, nil
+	}
+	if config.Timestamp == "" {
+		return nil, errors.New("Merge Timestamp is required")
+	}
+	timestamp, err := regexp.Compile(config.Timestamp)
+	if err != nil {
+		return nil, errors.New("Merge Timestamp error: " + err.Error())
+	}
+	merger.timestamp = timestamp
+	return merger, nil
+}
+
+func (p *Merger) IsEnable() bool {
+	return p.config.Enable
+}
+
+// Start releases the held lines to process in background
+func (p *Merger) Start(process func(path, content, seq string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.process = process
+	if p.stop != nil {
+		return
+	}
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		tick := p.window / 10
+		if tick < 10*time.Millisecond {
+			tick = 10 * time.Millisecond
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				p.Release(now)
+			case <-stop:
+				return
+			}
+		}
+	}(p.stop, p.done)
+}
+
+// Add holds a line read from the file path at now
+func (p *Merger) Add(path, content, seq string, now time.Time) {
+	ts, ok := p.parseTime(content)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ok {
+		p.last[path] = ts
+	} else if ts, ok = p.last[path]; !ok {
+		ts = now.UnixNano() / int64(time.Millisecond)
+	}
+	p.order++
+	heap.Push(&p.lines, &mergeLine{path: path, content: content, seq: seq, time: ts, order: p.order, due: now.Add(p.window)})
+}
+
+func (p *Merger) parseTime(content string) (int64, bool) {
+	match := p.timestamp.FindStringSubmatch(content)
+	if match == nil {
+		return 0, false
+	}
+	value := match[0]
+	if len(match) > 1 {
+		value = match[1]
+	}
+	ts, err := ParseEventTime(value, p.config.Format)
+	return ts, err == nil
+}
+
+// Release processes the lines held until now, in time order
+func (p *Merger) Release(now time.Time) {
+	p.releaseMu.Lock()
+	defer p.releaseMu.Unlock()
+	p.mu.Lock()
+	var lines []*mergeLine
+	for len(p.lines) > 0 && !p.lines[0].due.After(now) {
+		lines = append(lines, heap.Pop(&p.lines).(*mergeLine))
+	}
+	process := p.process
+	p.mu.Unlock()
+	if process == nil {
+		return
+	}
+	for _, line := range lines {
+		process(line.path, line.content, line.seq)
+	}
+}
+
+// Held returns how many lines are held
+func (p *Merger) Held() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.lines)
+}
+
+// Stop stops releasing in background and processes all held lines
+func (p *Merger) Stop() {
+	p.mu.Lock()
+	stop, done := p.stop, p.done
+	p.stop, p.done = nil, nil
+	for i := range p.lines {
+		p.lines[i].due = time.Time{}
+	}
+	p.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	p.Release(time.Time{})
+}
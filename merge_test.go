@@ -0,0 +1,61 @@
+package logpeck
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMerger(*testing.T) {
+	if _, err := NewMerger(&MergeConfig{Enable: true}); err == nil {
+		panic("merge without Timestamp accepted")
+	}
+	merger, err := NewMerger(&MergeConfig{Enable: true, Timestamp: `^(\d+) `, Format: "epoch_ms", Window: 100})
+	if err != nil {
+		panic(err)
+	}
+	var lines []string
+	merger.process = func(path, content, seq string) {
+		lines = append(lines, content)
+	}
+	now := time.Now()
+	merger.Add("a.log", "1000 a1", "", now)
+	merger.Add("b.log", "999 b1", "", now)
+	merger.Add("a.log", "  continued", "", now)
+	merger.Add("b.log", "1001 b2", "", now.Add(50*time.Millisecond))
+	merger.Add("a.log", "1000 a2", "", now.Add(50*time.Millisecond))
+
+	merger.Release(now.Add(99 * time.Millisecond))
+	if len(lines) != 0 {
+		panic(lines)
+	}
+	merger.Release(now.Add(100 * time.Millisecond))
+	if !reflect.DeepEqual(lines, []string{"999 b1", "1000 a1", "  continued"}) {
+		panic(lines)
+	}
+	merger.Stop()
+	if !reflect.DeepEqual(lines[3:], []string{"1000 a2", "1001 b2"}) || merger.Held() != 0 {
+		panic(lines)
+	}
+}
+
+func TestPeckTaskMerge(*testing.T) {
+	h, err := NewHarness([]byte(`{
+		"Name": "MergeLog",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"},
+		"Merge": {"Enable": true, "Timestamp": "^(\\S+)", "Window": 50}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &eventSender{}
+	h.Task.sender = sender
+	h.Task.ProcessFileLine("b.log", "2024-05-01T10:00:02Z b", "")
+	h.Task.ProcessFileLine("a.log", "2024-05-01T10:00:01Z a", "")
+	time.Sleep(200 * time.Millisecond)
+	h.Task.Stop()
+	if len(sender.events) != 2 || sender.events[0]["_Log"] != "2024-05-01T10:00:01Z a" {
+		panic(sender.events)
+	}
+}
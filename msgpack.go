@@ -0,0 +1,313 @@
+package logpeck
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// msgpackEncode appends the msgpack encoding of v to buf. Values of other
+// types than the extracted ones are encoded through their json form
+func msgpackEncode(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case int:
+		msgpackInt(buf, int64(v))
+	case int32:
+		msgpackInt(buf, int64(v))
+	case int64:
+		msgpackInt(buf, v)
+	case uint:
+		msgpackUint(buf, uint64(v))
+	case uint32:
+		msgpackUint(buf, uint64(v))
+	case uint64:
+		msgpackUint(buf, v)
+	case float32:
+		msgpackFloat(buf, float64(v))
+	case float64:
+		msgpackFloat(buf, v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			msgpackInt(buf, i)
+		} else if f, err := v.Float64(); err == nil {
+			msgpackFloat(buf, f)
+		} else {
+			msgpackString(buf, v.String())
+		}
+	case string:
+		msgpackString(buf, v)
+	case []byte:
+		msgpackHeader(buf, len(v), 0xc4, 0xc4, 0xc5, 0xc6, 256)
+		buf.Write(v)
+	case []interface{}:
+		msgpackHeader(buf, len(v), 0x90, 0xdc, 0xdc, 0xdd, 16)
+		for _, e := range v {
+			msgpackEncode(buf, e)
+		}
+	case []string:
+		msgpackHeader(buf, len(v), 0x90, 0xdc, 0xdc, 0xdd, 16)
+		for _, e := range v {
+			msgpackString(buf, e)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		msgpackHeader(buf, len(v), 0x80, 0xde, 0xde, 0xdf, 16)
+		for _, k := range keys {
+			msgpackString(buf, k)
+			msgpackEncode(buf, v[k])
+		}
+	default:
+		var value interface{}
+		raw, err := json.Marshal(v)
+		if err == nil {
+			decoder := json.NewDecoder(bytes.NewReader(raw))
+			decoder.UseNumber()
+			err = decoder.Decode(&value)
+		}
+		if err != nil {
+			msgpackString(buf, fmt.Sprintf("%v", v))
+			return
+		}
+		msgpackEncode(buf, value)
+	}
+}
+
+// msgpackHeader writes the header of a str, bin, array or map of n
+// elements. The fix format is used below fixMax unless fix equals b8, the 8
+// bits format unless b8 equals b16
+func msgpackHeader(buf *bytes.Buffer, n int, fix, b8, b16, b32 byte, fixMax int) {
+	switch {
+	case n < fixMax && fix != b8:
+		buf.WriteByte(fix | byte(n))
+	case n < 256 && b8 != b16:
+		buf.WriteByte(b8)
+		buf.WriteByte(byte(n))
+	case n < 65536:
+		buf.WriteByte(b16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(b32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func msgpackString(buf *bytes.Buffer, s string) {
+	msgpackHeader(buf, len(s), 0xa0, 0xd9, 0xda, 0xdb, 32)
+	buf.WriteString(s)
+}
+
+func msgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0:
+		msgpackUint(buf, uint64(i))
+	case i >= -32:
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+func msgpackUint(buf *bytes.Buffer, u uint64) {
+	switch {
+	case u < 128:
+		buf.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(u))
+	case u <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(u))
+	case u <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(u))
+	default:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, u)
+	}
+}
+
+func msgpackFloat(buf *bytes.Buffer, f float64) {
+	buf.WriteByte(0xcb)
+	binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+}
+
+// msgpackDecode reads one msgpack value. Integers are returned as int64,
+// or uint64 above math.MaxInt64, maps as map[string]interface{} and ext values as []byte
+func msgpackDecode(r *bufio.Reader) (interface{}, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return msgpackDecodeMap(r, int(b&0x0f))
+	case b&0xf0 == 0x90:
+		return msgpackDecodeArray(r, int(b&0x0f))
+	case b&0xe0 == 0xa0:
+		return msgpackDecodeString(r, int(b&0x1f))
+	}
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := msgpackReadLength(r, b-0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackReadBytes(r, n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := msgpackReadLength(r, b-0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackReadBytes(r, n+1)
+	case 0xca:
+		var f uint32
+		err := binary.Read(r, binary.BigEndian, &f)
+		return float64(math.Float32frombits(f)), err
+	case 0xcb:
+		var f uint64
+		err := binary.Read(r, binary.BigEndian, &f)
+		return math.Float64frombits(f), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		raw, err := msgpackReadBytes(r, 1<<(b-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		var u uint64
+		for _, c := range raw {
+			u = u<<8 | uint64(c)
+		}
+		if u <= math.MaxInt64 {
+			return int64(u), nil
+		}
+		return u, nil
+	case 0xd0:
+		var i int8
+		err := binary.Read(r, binary.BigEndian, &i)
+		return int64(i), err
+	case 0xd1:
+		var i int16
+		err := binary.Read(r, binary.BigEndian, &i)
+		return int64(i), err
+	case 0xd2:
+		var i int32
+		err := binary.Read(r, binary.BigEndian, &i)
+		return int64(i), err
+	case 0xd3:
+		var i int64
+		err := binary.Read(r, binary.BigEndian, &i)
+		return i, err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return msgpackReadBytes(r, 1+1<<(b-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := msgpackReadLength(r, b-0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackDecodeString(r, n)
+	case 0xdc, 0xdd:
+		n, err := msgpackReadLength(r, b-0xdc+1)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackDecodeArray(r, n)
+	case 0xde, 0xdf:
+		n, err := msgpackReadLength(r, b-0xde+1)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackDecodeMap(r, n)
+	}
+	return nil, fmt.Errorf("msgpack: unknown format 0x%x", b)
+}
+
+// msgpackReadLength reads a length of 1, 2 or 4 bytes for size 0, 1 or 2
+func msgpackReadLength(r *bufio.Reader, size byte) (int, error) {
+	raw, err := msgpackReadBytes(r, 1<<size)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, c := range raw {
+		n = n<<8 | int(c)
+	}
+	return n, nil
+}
+
+func msgpackReadBytes(r *bufio.Reader, n int) ([]byte, error) {
+	raw := make([]byte, n)
+	_, err := io.ReadFull(r, raw)
+	return raw, err
+}
+
+func msgpackDecodeString(r *bufio.Reader, n int) (interface{}, error) {
+	raw, err := msgpackReadBytes(r, n)
+	return string(raw), err
+}
+
+func msgpackDecodeArray(r *bufio.Reader, n int) (interface{}, error) {
+	array := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := msgpackDecode(r)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, v)
+	}
+	return array, nil
+}
+
+func msgpackDecodeMap(r *bufio.Reader, n int) (interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := msgpackDecode(r)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, errors.New("msgpack: map key is not a string")
+		}
+		if m[key], err = msgpackDecode(r); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,117 @@
+package logpeck
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// pathFieldsCacheSize bounds how many paths the fields are kept for
+const pathFieldsCacheSize = 4096
+
+var pathFieldName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// PathFieldsConfig sets fields of the events from the path of the file their
+// line was read from, for glob and directory LogPaths. Pattern is a path
+// where "{name}" captures a part of a path component into the field name and
+// "*" matches a part not captured, e.g. "/var/log/{service}/{instance}.log".
+// A relative Pattern matches the end of the path. Files not matching Pattern
+// get no field
+type PathFieldsConfig struct {
+	Enable  bool   `json:"Enable"`
+	Pattern string `json:"Pattern"`
+}
+
+// PathFields captures the fields of file paths
+type PathFields struct {
+	config  PathFieldsConfig
+	pattern *regexp.Regexp
+
+	mu     sync.Mutex
+	fields map[string]map[string]interface{}
+}
+
+func NewPathFields(config *PathFieldsConfig) (*PathFields, error) {
+	p := &PathFields{
+		config: *config,
+		fields: make(map[string]map[string]interface{}),
+	}
+	if !config.Enable {
+		return p, nil
+	}
+	if config.Pattern == "" {
+		return nil, errors.New("PathFields Pattern is required")
+	}
+	pattern, err := compilePathPattern(config.Pattern)
+	if err != nil {
+		return nil, errors.New("PathFields Pattern error: " + err.Error())
+	}
+	p.pattern = pattern
+	return p, nil
+}
+
+// compilePathPattern converts a path pattern into a regular expression with
+// a named group for each "{name}"
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+	expr := "^"
+	if !strings.HasPrefix(pattern, "/") {
+		expr = "(?:^|/)"
+	}
+	names := make(map[string]bool)
+	for rest := pattern; rest != ""; {
+		i := strings.IndexAny(rest, "{*")
+		if i < 0 {
+			expr += regexp.QuoteMeta(rest)
+			break
+		}
+		expr += regexp.QuoteMeta(rest[:i])
+		if rest[i] == '*' {
+			expr += "[^/]*?"
+			rest = rest[i+1:]
+			continue
+		}
+		end := strings.IndexByte(rest[i:], '}')
+		if end < 0 {
+			return nil, errors.New("unclosed {")
+		}
+		name := rest[i+1 : i+end]
+		if !pathFieldName.MatchString(name) {
+			return nil, errors.New("invalid field name {" + name + "}")
+		}
+		if names[name] {
+			return nil, errors.New("duplicate field name {" + name + "}")
+		}
+		names[name] = true
+		expr += "(?P<" + name + ">[^/]+?)"
+		rest = rest[i+end+1:]
+	}
+	return regexp.Compile(expr + "$")
+}
+
+func (p *PathFields) IsEnable() bool {
+	return p.config.Enable
+}
+
+// Fields returns the fields captured from path, nil if it does not match
+func (p *PathFields) Fields(path string) map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if fields, ok := p.fields[path]; ok {
+		return fields
+	}
+	var fields map[string]interface{}
+	if match := p.pattern.FindStringSubmatch(path); match != nil {
+		fields = make(map[string]interface{})
+		for i, name := range p.pattern.SubexpNames() {
+			if name != "" {
+				fields[name] = match[i]
+			}
+		}
+	}
+	if len(p.fields) >= pathFieldsCacheSize {
+		p.fields = make(map[string]map[string]interface{})
+	}
+	p.fields[path] = fields
+	return fields
+}
@@ -0,0 +1,47 @@
+package logpeck
+
+import (
+	"testing"
+)
+
+func TestPathFields(*testing.T) {
+	for _, pattern := range []string{"/var/log/{service", "/var/log/{a-b}.log", "/{a}/{a}.log"} {
+		if _, err := NewPathFields(&PathFieldsConfig{Enable: true, Pattern: pattern}); err == nil {
+			panic(pattern)
+		}
+	}
+	fields, err := NewPathFields(&PathFieldsConfig{Enable: true, Pattern: "/var/log/{service}/{instance}.log"})
+	if err != nil {
+		panic(err)
+	}
+	if f := fields.Fields("/var/log/api/web-1.2.log"); f["service"] != "api" || f["instance"] != "web-1.2" {
+		panic(f)
+	}
+	if f := fields.Fields("/var/log/api/web/1.log"); f != nil {
+		panic(f)
+	}
+	fields, _ = NewPathFields(&PathFieldsConfig{Enable: true, Pattern: "{service}/*-{date}.log"})
+	if f := fields.Fields("/data/api/app-2024-05-01.log"); f["service"] != "api" || f["date"] != "2024-05-01" {
+		panic(f)
+	}
+
+	h, err := NewHarness([]byte(`{
+		"Name": "PathLog",
+		"LogPath": "/var/log/*/*.log",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"},
+		"PathFields": {"Enable": true, "Pattern": "/var/log/{service}/{instance}.log"},
+		"Fields": [{"Name": "instance", "Default": "none"}]
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &eventSender{}
+	h.Task.sender = sender
+	h.Task.ProcessFileLine("/var/log/api/web-1.log", "a", "")
+	h.Task.ProcessFileLine("/var/log/other.log", "b", "")
+	if len(sender.events) != 2 || sender.events[0]["service"] != "api" || sender.events[0]["instance"] != "web-1" ||
+		sender.events[1]["service"] != nil || sender.events[1]["instance"] != "none" {
+		panic(sender.events)
+	}
+}
@@ -1,21 +1,123 @@
 package logpeck
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ackMinBackoff and ackMaxBackoff bound the wait before sending again an
+// event the sender did not confirm
+var (
+	ackMinBackoff = 100 * time.Millisecond
+	ackMaxBackoff = 10 * time.Second
 )
 
 type PeckTask struct {
 	Config PeckTaskConfig
 	Stat   PeckTaskStat
 
-	filter     PeckFilter
-	extractor  Extractor
-	sender     Sender
-	aggregator *Aggregator
+	filter      PeckFilter
+	charset     *CharsetConverter
+	preprocess  *Preprocessor
+	selector    *FieldSelector
+	tags        map[string]interface{}
+	truncator   *Truncator
+	extractor   Extractor
+	sender      Sender
+	aggregators []*Aggregator
+	dedup       *Deduplicator
+	correlator  *Correlator
+	alerter     *Alerter
+	anomaly     *AnomalyDetector
+	templates   *FieldTemplates
+	replayTag   string
+	backfiller  *Backfiller
+	// catchUp reads the lines written while the task was stopped
+	catchUp *Backfiller
+
+	anomalySender Sender
+	health        *HealthMonitor
+	healthSender  Sender
+	schedule      *Schedule
+	profiler      *Profiler
+	schema        *SchemaMonitor
+	schemaSender  Sender
+	sharder       *Sharder
+	merger        *Merger
+	pathFields    *PathFields
+	read          ReadConfig
+
+	lines RateMeter
+	bytes RateMeter
+	// dirty is set when the counters changed since they were persisted
+	dirty int32
+	// stopped mirrors Stat.Stop for the goroutines processing lines
+	stopped int32
+	// onFailure stops the task when its health monitor gives up on it
+	onFailure func(*PeckTask)
+
+	// sleeping is set outside the schedule windows, lines are ignored
+	sleeping int32
+
+	// capture keeps samples of lines and events while set
+	capture atomic.Pointer[SampleCapture]
+	// tracer looks for a marker line, tracing is set while the marker line
+	// is processed under processMu
+	tracer  atomic.Pointer[Tracer]
+	tracing *Tracer
+
+	// cancel aborts the in-flight sends of the running task, whose context
+	// is ctx
+	cancel context.CancelFunc
+	ctx    context.Context
+
+	mu              sync.Mutex
+	lastAggregation map[string]interface{}
+
+	// processMu serializes lines of the live tail and the backfill
+	processMu sync.Mutex
+	// successor replaces the task after a handover, late lines are
+	// forwarded to it
+	successor atomic.Pointer[PeckTask]
+
+	// failed is set when processing panicked, the task ignores lines until
+	// it is started again
+	failed  int32
+	failure string
 }
 
 func NewPeckTask(c *PeckTaskConfig, s *PeckTaskStat) (*PeckTask, error) {
+	return newPeckTask(c, s, NewSender)
+}
+
+// heldStage returns the first enabled section which holds lines or events
+// after they are processed, their offset is then committed before the
+// sender acks them
+func heldStage(config *PeckTaskConfig) string {
+	switch {
+	case config.Aggregator.Enable:
+		return "Aggregator"
+	case config.Dedup.Enable:
+		return "Dedup"
+	case config.Correlate.Enable:
+		return "Correlate"
+	case config.Shard.Workers > 1:
+		return "Shard"
+	case config.Merge.Enable:
+		return "Merge"
+	}
+	return ""
+}
+
+// newPeckTask builds a task whose senders are made by newSender
+func newPeckTask(c *PeckTaskConfig, s *PeckTaskStat, newSender func(*SenderConfig) (Sender, error)) (*PeckTask, error) {
 	var config *PeckTaskConfig = c
 	var stat *PeckTaskStat
 	if s == nil {
@@ -32,72 +134,987 @@ func NewPeckTask(c *PeckTaskConfig, s *PeckTaskStat) (*PeckTask, error) {
 	}
 	filter := NewPeckFilter(config.Keywords)
 	//var sender Sender
-	sender, err := NewSender(&config.Sender)
+	sender, err := newSender(&config.Sender)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := sender.(AckSender); config.Delivery == DeliveryAtLeastOnce && !ok {
+		return nil, fmt.Errorf("Delivery %s is not supported by sender %s", config.Delivery, config.Sender.Name)
+	}
+	if stage := heldStage(config); config.Delivery == DeliveryAtLeastOnce && stage != "" {
+		return nil, fmt.Errorf("Delivery %s is not supported with %s", config.Delivery, stage)
+	}
+	if err := config.Aggregator.Validate(); err != nil {
+		return nil, err
+	}
+	aggregators := NewAggregators(&config.Aggregator)
+	var anomalySender Sender
+	if config.Anomaly.Enable && config.Anomaly.Sender.Name != "" {
+		anomalySender, err = newSender(&config.Anomaly.Sender)
+		if err != nil {
+			return nil, err
+		}
+	}
+	schedule, err := NewSchedule(&config.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	sharder, err := NewSharder(&config.Shard)
 	if err != nil {
 		return nil, err
 	}
-	aggregator := NewAggregator(&config.Aggregator)
+	charset, err := NewCharsetConverter(&config.Charset)
+	if err != nil {
+		return nil, err
+	}
+	merger, err := NewMerger(&config.Merge)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Directory.Validate(config.LogPath); err != nil {
+		return nil, err
+	}
+	pathFields, err := NewPathFields(&config.PathFields)
+	if err != nil {
+		return nil, err
+	}
+	alerter, err := NewAlerter(config.Name, &config.Alert)
+	if err != nil {
+		return nil, err
+	}
+	var healthSender Sender
+	if config.Health.Enable && config.Health.Sender.Name != "" {
+		healthSender, err = newSender(&config.Health.Sender)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var schemaSender Sender
+	if config.Schema.Enable && config.Schema.Sender.Name != "" {
+		schemaSender, err = newSender(&config.Schema.Sender)
+		if err != nil {
+			return nil, err
+		}
+	}
 	task := &PeckTask{
-		Config:     *config,
-		Stat:       *stat,
-		filter:     *filter,
-		extractor:  extractor,
-		sender:     sender,
-		aggregator: aggregator,
+		Config:      *config,
+		Stat:        *stat,
+		filter:      *filter,
+		charset:     charset,
+		preprocess:  NewPreprocessor(&config.Preprocess),
+		selector:    NewFieldSelector(&config.Output),
+		tags:        Config.Tags,
+		truncator:   NewTruncator(&config.Truncate),
+		extractor:   extractor,
+		sender:      sender,
+		aggregators: aggregators,
+		dedup:       NewDeduplicator(&config.Dedup),
+		correlator:  NewCorrelator(&config.Correlate),
+		alerter:     alerter,
+		anomaly:     NewAnomalyDetector(config.Name, &config.Anomaly),
+		templates:   NewFieldTemplates(config.Name, Config.Fields),
+
+		anomalySender: anomalySender,
+		health:        NewHealthMonitor(config.Name, &config.Health),
+		healthSender:  healthSender,
+		schedule:      schedule,
+		profiler:      NewProfiler(&config.Profile),
+		schema:        NewSchemaMonitor(config.Name, &config.Schema),
+		schemaSender:  schemaSender,
+		sharder:       sharder,
+		merger:        merger,
+		pathFields:    pathFields,
+		read:          Config.Read.Merge(config.Read),
 	}
+	task.setStop(stat.Stop)
+	task.lines.Add(stat.LinesTotal)
+	task.bytes.Add(stat.BytesTotal)
 	log.Infof("[PeckTask] new peck task %#v", task)
 	return task, nil
 }
 
-func (p *PeckTask) Start() error {
-	p.Stat.Stop = false
-	if err := p.sender.Start(); err != nil {
+// Start starts the senders of the task, they are canceled when ctx is done
+// or the task is stopped
+func (p *PeckTask) Start(ctx context.Context) error {
+	p.setStop(false)
+	p.mu.Lock()
+	atomic.StoreInt32(&p.failed, 0)
+	p.failure = ""
+	if p.cancel != nil {
+		p.cancel()
+	}
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.ctx = ctx
+	p.mu.Unlock()
+	p.ApplySchedule(time.Now())
+	if err := p.sender.Start(ctx); err != nil {
 		return err
 	}
+	if p.anomalySender != nil {
+		if err := p.anomalySender.Start(ctx); err != nil {
+			return err
+		}
+	}
+	if p.schemaSender != nil {
+		if err := p.schemaSender.Start(ctx); err != nil {
+			return err
+		}
+	}
+	if p.sharder.IsEnable() {
+		workers := make([]func(path, content, seq string), p.Config.Shard.Workers)
+		for i := range workers {
+			extractor, err := NewExtractor(p.Config.Extractor)
+			if err != nil {
+				return err
+			}
+			workers[i] = func(path, content, seq string) {
+				p.processShard(extractor, path, content, seq)
+			}
+		}
+		p.sharder.Start(workers)
+	}
+	if p.merger.IsEnable() {
+		p.merger.Start(p.processFileLine)
+	}
+	if p.health.IsEnable() {
+		if p.healthSender != nil {
+			if err := p.healthSender.Start(ctx); err != nil {
+				return err
+			}
+		}
+		go p.watchHealth(ctx)
+	}
+	if p.dedup.IsEnable() {
+		go p.expireDedup(ctx)
+	}
+	if p.Config.Backfill.Enable && !p.Stat.BackfillDone {
+		p.mu.Lock()
+		p.backfiller = NewBackfiller(p.Config.LogPath, &p.Config.Backfill, p.Process)
+		p.backfiller.bufferSize = p.read.BufferSize
+		p.mu.Unlock()
+		if err := p.backfiller.Start(p.finishBackfill); err != nil {
+			log.Infof("[PeckTask %s] Backfill error, err[%s]", p.Config.Name, err)
+		}
+	}
 	return nil
 }
 
+// watchHealth checks the sender health until ctx is done, degraded and
+// recovered events are sent with the health sender
+func (p *PeckTask) watchHealth(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			event := p.health.Check(p.FailingSince(), now)
+			if event == nil {
+				continue
+			}
+			if p.healthSender != nil {
+				p.healthSender.Send(event)
+			}
+			if p.health.Degraded() && p.Config.Health.StopOnFailure {
+				p.stopOnFailure()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// expireDedup sends the repeat counts of the finished dedup windows until
+// ctx is done, so they are not held back until the next line is read
+func (p *PeckTask) expireDedup(ctx context.Context) {
+	ticker := time.NewTicker(dedupExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.sendExpiredDedup(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendExpiredDedup sends the repeat counts of the dedup windows finished at
+// now, serialized with the lines like the other sends
+func (p *PeckTask) sendExpiredDedup(now time.Time) {
+	defer p.recoverPanic()
+	p.processMu.Lock()
+	defer p.processMu.Unlock()
+	if p.successor.Load() != nil {
+		// the dedup windows are expired by the successor
+		return
+	}
+	for _, event := range p.dedup.Expire(now) {
+		p.send(event)
+	}
+}
+
+// stopOnFailure stops the degraded task through onFailure, so its log and
+// its saved stat are updated as if it was stopped by the API
+func (p *PeckTask) stopOnFailure() {
+	log.Errorf("[PeckTask %s] Sender keeps failing, stop task", p.Config.Name)
+	if p.onFailure != nil {
+		p.onFailure(p)
+		return
+	}
+	if err := p.Stop(); err != nil {
+		log.Infof("[PeckTask %s] Stop error, err[%s]", p.Config.Name, err)
+	}
+}
+
+// ApplySchedule puts the task to sleep outside its schedule windows and
+// wakes it up inside
+func (p *PeckTask) ApplySchedule(now time.Time) {
+	sleeping := int32(0)
+	if !p.schedule.Active(now) {
+		sleeping = 1
+	}
+	if atomic.SwapInt32(&p.sleeping, sleeping) != sleeping {
+		if sleeping != 0 {
+			log.Infof("[PeckTask %s] Out of schedule, sleep", p.Config.Name)
+		} else {
+			log.Infof("[PeckTask %s] In schedule, wake up", p.Config.Name)
+		}
+	}
+}
+
+// Sleeping reports whether the task is outside its schedule windows
+func (p *PeckTask) Sleeping() bool {
+	return atomic.LoadInt32(&p.sleeping) != 0
+}
+
+// FailingSince returns since when the task sender fails continuously, zero
+// if it is healthy
+func (p *PeckTask) FailingSince() time.Time {
+	if reporter, ok := p.sender.(HealthReporter); ok {
+		return reporter.FailingSince()
+	}
+	return time.Time{}
+}
+
+// finishBackfill records that the file was backfilled, so the task does not
+// backfill again when restarted
+func (p *PeckTask) finishBackfill() {
+	p.mu.Lock()
+	p.Stat.BackfillDone = true
+	p.mu.Unlock()
+	if db == nil {
+		return
+	}
+	stat, err := db.GetStat(p.Config.Name)
+	if err != nil {
+		return
+	}
+	stat.BackfillDone = true
+	db.SaveStat(stat)
+}
+
+// BackfillProgress returns the percent and ETA of the running backfill
+func (p *PeckTask) BackfillProgress() (bool, float64, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Stat.BackfillDone {
+		return true, 100, 0
+	}
+	if p.backfiller == nil {
+		return false, 0, -1
+	}
+	percent, eta := p.backfiller.Progress()
+	return false, percent, eta
+}
+
+// StopConfig sets what a task does when it is stopped or removed. With
+// Flush the partial interval of the aggregators, dedup and correlator is sent
+// and the senders drain their queues, for up to Timeout seconds (10 by
+// default) before the remaining sends are canceled
+type StopConfig struct {
+	Flush   bool  `json:"Flush"`
+	Timeout int64 `json:"Timeout"`
+}
+
+func (c *StopConfig) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
 func (p *PeckTask) Stop() error {
-	p.Stat.Stop = true
+	p.stopBackfill()
+	p.merger.Stop()
+	p.sharder.Stop()
+	p.mu.Lock()
+	cancel := p.cancel
+	p.cancel = nil
+	p.mu.Unlock()
+	p.setStop(true)
+	if p.Config.OnStop.Flush && cancel != nil {
+		return p.drain(cancel)
+	}
+	if cancel != nil {
+		cancel()
+	}
+	return p.stopSenders()
+}
+
+// drain flushes the task and stops its senders, the sends still in flight
+// after the OnStop timeout are canceled
+func (p *PeckTask) drain(cancel context.CancelFunc) error {
+	done := make(chan error, 1)
+	go func() {
+		p.flush(time.Now())
+		done <- p.stopSenders()
+	}()
+	timer := time.NewTimer(p.Config.OnStop.timeout())
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		cancel()
+		return err
+	case <-timer.C:
+		log.Warnf("[PeckTask %s] Flush on stop timeout after %s, cancel the remaining sends", p.Config.Name, p.Config.OnStop.timeout())
+		cancel()
+		return <-done
+	}
+}
+
+// Handover replaces the running task p by next without losing data. Lines
+// are held while the state of unchanged aggregator, dedup and correlate
+// stages moves to next, changed ones are flushed with the old config. Late
+// lines reaching p are forwarded to next, and the old senders flush their
+// queues before their sends are canceled
+func (p *PeckTask) Handover(ctx context.Context, next *PeckTask) error {
+	p.stopBackfill()
+	p.merger.Stop()
+	p.sharder.Stop()
+	if err := next.Start(ctx); err != nil {
+		return err
+	}
+	p.processMu.Lock()
+	next.processMu.Lock()
+	now := time.Now()
+	if reflect.DeepEqual(p.Config.Correlate, next.Config.Correlate) {
+		next.correlator = p.correlator
+	} else if p.correlator.IsEnable() {
+		p.emit(p.correlator.Flush(), now)
+	}
+	if reflect.DeepEqual(p.Config.Dedup, next.Config.Dedup) {
+		next.dedup = p.dedup
+	} else if p.dedup.IsEnable() {
+		for _, event := range p.dedup.Flush() {
+			p.send(event)
+		}
+	}
+	if reflect.DeepEqual(p.Config.Profile, next.Config.Profile) {
+		next.profiler = p.profiler
+	}
+	if reflect.DeepEqual(p.Config.Schema, next.Config.Schema) {
+		next.schema = p.schema
+	}
+	if reflect.DeepEqual(p.Config.Aggregator, next.Config.Aggregator) {
+		next.aggregators = p.aggregators
+		lastAggregation := p.LastAggregation()
+		next.mu.Lock()
+		next.lastAggregation = lastAggregation
+		next.mu.Unlock()
+	} else if p.aggregators[0].IsEnable() {
+		for _, aggregator := range p.aggregators {
+			if fields := aggregator.Flush(now.Unix()); fields != nil {
+				p.processAggregation(fields)
+			}
+		}
+	}
+	if next.capture.Load() == nil {
+		next.capture.Store(p.capture.Load())
+	}
+	if next.tracer.Load() == nil {
+		next.tracer.Store(p.tracer.Load())
+	}
+	p.successor.Store(next)
+	next.processMu.Unlock()
+	p.processMu.Unlock()
+
+	p.mu.Lock()
+	cancel := p.cancel
+	p.cancel = nil
+	p.mu.Unlock()
+	if err := p.stopSenders(); err != nil {
+		log.Infof("[PeckTask %s] Stop error, err[%s]", p.Config.Name, err)
+	}
+	if cancel != nil {
+		cancel()
+	}
+	return nil
+}
+
+// flush sends what the correlator, dedup and aggregators hold
+func (p *PeckTask) flush(now time.Time) {
+	p.processMu.Lock()
+	defer p.processMu.Unlock()
+	if p.correlator.IsEnable() {
+		p.emit(p.correlator.Flush(), now)
+	}
+	if p.dedup.IsEnable() {
+		for _, event := range p.dedup.Flush() {
+			p.send(event)
+		}
+	}
+	if p.aggregators[0].IsEnable() {
+		for _, aggregator := range p.aggregators {
+			if fields := aggregator.Flush(now.Unix()); fields != nil {
+				p.processAggregation(fields)
+			}
+		}
+	}
+}
+
+func (p *PeckTask) stopBackfill() {
+	p.mu.Lock()
+	backfiller, catchUp := p.backfiller, p.catchUp
+	p.backfiller, p.catchUp = nil, nil
+	p.mu.Unlock()
+	if backfiller != nil {
+		backfiller.Stop()
+	}
+	if catchUp != nil {
+		catchUp.Stop()
+	}
+}
+
+// CatchUp processes the lines of LogPath between the offsets from and to,
+// which were written while the task was stopped, besides the live tail
+func (p *PeckTask) CatchUp(from, to int64) error {
+	catchUp := NewRangeBackfiller(p.Config.LogPath, from, to, p.Process)
+	catchUp.bufferSize = p.read.BufferSize
+	p.mu.Lock()
+	p.catchUp = catchUp
+	p.mu.Unlock()
+	log.Infof("[PeckTask %s] Catch up %d bytes written while stopped", p.Config.Name, to-from)
+	return catchUp.Start(func() {
+		log.Infof("[PeckTask %s] Caught up", p.Config.Name)
+	})
+}
+
+func (p *PeckTask) stopSenders() error {
 	if err := p.sender.Stop(); err != nil {
 		return err
 	}
+	if p.anomalySender != nil {
+		if err := p.anomalySender.Stop(); err != nil {
+			return err
+		}
+	}
+	if p.healthSender != nil {
+		if err := p.healthSender.Stop(); err != nil {
+			return err
+		}
+	}
+	if p.schemaSender != nil {
+		if err := p.schemaSender.Stop(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (p *PeckTask) IsStop() bool {
-	return p.Stat.Stop
+	return atomic.LoadInt32(&p.stopped) != 0
+}
+
+// setStop sets the stop flag read by the log goroutines, and Stat.Stop
+// saved by the pecker
+func (p *PeckTask) setStop(stop bool) {
+	stopped := int32(0)
+	if stop {
+		stopped = 1
+	}
+	atomic.StoreInt32(&p.stopped, stopped)
+	p.Stat.Stop = stop
+}
+
+// ProcessFileLine processes a line read from path, one of the files of
+// LogPath, lines are held to be merged in time order if Merge is enabled
+func (p *PeckTask) ProcessFileLine(path, content, seq string) {
+	if p.merger.IsEnable() && !p.IsStop() {
+		p.merger.Add(path, content, seq, time.Now())
+		return
+	}
+	p.processFileLine(path, content, seq)
 }
 
 func (p *PeckTask) Process(content string) {
-	//log.Infof("sender%v",p.sender)
-	if p.Stat.Stop {
+	p.ProcessLine(content, "")
+}
+
+// ProcessLine processes a line whose position in the log is seq, events of
+// the line get seq in SequenceField
+func (p *PeckTask) ProcessLine(content string, seq string) {
+	p.processFileLine("", content, seq)
+}
+
+// processFileLine processes a line of the file path, empty if unknown.
+// Lines longer than MaxLineSize are processed in parts
+func (p *PeckTask) processFileLine(path, content, seq string) {
+	if parts := SplitLine(content, p.read.MaxLineSize); len(parts) > 1 {
+		for i, part := range parts {
+			p.processLine(path, part, partSequence(seq, i))
+		}
+		return
+	}
+	p.processLine(path, content, seq)
+}
+
+func (p *PeckTask) processLine(path, content, seq string) {
+	if next := p.successor.Load(); next != nil {
+		next.processLine(path, content, seq)
+		return
+	}
+	if p.charset.IsEnable() {
+		content = p.charset.Convert(content)
+	}
+	if p.preprocess.IsEnable() {
+		content = p.preprocess.Clean(content)
+	}
+	tracer := p.tracer.Load()
+	if tracer != nil && tracer.Matches(content) {
+		tracer.Step("received", p.Config.Name)
+		defer tracer.Finish()
+	} else {
+		tracer = nil
+	}
+	if p.IsStop() || atomic.LoadInt32(&p.failed) != 0 || atomic.LoadInt32(&p.sleeping) != 0 {
+		if tracer != nil {
+			tracer.Step("ignored", "task is stopped, failed or sleeping")
+		}
 		return
 	}
+	if capture := p.capture.Load(); capture != nil {
+		capture.AddLine(content, time.Now())
+	}
+	p.lines.Add(1)
+	p.bytes.Add(int64(len(content)))
+	atomic.StoreInt32(&p.dirty, 1)
+	p.processConverted(path, content, seq, tracer)
+}
+
+// processConverted filters, extracts and sends a line which was converted
+// and counted
+func (p *PeckTask) processConverted(path, content, seq string, tracer *Tracer) {
+	defer p.recoverPanic()
 	if p.filter.Drop(content) {
+		if tracer != nil {
+			tracer.Step("filter", "dropped by "+p.filter.DropReason(content))
+		}
+		return
+	}
+	if tracer == nil && p.sharder.IsEnable() && p.sharder.Dispatch(path, content, seq) {
+		return
+	}
+	p.processMu.Lock()
+	if next := p.successor.Load(); next != nil {
+		// handed over meanwhile, the line is not converted nor counted again
+		p.processMu.Unlock()
+		next.processConverted(path, content, seq, nil)
 		return
 	}
+	defer p.processMu.Unlock()
+	if tracer != nil {
+		tracer.Step("filter", "passed")
+		p.tracing = tracer
+		defer func() { p.tracing = nil }()
+	}
+
+	line, truncated := p.truncator.TruncateLine(content)
+	fields, err := p.extractor.Extract(line)
+	p.processFields(fields, err, truncated, path, seq, tracer)
+}
 
-	fields, _ := p.extractor.Extract(content)
-	if p.aggregator.IsEnable() {
-		timestamp := p.aggregator.Record(fields)
-		deadline := p.aggregator.IsDeadline(timestamp)
-		if deadline {
-			fields = p.aggregator.Dump(timestamp)
-			p.sender.Send(fields)
+// processShard extracts a line in a shard worker with its own extractor,
+// the extracted fields are processed under processMu
+func (p *PeckTask) processShard(extractor Extractor, path, content, seq string) {
+	defer p.recoverPanic()
+	line, truncated := p.truncator.TruncateLine(content)
+	fields, err := extractor.Extract(line)
+	p.processMu.Lock()
+	if next := p.successor.Load(); next != nil {
+		p.processMu.Unlock()
+		next.processConverted(path, content, seq, nil)
+		return
+	}
+	defer p.processMu.Unlock()
+	p.processFields(fields, err, truncated, path, seq, nil)
+}
+
+// processFields sends the fields extracted from a line of the file path
+// through the aggregators or as an event, processMu must be held
+func (p *PeckTask) processFields(fields map[string]interface{}, err error, truncated bool, path, seq string, tracer *Tracer) {
+	if tracer != nil {
+		if err != nil {
+			tracer.Step("extractor", "error: "+err.Error())
+		} else {
+			tracer.Step("extractor", fmt.Sprintf("%v", fields))
+		}
+	}
+	if p.truncator.TruncateFields(fields) || truncated {
+		atomic.AddInt64(&p.Stat.TruncatedTotal, 1)
+	}
+	if fields != nil {
+		p.setFields(fields, path)
+	}
+	if p.profiler.IsEnable() && err == nil {
+		p.profiler.Record(fields, time.Now())
+	}
+	if p.schema.IsEnable() && err == nil {
+		for _, event := range p.schema.Record(fields, time.Now()) {
+			if p.schemaSender != nil {
+				p.schemaSender.Send(event)
+			}
+		}
+	}
+	if p.replayTag != "" && fields != nil {
+		fields[p.replayTag] = true
+	}
+	if p.Config.SequenceField != "" && seq != "" && fields != nil {
+		fields[p.Config.SequenceField] = seq
+	}
+	if p.aggregators[0].IsEnable() {
+		for _, aggregator := range p.aggregators {
+			timestamp := aggregator.Record(fields)
+			if tracer != nil {
+				tracer.Step("aggregator", fmt.Sprintf("recorded at %d", timestamp))
+			}
+			deadline := aggregator.IsDeadline(timestamp)
+			if deadline {
+				p.processAggregation(aggregator.Dump(timestamp))
+			}
 		}
 	} else {
-		p.sender.Send(fields)
+		p.processEvent(fields)
 	}
 }
 
-func (p *PeckTask) ProcessTest(content string) (map[string]interface{}, error) {
-	if p.filter.Drop(content) {
-		return map[string]interface{}{}, errors.New("Discarded")
+// setFields sets the fields captured from the file path, then the constant
+// and default Fields of the task on fields. Lines of a single file LogPath
+// without path, e.g. backfilled, are of LogPath
+func (p *PeckTask) setFields(fields map[string]interface{}, path string) {
+	if p.pathFields.IsEnable() {
+		if path == "" && !IsGlobPath(p.Config.LogPath) && !p.Config.Directory.Enable {
+			path = p.Config.LogPath
+		}
+		for name, value := range p.pathFields.Fields(path) {
+			fields[name] = value
+		}
 	}
-	fields, err := p.extractor.Extract(content)
-	if err != nil {
-		return map[string]interface{}{}, err
+	for _, field := range p.Config.Fields {
+		if field.Value != nil {
+			fields[field.Name] = field.Value
+		} else if _, ok := fields[field.Name]; !ok {
+			fields[field.Name] = field.Default
+		}
+	}
+}
+
+// takeCounters returns the counters of the task if they changed since the
+// previous call
+func (p *PeckTask) takeCounters() (PeckTaskStat, bool) {
+	if !atomic.CompareAndSwapInt32(&p.dirty, 1, 0) {
+		return PeckTaskStat{}, false
+	}
+	return PeckTaskStat{
+		Name:           p.Config.Name,
+		LinesTotal:     p.lines.Total(),
+		BytesTotal:     p.bytes.Total(),
+		TruncatedTotal: atomic.LoadInt64(&p.Stat.TruncatedTotal),
+		PanicTotal:     atomic.LoadInt64(&p.Stat.PanicTotal),
+	}, true
+}
+
+// savedStat returns the fields of the task stat kept in the database, with
+// the current counters
+func (p *PeckTask) savedStat() PeckTaskStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stat := PeckTaskStat{
+		Name:           p.Config.Name,
+		LinesTotal:     p.lines.Total(),
+		BytesTotal:     p.bytes.Total(),
+		Stop:           p.IsStop(),
+		TruncatedTotal: atomic.LoadInt64(&p.Stat.TruncatedTotal),
+		PanicTotal:     atomic.LoadInt64(&p.Stat.PanicTotal),
+		BackfillDone:   p.Stat.BackfillDone,
+	}
+	if p.Stat.StopOffset != nil {
+		offset := *p.Stat.StopOffset
+		stat.StopOffset = &offset
+	}
+	return stat
+}
+
+// recoverPanic marks the task failed on a panic, other tasks of the same
+// log keep running
+func (p *PeckTask) recoverPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	atomic.AddInt64(&p.Stat.PanicTotal, 1)
+	p.mu.Lock()
+	p.failure = fmt.Sprintf("panic: %v", r)
+	atomic.StoreInt32(&p.failed, 1)
+	p.mu.Unlock()
+	log.Errorf("[PeckTask %s] Process panic, task failed: %v\n%s", p.Config.Name, r, debug.Stack())
+}
+
+// Failure returns whether the task failed and why
+func (p *PeckTask) Failure() (bool, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return atomic.LoadInt32(&p.failed) != 0, p.failure
+}
+
+// processAggregation checks alerts and anomalies of an aggregator dump and
+// sends it
+func (p *PeckTask) processAggregation(fields map[string]interface{}) {
+	p.mu.Lock()
+	p.lastAggregation = fields
+	p.mu.Unlock()
+	if p.alerter.IsEnable() {
+		p.alerter.Evaluate(fields)
+	}
+	if p.anomaly.IsEnable() {
+		for _, anomaly := range p.anomaly.Detect(fields) {
+			if p.anomalySender != nil {
+				p.anomalySender.Send(anomaly)
+			} else {
+				p.sender.Send(anomaly)
+			}
+		}
+	}
+	p.send(fields)
+}
+
+// processEvent sends a not aggregated event through correlator and dedup
+func (p *PeckTask) processEvent(fields map[string]interface{}) {
+	now := time.Now()
+	events := []map[string]interface{}{fields}
+	if p.correlator.IsEnable() {
+		events = p.correlator.Correlate(fields, now)
+	}
+	p.emit(events, now)
+}
+
+// emit sends events through the field templates and dedup
+func (p *PeckTask) emit(events []map[string]interface{}, now time.Time) {
+	for _, event := range events {
+		if p.templates.IsEnable() && event != nil {
+			p.templates.Apply(event)
+		}
+		if !p.dedup.IsEnable() {
+			p.send(event)
+			continue
+		}
+		for _, e := range p.dedup.Dedup(event, now) {
+			p.send(e)
+		}
+	}
+}
+
+// send hands event to the sender, keeping a sample when capturing
+func (p *PeckTask) send(event map[string]interface{}) {
+	if len(p.tags) > 0 && event != nil {
+		for k, v := range p.tags {
+			if _, ok := event[k]; !ok {
+				event[k] = v
+			}
+		}
+	}
+	if p.selector.IsEnable() && event != nil {
+		event = p.selector.Select(event)
+	}
+	if capture := p.capture.Load(); capture != nil && event != nil {
+		capture.AddEvent(event, time.Now())
+	}
+	if p.tracing != nil {
+		p.tracing.Step("sender", fmt.Sprintf("%v", event))
+	}
+	if p.Config.Delivery == DeliveryAtLeastOnce {
+		p.sendAck(event)
+		return
+	}
+	p.sender.Send(event)
+}
+
+// sendAck sends event again with backoff until the sender confirms it or
+// the task is stopped, the log is not read further meanwhile
+func (p *PeckTask) sendAck(event map[string]interface{}) {
+	sender := p.sender.(AckSender)
+	p.mu.Lock()
+	ctx := p.ctx
+	p.mu.Unlock()
+	backoff := ackMinBackoff
+	for {
+		err := sender.SendAck(event)
+		if err == nil || ctx == nil {
+			return
+		}
+		log.Infof("[PeckTask %s] Send not confirmed, retry in %s, err[%s]", p.Config.Name, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		backoff *= 2
+		if backoff > ackMaxBackoff {
+			backoff = ackMaxBackoff
+		}
+	}
+}
+
+// SetCapture starts capturing the last size lines and events, a size of 0
+// stops capturing and drops the samples
+func (p *PeckTask) SetCapture(size int) {
+	if size <= 0 {
+		p.capture.Store(nil)
+		return
+	}
+	p.capture.Store(NewSampleCapture(size))
+}
+
+// Trace traces the next line containing the marker of tracer, nil stops
+// tracing
+func (p *PeckTask) Trace(tracer *Tracer) {
+	p.tracer.Store(tracer)
+}
+
+// Profile returns the field profiles, nil if Profile is not enabled
+func (p *PeckTask) Profile() []ProfileReport {
+	if !p.profiler.IsEnable() {
+		return nil
+	}
+	return p.profiler.Reports(time.Now())
+}
+
+// Capture returns the running capture, nil if not capturing
+func (p *PeckTask) Capture() *SampleCapture {
+	return p.capture.Load()
+}
+
+// TimeoutTotal returns the request timeouts of the task senders
+func (p *PeckTask) TimeoutTotal() int64 {
+	total := int64(0)
+	for _, sender := range []Sender{p.sender, p.anomalySender} {
+		if counter, ok := sender.(TimeoutCounter); ok {
+			total += counter.TimeoutTotal()
+		}
+	}
+	return total
+}
+
+// DroppedTotal returns the events the task senders gave up and the values
+// the aggregators couldn't parse
+func (p *PeckTask) DroppedTotal() int64 {
+	total := int64(0)
+	for _, aggregator := range p.aggregators {
+		total += aggregator.DroppedTotal()
+	}
+	for _, sender := range []Sender{p.sender, p.anomalySender} {
+		if counter, ok := sender.(DropCounter); ok {
+			total += counter.DroppedTotal()
+		}
+	}
+	return total
+}
+
+// BreakerStates returns the circuit breaker state of each backend of the
+// task senders
+func (p *PeckTask) BreakerStates() map[string]string {
+	states := make(map[string]string)
+	for _, sender := range []Sender{p.sender, p.anomalySender} {
+		if stater, ok := sender.(BreakerStater); ok {
+			for backend, state := range stater.BreakerStates() {
+				states[backend] = state
+			}
+		}
+	}
+	return states
+}
+
+// BackendStats returns the response counters of each backend of the task
+// senders
+func (p *PeckTask) BackendStats() map[string]BackendStat {
+	stats := make(map[string]BackendStat)
+	for _, sender := range []Sender{p.sender, p.anomalySender} {
+		if stater, ok := sender.(BackendStater); ok {
+			for backend, stat := range stater.BackendStats() {
+				stats[backend] = stat
+			}
+		}
+	}
+	return stats
+}
+
+// LastAggregation returns the latest aggregator output, nil if none
+func (p *PeckTask) LastAggregation() map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastAggregation
+}
+
+// TestResult tells how a line went through the filter and the extractor in
+// test mode. Matched, Missing and Remainder are only set by extractors
+// implementing ExtractorDiagnoser with configured fields
+type TestResult struct {
+	DroppedBy string
+	Fields    map[string]interface{}
+	Matched   []string
+	Missing   []string
+	Remainder string
+	Err       error
+	Elapsed   time.Duration
+}
+
+func (p *PeckTask) ProcessTest(content string) TestResult {
+	start := time.Now()
+	res := TestResult{}
+	if p.charset.IsEnable() {
+		content = p.charset.Convert(content)
+	}
+	if p.preprocess.IsEnable() {
+		content = p.preprocess.Clean(content)
+	}
+	if res.DroppedBy = p.filter.DropReason(content); res.DroppedBy != "" {
+		res.Elapsed = time.Since(start)
+		return res
+	}
+	content, _ = p.truncator.TruncateLine(content)
+	res.Fields, res.Err = p.extractor.Extract(content)
+	if res.Err != nil {
+		res.Elapsed = time.Since(start)
+		return res
+	}
+	p.truncator.TruncateFields(res.Fields)
+	res.Elapsed = time.Since(start)
+	d, ok := p.extractor.(ExtractorDiagnoser)
+	if _, raw := res.Fields["_Log"]; !ok || raw {
+		return res
+	}
+	res.Missing, res.Remainder = d.Diagnose(content, res.Fields)
+	missing := make(map[string]bool)
+	for _, field := range res.Missing {
+		missing[field] = true
+	}
+	for field := range res.Fields {
+		if !missing[field] {
+			res.Matched = append(res.Matched, field)
+		}
 	}
-	return fields, nil
+	sort.Strings(res.Matched)
+	return res
 }
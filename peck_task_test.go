@@ -0,0 +1,326 @@
+package logpeck
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type panicSender struct {
+	sent int
+}
+
+func (p *panicSender) Start(ctx context.Context) error { return nil }
+func (p *panicSender) Stop() error                     { return nil }
+func (p *panicSender) Send(fields map[string]interface{}) {
+	p.sent++
+	if fields["_Log"] == "boom" {
+		panic("boom")
+	}
+}
+
+func TestPeckTaskPanic(*testing.T) {
+	h, err := NewHarness([]byte(`{
+		"Name": "PanicLog",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &panicSender{}
+	h.Task.sender = sender
+	h.Feed("ok", "boom", "ignored")
+	failed, failure := h.Task.Failure()
+	if !failed || !strings.Contains(failure, "boom") || h.Task.Stat.PanicTotal != 1 || sender.sent != 2 {
+		panic(failure)
+	}
+	h.Task.Start(context.Background())
+	h.Feed("ok")
+	if failed, _ := h.Task.Failure(); failed || sender.sent != 3 {
+		panic(sender.sent)
+	}
+}
+
+type eventSender struct {
+	events []map[string]interface{}
+}
+
+func (p *eventSender) Start(ctx context.Context) error { return nil }
+func (p *eventSender) Stop() error                     { return nil }
+func (p *eventSender) Send(fields map[string]interface{}) {
+	p.events = append(p.events, fields)
+}
+
+func TestPeckTaskHandover(*testing.T) {
+	newTask := func(window int) (*PeckTask, *eventSender) {
+		config := &PeckTaskConfig{}
+		err := config.Unmarshal([]byte(`{
+			"Name": "DedupLog",
+			"Extractor": {"Name": "text", "Config": {"Fields": []}},
+			"Sender": {"Name": "prometheus"},
+			"Dedup": {"Enable": true, "Fields": ["_Log"], "Window": ` + strconv.Itoa(window) + `}
+		}`))
+		if err != nil {
+			panic(err)
+		}
+		task, err := NewPeckTask(config, &PeckTaskStat{Name: config.Name})
+		if err != nil {
+			panic(err)
+		}
+		sender := &eventSender{}
+		task.sender = sender
+		return task, sender
+	}
+	ctx := context.Background()
+
+	// unchanged dedup moves to the new task, repeats stay suppressed
+	old, oldSender := newTask(60)
+	old.Start(ctx)
+	old.Process("a")
+	old.Process("a")
+	next, nextSender := newTask(60)
+	if err := old.Handover(ctx, next); err != nil {
+		panic(err)
+	}
+	old.Process("a")
+	next.Process("b")
+	if len(oldSender.events) != 1 || len(nextSender.events) != 1 || nextSender.events[0]["_Log"] != "b" {
+		panic(nextSender.events)
+	}
+
+	// changed dedup flushes the repeat count with the old sender
+	next2, next2Sender := newTask(30)
+	if err := next.Handover(ctx, next2); err != nil {
+		panic(err)
+	}
+	if len(nextSender.events) != 2 || nextSender.events[1]["repeat_count"] != int64(2) {
+		panic(nextSender.events)
+	}
+	next2.Process("a")
+	if len(next2Sender.events) != 1 {
+		panic(next2Sender.events)
+	}
+}
+
+func TestPeckTaskProcessTest(*testing.T) {
+	h, err := NewHarness([]byte(`{
+		"Name": "TestLog",
+		"Keywords": "GET|POST|^health",
+		"Extractor": {"Name": "text", "Config": {"Delimiters": " ", "Fields": [
+			{"Name": "method", "Value": "$1"}, {"Name": "code", "Value": "$3"}, {"Name": "cost", "Value": "$5"}]}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	if res := h.Task.ProcessTest("PUT /a 200"); res.DroppedBy != "GET|POST" {
+		panic(res)
+	}
+	if res := h.Task.ProcessTest("GET /health 200"); res.DroppedBy != "^health" {
+		panic(res)
+	}
+	res := h.Task.ProcessTest("GET /a 200 xyz")
+	if res.DroppedBy != "" || res.Err != nil || res.Fields["code"] != "200" ||
+		strings.Join(res.Matched, ",") != "code,method" ||
+		strings.Join(res.Missing, ",") != "cost" || res.Remainder != "/a xyz" {
+		panic(res)
+	}
+}
+
+func TestPeckTaskTags(*testing.T) {
+	Config.Tags = map[string]interface{}{"datacenter": "dc1", "rack": int64(12)}
+	defer func() { Config.Tags = nil }()
+	config := &PeckTaskConfig{}
+	err := config.Unmarshal([]byte(`{
+		"Name": "TagsLog",
+		"Extractor": {"Name": "text", "Config": {"Fields": [{"Name": "rack", "Value": "$1"}]}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	task, err := NewPeckTask(config, &PeckTaskStat{Name: config.Name})
+	if err != nil {
+		panic(err)
+	}
+	sender := &eventSender{}
+	task.sender = sender
+	task.Start(context.Background())
+	task.Process("7 line")
+	task.Stop()
+	if len(sender.events) != 1 || sender.events[0]["datacenter"] != "dc1" || sender.events[0]["rack"] != "7" {
+		panic(sender.events)
+	}
+}
+
+func TestPeckTaskFields(*testing.T) {
+	config := &PeckTaskConfig{}
+	if err := config.Unmarshal([]byte(`{"Name": "FieldsLog", "Fields": [{"Name": "a"}]}`)); err == nil {
+		panic("field without Value or Default accepted")
+	}
+	config = &PeckTaskConfig{}
+	err := config.Unmarshal([]byte(`{
+		"Name": "FieldsLog",
+		"Extractor": {"Name": "text", "Config": {"Fields": [{"Name": "level", "Value": "$1"}, {"Name": "service", "Value": "$2"}]}},
+		"Sender": {"Name": "prometheus"},
+		"Fields": [
+			{"Name": "service", "Value": "checkout"},
+			{"Name": "level", "Default": "info"},
+			{"Name": "cost", "Default": 0}
+		]
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	task, err := NewPeckTask(config, &PeckTaskStat{Name: config.Name})
+	if err != nil {
+		panic(err)
+	}
+	sender := &eventSender{}
+	task.sender = sender
+	task.Start(context.Background())
+	task.Process("error web")
+	task.Process("")
+	task.Stop()
+	if len(sender.events) != 2 {
+		panic(sender.events)
+	}
+	first, second := sender.events[0], sender.events[1]
+	if first["level"] != "error" || first["service"] != "checkout" || first["cost"] != float64(0) {
+		panic(first)
+	}
+	if second["level"] != "info" || second["service"] != "checkout" {
+		panic(second)
+	}
+}
+
+type drainSender struct {
+	eventSender
+	ctx context.Context
+}
+
+func (p *drainSender) Start(ctx context.Context) error {
+	p.ctx = ctx
+	return nil
+}
+
+// Stop waits for the sends canceled by the task
+func (p *drainSender) Stop() error {
+	<-p.ctx.Done()
+	return nil
+}
+
+func TestPeckTaskFlushOnStop(*testing.T) {
+	newTask := func(onStop string) (*PeckTask, *eventSender) {
+		config := &PeckTaskConfig{}
+		err := config.Unmarshal([]byte(`{
+			"Name": "DedupLog",
+			"Extractor": {"Name": "text", "Config": {"Fields": []}},
+			"Sender": {"Name": "prometheus"},
+			"Dedup": {"Enable": true, "Fields": ["_Log"], "Window": 60},
+			"OnStop": ` + onStop + `
+		}`))
+		if err != nil {
+			panic(err)
+		}
+		task, err := NewPeckTask(config, &PeckTaskStat{Name: config.Name})
+		if err != nil {
+			panic(err)
+		}
+		sender := &eventSender{}
+		task.sender = sender
+		task.Start(context.Background())
+		task.Process("a")
+		task.Process("a")
+		return task, sender
+	}
+
+	task, sender := newTask(`{}`)
+	task.Stop()
+	if len(sender.events) != 1 {
+		panic(sender.events)
+	}
+
+	task, sender = newTask(`{"Flush": true}`)
+	task.Stop()
+	if len(sender.events) != 2 || sender.events[1]["repeat_count"] != int64(1) {
+		panic(sender.events)
+	}
+
+	// senders not drained in time are canceled
+	task, sender = newTask(`{"Flush": true, "Timeout": 1}`)
+	drain := &drainSender{eventSender: *sender}
+	task.sender = drain
+	task.Start(context.Background())
+	if err := task.Stop(); err != nil || len(drain.events) != 2 {
+		panic(drain.events)
+	}
+}
+
+func TestPeckTaskDeliveryStages(*testing.T) {
+	newSender := func(*SenderConfig) (Sender, error) { return &ackSender{}, nil }
+	for stage, section := range map[string]string{
+		"":           `"Dedup": {"Enable": false}`,
+		"Aggregator": `"Aggregator": {"Enable": true, "Target": "cost"}`,
+		"Dedup":      `"Dedup": {"Enable": true, "Fields": ["_Log"]}`,
+		"Correlate":  `"Correlate": {"Enable": true, "KeyField": "id"}`,
+		"Shard":      `"Shard": {"Workers": 4}`,
+		"Merge":      `"Merge": {"Enable": true, "Timestamp": "^\\d+"}`,
+	} {
+		config := &PeckTaskConfig{}
+		err := config.Unmarshal([]byte(`{
+			"Name": "AckLog",
+			"Delivery": "at-least-once",
+			"Extractor": {"Name": "text", "Config": {"Fields": []}},
+			"Sender": {"Name": "prometheus"},
+			` + section + `
+		}`))
+		if err != nil {
+			panic(err)
+		}
+		_, err = newPeckTask(config, nil, newSender)
+		if (stage == "") != (err == nil) || err != nil && !strings.HasSuffix(err.Error(), "not supported with "+stage) {
+			panic(fmt.Sprint(stage, err))
+		}
+	}
+}
+
+func TestPeckTaskHandoverCharset(*testing.T) {
+	newTask := func() (*PeckTask, *eventSender) {
+		config := &PeckTaskConfig{}
+		err := config.Unmarshal([]byte(`{
+			"Name": "Latin1Log",
+			"Extractor": {"Name": "text", "Config": {"Fields": []}},
+			"Sender": {"Name": "prometheus"},
+			"Charset": {"Charset": "latin1"}
+		}`))
+		if err != nil {
+			panic(err)
+		}
+		task, err := NewPeckTask(config, &PeckTaskStat{Name: config.Name})
+		if err != nil {
+			panic(err)
+		}
+		sender := &eventSender{}
+		task.sender = sender
+		return task, sender
+	}
+	ctx := context.Background()
+	old, _ := newTask()
+	old.Start(ctx)
+	next, nextSender := newTask()
+	if err := old.Handover(ctx, next); err != nil {
+		panic(err)
+	}
+	// late lines of the old task are converted and counted once
+	old.Process("caf\xe9")
+	if len(nextSender.events) != 1 || nextSender.events[0]["_Log"] != "café" {
+		panic(nextSender.events)
+	}
+	if old.lines.Total() != 0 || next.lines.Total() != 1 {
+		panic(next.lines.Total())
+	}
+}
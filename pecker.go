@@ -1,11 +1,16 @@
 package logpeck
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/hpcloud/tail"
+	"io"
+	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,6 +18,22 @@ type Pecker struct {
 	logTasks   map[string]*LogTask
 	nameToPath map[string]string
 	db         *DB
+	replays    map[string]*Replayer
+	history    *StatsHistory
+	statsHub   *StatsHub
+	governor   *LoadGovernor
+	persister  *StatPersister
+
+	// flushInterval is the nanoseconds between stat and offset saves
+	flushInterval int64
+
+	// restoreErrors are the saved tasks which failed to restore, by name,
+	// they stay failed until updated or removed
+	restoreErrors map[string]string
+
+	// ctx is the parent of all task contexts, canceled by Stop
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	mu   sync.Mutex
 	stop bool
@@ -23,8 +44,17 @@ func NewPecker(db *DB) (*Pecker, error) {
 		logTasks:   make(map[string]*LogTask),
 		nameToPath: make(map[string]string),
 		db:         db,
-		stop:       true,
+		replays:    make(map[string]*Replayer),
+		history:    NewStatsHistory(statsHistorySize),
+		statsHub:   NewStatsHub(),
+		governor:   NewLoadGovernor(&Config.LoadGovernor),
+		persister:  NewStatPersister(db),
+
+		flushInterval: int64(statPersistInterval),
+		restoreErrors: make(map[string]string),
+		stop:          true,
 	}
+	pecker.ctx, pecker.cancel = context.WithCancel(context.Background())
 	err := pecker.restorePeckTasks(db)
 	if err != nil {
 		return nil, err
@@ -34,13 +64,21 @@ func NewPecker(db *DB) (*Pecker, error) {
 
 func (p *Pecker) restorePeckTasks(db *DB) error {
 	defer LogExecTime(time.Now(), "Restore PeckTaskConfig")
-	configs, err := p.db.GetAllConfigs()
+	configs, broken, err := p.db.LoadAllConfigs()
 	if err != nil {
 		return err
 	}
+	for name, err := range broken {
+		log.Errorf("[Pecker] Restore PeckTask %s error, err[%s]", name, err)
+		p.restoreErrors[name] = err.Error()
+	}
 	for i, config := range configs {
 		stat, _ := p.db.GetStat(config.Name)
-		p.AddPeckTask(&config, stat)
+		if err := p.addPeckTask(&config, stat); err != nil {
+			log.Errorf("[Pecker] Restore PeckTask %s error, err[%s]", config.Name, err)
+			p.restoreErrors[config.Name] = err.Error()
+			continue
+		}
 		log.Infof("[Pecker] Restore PeckTask[%d] : %s", i, config)
 	}
 	return nil
@@ -50,7 +88,11 @@ func (p *Pecker) restorePeckTasks(db *DB) error {
 func (p *Pecker) record(config *PeckTaskConfig, stat *PeckTaskStat) {
 	if _, ok := p.nameToPath[config.Name]; !ok {
 		if _, ok2 := p.logTasks[config.LogPath]; !ok2 {
-			p.logTasks[config.LogPath] = NewLogTask(config.LogPath)
+			logTask := NewLogTask(config.LogPath)
+			logTask.governor = p.governor
+			logTask.SetDirectory(&config.Directory)
+			p.loadOffsets(logTask)
+			p.logTasks[config.LogPath] = logTask
 		}
 		p.nameToPath[config.Name] = config.LogPath
 	}
@@ -63,12 +105,23 @@ func (p *Pecker) record(config *PeckTaskConfig, stat *PeckTaskStat) {
 func (p *Pecker) AddPeckTask(config *PeckTaskConfig, stat *PeckTaskStat) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	// a saved task that failed to restore still owns its name
+	if _, err := p.db.GetConfig(config.Name); err == nil {
+		return errors.New("Peck task already exist")
+	}
+	return p.addPeckTask(config, stat)
+}
+
+func (p *Pecker) addPeckTask(config *PeckTaskConfig, stat *PeckTaskStat) error {
 	log.Infof("[Pecker] AddPeckTask %s", *config)
 	if _, ok := p.nameToPath[config.Name]; ok {
 		return errors.New("Peck task already exist")
 	}
+	if logTask, ok := p.logTasks[config.LogPath]; ok && !logTask.sameDirectory(&config.Directory) {
+		return fmt.Errorf("Tasks of LogPath %s must have the same Directory", config.LogPath)
+	}
 
-	task, err := NewPeckTask(config, stat)
+	task, err := p.newPeckTask(config, stat)
 	if err != nil {
 		return err
 	}
@@ -87,20 +140,26 @@ func (p *Pecker) UpdatePeckTask(config *PeckTaskConfig) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	log.Infof("[Pecker] UpdatePeckTask %s", *config)
+	if _, ok := p.restoreErrors[config.Name]; ok {
+		return p.repairPeckTask(config)
+	}
 	if _, ok := p.nameToPath[config.Name]; !ok {
 		return errors.New("Peck task name not exist")
 	}
 
 	stat, err := db.GetStat(config.Name)
-	task, err := NewPeckTask(config, stat)
+	task, err := p.newPeckTask(config, stat)
 	if err != nil {
 		return err
 	}
+	if err := p.updateDirectory(config); err != nil {
+		return err
+	}
 
 	p.record(config, &task.Stat)
 
 	// UpdatePeckTask must be successful
-	if err := p.logTasks[p.nameToPath[config.Name]].UpdatePeckTask(task); err != nil {
+	if err := p.logTasks[p.nameToPath[config.Name]].UpdatePeckTask(p.ctx, task); err != nil {
 		return err
 	}
 	log.Infof("[Pecker] Update PeckTask nameToPath: %v", p.nameToPath)
@@ -108,9 +167,106 @@ func (p *Pecker) UpdatePeckTask(config *PeckTaskConfig) error {
 	return nil
 }
 
+// loadOffsets sets where the files of logTask resume from the saved offsets,
+// the offsets of gone files of a glob or directory are loaded too so that
+// they expire
+func (p *Pecker) loadOffsets(logTask *LogTask) {
+	if !logTask.multiFile() {
+		if offset, err := p.db.GetOffset(logTask.LogPath); err == nil {
+			logTask.SetOffset(offset)
+		}
+		return
+	}
+	offsets, err := p.db.GetAllOffsets()
+	if err != nil {
+		log.Errorf("[Pecker] Load offsets error, %s", err)
+		return
+	}
+	for i := range offsets {
+		if logTask.ownsFile(offsets[i].LogPath) {
+			logTask.SetOffset(&offsets[i])
+		}
+	}
+}
+
+// updateDirectory applies the Directory of config to the log of the task,
+// which must be the only task of the log if it changed
+func (p *Pecker) updateDirectory(config *PeckTaskConfig) error {
+	logTask := p.logTasks[p.nameToPath[config.Name]]
+	if logTask.sameDirectory(&config.Directory) {
+		return nil
+	}
+	if len(logTask.peckTasks) > 1 {
+		return fmt.Errorf("Tasks of LogPath %s must have the same Directory", logTask.LogPath)
+	}
+	running := !logTask.IsStop()
+	if running {
+		logTask.Stop()
+	}
+	logTask.SetDirectory(&config.Directory)
+	p.loadOffsets(logTask)
+	if running {
+		return logTask.Start(p.ctx)
+	}
+	return nil
+}
+
+// DiffPeckTask validates config and returns what UpdatePeckTask would
+// change, nothing is applied
+func (p *Pecker) DiffPeckTask(config *PeckTaskConfig) (*ConfigDiff, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.nameToPath[config.Name]; !ok {
+		return nil, errors.New("Peck task name not exist")
+	}
+	if _, err := NewPeckTask(config, nil); err != nil {
+		return nil, err
+	}
+	old, err := p.db.GetConfig(config.Name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := p.db.GetStat(config.Name)
+	if err != nil {
+		return nil, err
+	}
+	return DiffPeckTaskConfig(old, config, !stat.Stop)
+}
+
+// repairPeckTask replaces the config of a task which failed to restore, the
+// task is started if it was running
+func (p *Pecker) repairPeckTask(config *PeckTaskConfig) error {
+	stat, _ := p.db.GetStat(config.Name)
+	if err := p.addPeckTask(config, stat); err != nil {
+		return err
+	}
+	delete(p.restoreErrors, config.Name)
+	if p.stop {
+		return nil
+	}
+	logTask := p.logTasks[p.nameToPath[config.Name]]
+	if task := logTask.peckTasks[config.Name]; !task.IsStop() {
+		if err := task.Start(p.ctx); err != nil {
+			return err
+		}
+	}
+	if logTask.IsStop() {
+		return logTask.Start(p.ctx)
+	}
+	return nil
+}
+
 func (p *Pecker) RemovePeckTask(config *PeckTaskConfig) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if _, ok := p.restoreErrors[config.Name]; ok {
+		if err := db.RemoveConfig(config.Name); err != nil {
+			return err
+		}
+		db.RemoveStat(config.Name)
+		delete(p.restoreErrors, config.Name)
+		return nil
+	}
 	if _, ok := p.nameToPath[config.Name]; !ok {
 		return errors.New("Peck task name not exist")
 	}
@@ -118,14 +274,16 @@ func (p *Pecker) RemovePeckTask(config *PeckTaskConfig) error {
 	log_path, ok1 := p.nameToPath[config.Name]
 	log_task, ok2 := p.logTasks[log_path]
 	if !ok1 || !ok2 {
-		log.Panicf("%v\n%v\n%v", config.Name, p.nameToPath, p.logTasks)
+		log.Errorf("[Pecker] Inconsistent task %v, %v, %v", config.Name, p.nameToPath, p.logTasks)
+		return fmt.Errorf("Peck task %s has no log task", config.Name)
 	}
 
 	log.Infof("[Pecker] Remove PeckTask try clean db: %s", config)
-	err1 := db.RemoveConfig(config.Name)
-	err2 := db.RemoveStat(config.Name)
-	if err1 != nil || err2 != nil {
-		panic(err1.Error() + " " + err2.Error())
+	if err := db.RemoveConfig(config.Name); err != nil {
+		return err
+	}
+	if err := db.RemoveStat(config.Name); err != nil {
+		return err
 	}
 
 	if err := log_task.RemovePeckTask(config); err != nil {
@@ -133,33 +291,341 @@ func (p *Pecker) RemovePeckTask(config *PeckTaskConfig) error {
 	}
 	delete(p.nameToPath, config.Name)
 	if log_task.Empty() {
+		if !log_task.IsStop() {
+			log_task.Stop()
+		}
 		log_task.Close()
 		delete(p.logTasks, log_path)
+		db.RemoveOffset(log_path)
+		for _, offset := range log_task.Offsets() {
+			db.RemoveOffset(offset.LogPath)
+		}
 	}
 	log.Infof("[Pecker] Remove PeckTask nameToPath: %v", p.nameToPath)
 	log.Infof("[Pecker] Remove PeckTask logTasks: %v", p.logTasks)
 	return nil
 }
 
-func (p *Pecker) ListPeckTask() ([]PeckTaskConfig, error) {
+// RenamePeckTask renames a task, its stat and backfill progress are kept and
+// a running task keeps running under the new name
+func (p *Pecker) RenamePeckTask(config *RenameConfig) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	configs, err := p.db.GetAllConfigs()
+	log.Infof("[Pecker] RenamePeckTask %s to %s", config.Name, config.NewName)
+	if config.NewName == "" {
+		return errors.New("NewName is required")
+	}
+	logPath, ok := p.nameToPath[config.Name]
+	if !ok {
+		return fmt.Errorf("Task not exist, Name: %s", config.Name)
+	}
+	if _, ok := p.nameToPath[config.NewName]; ok {
+		return errors.New("Peck task already exist")
+	}
+	if _, ok := p.replays[config.Name]; ok {
+		return errors.New("Task is replaying")
+	}
+	logTask := p.logTasks[logPath]
+	old := logTask.peckTasks[config.Name]
+
+	taskConfig := old.Config
+	taskConfig.Name = config.NewName
+	stat := old.savedStat()
+	stat.Name = config.NewName
+	task, err := p.newPeckTask(&taskConfig, &stat)
+	if err != nil {
+		return err
+	}
+	// the offsets are saved with the counters, so they match after a crash
+	if err := p.db.RenameTask(config.Name, &taskConfig, &stat, logTask.Offsets()); err != nil {
+		return err
+	}
+
+	if !old.IsStop() {
+		old.Stop()
+	}
+	delete(logTask.peckTasks, config.Name)
+	delete(p.nameToPath, config.Name)
+	logTask.AddPeckTask(task)
+	p.nameToPath[config.NewName] = logPath
+	if !task.IsStop() {
+		if err := task.Start(p.ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListPeckTask returns the configs of tasks matching selector, nil selects
+// all tasks
+func (p *Pecker) ListPeckTask(selector *LabelSelector) ([]PeckTaskConfig, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	configs, _, err := p.db.LoadAllConfigs()
 	if err != nil {
 		return nil, err
 	}
-	return configs, nil
+	selected := configs[:0]
+	for _, config := range configs {
+		if selector.Matches(config.Labels) {
+			selected = append(selected, config)
+		}
+	}
+	return selected, nil
 }
-func (p *Pecker) ListTaskStats() ([]PeckTaskStat, error) {
+
+// ListTaskStats returns the stats of tasks matching selector, nil selects
+// all tasks
+func (p *Pecker) ListTaskStats(selector *LabelSelector) ([]PeckTaskStat, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	stats, err := p.db.GetAllStats()
 	if err != nil {
 		return nil, err
 	}
+	if selector != nil {
+		configs, _, err := p.db.LoadAllConfigs()
+		if err != nil {
+			return nil, err
+		}
+		labels := make(map[string]map[string]string)
+		for _, config := range configs {
+			labels[config.Name] = config.Labels
+		}
+		selected := stats[:0]
+		for _, stat := range stats {
+			if _, ok := labels[stat.Name]; ok && selector.Matches(labels[stat.Name]) {
+				selected = append(selected, stat)
+			}
+		}
+		stats = selected
+	}
+	// runtime counters only live in memory
+	now := time.Now()
+	for i, stat := range stats {
+		if msg, ok := p.restoreErrors[stat.Name]; ok {
+			stats[i].Failed = true
+			stats[i].Error = "restore: " + msg
+		}
+		if logTask, ok := p.logTasks[p.nameToPath[stat.Name]]; ok {
+			stats[i].Pending = logTask.Pending()
+			stats[i].LagBytes = logTask.Lag()
+			stats[i].Files = logTask.FileStats()
+			stats[i].Throttled = p.governor.Throttled()
+		}
+		if task := p.getPeckTask(stat.Name); task != nil {
+			stats[i].TruncatedTotal = atomic.LoadInt64(&task.Stat.TruncatedTotal)
+			stats[i].TimeoutTotal = task.TimeoutTotal()
+			stats[i].DroppedTotal = task.DroppedTotal()
+			stats[i].Failed, stats[i].Error = task.Failure()
+			stats[i].PanicTotal = atomic.LoadInt64(&task.Stat.PanicTotal)
+			stats[i].Sleeping = task.Sleeping()
+			stats[i].LinesTotal = task.lines.Total()
+			stats[i].BytesTotal = task.bytes.Total()
+			stats[i].LinesPerSec = task.lines.Rate(now)
+			stats[i].BytesPerSec = task.bytes.Rate(now)
+			stats[i].Degraded = task.health.Degraded()
+			if task.schema.IsEnable() {
+				stats[i].SchemaDrift = task.schema.Drift()
+			}
+			if since := task.FailingSince(); !since.IsZero() {
+				stats[i].FailingSince = since.Unix()
+			}
+			stats[i].Breakers = task.BreakerStates()
+			stats[i].Backends = task.BackendStats()
+			if writer, ok := task.sender.(DualWriter); ok {
+				stats[i].DualWrite = writer.SecondaryStat()
+			}
+			done, percent, eta := task.BackfillProgress()
+			stats[i].BackfillDone = done
+			stats[i].BackfillPercent = percent
+			stats[i].BackfillEta = int64(eta / time.Second)
+			if eta < 0 {
+				stats[i].BackfillEta = -1
+			}
+		}
+	}
 	return stats, nil
 }
 
+// GetAggregations returns the latest aggregator output of each task
+func (p *Pecker) GetAggregations() map[string]map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	aggregations := make(map[string]map[string]interface{})
+	for _, logTask := range p.logTasks {
+		for name, task := range logTask.peckTasks {
+			if fields := task.LastAggregation(); fields != nil {
+				aggregations[name] = fields
+			}
+		}
+	}
+	return aggregations
+}
+
+// GetBackendStats returns the sender backend stats of each running task
+func (p *Pecker) GetBackendStats() map[string]map[string]BackendStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make(map[string]map[string]BackendStat)
+	for _, logTask := range p.logTasks {
+		for name, task := range logTask.peckTasks {
+			if stats := task.BackendStats(); len(stats) > 0 {
+				res[name] = stats
+			}
+		}
+	}
+	return res
+}
+
+// ReplayPeckTask replays files through a copy of a task in background
+func (p *Pecker) ReplayPeckTask(config *ReplayConfig) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	task := p.getPeckTask(config.Name)
+	if task == nil {
+		return fmt.Errorf("Task not exist, Name: %s", config.Name)
+	}
+	if _, ok := p.replays[config.Name]; ok {
+		return errors.New("Task is replaying")
+	}
+	replayer, err := NewReplayer(config, &task.Config)
+	if err != nil {
+		return err
+	}
+	p.replays[config.Name] = replayer
+	go func() {
+		replayer.Run(p.ctx)
+		p.mu.Lock()
+		delete(p.replays, config.Name)
+		p.mu.Unlock()
+	}()
+	return nil
+}
+
+func (p *Pecker) getPeckTask(name string) *PeckTask {
+	logPath, ok := p.nameToPath[name]
+	if !ok {
+		return nil
+	}
+	logTask, ok := p.logTasks[logPath]
+	if !ok {
+		return nil
+	}
+	return logTask.peckTasks[name]
+}
+
+// SetCapture starts or stops capturing samples of a task, samples are not
+// persisted
+func (p *Pecker) SetCapture(config *CaptureConfig) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	task := p.getPeckTask(config.Name)
+	if task == nil {
+		return fmt.Errorf("Task not exist, Name: %s", config.Name)
+	}
+	task.SetCapture(config.Size)
+	log.Infof("[Pecker] Set capture of %s to %d", config.Name, config.Size)
+	return nil
+}
+
+// SetDualWrite starts or stops the writes of a task to the secondary backend
+// of its sender, until the task restarts
+func (p *Pecker) SetDualWrite(config *DualWriteConfig) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	task := p.getPeckTask(config.Name)
+	if task == nil {
+		return fmt.Errorf("Task not exist, Name: %s", config.Name)
+	}
+	writer, ok := task.sender.(DualWriter)
+	if !ok {
+		return fmt.Errorf("Sender of %s can't dual-write", config.Name)
+	}
+	if err := writer.SetDualWrite(config.Enable); err != nil {
+		return err
+	}
+	log.Infof("[Pecker] Set dual-write of %s to %v", config.Name, config.Enable)
+	return nil
+}
+
+func (p *Pecker) GetCapture(name string) (*CaptureStat, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	task := p.getPeckTask(name)
+	if task == nil {
+		return nil, fmt.Errorf("Task not exist, Name: %s", name)
+	}
+	capture := task.Capture()
+	if capture == nil {
+		return nil, fmt.Errorf("Task not capturing, Name: %s", name)
+	}
+	stat := &CaptureStat{Name: name}
+	stat.Lines, stat.Events = capture.Samples()
+	return stat, nil
+}
+
+// TracePeckTask injects a marker line and reports how the task processed
+// it
+func (p *Pecker) TracePeckTask(config *TraceConfig) (*TraceReport, error) {
+	p.mu.Lock()
+	task := p.getPeckTask(config.Name)
+	p.mu.Unlock()
+	if task == nil {
+		return nil, fmt.Errorf("Task not exist, Name: %s", config.Name)
+	}
+	marker := config.Marker
+	if marker == "" {
+		marker = fmt.Sprintf("logpeck-trace-%d", time.Now().UnixNano())
+	}
+	timeout := time.Duration(config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	if config.WriteLog && (IsGlobPath(task.Config.LogPath) || task.Config.Directory.Enable) {
+		return nil, fmt.Errorf("WriteLog is not supported for the glob or directory LogPath %s", task.Config.LogPath)
+	}
+	tracer := NewTracer(marker)
+	task.Trace(tracer)
+	defer task.tracer.CompareAndSwap(tracer, nil)
+	if config.WriteLog {
+		f, err := os.OpenFile(task.Config.LogPath, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return nil, err
+		}
+		tracer.Step("injected", task.Config.LogPath)
+		_, err = f.WriteString(marker + "\n")
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		tracer.Step("injected", "api")
+		go task.Process(marker)
+	}
+	done := tracer.Wait(timeout)
+	log.Infof("[Pecker] Trace %s of %s, done %v", marker, config.Name, done)
+	return &TraceReport{
+		Name:   config.Name,
+		Marker: marker,
+		Done:   done,
+		Steps:  tracer.Steps(),
+	}, nil
+}
+
+func (p *Pecker) GetProfile(name string) (*ProfileStat, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	task := p.getPeckTask(name)
+	if task == nil {
+		return nil, fmt.Errorf("Task not exist, Name: %s", name)
+	}
+	if !task.profiler.IsEnable() {
+		return nil, fmt.Errorf("Profile not enabled, Name: %s", name)
+	}
+	return &ProfileStat{Name: name, Reports: task.Profile()}, nil
+}
+
 func (p *Pecker) StartPeckTask(config *PeckTaskConfig) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -170,10 +636,15 @@ func (p *Pecker) StartPeckTask(config *PeckTaskConfig) error {
 	}
 
 	log_task := p.logTasks[log_path]
+	// where the log is read up to, or resumes if it is stopped
+	to, hasTo := log_task.Offset()
 
-	if err := log_task.StartPeckTask(config); err != nil {
+	if err := log_task.StartPeckTask(p.ctx, config); err != nil {
 		return err
 	}
+	task := log_task.peckTasks[config.Name]
+	from := task.Stat.StopOffset
+	task.Stat.StopOffset = nil
 
 	{
 		// Try update peck task stat in boltdb
@@ -186,10 +657,20 @@ func (p *Pecker) StartPeckTask(config *PeckTaskConfig) error {
 			return errors.New("Task already started")
 		}
 		stat.Stop = false
+		stat.StopOffset = nil
 		err = db.SaveStat(stat)
 	}
 	if log_task.IsStop() {
-		log_task.Start()
+		if err := log_task.Start(p.ctx); err != nil {
+			return err
+		}
+	}
+	if from != nil && hasTo {
+		if start, end, ok := log_task.missedRange(*from, to); ok {
+			if err := task.CatchUp(start, end); err != nil {
+				log.Errorf("[Pecker] Catch up %s error, err[%s]", config.Name, err)
+			}
+		}
 	}
 	return nil
 }
@@ -197,6 +678,35 @@ func (p *Pecker) StartPeckTask(config *PeckTaskConfig) error {
 func (p *Pecker) StopPeckTask(config *PeckTaskConfig) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	return p.stopPeckTask(config)
+}
+
+// stopFailedTask stops task on behalf of its health monitor, unless it was
+// replaced or stopped meanwhile
+func (p *Pecker) stopFailedTask(task *PeckTask) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	logTask, ok := p.logTasks[p.nameToPath[task.Config.Name]]
+	if !ok || logTask.peckTasks[task.Config.Name] != task || task.IsStop() {
+		return
+	}
+	if err := p.stopPeckTask(&task.Config); err != nil {
+		log.Errorf("[Pecker] Stop failed task %s error, err[%s]", task.Config.Name, err)
+	}
+}
+
+// newPeckTask builds a task of the pecker, its health monitor stops it
+// through the pecker
+func (p *Pecker) newPeckTask(config *PeckTaskConfig, stat *PeckTaskStat) (*PeckTask, error) {
+	task, err := NewPeckTask(config, stat)
+	if err != nil {
+		return nil, err
+	}
+	task.onFailure = p.stopFailedTask
+	return task, nil
+}
+
+func (p *Pecker) stopPeckTask(config *PeckTaskConfig) error {
 	log.Infof("[Pecker]Try stop task, Name: %s, Exist: %v", config.Name, p.nameToPath)
 	log_path, ok := p.nameToPath[config.Name]
 	if !ok {
@@ -205,10 +715,25 @@ func (p *Pecker) StopPeckTask(config *PeckTaskConfig) error {
 	}
 
 	log_task := p.logTasks[log_path]
+	offset, hasOffset := log_task.Offset()
 
 	if err := log_task.StopPeckTask(config); err != nil {
 		return err
 	}
+	// a log whose tasks are all stopped is not read, it resumes where it
+	// stopped
+	if log_task.Idle() && !log_task.IsStop() {
+		log_task.Stop()
+		offset, hasOffset = log_task.Offset()
+		if err := db.SaveOffsets(log_task.Offsets()); err != nil {
+			log.Errorf("[Pecker] Save offset of %s error, err[%s]", log_path, err)
+		}
+	}
+	task := log_task.peckTasks[config.Name]
+	task.Stat.StopOffset = nil
+	if hasOffset {
+		task.Stat.StopOffset = &offset
+	}
 
 	{
 		// Try update peck task stat in boltdb
@@ -220,14 +745,61 @@ func (p *Pecker) StopPeckTask(config *PeckTaskConfig) error {
 			return errors.New("Task already stopped")
 		}
 		stat.Stop = true
+		stat.StopOffset = task.Stat.StopOffset
 		err = db.SaveStat(stat)
 	}
 
 	return nil
 }
 
+// ResetPeckTask makes a stopped task skip what was written to its log while
+// it was stopped, it reads from the current end of the log when started
+func (p *Pecker) ResetPeckTask(config *PeckTaskConfig) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	logPath, ok := p.nameToPath[config.Name]
+	if !ok {
+		return fmt.Errorf("Task not exist, Name: %s", config.Name)
+	}
+	logTask := p.logTasks[logPath]
+	task := logTask.peckTasks[config.Name]
+	if !task.IsStop() {
+		return errors.New("Task is running, stop it before Reset")
+	}
+	stat, err := p.db.GetStat(config.Name)
+	if err != nil {
+		return err
+	}
+	task.Stat.StopOffset = nil
+	stat.StopOffset = nil
+	if err := p.db.SaveStat(stat); err != nil {
+		return err
+	}
+	if logTask.IsStop() {
+		var offsets []LogOffset
+		for _, file := range logTask.logFiles() {
+			info, err := os.Stat(file)
+			if err != nil {
+				return err
+			}
+			offset := LogOffset{LogPath: file, Inode: fileInode(info), Offset: info.Size()}
+			logTask.SetOffset(&offset)
+			offsets = append(offsets, offset)
+		}
+		if err := p.db.SaveOffsets(offsets); err != nil {
+			return err
+		}
+	}
+	log.Infof("[Pecker] Reset %s to the end of %s", config.Name, logPath)
+	return nil
+}
+
+// TestPeckTask tails the log of config and returns how the next lines are
+// processed, nothing is sent: _Sent of a line holds the events it would
+// send, and a last result with _Flushed holds what the task would send when
+// stopped
 func TestPeckTask(config *PeckTaskConfig) ([]map[string]interface{}, error) {
-	task, err := NewPeckTask(config, nil)
+	task, run, err := NewDryRunPeckTask(config)
 	if err != nil {
 		return []map[string]interface{}{}, err
 	}
@@ -241,51 +813,70 @@ func TestPeckTask(config *PeckTaskConfig) ([]map[string]interface{}, error) {
 			Whence: 2,
 		},
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(config.Test.Timeout))
+	defer cancel()
 	ch := make(chan bool, 1)
 	resultsCh := make(chan map[string]interface{}, config.Test.TestNum)
 	id := 0
-	close := false
-	tail, err := tail.TailFile(config.LogPath, tailConf)
+	tailer, err := tail.TailFile(config.LogPath, tailConf)
 	if err != nil {
 		return []map[string]interface{}{}, err
 	}
+	defer stopTail(tailer)
 	go func() {
-		for content := range tail.Lines {
-			if close == true {
-				break
+		defer func() { ch <- true }()
+		for {
+			var content *tail.Line
+			var ok bool
+			select {
+			case content, ok = <-tailer.Lines:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
 			}
-			fields, err := task.ProcessTest(content.Text)
-			Log := make(map[string]interface{})
-			if err != nil {
-				if err.Error() == "Discarded" {
-					continue
+			res := task.ProcessTest(content.Text)
+			Log := map[string]interface{}{
+				"_Log":       content.Text,
+				"_ElapsedUs": res.Elapsed.Microseconds(),
+			}
+			if res.DroppedBy != "" {
+				Log["_DroppedBy"] = res.DroppedBy
+			} else if res.Err != nil {
+				Log["_Error"] = res.Err.Error()
+			} else if _, ok := res.Fields["_Log"]; !ok {
+				Log["_Fields"] = res.Fields
+				if res.Matched != nil || res.Missing != nil {
+					Log["_Matched"] = res.Matched
+					Log["_Missing"] = res.Missing
+					Log["_Remainder"] = res.Remainder
 				}
-				Log["_Error"] = err.Error()
-				Log["_Log"] = content.Text
-			} else if _, ok := fields["_Log"]; !ok {
-				Log["_Log"] = content.Text
-				Log["_Fields"] = fields
 			} else {
-				Log = fields
+				for k, v := range res.Fields {
+					Log[k] = v
+				}
+			}
+			task.Process(content.Text)
+			if sent := run.Take(); len(sent) > 0 {
+				Log["_Sent"] = sent
 			}
 			resultsCh <- Log
 			id++
 			if id >= config.Test.TestNum {
-				break
+				return
 			}
 		}
-		ch <- true
 	}()
 	var res []map[string]interface{}
-	select {
-	case <-ch:
-	case <-time.After(time.Second * time.Duration(config.Test.Timeout)):
-		close = true
-	}
+	<-ch
 	l := len(resultsCh)
 	for i := 0; i < l; i++ {
 		res = append(res, <-resultsCh)
 	}
+	if sent := run.Flush(task); len(sent) > 0 {
+		res = append(res, map[string]interface{}{"_Flushed": true, "_Sent": sent})
+	}
 	return res, nil
 }
 
@@ -295,13 +886,291 @@ func (p *Pecker) Start() error {
 	if !p.stop {
 		return errors.New("Pecker already started")
 	}
+	if p.ctx.Err() != nil {
+		p.ctx, p.cancel = context.WithCancel(context.Background())
+	}
 	for path, logTask := range p.logTasks {
+		if logTask.Idle() {
+			continue
+		}
 		log.Infof("[Pecker] Start LogTask %s", path)
-		logTask.Start()
+		if err := logTask.Start(p.ctx); err != nil {
+			log.Errorf("[Pecker] Start LogTask %s error, err[%s]", path, err)
+		}
+	}
+	go p.runSchedules(p.ctx)
+	go p.recordHistory(p.ctx)
+	go p.publishStats(p.ctx)
+	go p.persistStats(p.ctx)
+	if p.governor.IsEnable() {
+		go p.governor.Run(p.ctx)
 	}
+	p.stop = false
 	return nil
 }
 
+// runSchedules applies the task schedules until ctx is done
+func (p *Pecker) runSchedules(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.mu.Lock()
+			for _, logTask := range p.logTasks {
+				for _, task := range logTask.peckTasks {
+					task.ApplySchedule(now)
+				}
+			}
+			p.mu.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recordHistory samples the task stats into the history until ctx is done
+func (p *Pecker) recordHistory(ctx context.Context) {
+	ticker := time.NewTicker(statsHistoryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			stats, err := p.ListTaskStats(nil)
+			if err != nil {
+				log.Errorf("[Pecker] Record stats history error, %s", err)
+				continue
+			}
+			p.history.Record(stats, now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publishStats publishes the task stats to the stream subscribers until ctx
+// is done
+func (p *Pecker) publishStats(ctx context.Context) {
+	ticker := time.NewTicker(statsStreamInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !p.statsHub.Active() {
+				continue
+			}
+			stats, err := p.ListTaskStats(nil)
+			if err != nil {
+				log.Errorf("[Pecker] Publish stats error, %s", err)
+				continue
+			}
+			p.statsHub.Publish(stats)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SubscribeStats returns a channel receiving the task stats every
+// statsStreamInterval, until UnsubscribeStats
+func (p *Pecker) SubscribeStats() chan []PeckTaskStat {
+	return p.statsHub.Subscribe()
+}
+
+func (p *Pecker) UnsubscribeStats(ch chan []PeckTaskStat) {
+	p.statsHub.Unsubscribe(ch)
+}
+
+// persistStats writes the changed counters of tasks in batches until ctx is
+// done
+func (p *Pecker) persistStats(ctx context.Context) {
+	interval := time.Duration(atomic.LoadInt64(&p.flushInterval))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if next := time.Duration(atomic.LoadInt64(&p.flushInterval)); next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
+			p.mu.Lock()
+			p.markCounters()
+			offsets := p.collectOffsets()
+			var expired []string
+			for _, logTask := range p.logTasks {
+				expired = append(expired, logTask.TakeExpired()...)
+			}
+			p.mu.Unlock()
+			for _, path := range expired {
+				if err := p.db.RemoveOffset(path); err != nil {
+					log.Errorf("[Pecker] Remove offset of %s error, %s", path, err)
+				}
+			}
+			if err := p.persister.Flush(); err != nil {
+				log.Errorf("[Pecker] Persist stats error, %s", err)
+			}
+			if err := p.db.SaveOffsets(offsets); err != nil {
+				log.Errorf("[Pecker] Save offsets error, %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// collectOffsets returns how far each log is read
+func (p *Pecker) collectOffsets() []LogOffset {
+	var offsets []LogOffset
+	for _, logTask := range p.logTasks {
+		offsets = append(offsets, logTask.Offsets()...)
+	}
+	return offsets
+}
+
+// ListOffsets returns the read offset of the log of each task, with the
+// current size and mtime of the log
+func (p *Pecker) ListOffsets() []OffsetStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := []OffsetStat{}
+	for name, logPath := range p.nameToPath {
+		// a glob or directory LogPath has an offset per file
+		if p.logTasks[logPath].multiFile() {
+			for _, offset := range p.logTasks[logPath].Offsets() {
+				res = append(res, newOffsetStat(name, offset.LogPath, &offset))
+			}
+			continue
+		}
+		if offset, ok := p.logTasks[logPath].Offset(); ok {
+			res = append(res, newOffsetStat(name, logPath, &offset))
+		} else {
+			res = append(res, newOffsetStat(name, logPath, nil))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Name != res[j].Name {
+			return res[i].Name < res[j].Name
+		}
+		return res[i].LogPath < res[j].LogPath
+	})
+	return res
+}
+
+// newOffsetStat returns the offset of the file path of task name, with the
+// current size and mtime of the file
+func newOffsetStat(name, path string, offset *LogOffset) OffsetStat {
+	stat := OffsetStat{Name: name, LogPath: path, Offset: -1}
+	if offset != nil {
+		stat.Inode, stat.Offset = offset.Inode, offset.Offset
+	}
+	if info, err := os.Stat(path); err == nil {
+		stat.FileInode = fileInode(info)
+		stat.Size = info.Size()
+		stat.ModTime = info.ModTime().Unix()
+	}
+	return stat
+}
+
+// SetOffset moves the read offset of the log of a task, all tasks of the log
+// continue from there
+func (p *Pecker) SetOffset(config *OffsetConfig) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	logPath, ok := p.nameToPath[config.Name]
+	if !ok {
+		return fmt.Errorf("Task not exist, Name: %s", config.Name)
+	}
+	if p.logTasks[logPath].multiFile() {
+		return errors.New("Offset of a glob or directory LogPath can not be set")
+	}
+	info, err := os.Stat(logPath)
+	if err != nil {
+		return err
+	}
+	if config.Offset < 0 || config.Offset > info.Size() {
+		return fmt.Errorf("Offset %d out of file size %d", config.Offset, info.Size())
+	}
+	logTask := p.logTasks[logPath]
+	running := !logTask.IsStop()
+	if running {
+		logTask.Stop()
+	}
+	offset := &LogOffset{LogPath: logPath, Inode: fileInode(info), Offset: config.Offset}
+	logTask.SetOffset(offset)
+	if err := p.db.SaveOffsets([]LogOffset{*offset}); err != nil {
+		return err
+	}
+	log.Infof("[Pecker] Set offset of %s to %d", logPath, config.Offset)
+	if running {
+		return logTask.Start(p.ctx)
+	}
+	return nil
+}
+
+// markCounters hands the changed counters of all tasks to the persister
+func (p *Pecker) markCounters() {
+	for _, logTask := range p.logTasks {
+		for _, task := range logTask.peckTasks {
+			if counters, ok := task.takeCounters(); ok {
+				p.persister.Mark(counters)
+			}
+		}
+	}
+}
+
+// GetStatsHistory returns the recent stats samples of task name, or of all
+// tasks if name is empty
+func (p *Pecker) GetStatsHistory(name string, since int64) map[string][]StatsSample {
+	return p.history.Get(name, since)
+}
+
+// Stop cancels all tasks, in-flight sends and tail reads return promptly
+func (p *Pecker) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop {
+		return errors.New("Pecker already stopped")
+	}
+	p.cancel()
+	for path, logTask := range p.logTasks {
+		log.Infof("[Pecker] Stop LogTask %s", path)
+		if !logTask.IsStop() {
+			logTask.Stop()
+		}
+		for _, task := range logTask.peckTasks {
+			if !task.IsStop() {
+				task.Stop()
+			}
+		}
+	}
+	p.markCounters()
+	if err := p.persister.Flush(); err != nil {
+		log.Errorf("[Pecker] Persist stats error, %s", err)
+	}
+	if err := p.db.SaveOffsets(p.collectOffsets()); err != nil {
+		log.Errorf("[Pecker] Save offsets error, %s", err)
+	}
+	p.stop = true
+	return nil
+}
+
+// flushCounters saves the changed counters, so a snapshot is up to date
+func (p *Pecker) flushCounters() {
+	p.mu.Lock()
+	p.markCounters()
+	p.mu.Unlock()
+	if err := p.persister.Flush(); err != nil {
+		log.Errorf("[Pecker] Persist stats error, %s", err)
+	}
+}
+
+// WriteSnapshot streams a consistent copy of the database to w
+func (p *Pecker) WriteSnapshot(w io.Writer) (int64, error) {
+	p.flushCounters()
+	return p.db.WriteSnapshot(w)
+}
+
 func (p *Pecker) GetStat() *PeckerStat {
 	p.mu.Lock()
 	defer p.mu.Unlock()
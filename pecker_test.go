@@ -0,0 +1,274 @@
+package logpeck
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeckerStopKeepsOffset(t *testing.T) {
+	opened := db
+	defer func() { db = opened }()
+	dir := t.TempDir()
+	if err := OpenDB(filepath.Join(dir, "pecker.db")); err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	pecker, err := NewPecker(db)
+	if err != nil {
+		panic(err)
+	}
+	logName := filepath.Join(dir, "pecker.log")
+	write := func(line string) {
+		f, err := os.OpenFile(logName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			panic(err)
+		}
+		f.WriteString(line + "\n")
+		f.Close()
+	}
+	write("a")
+
+	senders := map[string]*chanSender{}
+	var configs []*PeckTaskConfig
+	for _, name := range []string{"First", "Second"} {
+		config := &PeckTaskConfig{}
+		err := config.Unmarshal([]byte(`{
+			"Name": "` + name + `",
+			"LogPath": "` + logName + `",
+			"Extractor": {"Name": "text", "Config": {"Fields": []}},
+			"Sender": {"Name": "prometheus"}
+		}`))
+		if err != nil {
+			panic(err)
+		}
+		if err := pecker.AddPeckTask(config, nil); err != nil {
+			panic(err)
+		}
+		senders[name] = &chanSender{lines: make(chan interface{}, 10)}
+		pecker.getPeckTask(name).sender = senders[name]
+		configs = append(configs, config)
+	}
+	first, second := configs[0], configs[1]
+	logTask := pecker.logTasks[logName]
+	defer pecker.Stop()
+
+	tailing := func() {
+		for i := 0; i < 300; i++ {
+			logTask.mu.Lock()
+			tailer := logTask.tailer
+			logTask.mu.Unlock()
+			if tailer != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		panic("log not tailed")
+	}
+	expect := func(name string, lines ...string) {
+		for _, line := range lines {
+			select {
+			case got := <-senders[name].lines:
+				if got != line {
+					panic(name + " got " + got.(string) + ", expected " + line)
+				}
+			case <-time.After(3 * time.Second):
+				panic(name + " did not get " + line)
+			}
+		}
+	}
+
+	// a stopped log resumes where it stopped
+	if err := pecker.StartPeckTask(first); err != nil {
+		panic(err)
+	}
+	tailing()
+	write("b")
+	expect("First", "b")
+	if err := pecker.StopPeckTask(first); err != nil {
+		panic(err)
+	}
+	if !logTask.IsStop() {
+		panic("log of stopped tasks still read")
+	}
+	write("c")
+	if err := pecker.StartPeckTask(first); err != nil {
+		panic(err)
+	}
+	expect("First", "c")
+
+	// a task stopped while the log is read catches up
+	if err := pecker.StartPeckTask(second); err != nil {
+		panic(err)
+	}
+	if err := pecker.StopPeckTask(first); err != nil {
+		panic(err)
+	}
+	write("d")
+	expect("Second", "d")
+	if err := pecker.StartPeckTask(first); err != nil {
+		panic(err)
+	}
+	expect("First", "d")
+
+	// a reset task skips what was written while stopped
+	if err := pecker.ResetPeckTask(first); err == nil {
+		panic("running task reset")
+	}
+	pecker.StopPeckTask(first)
+	pecker.StopPeckTask(second)
+	write("e")
+	if err := pecker.ResetPeckTask(first); err != nil {
+		panic(err)
+	}
+	if err := pecker.StartPeckTask(first); err != nil {
+		panic(err)
+	}
+	tailing()
+	write("f")
+	expect("First", "f")
+	if err := pecker.StartPeckTask(second); err != nil {
+		panic(err)
+	}
+	expect("Second", "e", "f")
+	if stat, _ := db.GetStat("Second"); stat.StopOffset != nil {
+		panic(stat.StopOffset)
+	}
+}
+
+func TestPeckerStopFailedTask(t *testing.T) {
+	opened := db
+	defer func() { db = opened }()
+	dir := t.TempDir()
+	if err := OpenDB(filepath.Join(dir, "pecker.db")); err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	pecker, err := NewPecker(db)
+	if err != nil {
+		panic(err)
+	}
+	defer pecker.Stop()
+	logName := filepath.Join(dir, "pecker.log")
+	ioutil.WriteFile(logName, []byte("a\n"), 0644)
+	config := &PeckTaskConfig{}
+	err = config.Unmarshal([]byte(`{
+		"Name": "Failing",
+		"LogPath": "` + logName + `",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	if err := pecker.AddPeckTask(config, nil); err != nil {
+		panic(err)
+	}
+	if err := pecker.StartPeckTask(config); err != nil {
+		panic(err)
+	}
+	task := pecker.getPeckTask("Failing")
+	task.stopOnFailure()
+	if !task.IsStop() || !pecker.logTasks[logName].IsStop() {
+		panic("failed task or its idle log not stopped")
+	}
+	if stat, err := db.GetStat("Failing"); err != nil || !stat.Stop {
+		panic(stat)
+	}
+}
+
+func TestPeckerTraceWriteLogGlob(t *testing.T) {
+	opened := db
+	defer func() { db = opened }()
+	dir := t.TempDir()
+	if err := OpenDB(filepath.Join(dir, "pecker.db")); err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	pecker, err := NewPecker(db)
+	if err != nil {
+		panic(err)
+	}
+	defer pecker.Stop()
+	config := &PeckTaskConfig{}
+	err = config.Unmarshal([]byte(`{
+		"Name": "GlobTrace",
+		"LogPath": "` + filepath.Join(dir, "*.log") + `",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	if err := pecker.AddPeckTask(config, nil); err != nil {
+		panic(err)
+	}
+	if _, err := pecker.TracePeckTask(&TraceConfig{Name: "GlobTrace", WriteLog: true, Timeout: 1}); err == nil || !strings.Contains(err.Error(), "glob") {
+		panic(err)
+	}
+}
+
+func TestPeckerRenameKeepsCounters(t *testing.T) {
+	opened := db
+	defer func() { db = opened }()
+	dir := t.TempDir()
+	if err := OpenDB(filepath.Join(dir, "pecker.db")); err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	pecker, err := NewPecker(db)
+	if err != nil {
+		panic(err)
+	}
+	defer pecker.Stop()
+	logName := filepath.Join(dir, "pecker.log")
+	ioutil.WriteFile(logName, nil, 0644)
+	config := &PeckTaskConfig{}
+	err = config.Unmarshal([]byte(`{
+		"Name": "Old",
+		"LogPath": "` + logName + `",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	if err := pecker.AddPeckTask(config, nil); err != nil {
+		panic(err)
+	}
+	sender := &chanSender{lines: make(chan interface{}, 10)}
+	pecker.getPeckTask("Old").sender = sender
+	if err := pecker.StartPeckTask(config); err != nil {
+		panic(err)
+	}
+	for i := 0; ; i++ {
+		f, _ := os.OpenFile(logName, os.O_WRONLY|os.O_APPEND, 0644)
+		f.WriteString("a\n")
+		f.Close()
+		select {
+		case <-sender.lines:
+		case <-time.After(100 * time.Millisecond):
+			if i == 30 {
+				panic("log not read")
+			}
+			continue
+		}
+		break
+	}
+	lines := pecker.getPeckTask("Old").lines.Total()
+
+	// the counters are not saved yet, the renamed stat has them anyway
+	if err := pecker.RenamePeckTask(&RenameConfig{Name: "Old", NewName: "New"}); err != nil {
+		panic(err)
+	}
+	stat, err := db.GetStat("New")
+	if err != nil || stat.LinesTotal != lines || stat.Stop {
+		panic(stat)
+	}
+	if offset, err := db.GetOffset(logName); err != nil || offset.Offset == 0 {
+		panic(offset)
+	}
+}
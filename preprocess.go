@@ -0,0 +1,115 @@
+package logpeck
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// PreprocessConfig cleans lines before filtering and extraction. StripANSI
+// removes ANSI escape sequences such as colors, StripControl removes control
+// characters except tab
+type PreprocessConfig struct {
+	StripANSI    bool `json:"StripANSI"`
+	StripControl bool `json:"StripControl"`
+}
+
+type Preprocessor struct {
+	config PreprocessConfig
+}
+
+func NewPreprocessor(config *PreprocessConfig) *Preprocessor {
+	return &Preprocessor{config: *config}
+}
+
+func (p *Preprocessor) IsEnable() bool {
+	return p.config.StripANSI || p.config.StripControl
+}
+
+// Clean returns content without the stripped sequences and characters
+func (p *Preprocessor) Clean(content string) string {
+	if p.config.StripANSI {
+		content = StripANSI(content)
+	}
+	if p.config.StripControl {
+		content = StripControl(content)
+	}
+	return content
+}
+
+// ansiLength returns the length of the escape sequence at the start of s,
+// which starts with ESC
+func ansiLength(s string) int {
+	if len(s) < 2 {
+		return 1
+	}
+	switch s[1] {
+	case '[':
+		// CSI: parameter and intermediate bytes up to a final byte
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+			if s[i] < 0x20 || s[i] > 0x3f {
+				return i
+			}
+		}
+		return len(s)
+	case ']', 'P', '_', '^':
+		// OSC and other strings end with BEL or ST (ESC \)
+		for i := 2; i < len(s); i++ {
+			if s[i] == 0x07 {
+				return i + 1
+			}
+			if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return len(s)
+	}
+	if s[1] >= 0x20 && s[1] <= 0x7e {
+		return 2
+	}
+	return 1
+}
+
+// StripANSI removes ANSI escape sequences from content
+func StripANSI(content string) string {
+	if strings.IndexByte(content, 0x1b) < 0 {
+		return content
+	}
+	var b strings.Builder
+	b.Grow(len(content))
+	for {
+		i := strings.IndexByte(content, 0x1b)
+		if i < 0 {
+			b.WriteString(content)
+			return b.String()
+		}
+		b.WriteString(content[:i])
+		content = content[i+ansiLength(content[i:]):]
+	}
+}
+
+func isControl(r rune) bool {
+	return (r < 0x20 && r != '\t') || (r >= 0x7f && r <= 0x9f)
+}
+
+// StripControl removes control characters except tab from content
+func StripControl(content string) string {
+	if strings.IndexFunc(content, isControl) < 0 {
+		return content
+	}
+	var b strings.Builder
+	b.Grow(len(content))
+	for i := 0; i < len(content); {
+		r, n := utf8.DecodeRuneInString(content[i:])
+		if r == utf8.RuneError && n == 1 {
+			// keep invalid bytes, the Charset section handles them
+			b.WriteByte(content[i])
+		} else if !isControl(r) {
+			b.WriteRune(r)
+		}
+		i += n
+	}
+	return b.String()
+}
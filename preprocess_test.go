@@ -0,0 +1,28 @@
+package logpeck
+
+import (
+	"testing"
+)
+
+func TestPreprocessor(*testing.T) {
+	if NewPreprocessor(&PreprocessConfig{}).IsEnable() {
+		panic("enabled without config")
+	}
+	p := NewPreprocessor(&PreprocessConfig{StripANSI: true, StripControl: true})
+	cases := map[string]string{
+		"\x1b[31mERROR\x1b[0m disk full":        "ERROR disk full",
+		"\x1b[1;38;5;208mwarn\x1b[m\tnext":      "warn\tnext",
+		"\x1b]0;title\x07line\x1b]8;;x\x1b\\ok": "lineok",
+		"bell\x07 back\x08 del\x7f c1\u0085":    "bell back del c1",
+		"plain 中文 \xff":                         "plain 中文 \xff",
+		"dangling \x1b":                         "dangling ",
+	}
+	for in, want := range cases {
+		if got := p.Clean(in); got != want {
+			panic(got)
+		}
+	}
+	if got := NewPreprocessor(&PreprocessConfig{StripANSI: true}).Clean("\x1b[2Ka\x01"); got != "a\x01" {
+		panic(got)
+	}
+}
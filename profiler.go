@@ -0,0 +1,204 @@
+package logpeck
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProfileConfig profiles the extracted fields of 1 in SampleRate events
+// over windows of Window seconds, at most MaxValues distinct values of a
+// field are counted
+type ProfileConfig struct {
+	Enable     bool  `json:"Enable"`
+	SampleRate int   `json:"SampleRate"`
+	Window     int64 `json:"Window"`
+	MaxValues  int   `json:"MaxValues"`
+}
+
+// FieldProfile describes a field over a window. NullRate is the share of
+// sampled events where the field is missing, null or empty, Types counts
+// the other values by type: "string", "int", "float", "bool", "object" and
+// "array". Cardinality stops at MaxValues with CardinalityCapped set
+type FieldProfile struct {
+	Field             string
+	Count             int64
+	NullRate          float64
+	Cardinality       int
+	CardinalityCapped bool
+	Types             map[string]int64
+}
+
+// ProfileReport is the field profiles of a task over [Start, End)
+type ProfileReport struct {
+	Start  int64
+	End    int64
+	Events int64
+	Fields []FieldProfile
+}
+
+type fieldStats struct {
+	count  int64
+	values map[string]bool
+	capped bool
+	types  map[string]int64
+}
+
+// Profiler samples events and keeps the profile of the current and of the
+// previous window
+type Profiler struct {
+	config ProfileConfig
+
+	mu       sync.Mutex
+	seen     int64
+	start    int64
+	events   int64
+	fields   map[string]*fieldStats
+	previous *ProfileReport
+}
+
+func NewProfiler(config *ProfileConfig) *Profiler {
+	profiler := &Profiler{
+		config: *config,
+		fields: make(map[string]*fieldStats),
+	}
+	if profiler.config.SampleRate <= 0 {
+		profiler.config.SampleRate = 1
+	}
+	if profiler.config.Window <= 0 {
+		profiler.config.Window = 300
+	}
+	if profiler.config.MaxValues <= 0 {
+		profiler.config.MaxValues = 1000
+	}
+	return profiler
+}
+
+func (p *Profiler) IsEnable() bool {
+	return p.config.Enable
+}
+
+// ValueType returns the type of an extracted value, strings holding a
+// number or a boolean are reported as such
+func ValueType(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return "int"
+		}
+		if _, err := strconv.ParseFloat(v, 64); err == nil {
+			return "float"
+		}
+		if v == "true" || v == "false" {
+			return "bool"
+		}
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "int"
+		}
+		return "float"
+	case int, int32, int64, uint, uint32, uint64:
+		return "int"
+	case float32, float64:
+		return "float"
+	case bool:
+		return "bool"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return "string"
+}
+
+func isNullValue(value interface{}) bool {
+	return value == nil || value == ""
+}
+
+func (p *Profiler) Record(fields map[string]interface{}, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seen++
+	if (p.seen-1)%int64(p.config.SampleRate) != 0 {
+		return
+	}
+	if p.start == 0 {
+		p.start = now.Unix()
+	} else if now.Unix()-p.start >= p.config.Window {
+		p.previous = p.report(now.Unix())
+		p.start = now.Unix()
+		p.events = 0
+		p.fields = make(map[string]*fieldStats)
+	}
+	p.events++
+	for k, v := range fields {
+		stats, ok := p.fields[k]
+		if !ok {
+			stats = &fieldStats{values: make(map[string]bool), types: make(map[string]int64)}
+			p.fields[k] = stats
+		}
+		if isNullValue(v) {
+			continue
+		}
+		stats.count++
+		stats.types[ValueType(v)]++
+		if stats.capped {
+			continue
+		}
+		value, ok := v.(string)
+		if !ok {
+			raw, _ := json.Marshal(v)
+			value = string(raw)
+		}
+		if !stats.values[value] {
+			if len(stats.values) >= p.config.MaxValues {
+				stats.capped = true
+				continue
+			}
+			stats.values[value] = true
+		}
+	}
+}
+
+func (p *Profiler) report(end int64) *ProfileReport {
+	report := &ProfileReport{
+		Start:  p.start,
+		End:    end,
+		Events: p.events,
+		Fields: []FieldProfile{},
+	}
+	for field, stats := range p.fields {
+		types := make(map[string]int64, len(stats.types))
+		for t, n := range stats.types {
+			types[t] = n
+		}
+		report.Fields = append(report.Fields, FieldProfile{
+			Field:             field,
+			Count:             stats.count,
+			NullRate:          1 - float64(stats.count)/float64(p.events),
+			Cardinality:       len(stats.values),
+			CardinalityCapped: stats.capped,
+			Types:             types,
+		})
+	}
+	sort.Slice(report.Fields, func(i, j int) bool { return report.Fields[i].Field < report.Fields[j].Field })
+	return report
+}
+
+// Reports returns the profile of the previous window if any, followed by
+// the current window so far
+func (p *Profiler) Reports(now time.Time) []ProfileReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var reports []ProfileReport
+	if p.previous != nil {
+		reports = append(reports, *p.previous)
+	}
+	if p.events > 0 {
+		reports = append(reports, *p.report(now.Unix()))
+	}
+	return reports
+}
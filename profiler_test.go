@@ -0,0 +1,62 @@
+package logpeck
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestValueType(*testing.T) {
+	cases := map[string]interface{}{
+		"int":    "42",
+		"float":  "4.2",
+		"bool":   "true",
+		"string": "GET",
+		"object": map[string]interface{}{},
+		"array":  []interface{}{},
+	}
+	for expected, value := range cases {
+		if t := ValueType(value); t != expected {
+			panic(expected + " " + t)
+		}
+	}
+	if ValueType(json.Number("1.5")) != "float" || ValueType(json.Number("1")) != "int" {
+		panic("json.Number")
+	}
+}
+
+func TestProfiler(*testing.T) {
+	profiler := NewProfiler(&ProfileConfig{Enable: true, SampleRate: 2, Window: 60, MaxValues: 2})
+	now := time.Unix(1000, 0)
+	events := []map[string]interface{}{
+		{"code": "200", "user": "a"},
+		{"code": "skipped"},
+		{"code": "500", "user": ""},
+		{"code": "skipped"},
+		{"code": "oops", "user": "b"},
+		{"code": "skipped"},
+		{"code": "404"},
+	}
+	for _, event := range events {
+		profiler.Record(event, now)
+	}
+	reports := profiler.Reports(now.Add(10 * time.Second))
+	if len(reports) != 1 || reports[0].Events != 4 || len(reports[0].Fields) != 2 {
+		panic(reports)
+	}
+	code, user := reports[0].Fields[0], reports[0].Fields[1]
+	if code.Field != "code" || code.Count != 4 || code.Cardinality != 2 || !code.CardinalityCapped ||
+		code.Types["int"] != 3 || code.Types["string"] != 1 || code.NullRate != 0 {
+		panic(code)
+	}
+	if user.Field != "user" || user.Count != 2 || user.NullRate != 0.5 {
+		panic(user)
+	}
+
+	profiler.Record(map[string]interface{}{}, now.Add(time.Minute))
+	profiler.Record(map[string]interface{}{"code": "200"}, now.Add(time.Minute))
+	reports = profiler.Reports(now.Add(time.Minute))
+	if len(reports) != 2 || reports[0].End != 1060 || reports[1].Events != 1 {
+		panic(reports)
+	}
+}
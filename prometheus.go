@@ -0,0 +1,128 @@
+package logpeck
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const prometheusMetricName string = "logpeck_aggregation"
+
+// splitSeries splits a series name like "measurement,tag=value" into the
+// measurement and its tags, escaped "\," and "\=" are kept in values
+func splitSeries(series string) (string, map[string]string) {
+	var parts []string
+	var part []byte
+	for i := 0; i < len(series); i++ {
+		if series[i] == '\\' && i+1 < len(series) {
+			part = append(part, series[i+1])
+			i++
+			continue
+		}
+		if series[i] == ',' {
+			parts = append(parts, string(part))
+			part = part[:0]
+			continue
+		}
+		part = append(part, series[i])
+	}
+	parts = append(parts, string(part))
+
+	tags := make(map[string]string)
+	for _, tag := range parts[1:] {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) == 2 {
+			tags[kv[0]] = kv[1]
+		}
+	}
+	return parts[0], tags
+}
+
+func prometheusLabelName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= '0' && c <= '9' && i > 0) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+func prometheusLabelValue(value string) string {
+	return strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`).Replace(value)
+}
+
+// WriteSenderMetrics writes the backend stats of the senders of each task
+// in the prometheus text exposition format
+func WriteSenderMetrics(w io.Writer, backends map[string]map[string]BackendStat) {
+	var responses, requests, bytes, latency []string
+	for task, stats := range backends {
+		for backend, stat := range stats {
+			labels := fmt.Sprintf(`task="%s",backend="%s"`, prometheusLabelValue(task), prometheusLabelValue(backend))
+			for code, n := range map[string]int64{
+				"2xx": stat.Status2xx, "4xx": stat.Status4xx, "429": stat.Status429,
+				"5xx": stat.Status5xx, "error": stat.Errors,
+			} {
+				responses = append(responses, fmt.Sprintf("logpeck_sender_responses_total{%s,code=\"%s\"} %d\n", labels, code, n))
+			}
+			requests = append(requests, fmt.Sprintf("logpeck_sender_requests_total{%s} %d\n", labels, stat.Requests))
+			bytes = append(bytes, fmt.Sprintf("logpeck_sender_bytes_total{%s} %d\n", labels, stat.BytesSent))
+			latency = append(latency, fmt.Sprintf("logpeck_sender_latency_avg_seconds{%s} %g\n", labels, stat.AvgLatencyMs/1000))
+		}
+	}
+	for _, metric := range []struct {
+		name, kind, help string
+		lines            []string
+	}{
+		{"logpeck_sender_responses_total", "counter", "Sender responses by status class.", responses},
+		{"logpeck_sender_requests_total", "counter", "Sender requests.", requests},
+		{"logpeck_sender_bytes_total", "counter", "Sender request body bytes.", bytes},
+		{"logpeck_sender_latency_avg_seconds", "gauge", "Average sender request latency.", latency},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", metric.name, metric.kind)
+		sort.Strings(metric.lines)
+		for _, line := range metric.lines {
+			io.WriteString(w, line)
+		}
+	}
+}
+
+// WritePrometheusMetrics writes the aggregation results of each task in the
+// prometheus text exposition format
+func WritePrometheusMetrics(w io.Writer, aggregations map[string]map[string]interface{}) {
+	fmt.Fprintf(w, "# HELP %s Latest aggregation results of logpeck tasks.\n", prometheusMetricName)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", prometheusMetricName)
+	var lines []string
+	for task, fields := range aggregations {
+		results, ok := ParseAggregationResult(fields)
+		if !ok {
+			continue
+		}
+		for series, values := range results.Series {
+			measurement, tags := splitSeries(series)
+			labels := fmt.Sprintf(`task="%s",measurement="%s"`, prometheusLabelValue(task), prometheusLabelValue(measurement))
+			tagNames := make([]string, 0, len(tags))
+			for k := range tags {
+				tagNames = append(tagNames, k)
+			}
+			sort.Strings(tagNames)
+			for _, k := range tagNames {
+				name := prometheusLabelName(k)
+				if name == "task" || name == "measurement" || name == "aggregation" {
+					name = "tag_" + name
+				}
+				labels += fmt.Sprintf(`,%s="%s"`, name, prometheusLabelValue(tags[k]))
+			}
+			for aggregation, value := range values {
+				lines = append(lines, fmt.Sprintf("%s{%s,aggregation=\"%s\"} %g\n",
+					prometheusMetricName, labels, prometheusLabelValue(aggregation), value))
+			}
+		}
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		io.WriteString(w, line)
+	}
+}
@@ -6,15 +6,52 @@ import (
 	sjson "github.com/bitly/go-simplejson"
 )
 
+const (
+	DeliveryAtMostOnce  = "at-most-once"
+	DeliveryAtLeastOnce = "at-least-once"
+)
+
 type PeckTaskConfig struct {
 	Name       string
 	LogPath    string
+	Labels     map[string]string
 	Extractor  ExtractorConfig
 	Sender     SenderConfig
 	Aggregator AggregatorConfig
+	Delivery   string
+	// SequenceField is set on events to the position of their line
+	SequenceField string
 
-	Keywords string
-	Test     TestModule
+	Keywords   string
+	Fields     []TaskField
+	Charset    CharsetConfig
+	Preprocess PreprocessConfig
+	Output     FieldSelectConfig
+	Truncate   TruncateConfig
+	Dedup      DedupConfig
+	Correlate  CorrelateConfig
+	Alert      AlertConfig
+	Anomaly    AnomalyConfig
+	Backfill   BackfillConfig
+	Health     HealthConfig
+	Schedule   ScheduleConfig
+	Profile    ProfileConfig
+	Shard      ShardConfig
+	Read       ReadConfig
+	Schema     SchemaConfig
+	OnStop     StopConfig
+	Merge      MergeConfig
+	Directory  DirectoryConfig
+	PathFields PathFieldsConfig
+	Test       TestModule
+}
+
+// TaskField is a field set on every event of a task, to Value, or to
+// Default if the event has no such field
+type TaskField struct {
+	Name    string      `json:"Name"`
+	Value   interface{} `json:"Value"`
+	Default interface{} `json:"Default"`
 }
 
 type PeckField struct {
@@ -39,6 +76,55 @@ type PeckTaskStat struct {
 	LinesTotal  int64
 	BytesTotal  int64
 	Stop        bool
+	Pending     bool
+	Sleeping    bool
+
+	TruncatedTotal int64
+	TimeoutTotal   int64
+	DroppedTotal   int64
+	Breakers       map[string]string
+
+	BackfillDone    bool
+	BackfillPercent float64
+	BackfillEta     int64
+
+	Failed     bool
+	Error      string
+	PanicTotal int64
+
+	Degraded     bool
+	FailingSince int64
+
+	LagBytes  int64
+	Throttled bool
+
+	SchemaDrift []string
+
+	Backends map[string]BackendStat
+
+	DualWrite *DualWriteStat `json:",omitempty"`
+
+	// StopOffset is how far the log was read when the task was stopped,
+	// the task reads the lines it missed when started again
+	StopOffset *LogOffset `json:",omitempty"`
+
+	// Files are the stats of the files of a glob or directory LogPath
+	Files map[string]FileStat `json:",omitempty"`
+}
+
+// DualWriteStat is the failure accounting of the secondary backend of a
+// dual-writing sender, kept apart from the one of the task
+type DualWriteStat struct {
+	Enable       bool
+	DroppedTotal int64
+	TimeoutTotal int64
+	FailingSince int64
+}
+
+// DualWriteConfig starts or stops the dual-write of task Name
+type DualWriteConfig struct {
+	Name   string `json:"Name"`
+	Enable bool   `json:"Enable"`
 }
 
 type Stat struct {
@@ -66,6 +152,70 @@ type TestModule struct {
 	Timeout int
 }
 
+// RenameConfig renames task Name to NewName, keeping its stat
+type RenameConfig struct {
+	Name    string `json:"Name"`
+	NewName string `json:"NewName"`
+}
+
+// OffsetStat is the read offset of the log of a task. Inode and Offset are
+// where the log is read, FileInode, Size and ModTime describe the current
+// file, a different FileInode means the log was rotated
+type OffsetStat struct {
+	Name      string
+	LogPath   string
+	Inode     uint64
+	Offset    int64
+	FileInode uint64
+	Size      int64
+	ModTime   int64
+}
+
+// OffsetConfig moves the read offset of the log of task Name
+type OffsetConfig struct {
+	Name   string `json:"Name"`
+	Offset int64  `json:"Offset"`
+}
+
+// CaptureConfig captures the last Size raw lines and events of task Name,
+// a Size of 0 stops capturing
+type CaptureConfig struct {
+	Name string `json:"Name"`
+	Size int    `json:"Size"`
+}
+
+// CaptureStat is the samples captured of a task
+type CaptureStat struct {
+	Name   string
+	Lines  []CapturedLine
+	Events []CapturedEvent
+}
+
+// TraceConfig traces a marker line through task Name. The line is appended
+// to the log file with WriteLog, otherwise it is given to the task directly.
+// Marker defaults to a unique string, Timeout (seconds) to 10
+type TraceConfig struct {
+	Name     string `json:"Name"`
+	Marker   string `json:"Marker"`
+	WriteLog bool   `json:"WriteLog"`
+	Timeout  int    `json:"Timeout"`
+}
+
+// TraceReport is the stages the marker line went through, Done is false if
+// the line was not processed within the timeout
+type TraceReport struct {
+	Name   string
+	Marker string
+	Done   bool
+	Steps  []TraceStep
+}
+
+// ProfileStat is the field profiles of task Name
+type ProfileStat struct {
+	Name    string
+	Reports []ProfileReport
+}
+
 func GetString(j *sjson.Json, key string, required bool) (string, error) {
 	valJson := j.Get(key)
 
@@ -100,7 +250,25 @@ func GetMarshalString(j *sjson.Json, name string) (string, bool) {
 	return string(jbyte), true
 }
 
+// GetSection unmarshal a json object field into v, missing field is ignored
+func GetSection(j *sjson.Json, key string, v interface{}) error {
+	sJson, ok := j.CheckGet(key)
+	if !ok || sJson.Interface() == nil {
+		return nil
+	}
+	jbyte, err := sJson.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jbyte, v)
+}
+
 func (p *PeckTaskConfig) Unmarshal(jsonStr []byte) (e error) {
+	if !isJSONObject(jsonStr) {
+		if jsonStr, e = YAMLToJSON(jsonStr); e != nil {
+			return e
+		}
+	}
 	j, e := sjson.NewJson(jsonStr)
 	if e != nil {
 		return e
@@ -118,6 +286,12 @@ func (p *PeckTaskConfig) Unmarshal(jsonStr []byte) (e error) {
 		return e
 	}
 
+	// Parse "Labels", optional
+	e = GetSection(j, "Labels", &p.Labels)
+	if e != nil {
+		return e
+	}
+
 	// Parse "ExtractorConfig", optional
 	eConfStr, ok := GetMarshalString(j, "Extractor")
 	if ok {
@@ -150,6 +324,149 @@ func (p *PeckTaskConfig) Unmarshal(jsonStr []byte) (e error) {
 		return e
 	}
 
+	// Parse "Delivery", optional
+	p.Delivery, e = GetString(j, "Delivery", false)
+	if e != nil {
+		return e
+	}
+	switch p.Delivery {
+	case "", DeliveryAtMostOnce, DeliveryAtLeastOnce:
+	default:
+		return errors.New("Delivery error: " + p.Delivery)
+	}
+
+	// Parse "SequenceField", optional
+	p.SequenceField, e = GetString(j, "SequenceField", false)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Charset", optional
+	e = GetSection(j, "Charset", &p.Charset)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Preprocess", optional
+	e = GetSection(j, "Preprocess", &p.Preprocess)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Output", optional
+	e = GetSection(j, "Output", &p.Output)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Truncate", optional
+	e = GetSection(j, "Truncate", &p.Truncate)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Dedup", optional
+	e = GetSection(j, "Dedup", &p.Dedup)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Correlate", optional
+	e = GetSection(j, "Correlate", &p.Correlate)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Alert", optional
+	e = GetSection(j, "Alert", &p.Alert)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Anomaly", optional
+	e = GetSection(j, "Anomaly", &p.Anomaly)
+	if e != nil {
+		return e
+	}
+	p.Anomaly.Sender, e = GetSenderConfig(j.Get("Anomaly"))
+	if e != nil {
+		return e
+	}
+
+	// Parse "Backfill", optional
+	e = GetSection(j, "Backfill", &p.Backfill)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Schedule", optional
+	e = GetSection(j, "Schedule", &p.Schedule)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Health", optional
+	e = GetSection(j, "Health", &p.Health)
+	if e != nil {
+		return e
+	}
+	p.Health.Sender, e = GetSenderConfig(j.Get("Health"))
+	if e != nil {
+		return e
+	}
+
+	// Parse "Profile", optional
+	e = GetSection(j, "Profile", &p.Profile)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Shard", optional
+	e = GetSection(j, "Shard", &p.Shard)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Read", optional
+	e = GetSection(j, "Read", &p.Read)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Schema", optional
+	e = GetSection(j, "Schema", &p.Schema)
+	if e != nil {
+		return e
+	}
+	p.Schema.Sender, e = GetSenderConfig(j.Get("Schema"))
+	if e != nil {
+		return e
+	}
+
+	// Parse "OnStop", optional
+	e = GetSection(j, "OnStop", &p.OnStop)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Merge", optional
+	e = GetSection(j, "Merge", &p.Merge)
+	if e != nil {
+		return e
+	}
+
+	// Parse "Directory", optional
+	e = GetSection(j, "Directory", &p.Directory)
+	if e != nil {
+		return e
+	}
+
+	// Parse "PathFields", optional
+	e = GetSection(j, "PathFields", &p.PathFields)
+	if e != nil {
+		return e
+	}
+
 	testJ := j.Get("Test")
 	if e != nil {
 		p.Test.TestNum = 1
@@ -169,29 +486,19 @@ func (p *PeckTaskConfig) Unmarshal(jsonStr []byte) (e error) {
 	}
 	p.Test.Timeout = time
 
-	/*
-		// Parse "Fields", optional
-		if fields, e := j.Get("Fields").Array(); e == nil {
-			fmt.Println(len(fields))
-			for _, field := range fields {
-				var f PeckField
-				if name, ok := field.(map[string]interface{})["Name"]; ok {
-					if f.Name, ok = name.(string); !ok {
-						return errors.New("Fields format error: Name must be a string")
-					}
-				} else {
-					return errors.New("Fields error: need Name")
-				}
-				if value, ok := field.(map[string]interface{})["Value"]; ok {
-					if f.Value, ok = value.(string); !ok {
-						return errors.New("Fields format error: Name must be a string")
-					}
-				}else {
-					return errors.New("Fields error: need Value")
-				}
-				p.Fields = append(p.Fields, f)
-			}
-		}*/
+	// Parse "Fields", optional
+	e = GetSection(j, "Fields", &p.Fields)
+	if e != nil {
+		return e
+	}
+	for _, field := range p.Fields {
+		if field.Name == "" {
+			return errors.New("Fields error: need Name")
+		}
+		if (field.Value == nil) == (field.Default == nil) {
+			return errors.New("Fields error: " + field.Name + " needs either Value or Default")
+		}
+	}
 
 	return nil
 }
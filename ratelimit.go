@@ -0,0 +1,76 @@
+package logpeck
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimiter paces a loop to at most rate events per second, a rate <= 0
+// is unlimited
+type RateLimiter struct {
+	rate  int64
+	start time.Time
+	count int64
+}
+
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: rate, start: time.Now()}
+}
+
+// Wait blocks until the next event is allowed, it returns false if stop is
+// closed meanwhile
+func (p *RateLimiter) Wait(stop <-chan struct{}) bool {
+	if p.rate <= 0 {
+		return true
+	}
+	due := p.start.Add(time.Duration(p.count) * time.Second / time.Duration(p.rate))
+	p.count++
+	wait := time.Until(due)
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// RateMeter counts events and measures their rate per second between two
+// reads at least a second apart
+type RateMeter struct {
+	total int64
+
+	mu        sync.Mutex
+	lastTotal int64
+	lastTime  time.Time
+	rate      int64
+}
+
+func (p *RateMeter) Add(n int64) {
+	atomic.AddInt64(&p.total, n)
+}
+
+func (p *RateMeter) Total() int64 {
+	return atomic.LoadInt64(&p.total)
+}
+
+// Rate returns the events per second since the previous measure
+func (p *RateMeter) Rate(now time.Time) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	total := p.Total()
+	if p.lastTime.IsZero() {
+		p.lastTime, p.lastTotal = now, total
+		return 0
+	}
+	if elapsed := now.Sub(p.lastTime); elapsed >= time.Second {
+		p.rate = int64(float64(total-p.lastTotal) / elapsed.Seconds())
+		p.lastTime, p.lastTotal = now, total
+	}
+	return p.rate
+}
@@ -0,0 +1,141 @@
+package logpeck
+
+import (
+	"github.com/hpcloud/tail"
+	"github.com/hpcloud/tail/watch"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const defaultReadBufferSize = 64 * 1024
+
+// ReadConfig tunes how logs are read, the [read] section of the agent
+// config is overridden by the Read section of tasks. BufferSize is the read
+// buffer of backfill and replay, lines longer than MaxLineSize are split in
+// parts of at most MaxLineSize, the tailer splits them while reading.
+// PollInterval (milliseconds) is how often tailed logs are checked for new
+// lines, it is agent wide
+type ReadConfig struct {
+	BufferSize   int   `toml:"buffer_size" json:"BufferSize"`
+	MaxLineSize  int   `toml:"max_line_size" json:"MaxLineSize"`
+	PollInterval int64 `toml:"poll_interval" json:"-"`
+}
+
+// Merge returns c overridden by the set fields of task
+func (c ReadConfig) Merge(task ReadConfig) ReadConfig {
+	if task.BufferSize > 0 {
+		c.BufferSize = task.BufferSize
+	}
+	if task.MaxLineSize > 0 {
+		c.MaxLineSize = task.MaxLineSize
+	}
+	if c.BufferSize <= 0 {
+		c.BufferSize = defaultReadBufferSize
+	}
+	return c
+}
+
+// ApplyReadConfig sets the agent wide poll interval of tailed logs
+func ApplyReadConfig(config *ReadConfig) {
+	if config.PollInterval > 0 {
+		watch.POLL_DURATION = time.Duration(config.PollInterval) * time.Millisecond
+	}
+}
+
+// SplitLine splits content in parts of at most max bytes, max <= 0 keeps
+// the line whole. Parts end on character boundaries
+func SplitLine(content string, max int) []string {
+	if max <= 0 || len(content) <= max {
+		return []string{content}
+	}
+	parts := make([]string, 0, (len(content)+max-1)/max)
+	for len(content) > max {
+		end := max
+		for i := max; i > 0 && i > max-utf8.UTFMax; i-- {
+			if utf8.RuneStart(content[i]) {
+				end = i
+				break
+			}
+		}
+		parts = append(parts, content[:end])
+		content = content[end:]
+	}
+	return append(parts, content)
+}
+
+// cutRune returns where the character cut at the end of s starts, len(s) if
+// s ends with a whole character
+func cutRune(s string) int {
+	for i := len(s) - 1; i > 0 && i >= len(s)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			if !utf8.FullRuneInString(s[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(s)
+}
+
+// linePartWait is how long a part of max bytes waits for the part
+// continuing it before it is taken as the end of its line
+var linePartWait = 100 * time.Millisecond
+
+// linePart is a part of a line ready to process, n is how many bytes of the
+// file it ends
+type linePart struct {
+	text string
+	n    int64
+}
+
+// lineParts joins the parts the tailer splits lines longer than max bytes
+// into. Parts of a line have its Time and only the last one is followed by a
+// newline, a part of max bytes is held until it is known if the next part
+// continues it. The bytes of a character cut at the end of a part are moved
+// to the next one
+type lineParts struct {
+	max   int
+	held  *tail.Line
+	carry string
+}
+
+// add returns the parts ready to process once l is received
+func (s *lineParts) add(l *tail.Line) []linePart {
+	var ready []linePart
+	if s.held != nil {
+		if l.Time.Equal(s.held.Time) {
+			text := s.held.Text
+			end := cutRune(text)
+			ready = append(ready, linePart{s.carry + text[:end], int64(len(s.carry) + end)})
+			s.held, s.carry = nil, text[end:]
+		} else {
+			ready = s.end()
+		}
+	}
+	if s.max > 0 && len(l.Text) == s.max {
+		s.held = l
+		return ready
+	}
+	ready = append(ready, linePart{s.carry + l.Text, int64(len(s.carry)+len(l.Text)) + 1})
+	s.carry = ""
+	return ready
+}
+
+// end returns the held part as the end of its line
+func (s *lineParts) end() []linePart {
+	if s.held == nil {
+		return nil
+	}
+	part := linePart{s.carry + s.held.Text, int64(len(s.carry)+len(s.held.Text)) + 1}
+	s.held, s.carry = nil, ""
+	return []linePart{part}
+}
+
+// partSequence is the sequence of the i-th part of a split line
+func partSequence(seq string, i int) string {
+	if seq == "" || i == 0 {
+		return seq
+	}
+	return seq + "-" + strconv.Itoa(i)
+}
@@ -0,0 +1,122 @@
+package logpeck
+
+import (
+	"context"
+	"github.com/hpcloud/tail"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestReadConfigMerge(*testing.T) {
+	agent := ReadConfig{MaxLineSize: 100, PollInterval: 50}
+	if c := agent.Merge(ReadConfig{}); c.BufferSize != defaultReadBufferSize || c.MaxLineSize != 100 || c.PollInterval != 50 {
+		panic(c)
+	}
+	if c := agent.Merge(ReadConfig{BufferSize: 4096, MaxLineSize: 10}); c.BufferSize != 4096 || c.MaxLineSize != 10 {
+		panic(c)
+	}
+}
+
+func TestSplitLine(*testing.T) {
+	if parts := SplitLine("abcdefg", 0); len(parts) != 1 {
+		panic(parts)
+	}
+	if parts := SplitLine("abcdefg", 3); len(parts) != 3 || parts[0] != "abc" || parts[2] != "g" {
+		panic(parts)
+	}
+	if parts := SplitLine("abcdef", 3); len(parts) != 2 || parts[1] != "def" {
+		panic(parts)
+	}
+	if parts := SplitLine("aéé", 2); len(parts) != 3 || parts[0] != "a" || parts[1] != "é" {
+		panic(parts)
+	}
+}
+
+func TestLineParts(*testing.T) {
+	parts := &lineParts{max: 3}
+	now := time.Now()
+	if ready := parts.add(&tail.Line{Text: "a\xc3\xa9", Time: now}); len(ready) != 0 {
+		panic(ready)
+	}
+	ready := parts.add(&tail.Line{Text: "\xc3\xa9\xc3", Time: now})
+	if len(ready) != 1 || ready[0].text != "aé" || ready[0].n != 3 || parts.held == nil {
+		panic(ready)
+	}
+	ready = parts.add(&tail.Line{Text: "\xa9", Time: now})
+	if len(ready) != 2 || ready[0].text != "é" || ready[0].n != 2 || ready[1].text != "é" || ready[1].n != 3 {
+		panic(ready)
+	}
+	parts.add(&tail.Line{Text: "abc", Time: now.Add(time.Second)})
+	if ready := parts.end(); len(ready) != 1 || ready[0].text != "abc" || ready[0].n != 4 {
+		panic(ready)
+	}
+}
+
+func TestLogTaskMaxLineSize(t *testing.T) {
+	logName := t.TempDir() + "/split.log"
+	if err := ioutil.WriteFile(logName, []byte("ééééé\nabc\n"), 0644); err != nil {
+		panic(err)
+	}
+	h, err := NewHarness([]byte(`{
+		"Name": "SplitLog",
+		"LogPath": "` + logName + `",
+		"Read": {"MaxLineSize": 3},
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &chanSender{lines: make(chan interface{}, 20)}
+	h.Task.sender = sender
+	task := NewLogTask(logName)
+	task.AddPeckTask(h.Task)
+	info, _ := os.Stat(logName)
+	task.SetOffset(&LogOffset{LogPath: logName, Inode: fileInode(info), Offset: 0})
+	if err := task.Start(context.Background()); err != nil {
+		panic(err)
+	}
+	defer task.Stop()
+	read := ""
+	for read != "éééééabc" {
+		select {
+		case line := <-sender.lines:
+			if text := line.(string); !utf8.ValidString(text) || len(text) > 3 {
+				panic(text)
+			} else {
+				read += text
+			}
+		case <-time.After(3 * time.Second):
+			panic(read)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if offset, ok := task.Offset(); ok && offset.Offset == info.Size() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	panic("offset not at the end")
+}
+
+func TestPeckTaskMaxLineSize(*testing.T) {
+	h, err := NewHarness([]byte(`{
+		"Name": "SplitLog",
+		"SequenceField": "_seq",
+		"Read": {"MaxLineSize": 4},
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	sender := &eventSender{}
+	h.Task.sender = sender
+	h.Task.ProcessLine("abcdefghij", "h-1-0")
+	if len(sender.events) != 3 || sender.events[1]["_Log"] != "efgh" || sender.events[0]["_seq"] != "h-1-0" || sender.events[2]["_seq"] != "h-1-0-2" {
+		panic(sender.events)
+	}
+}
@@ -0,0 +1,95 @@
+package logpeck
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ReadyConfig sets the checks of /readyz. MinSenders is how many running
+// tasks must have a sender which is not failing, 0 skips the check.
+// SpoolDir must be writable, it is the directory of database_file by default
+type ReadyConfig struct {
+	MinSenders int    `toml:"min_senders"`
+	SpoolDir   string `toml:"spool_dir"`
+}
+
+// ReadyCheck is the result of one readiness check, Error is empty if it
+// passed
+type ReadyCheck struct {
+	Name  string
+	Ok    bool
+	Error string `json:",omitempty"`
+}
+
+// ReadyReport is the response of /readyz, Ready if all checks passed
+type ReadyReport struct {
+	Ready  bool
+	Checks []ReadyCheck
+}
+
+func (r *ReadyReport) add(name string, err error) {
+	check := ReadyCheck{Name: name, Ok: err == nil}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	r.Checks = append(r.Checks, check)
+	r.Ready = r.Ready && check.Ok
+}
+
+// checkWritable creates and removes a file in dir
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".logpeck-ready-")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, err = f.Write([]byte("ok"))
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if e := os.Remove(name); err == nil {
+		err = e
+	}
+	return err
+}
+
+// HealthySenders returns how many running tasks have a sender which is not
+// failing, and how many tasks are running
+func (p *Pecker) HealthySenders() (healthy int, running int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, logTask := range p.logTasks {
+		for _, task := range logTask.peckTasks {
+			if task.IsStop() {
+				continue
+			}
+			running++
+			if task.FailingSince().IsZero() {
+				healthy++
+			}
+		}
+	}
+	return
+}
+
+// Readiness runs the checks of config
+func (p *Pecker) Readiness(config *ReadyConfig) *ReadyReport {
+	report := &ReadyReport{Ready: true, Checks: []ReadyCheck{}}
+	report.add("database", p.db.Ping())
+
+	if config.MinSenders > 0 {
+		var err error
+		if healthy, running := p.HealthySenders(); healthy < config.MinSenders {
+			err = fmt.Errorf("%d of %d running tasks have a healthy sender, %d required", healthy, running, config.MinSenders)
+		}
+		report.add("senders", err)
+	}
+
+	dir := config.SpoolDir
+	if dir == "" {
+		dir = filepath.Dir(Config.DatabaseFile)
+	}
+	report.add("spool", checkWritable(dir))
+	return report
+}
@@ -0,0 +1,50 @@
+package logpeck
+
+import (
+	"github.com/boltdb/bolt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	dir := t.TempDir()
+	boltdb, err := bolt.Open(filepath.Join(dir, "ready.db"), 0600, nil)
+	if err != nil {
+		panic(err)
+	}
+	pecker := &Pecker{db: &DB{boltdb: boltdb}, logTasks: map[string]*LogTask{}}
+
+	report := pecker.Readiness(&ReadyConfig{SpoolDir: dir})
+	if report.Ready || report.Checks[0].Name != "database" || report.Checks[0].Ok {
+		panic(report)
+	}
+	boltdb.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{configBucket, statBucket, offsetBucket} {
+			tx.CreateBucketIfNotExists([]byte(bucket))
+		}
+		return nil
+	})
+	report = pecker.Readiness(&ReadyConfig{SpoolDir: dir})
+	if !report.Ready || len(report.Checks) != 2 {
+		panic(report)
+	}
+
+	report = pecker.Readiness(&ReadyConfig{MinSenders: 1, SpoolDir: dir})
+	if report.Ready || report.Checks[1].Name != "senders" || report.Checks[1].Ok {
+		panic(report)
+	}
+
+	report = pecker.Readiness(&ReadyConfig{SpoolDir: filepath.Join(dir, "missing")})
+	if report.Ready || report.Checks[1].Name != "spool" || report.Checks[1].Ok {
+		panic(report)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		panic(entries)
+	}
+
+	boltdb.Close()
+	if report = pecker.Readiness(&ReadyConfig{SpoolDir: dir}); report.Ready {
+		panic(report)
+	}
+}
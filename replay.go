@@ -0,0 +1,140 @@
+package logpeck
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ReplayConfig re-sends historical files through a task. Files may be glob
+// patterns, matched files are replayed from the oldest to the newest
+type ReplayConfig struct {
+	Name  string   `json:"Name"`
+	Files []string `json:"Files"`
+	Rate  int64    `json:"Rate"`
+	Tag   string   `json:"Tag"`
+}
+
+// Replayer feeds the lines of files to its own instance of a task, so live
+// aggregations are not mixed with replayed ones
+type Replayer struct {
+	config ReplayConfig
+	files  []string
+	task   *PeckTask
+
+	lines int64
+}
+
+func NewReplayer(config *ReplayConfig, taskConfig *PeckTaskConfig) (*Replayer, error) {
+	files, err := expandReplayFiles(config.Files)
+	if err != nil {
+		return nil, err
+	}
+	task, err := NewPeckTask(taskConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	task.replayTag = config.Tag
+	if task.replayTag == "" {
+		task.replayTag = "Replayed"
+	}
+	return &Replayer{
+		config: *config,
+		files:  files,
+		task:   task,
+	}, nil
+}
+
+func expandReplayFiles(patterns []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	modTimes := make(map[string]time.Time)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range matches {
+			info, err := os.Stat(file)
+			if err != nil || info.IsDir() || seen[file] {
+				continue
+			}
+			seen[file] = true
+			modTimes[file] = info.ModTime()
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("No file to replay")
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return modTimes[files[i]].Before(modTimes[files[j]])
+	})
+	return files, nil
+}
+
+// Lines returns how many lines were replayed
+func (p *Replayer) Lines() int64 {
+	return atomic.LoadInt64(&p.lines)
+}
+
+// Run replays all files at most Rate lines per second until ctx is done,
+// and stops the task so buffered events are flushed
+func (p *Replayer) Run(ctx context.Context) error {
+	defer LogExecTime(time.Now(), "Replay "+p.config.Name)
+	if err := p.task.Start(ctx); err != nil {
+		return err
+	}
+	defer p.task.Stop()
+	limiter := NewRateLimiter(p.config.Rate)
+	for _, file := range p.files {
+		log.Infof("[Replayer %s] Replay %s", p.config.Name, file)
+		if err := p.replayFile(ctx, file, limiter); err != nil {
+			log.Infof("[Replayer %s] Replay %s error, err[%s]", p.config.Name, file, err)
+			return err
+		}
+	}
+	log.Infof("[Replayer %s] Replayed %d lines of %v", p.config.Name, p.Lines(), p.files)
+	return nil
+}
+
+func (p *Replayer) replayFile(ctx context.Context, file string, limiter *RateLimiter) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var reader io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	scanner := bufio.NewScanner(reader)
+	// longer lines than MaxLineSize are split by the task
+	max := 1024 * 1024
+	if p.task.read.MaxLineSize > max {
+		max = p.task.read.MaxLineSize
+	}
+	scanner.Buffer(make([]byte, p.task.read.BufferSize), max)
+	for scanner.Scan() {
+		if !limiter.Wait(ctx.Done()) || ctx.Err() != nil {
+			return ctx.Err()
+		}
+		atomic.AddInt64(&p.lines, 1)
+		p.task.Process(scanner.Text())
+	}
+	return scanner.Err()
+}
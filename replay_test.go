@@ -0,0 +1,78 @@
+package logpeck
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplayer(*testing.T) {
+	var mu sync.Mutex
+	var docs []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			return
+		}
+		doc := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&doc)
+		mu.Lock()
+		docs = append(docs, doc)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	old := filepath.Join(dir, "app.log.1.gz")
+	f, _ := os.Create(old)
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("line 1\nline 2\n"))
+	gz.Close()
+	f.Close()
+	os.Chtimes(old, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	ioutil.WriteFile(filepath.Join(dir, "app.log"), []byte("line 3\n"), 0644)
+
+	var taskConfig PeckTaskConfig
+	err = taskConfig.Unmarshal([]byte(`{
+		"Name": "ReplayLog",
+		"LogPath": "` + filepath.Join(dir, "app.log") + `",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {
+			"Name": "elasticsearch",
+			"Config": {"Hosts": ["` + strings.TrimPrefix(server.URL, "http://") + `"], "Index": "replay", "Type": "log"}
+		}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	replayer, err := NewReplayer(&ReplayConfig{Name: "ReplayLog", Files: []string{filepath.Join(dir, "app.log*")}, Rate: 100}, &taskConfig)
+	if err != nil {
+		panic(err)
+	}
+	start := time.Now()
+	if err := replayer.Run(context.Background()); err != nil {
+		panic(err)
+	}
+	if replayer.Lines() != 3 || time.Since(start) < 20*time.Millisecond {
+		panic(replayer.Lines())
+	}
+	if len(docs) != 3 || docs[0]["_Log"] != "line 1" || docs[2]["_Log"] != "line 3" || docs[1]["Replayed"] != true {
+		panic(docs)
+	}
+
+	if _, err := NewReplayer(&ReplayConfig{Name: "ReplayLog", Files: []string{filepath.Join(dir, "none*")}}, &taskConfig); err == nil {
+		panic(err)
+	}
+}
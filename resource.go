@@ -0,0 +1,126 @@
+package logpeck
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// ResourceConfig bounds the resources of the agent. GoMaxProcs and
+// MemoryLimit (e.g. "256MB") default to the cgroup limits when DetectCgroup
+// is set, GCPercent 0 keeps the Go default. MemoryLimit is a soft limit,
+// the GC runs harder as the heap gets close to it
+type ResourceConfig struct {
+	GoMaxProcs   int    `toml:"gomaxprocs"`
+	GCPercent    int    `toml:"gc_percent"`
+	MemoryLimit  string `toml:"memory_limit"`
+	DetectCgroup bool   `toml:"detect_cgroup"`
+}
+
+// cgroupRoot is where the cgroup filesystem is mounted
+var cgroupRoot = "/sys/fs/cgroup"
+
+// memoryLimitRatio of the cgroup memory limit is used as the soft limit,
+// leaving room for memory outside the Go heap
+const memoryLimitRatio = 0.9
+
+// ApplyResourceLimits applies config to the Go runtime
+func ApplyResourceLimits(config *ResourceConfig) error {
+	procs := config.GoMaxProcs
+	var memoryLimit int64
+	if config.MemoryLimit != "" {
+		value, err := ParseValueWithUnit(config.MemoryLimit, "B")
+		if err != nil {
+			return fmt.Errorf("memory_limit error: %s", err)
+		}
+		memoryLimit = int64(value)
+	}
+	if config.DetectCgroup {
+		if cpus, err := cgroupCPULimit(); err == nil && procs <= 0 && cpus > 0 {
+			procs = int(math.Ceil(cpus))
+		}
+		if memory, err := cgroupMemoryLimit(); err == nil && memoryLimit <= 0 && memory > 0 {
+			memoryLimit = int64(float64(memory) * memoryLimitRatio)
+		}
+	}
+	if procs > 0 {
+		runtime.GOMAXPROCS(procs)
+	}
+	if config.GCPercent != 0 {
+		debug.SetGCPercent(config.GCPercent)
+	}
+	if memoryLimit > 0 {
+		debug.SetMemoryLimit(memoryLimit)
+	}
+	log.Infof("[Resource] GOMAXPROCS %d, gc percent %d, memory limit %d",
+		runtime.GOMAXPROCS(0), config.GCPercent, memoryLimit)
+	return nil
+}
+
+func readCgroupFile(name string) (string, error) {
+	raw, err := ioutil.ReadFile(cgroupRoot + "/" + name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// cgroupCPULimit returns the CPU quota in CPUs, 0 if unlimited. cgroup v2
+// "cpu.max" is tried first, then cgroup v1 cfs quota and period
+func cgroupCPULimit() (float64, error) {
+	var quota, period string
+	if max, err := readCgroupFile("cpu.max"); err == nil {
+		fields := strings.Fields(max)
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("cpu.max format error: %s", max)
+		}
+		quota, period = fields[0], fields[1]
+	} else {
+		if quota, err = readCgroupFile("cpu/cpu.cfs_quota_us"); err != nil {
+			return 0, err
+		}
+		if period, err = readCgroupFile("cpu/cpu.cfs_period_us"); err != nil {
+			return 0, err
+		}
+	}
+	if quota == "max" || quota == "-1" {
+		return 0, nil
+	}
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil {
+		return 0, err
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, errors.New("cpu period error: " + period)
+	}
+	return q / p, nil
+}
+
+// cgroupMemoryLimit returns the memory limit in bytes, 0 if unlimited
+func cgroupMemoryLimit() (int64, error) {
+	limit, err := readCgroupFile("memory.max")
+	if err != nil {
+		if limit, err = readCgroupFile("memory/memory.limit_in_bytes"); err != nil {
+			return 0, err
+		}
+	}
+	if limit == "max" {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(limit, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	// cgroup v1 reports a huge page aligned value when unlimited
+	if value >= math.MaxInt64/2 {
+		return 0, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,55 @@
+package logpeck
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"testing"
+)
+
+func TestCgroupLimits(*testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(root string) { cgroupRoot = root }(cgroupRoot)
+	cgroupRoot = dir
+
+	ioutil.WriteFile(filepath.Join(dir, "cpu.max"), []byte("150000 100000\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "memory.max"), []byte("max\n"), 0644)
+	if cpus, err := cgroupCPULimit(); err != nil || cpus != 1.5 {
+		panic(cpus)
+	}
+	if memory, err := cgroupMemoryLimit(); err != nil || memory != 0 {
+		panic(memory)
+	}
+
+	// cgroup v1
+	os.Remove(filepath.Join(dir, "cpu.max"))
+	os.Remove(filepath.Join(dir, "memory.max"))
+	os.MkdirAll(filepath.Join(dir, "cpu"), 0755)
+	os.MkdirAll(filepath.Join(dir, "memory"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "cpu/cpu.cfs_quota_us"), []byte("-1\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "cpu/cpu.cfs_period_us"), []byte("100000\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "memory/memory.limit_in_bytes"), []byte("1073741824\n"), 0644)
+	if cpus, err := cgroupCPULimit(); err != nil || cpus != 0 {
+		panic(cpus)
+	}
+
+	procs := runtime.GOMAXPROCS(0)
+	defer runtime.GOMAXPROCS(procs)
+	defer debug.SetMemoryLimit(debug.SetMemoryLimit(-1))
+	if err := ApplyResourceLimits(&ResourceConfig{GoMaxProcs: 1, DetectCgroup: true}); err != nil {
+		panic(err)
+	}
+	memory := float64(1073741824)
+	if runtime.GOMAXPROCS(0) != 1 || debug.SetMemoryLimit(-1) != int64(memory*memoryLimitRatio) {
+		panic("limits not applied")
+	}
+	if err := ApplyResourceLimits(&ResourceConfig{MemoryLimit: "lots"}); err == nil {
+		panic("memory_limit should be a size")
+	}
+}
@@ -0,0 +1,72 @@
+package logpeck
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RuntimeConfig is the agent settings changeable without a restart.
+// LogLevel is the level of the agent log, FlushInterval (seconds) is how
+// often stat counters and read offsets are saved. Unset fields are kept
+type RuntimeConfig struct {
+	LogLevel      string `json:"LogLevel"`
+	FlushInterval int64  `json:"FlushInterval"`
+}
+
+// SetLogLevel sets the level of the agent log, "error", "warning", "info"
+// or "debug"
+func SetLogLevel(level string) error {
+	switch strings.ToLower(level) {
+	case "error":
+		log.SetLevel(log.ErrorLevel)
+	case "warning":
+		log.SetLevel(log.ErrorLevel)
+	case "info":
+		log.SetLevel(log.InfoLevel)
+	case "debug":
+		log.SetLevel(log.DebugLevel)
+	default:
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	return nil
+}
+
+func logLevelName() string {
+	switch log.GetLevel() {
+	case log.ErrorLevel:
+		return "error"
+	case log.DebugLevel:
+		return "debug"
+	}
+	return log.GetLevel().String()
+}
+
+// Runtime returns the current runtime settings
+func (p *Pecker) Runtime() RuntimeConfig {
+	return RuntimeConfig{
+		LogLevel:      logLevelName(),
+		FlushInterval: int64(time.Duration(atomic.LoadInt64(&p.flushInterval)) / time.Second),
+	}
+}
+
+// SetRuntime changes the runtime settings, a new FlushInterval applies
+// from the next flush
+func (p *Pecker) SetRuntime(config *RuntimeConfig) error {
+	if config.FlushInterval < 0 {
+		return errors.New("FlushInterval must be positive")
+	}
+	if config.LogLevel != "" {
+		if err := SetLogLevel(config.LogLevel); err != nil {
+			return err
+		}
+	}
+	if config.FlushInterval > 0 {
+		atomic.StoreInt64(&p.flushInterval, int64(time.Duration(config.FlushInterval)*time.Second))
+	}
+	log.Infof("[Pecker] Runtime settings %+v", p.Runtime())
+	return nil
+}
@@ -0,0 +1,30 @@
+package logpeck
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"testing"
+)
+
+func TestPeckerRuntime(*testing.T) {
+	level := log.GetLevel()
+	defer log.SetLevel(level)
+	pecker := &Pecker{flushInterval: int64(statPersistInterval)}
+	if err := pecker.SetRuntime(&RuntimeConfig{LogLevel: "verbose"}); err == nil {
+		panic("bad LogLevel accepted")
+	}
+	if err := pecker.SetRuntime(&RuntimeConfig{FlushInterval: -1}); err == nil {
+		panic("bad FlushInterval accepted")
+	}
+	if err := pecker.SetRuntime(&RuntimeConfig{LogLevel: "debug", FlushInterval: 30}); err != nil {
+		panic(err)
+	}
+	if runtime := pecker.Runtime(); runtime.LogLevel != "debug" || runtime.FlushInterval != 30 {
+		panic(runtime)
+	}
+	if err := pecker.SetRuntime(&RuntimeConfig{LogLevel: "error"}); err != nil {
+		panic(err)
+	}
+	if runtime := pecker.Runtime(); runtime.LogLevel != "error" || runtime.FlushInterval != 30 {
+		panic(runtime)
+	}
+}
@@ -0,0 +1,118 @@
+package logpeck
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScheduleConfig limits a task to daily time windows, e.g. "00:00-06:00".
+// A window may cross midnight, Weekdays ("Mon".."Sun") restrict the days a
+// window starts on, empty means every day. Times are local
+type ScheduleConfig struct {
+	Windows  []string `json:"Windows"`
+	Weekdays []string `json:"Weekdays"`
+}
+
+// scheduleInterval is how often Pecker applies the schedules
+var scheduleInterval = 10 * time.Second
+
+type scheduleWindow struct {
+	start int
+	end   int
+}
+
+// Schedule tells whether a task should peck at a given time
+type Schedule struct {
+	windows  []scheduleWindow
+	weekdays map[time.Weekday]bool
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseMinuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("Schedule time error: %s", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func NewSchedule(config *ScheduleConfig) (*Schedule, error) {
+	schedule := &Schedule{}
+	for _, window := range config.Windows {
+		bounds := strings.Split(window, "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("Schedule window error: %s", window)
+		}
+		start, err := parseMinuteOfDay(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseMinuteOfDay(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		schedule.windows = append(schedule.windows, scheduleWindow{start: start, end: end})
+	}
+	if len(config.Weekdays) > 0 {
+		schedule.weekdays = make(map[time.Weekday]bool)
+		for _, name := range config.Weekdays {
+			day, ok := weekdayNames[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("Schedule weekday error: %s", name)
+			}
+			schedule.weekdays[day] = true
+		}
+	}
+	return schedule, nil
+}
+
+func (p *Schedule) IsEnable() bool {
+	return len(p.windows) > 0
+}
+
+func (p *Schedule) onDay(day time.Weekday) bool {
+	return p.weekdays == nil || p.weekdays[day]
+}
+
+// Active reports whether now is inside a window, always true without
+// windows
+func (p *Schedule) Active(now time.Time) bool {
+	if !p.IsEnable() {
+		return true
+	}
+	minute := now.Hour()*60 + now.Minute()
+	today := now.Weekday()
+	yesterday := now.AddDate(0, 0, -1).Weekday()
+	for _, w := range p.windows {
+		switch {
+		case w.start == w.end:
+			if p.onDay(today) {
+				return true
+			}
+		case w.start < w.end:
+			if minute >= w.start && minute < w.end && p.onDay(today) {
+				return true
+			}
+		default:
+			// crossing midnight, the part after midnight belongs to the
+			// window started yesterday
+			if minute >= w.start && p.onDay(today) {
+				return true
+			}
+			if minute < w.end && p.onDay(yesterday) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package logpeck
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(*testing.T) {
+	schedule, err := NewSchedule(&ScheduleConfig{
+		Windows:  []string{"22:00-02:00", "12:00-13:00"},
+		Weekdays: []string{"Mon"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	// 2024-01-01 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local)
+	}
+	cases := []struct {
+		now    time.Time
+		active bool
+	}{
+		{at(1, 12, 30), true},
+		{at(1, 13, 0), false},
+		{at(1, 23, 0), true},
+		{at(2, 1, 59), true},
+		{at(2, 2, 0), false},
+		{at(2, 12, 30), false},
+		{at(2, 23, 0), false},
+	}
+	for _, c := range cases {
+		if schedule.Active(c.now) != c.active {
+			panic(c.now.String())
+		}
+	}
+
+	for _, config := range []ScheduleConfig{
+		{Windows: []string{"25:00-26:00"}},
+		{Windows: []string{"01:00"}},
+		{Windows: []string{"01:00-02:00"}, Weekdays: []string{"Someday"}},
+	} {
+		if _, err := NewSchedule(&config); err == nil {
+			panic(config)
+		}
+	}
+	if always, _ := NewSchedule(&ScheduleConfig{}); !always.Active(at(3, 3, 3)) {
+		panic("no window means always active")
+	}
+}
@@ -0,0 +1,142 @@
+package logpeck
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SchemaConfig watches the extracted fields of a task against a baseline,
+// Fields or else the fields of the first LearnEvents events. A field out of
+// the baseline has appeared, a baseline field not seen for Window seconds
+// has vanished. Drift events are sent to Sender if set
+type SchemaConfig struct {
+	Enable      bool         `json:"Enable"`
+	Fields      []string     `json:"Fields"`
+	LearnEvents int          `json:"LearnEvents"`
+	Window      int64        `json:"Window"`
+	Sender      SenderConfig `json:"Sender"`
+}
+
+// SchemaMonitor detects schema drift of a task
+type SchemaMonitor struct {
+	task   string
+	config SchemaConfig
+
+	mu        sync.Mutex
+	learned   int
+	baseline  map[string]bool
+	since     int64
+	lastSeen  map[string]int64
+	lastCheck int64
+	appeared  map[string]bool
+	vanished  map[string]bool
+}
+
+func NewSchemaMonitor(task string, config *SchemaConfig) *SchemaMonitor {
+	monitor := &SchemaMonitor{
+		task:     task,
+		config:   *config,
+		baseline: make(map[string]bool),
+		lastSeen: make(map[string]int64),
+		appeared: make(map[string]bool),
+		vanished: make(map[string]bool),
+	}
+	if monitor.config.LearnEvents <= 0 {
+		monitor.config.LearnEvents = 100
+	}
+	if monitor.config.Window <= 0 {
+		monitor.config.Window = 300
+	}
+	for _, field := range config.Fields {
+		monitor.baseline[field] = true
+	}
+	if len(monitor.baseline) > 0 {
+		monitor.learned = monitor.config.LearnEvents
+	}
+	return monitor
+}
+
+func (p *SchemaMonitor) IsEnable() bool {
+	return p.config.Enable
+}
+
+func (p *SchemaMonitor) event(field, change string, now time.Time) map[string]interface{} {
+	event := map[string]interface{}{
+		"Type":      "schema_drift",
+		"Task":      p.task,
+		"Host":      GetHost(),
+		"Field":     field,
+		"Change":    change,
+		"timestamp": now.Unix(),
+		"_Log":      fmt.Sprintf("[logpeck] %s: field %s %s on %s", p.task, field, change, GetHost()),
+	}
+	log.Infof("[SchemaMonitor] %s", event["_Log"])
+	return event
+}
+
+// Record checks the fields of an event, it returns drift events of fields
+// which appeared or vanished since the last call
+func (p *SchemaMonitor) Record(fields map[string]interface{}, now time.Time) []map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ts := now.Unix()
+	if p.learned < p.config.LearnEvents {
+		for field := range fields {
+			p.baseline[field] = true
+		}
+		p.learned++
+		if p.learned < p.config.LearnEvents {
+			return nil
+		}
+	}
+	if p.since == 0 {
+		p.since = ts
+		for field := range p.baseline {
+			p.lastSeen[field] = ts
+		}
+	}
+	var events []map[string]interface{}
+	for field := range fields {
+		if !p.baseline[field] {
+			if !p.appeared[field] {
+				p.appeared[field] = true
+				events = append(events, p.event(field, "appeared", now))
+			}
+			continue
+		}
+		p.lastSeen[field] = ts
+		if p.vanished[field] {
+			delete(p.vanished, field)
+			events = append(events, p.event(field, "returned", now))
+		}
+	}
+	if ts > p.lastCheck {
+		p.lastCheck = ts
+		for field, seen := range p.lastSeen {
+			if !p.vanished[field] && ts-seen >= p.config.Window {
+				p.vanished[field] = true
+				events = append(events, p.event(field, "vanished", now))
+			}
+		}
+	}
+	return events
+}
+
+// Drift returns the appeared fields prefixed with "+" and the vanished ones
+// with "-"
+func (p *SchemaMonitor) Drift() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var drift []string
+	for field := range p.appeared {
+		drift = append(drift, "+"+field)
+	}
+	for field := range p.vanished {
+		drift = append(drift, "-"+field)
+	}
+	sort.Strings(drift)
+	return drift
+}
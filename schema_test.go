@@ -0,0 +1,39 @@
+package logpeck
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchemaMonitor(*testing.T) {
+	monitor := NewSchemaMonitor("SchemaLog", &SchemaConfig{Enable: true, LearnEvents: 2, Window: 60})
+	now := time.Unix(1000, 0)
+	if events := monitor.Record(map[string]interface{}{"a": "1", "b": "2"}, now); events != nil {
+		panic(events)
+	}
+	if events := monitor.Record(map[string]interface{}{"a": "1", "c": "3"}, now); events != nil {
+		panic(events)
+	}
+	events := monitor.Record(map[string]interface{}{"a": "1", "b": "2", "c": "3", "d": "4"}, now.Add(time.Second))
+	if len(events) != 1 || events[0]["Field"] != "d" || events[0]["Change"] != "appeared" {
+		panic(events)
+	}
+	if events := monitor.Record(map[string]interface{}{"a": "1", "d": "4"}, now.Add(2*time.Second)); len(events) != 0 {
+		panic(events)
+	}
+	events = monitor.Record(map[string]interface{}{"a": "1"}, now.Add(70*time.Second))
+	if len(events) != 2 || strings.Join(monitor.Drift(), ",") != "+d,-b,-c" {
+		panic(monitor.Drift())
+	}
+	events = monitor.Record(map[string]interface{}{"a": "1", "b": "2"}, now.Add(71*time.Second))
+	if len(events) != 1 || events[0]["Change"] != "returned" || strings.Join(monitor.Drift(), ",") != "+d,-c" {
+		panic(events)
+	}
+
+	monitor = NewSchemaMonitor("SchemaLog", &SchemaConfig{Enable: true, Fields: []string{"a"}})
+	events = monitor.Record(map[string]interface{}{"a": "1", "e": "5"}, now)
+	if len(events) != 1 || events[0]["Field"] != "e" {
+		panic(events)
+	}
+}
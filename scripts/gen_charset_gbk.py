@@ -0,0 +1,25 @@
+#!/usr/bin/env python3
+# Generates charset_gbk.go, the GBK double byte table, from the gbk codec of
+# Python: python3 scripts/gen_charset_gbk.py > charset_gbk.go
+import codecs
+
+TRAILS = 191  # 0x40-0xfe
+
+table = []
+for lead in range(0x81, 0xff):
+    for trail in range(0x40, 0xff):
+        try:
+            table.append(ord(codecs.decode(bytes([lead, trail]), 'gbk')))
+        except (UnicodeDecodeError, TypeError):
+            table.append(0)
+
+print('// Code generated by scripts/gen_charset_gbk.py. DO NOT EDIT.')
+print()
+print('package logpeck')
+print()
+print('// gbkTable maps the GBK bytes lead 0x81-0xfe, trail 0x40-0xfe to runes at')
+print('// (lead-0x81)*%d+trail-0x40, 0 if the bytes are not a character' % TRAILS)
+print('var gbkTable = [%d]uint16{' % len(table))
+for i in range(0, len(table), 12):
+    print('\t' + ' '.join('0x%04x,' % c for c in table[i:i + 12]))
+print('}')
@@ -2,7 +2,7 @@
 
 function Usage() {
  echo "Usage:"
-  echo "  $0 <task.config> [add|remove|stop|start|update|list]"
+  echo "  $0 <task.config> [add|remove|stop|start|update|diff|list|replay|rename]"
 }
 
 if [ $# != 2 ]; then
@@ -18,7 +18,10 @@ fi
 source $conf_file
 cmd=$2
 case $2 in
- 	add|remove|stop|start|update|list)
+ 	add|remove|stop|start|update|list|replay|rename)
+	 	;;
+ 	diff)
+	 	cmd="update?dry_run=true"
 	 	;;
  	*)
 	 	Usage; exit 1
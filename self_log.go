@@ -0,0 +1,88 @@
+package logpeck
+
+import (
+	"encoding/json"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"strings"
+)
+
+// SelfLogTaskName is the name of the built-in task pecking the agent log
+const SelfLogTaskName = "_logpeck"
+
+// selfLogLevels are the logrus levels shipped for each self_log level, lower
+// levels are filtered out so the task can't feed on its own logs
+var selfLogLevels = map[string][]string{
+	"debug":   {"debug", "info", "warning", "error", "fatal", "panic"},
+	"info":    {"info", "warning", "error", "fatal", "panic"},
+	"warning": {"warning", "error", "fatal", "panic"},
+	"error":   {"error", "fatal", "panic"},
+}
+
+// NewSelfLogTaskConfig returns the config of the self log task pecking
+// logFile
+func NewSelfLogTaskConfig(logFile string, config *SelfLogConfig) (*PeckTaskConfig, error) {
+	if logFile == "" {
+		return nil, errors.New("self_log requires log_file")
+	}
+	if config.Sender == "" {
+		return nil, errors.New("self_log requires sender")
+	}
+	level := strings.ToLower(config.Level)
+	if level == "" {
+		level = "warning"
+	}
+	levels, ok := selfLogLevels[level]
+	if !ok {
+		return nil, errors.New("self_log level error: " + config.Level)
+	}
+	keywords := make([]string, len(levels))
+	for i, l := range levels {
+		keywords[i] = "level=" + l
+	}
+	raw, err := json.Marshal(map[string]interface{}{
+		"Name":      SelfLogTaskName,
+		"LogPath":   logFile,
+		"Keywords":  strings.Join(keywords, "|"),
+		"Extractor": map[string]interface{}{"Name": ExTypeLogrus},
+		"Sender":    json.RawMessage(config.Sender),
+	})
+	if err != nil {
+		return nil, err
+	}
+	taskConfig := &PeckTaskConfig{}
+	if err := taskConfig.Unmarshal(raw); err != nil {
+		return nil, err
+	}
+	return taskConfig, nil
+}
+
+// SyncSelfLogTask adds, updates and starts the self log task after config,
+// or removes it once disabled
+func (p *Pecker) SyncSelfLogTask(config *LogPeckConfig) error {
+	_, err := p.db.GetConfig(SelfLogTaskName)
+	exist := err == nil
+	if !config.SelfLog.Enable {
+		if exist {
+			log.Infof("[Pecker] Remove self log task")
+			return p.RemovePeckTask(&PeckTaskConfig{Name: SelfLogTaskName})
+		}
+		return nil
+	}
+	taskConfig, err := NewSelfLogTaskConfig(config.LogFile, &config.SelfLog)
+	if err != nil {
+		return err
+	}
+	if exist {
+		err = p.UpdatePeckTask(taskConfig)
+	} else {
+		err = p.AddPeckTask(taskConfig, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if stat, err := p.db.GetStat(SelfLogTaskName); err == nil && !stat.Stop {
+		return nil
+	}
+	return p.StartPeckTask(taskConfig)
+}
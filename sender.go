@@ -1,24 +1,78 @@
 package logpeck
 
 import (
+	"context"
 	"errors"
 	log "github.com/Sirupsen/logrus"
 	sjson "github.com/bitly/go-simplejson"
 	"strings"
+	"time"
 )
 
 const (
-	SenderTypeES       = "elasticsearch"
-	SenderTypeKafka    = "kafka"
-	SenderTypeInfluxDb = "influxdb"
+	SenderTypeES         = "elasticsearch"
+	SenderTypeKafka      = "kafka"
+	SenderTypeInfluxDb   = "influxdb"
+	SenderTypePrometheus = "prometheus"
+	SenderTypeDatadog    = "datadog"
+	SenderTypeOtlp       = "otlp"
+	SenderTypeZabbix     = "zabbix"
+	SenderTypeSyslog     = "syslog"
+	SenderTypeGelf       = "gelf"
+	SenderTypeFluentd    = "fluentd"
+	SenderTypeLumberjack = "lumberjack"
+	SenderTypeEmail      = "email"
+	SenderTypeChat       = "chat"
 )
 
+// Sender sends events to a backend. In-flight sends are aborted when the
+// context given to Start is canceled
 type Sender interface {
 	Send(map[string]interface{})
-	Start() error
+	Start(ctx context.Context) error
 	Stop() error
 }
 
+// TimeoutCounter is implemented by senders counting request timeouts
+type TimeoutCounter interface {
+	TimeoutTotal() int64
+}
+
+// DropCounter is implemented by senders counting events they gave up
+type DropCounter interface {
+	DroppedTotal() int64
+}
+
+// HealthReporter is implemented by senders tracking continuous failures,
+// FailingSince is zero while sends succeed
+type HealthReporter interface {
+	FailingSince() time.Time
+}
+
+// BackendStater is implemented by senders counting responses per backend
+type BackendStater interface {
+	BackendStats() map[string]BackendStat
+}
+
+// AckSender is implemented by senders which can tell whether the backend
+// accepted an event, SendAck returns an error if sending it again may
+// succeed. It is required by the at-least-once delivery
+type AckSender interface {
+	SendAck(map[string]interface{}) error
+}
+
+// DualWriter is implemented by senders which also write to a secondary
+// backend, switched on and off at runtime
+type DualWriter interface {
+	SetDualWrite(enable bool) error
+	SecondaryStat() *DualWriteStat
+}
+
+// BreakerStater is implemented by senders with per backend circuit breakers
+type BreakerStater interface {
+	BreakerStates() map[string]string
+}
+
 func GetSenderConfig(j *sjson.Json) (senderConfig SenderConfig, err error) {
 	cJson := j.Get("Sender")
 	if cJson.Interface() == nil {
@@ -45,6 +99,25 @@ func GetSenderConfig(j *sjson.Json) (senderConfig SenderConfig, err error) {
 		senderConfig.Config, err = NewInfluxDbSenderConfig(jbyte)
 	case SenderTypeKafka:
 		senderConfig.Config, err = NewKafkaSenderConfig(jbyte)
+	case SenderTypePrometheus:
+	case SenderTypeDatadog:
+		senderConfig.Config, err = NewDatadogSenderConfig(jbyte)
+	case SenderTypeOtlp:
+		senderConfig.Config, err = NewOtlpSenderConfig(jbyte)
+	case SenderTypeZabbix:
+		senderConfig.Config, err = NewZabbixSenderConfig(jbyte)
+	case SenderTypeSyslog:
+		senderConfig.Config, err = NewSyslogSenderConfig(jbyte)
+	case SenderTypeGelf:
+		senderConfig.Config, err = NewGelfSenderConfig(jbyte)
+	case SenderTypeFluentd:
+		senderConfig.Config, err = NewFluentdSenderConfig(jbyte)
+	case SenderTypeLumberjack:
+		senderConfig.Config, err = NewLumberjackSenderConfig(jbyte)
+	case SenderTypeEmail:
+		senderConfig.Config, err = NewEmailSenderConfig(jbyte)
+	case SenderTypeChat:
+		senderConfig.Config, err = NewChatSenderConfig(jbyte)
 	default:
 		err = errors.New("[GetSenderConfig]sender name error: " + senderConfig.Name)
 	}
@@ -60,6 +133,26 @@ func NewSender(senderConfig *SenderConfig) (sender Sender, err error) {
 		sender, err = NewInfluxDbSender(senderConfig)
 	case SenderTypeKafka:
 		sender, err = NewKafkaSender(senderConfig)
+	case SenderTypePrometheus:
+		sender, err = NewPrometheusSender(senderConfig)
+	case SenderTypeDatadog:
+		sender, err = NewDatadogSender(senderConfig)
+	case SenderTypeOtlp:
+		sender, err = NewOtlpSender(senderConfig)
+	case SenderTypeZabbix:
+		sender, err = NewZabbixSender(senderConfig)
+	case SenderTypeSyslog:
+		sender, err = NewSyslogSender(senderConfig)
+	case SenderTypeGelf:
+		sender, err = NewGelfSender(senderConfig)
+	case SenderTypeFluentd:
+		sender, err = NewFluentdSender(senderConfig)
+	case SenderTypeLumberjack:
+		sender, err = NewLumberjackSender(senderConfig)
+	case SenderTypeEmail:
+		sender, err = NewEmailSender(senderConfig)
+	case SenderTypeChat:
+		sender, err = NewChatSender(senderConfig)
 	default:
 		err = errors.New("[NewSender]sender name error: " + senderConfig.Name)
 	}
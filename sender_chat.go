@@ -0,0 +1,166 @@
+package logpeck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const defaultChatTemplate = `[{{host}}] {{if ._Log}}{{._Log}}{{else}}{{json .}}{{end}}`
+
+type ChatConfig struct {
+	Url       string `json:"Url"`
+	Channel   string `json:"Channel"`
+	Username  string `json:"Username"`
+	IconUrl   string `json:"IconUrl"`
+	Template  string `json:"Template"`
+	RateLimit int    `json:"RateLimit"`
+
+	Http HttpClientConfig `json:"Http"`
+}
+
+// ChatSender posts events to a Slack or Mattermost incoming webhook, at most
+// RateLimit messages per minute
+type ChatSender struct {
+	config   ChatConfig
+	template *template.Template
+	client   *http.Client
+	ctx      context.Context
+
+	mu         sync.Mutex
+	window     int64
+	sent       int
+	suppressed int
+	httpStat
+}
+
+func chatTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"host": GetHost,
+		"json": func(v interface{}) string {
+			raw, _ := json.Marshal(v)
+			return string(raw)
+		},
+	}
+}
+
+func NewChatSenderConfig(jbyte []byte) (ChatConfig, error) {
+	chatConfig := ChatConfig{}
+	err := json.Unmarshal(jbyte, &chatConfig)
+	if err != nil {
+		return chatConfig, err
+	}
+	if err := chatConfig.Http.Validate(); err != nil {
+		return chatConfig, err
+	}
+	if chatConfig.Url == "" {
+		return chatConfig, errors.New("Chat Url is required")
+	}
+	if chatConfig.Template == "" {
+		chatConfig.Template = defaultChatTemplate
+	}
+	if _, err := template.New("chat").Funcs(chatTemplateFuncs()).Parse(chatConfig.Template); err != nil {
+		return chatConfig, err
+	}
+	if chatConfig.RateLimit <= 0 {
+		chatConfig.RateLimit = 10
+	}
+	log.Infof("[NewChatSenderConfig]ChatConfig: %v", chatConfig)
+	return chatConfig, nil
+}
+
+func NewChatSender(senderConfig *SenderConfig) (*ChatSender, error) {
+	sender := ChatSender{}
+	config, ok := senderConfig.Config.(ChatConfig)
+	if !ok {
+		return &sender, errors.New("New ChatSender error ")
+	}
+	client, err := NewHttpClient(&config.Http, 5*time.Second)
+	if err != nil {
+		return &sender, err
+	}
+	sender = ChatSender{
+		config:   config,
+		template: template.Must(template.New("chat").Funcs(chatTemplateFuncs()).Parse(config.Template)),
+		client:   client,
+		ctx:      context.Background(),
+		httpStat: newHttpStat(client),
+	}
+	return &sender, nil
+}
+
+func (p *ChatSender) Start(ctx context.Context) error {
+	p.ctx = ctx
+	return nil
+}
+
+func (p *ChatSender) Stop() error {
+	return nil
+}
+
+// allow counts the message in the current minute, and returns how many
+// messages were suppressed before it
+func (p *ChatSender) allow(now time.Time) (bool, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	window := now.Unix() / 60
+	if window != p.window {
+		p.window = window
+		p.sent = 0
+	}
+	if p.sent >= p.config.RateLimit {
+		p.suppressed++
+		return false, 0
+	}
+	p.sent++
+	suppressed := p.suppressed
+	p.suppressed = 0
+	return true, suppressed
+}
+
+func (p *ChatSender) Send(fields map[string]interface{}) {
+	ok, suppressed := p.allow(time.Now())
+	if !ok {
+		return
+	}
+	var text bytes.Buffer
+	if err := p.template.Execute(&text, fields); err != nil {
+		log.Infof("[ChatSender] Render message error, err[%s]", err)
+		return
+	}
+	if suppressed > 0 {
+		fmt.Fprintf(&text, "\n(%d messages suppressed by rate limit)", suppressed)
+	}
+	body := map[string]interface{}{"text": text.String()}
+	if p.config.Channel != "" {
+		body["channel"] = p.config.Channel
+	}
+	if p.config.Username != "" {
+		body["username"] = p.config.Username
+	}
+	if p.config.IconUrl != "" {
+		body["icon_url"] = p.config.IconUrl
+	}
+	raw_data, err := json.Marshal(body)
+	if err != nil {
+		log.Infof("[ChatSender] Marshal message error, err[%s]", err)
+		return
+	}
+	resp, err := p.post(p.ctx, p.client, p.config.Url, "application/json", bytes.NewBuffer(raw_data))
+	if err != nil {
+		p.observe(err)
+		log.Infof("[ChatSender] Post message error, err[%s]", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Infof("[ChatSender] Post message error, status[%s]", resp.Status)
+	}
+}
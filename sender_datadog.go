@@ -0,0 +1,168 @@
+package logpeck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type DatadogConfig struct {
+	ApiKey        string   `json:"ApiKey"`
+	Site          string   `json:"Site"`
+	Service       string   `json:"Service"`
+	Source        string   `json:"Source"`
+	Tags          []string `json:"Tags"`
+	MetricPrefix  string   `json:"MetricPrefix"`
+	BatchSize     int      `json:"BatchSize"`
+	FlushInterval int64    `json:"FlushInterval"`
+
+	Http HttpClientConfig `json:"Http"`
+}
+
+// DatadogSender sends logs to the Logs Intake API, and aggregator output to
+// the series API as gauges
+type DatadogSender struct {
+	config  DatadogConfig
+	host    string
+	client  *http.Client
+	ctx     context.Context
+	logs    *Batcher
+	metrics *Batcher
+	httpStat
+}
+
+func NewDatadogSenderConfig(jbyte []byte) (DatadogConfig, error) {
+	datadogConfig := DatadogConfig{}
+	err := json.Unmarshal(jbyte, &datadogConfig)
+	if err != nil {
+		return datadogConfig, err
+	}
+	if err := datadogConfig.Http.Validate(); err != nil {
+		return datadogConfig, err
+	}
+	if datadogConfig.ApiKey == "" {
+		return datadogConfig, errors.New("Datadog ApiKey is required")
+	}
+	if datadogConfig.Site == "" {
+		datadogConfig.Site = "datadoghq.com"
+	}
+	log.Infof("[NewDatadogSenderConfig]DatadogConfig: %v", datadogConfig)
+	return datadogConfig, nil
+}
+
+func NewDatadogSender(senderConfig *SenderConfig) (*DatadogSender, error) {
+	sender := DatadogSender{}
+	config, ok := senderConfig.Config.(DatadogConfig)
+	if !ok {
+		return &sender, errors.New("New DatadogSender error ")
+	}
+	client, err := NewHttpClient(&config.Http, 10*time.Second)
+	if err != nil {
+		return &sender, err
+	}
+	sender = DatadogSender{
+		config:   config,
+		host:     GetHost(),
+		client:   client,
+		ctx:      context.Background(),
+		httpStat: newHttpStat(client),
+	}
+	interval := time.Duration(config.FlushInterval) * time.Second
+	sender.logs = NewBatcher(config.BatchSize, interval, sender.postLogs)
+	sender.metrics = NewBatcher(config.BatchSize, interval, sender.postSeries)
+	return &sender, nil
+}
+
+func (p *DatadogSender) Start(ctx context.Context) error {
+	p.ctx = ctx
+	p.logs.Start()
+	p.metrics.Start()
+	return nil
+}
+
+func (p *DatadogSender) Stop() error {
+	p.logs.Stop()
+	p.metrics.Stop()
+	return nil
+}
+
+func (p *DatadogSender) ddtags(tags map[string]string) []string {
+	ddtags := append([]string{}, p.config.Tags...)
+	for k, v := range tags {
+		ddtags = append(ddtags, k+":"+v)
+	}
+	return ddtags
+}
+
+func (p *DatadogSender) Send(fields map[string]interface{}) {
+	result, ok := ParseAggregationResult(fields)
+	if !ok {
+		entry := map[string]interface{}{
+			"ddsource": p.config.Source,
+			"service":  p.config.Service,
+			"hostname": p.host,
+			"ddtags":   strings.Join(p.config.Tags, ","),
+		}
+		for k, v := range fields {
+			entry[k] = v
+		}
+		if msg, ok := fields["_Log"]; ok {
+			entry["message"] = msg
+		}
+		p.logs.Add(entry)
+		return
+	}
+	timestamp := result.Timestamp
+	for series, aggregations := range result.Series {
+		measurement, tags := splitSeries(series)
+		for aggregation, value := range aggregations {
+			p.metrics.Add(map[string]interface{}{
+				"metric": p.config.MetricPrefix + measurement + "." + aggregation,
+				"type":   "gauge",
+				"points": [][]interface{}{{timestamp, value}},
+				"host":   p.host,
+				"tags":   p.ddtags(tags),
+			})
+		}
+	}
+}
+
+func (p *DatadogSender) post(url string, body interface{}) {
+	raw_data, err := json.Marshal(body)
+	if err != nil {
+		log.Errorf("[DatadogSender] Marshal error, err[%s]", err)
+		return
+	}
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodPost, url, bytes.NewBuffer(raw_data))
+	if err != nil {
+		log.Infof("[DatadogSender] New request error, err[%s]", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", p.config.ApiKey)
+	resp, err := p.do(p.client, req)
+	if err != nil {
+		p.observe(err)
+		log.Infof("[DatadogSender] Post error, err[%s]", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		resp_str, _ := ioutil.ReadAll(resp.Body)
+		log.Infof("[DatadogSender] Post %s status %d, response %s", url, resp.StatusCode, resp_str)
+	}
+}
+
+func (p *DatadogSender) postLogs(items []interface{}) {
+	p.post("https://http-intake.logs."+p.config.Site+"/api/v2/logs", items)
+}
+
+func (p *DatadogSender) postSeries(items []interface{}) {
+	p.post("https://api."+p.config.Site+"/api/v1/series", map[string]interface{}{"series": items})
+}
@@ -2,14 +2,19 @@ package logpeck
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,12 +23,90 @@ type ElasticSearchConfig struct {
 	Index   string                 `json:"Index"`
 	Type    string                 `json:"Type"`
 	Mapping map[string]interface{} `json:"Mapping"`
+	Types   map[string]string      `json:"Types"`
+
+	TimestampField  string `json:"TimestampField"`
+	TimestampFormat string `json:"TimestampFormat"`
+
+	HostSelection string         `json:"HostSelection"`
+	HostWeights   map[string]int `json:"HostWeights"`
+
+	MappingCheck string `json:"MappingCheck"`
+	IdField      string `json:"IdField"`
+
+	Rollover ElasticSearchRolloverConfig `json:"Rollover"`
+
+	// Secondary is a second cluster documents are also written to while
+	// DualWrite is set, e.g. during a migration. Its fields default to the
+	// fields of this config
+	Secondary *ElasticSearchConfig `json:"Secondary"`
+	DualWrite bool                 `json:"DualWrite"`
+	// WaitMapping holds events back until the index mapping is initialized,
+	// so that fields are not mapped dynamically meanwhile
+	WaitMapping bool `json:"WaitMapping"`
+
+	// Pipeline is the ingest pipeline documents are sent through, it is
+	// installed with PipelineDefinition if set
+	Pipeline           string                 `json:"Pipeline"`
+	PipelineDefinition map[string]interface{} `json:"PipelineDefinition"`
+
+	Http HttpClientConfig `json:"Http"`
+}
+
+// ElasticSearchRolloverConfig makes Index a write alias whose index is
+// rolled over with the _rollover API once it reaches MaxSize (e.g. "50GB"),
+// MaxDocs documents or MaxAge seconds. The conditions are checked every
+// CheckInterval seconds, 60 by default
+type ElasticSearchRolloverConfig struct {
+	MaxSize       string `json:"MaxSize"`
+	MaxDocs       int64  `json:"MaxDocs"`
+	MaxAge        int64  `json:"MaxAge"`
+	CheckInterval int64  `json:"CheckInterval"`
+}
+
+func (c *ElasticSearchRolloverConfig) IsEnable() bool {
+	return c.MaxSize != "" || c.MaxDocs > 0 || c.MaxAge > 0
+}
+
+// Conditions returns the conditions of a _rollover request
+func (c *ElasticSearchRolloverConfig) Conditions() (map[string]interface{}, error) {
+	conditions := make(map[string]interface{})
+	if c.MaxSize != "" {
+		size, err := ParseValueWithUnit(c.MaxSize, "B")
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("Rollover MaxSize error: %s", c.MaxSize)
+		}
+		conditions["max_size"] = fmt.Sprintf("%db", int64(size))
+	}
+	if c.MaxDocs < 0 || c.MaxAge < 0 || c.CheckInterval < 0 {
+		return nil, errors.New("Rollover MaxDocs, MaxAge and CheckInterval must not be negative")
+	}
+	if c.MaxDocs > 0 {
+		conditions["max_docs"] = c.MaxDocs
+	}
+	if c.MaxAge > 0 {
+		conditions["max_age"] = fmt.Sprintf("%ds", c.MaxAge)
+	}
+	return conditions, nil
 }
 
 type ElasticSearchSender struct {
 	config        ElasticSearchConfig
 	mu            sync.Mutex
 	lastIndexName string
+	secondary     *ElasticSearchSender
+	dualWrite     int32
+	index         *IndexTemplate
+	mappings      map[string]*mappingState
+	// rolloverChecked is when the rollover conditions were last checked
+	rolloverChecked time.Time
+	fieldTypes      map[string]string
+	indexTypes      map[string]string
+	conflicts       map[string]bool
+	hosts           HostSelector
+	client          *http.Client
+	ctx             context.Context
+	httpStat
 }
 
 func NewElasticSearchSenderConfig(jbyte []byte) (ElasticSearchConfig, error) {
@@ -32,91 +115,670 @@ func NewElasticSearchSenderConfig(jbyte []byte) (ElasticSearchConfig, error) {
 	if err != nil {
 		return elasticSearchConfig, err
 	}
+	if err := elasticSearchConfig.Http.Validate(); err != nil {
+		return elasticSearchConfig, err
+	}
+	if _, err := NewHostSelector(elasticSearchConfig.HostSelection, nil, nil); err != nil {
+		return elasticSearchConfig, err
+	}
+	switch elasticSearchConfig.MappingCheck {
+	case "", MappingCheckWarn, MappingCheckCoerce:
+	default:
+		return elasticSearchConfig, errors.New("MappingCheck error: " + elasticSearchConfig.MappingCheck)
+	}
+	index, err := NewIndexTemplate(elasticSearchConfig.Index)
+	if err != nil {
+		return elasticSearchConfig, err
+	}
+	if elasticSearchConfig.PipelineDefinition != nil && elasticSearchConfig.Pipeline == "" {
+		return elasticSearchConfig, errors.New("Pipeline error: PipelineDefinition requires Pipeline")
+	}
+	if rollover := &elasticSearchConfig.Rollover; rollover.IsEnable() {
+		if _, err := rollover.Conditions(); err != nil {
+			return elasticSearchConfig, err
+		}
+		if index.HasDate() || !index.IsStatic() {
+			return elasticSearchConfig, errors.New("Rollover error: Index is an alias and can't contain a date or field")
+		}
+		if rollover.CheckInterval == 0 {
+			rollover.CheckInterval = 60
+		}
+	}
+	if elasticSearchConfig.Secondary != nil {
+		if elasticSearchConfig.Secondary, err = newSecondaryConfig(jbyte); err != nil {
+			return elasticSearchConfig, err
+		}
+	}
 	log.Infof("[NewElasticSearchSenderConfig]ElasticSearchConfig: %v", elasticSearchConfig)
 	return elasticSearchConfig, nil
 }
 
+// newSecondaryConfig returns the Secondary config of jbyte with the unset
+// fields taken from the primary config
+func newSecondaryConfig(jbyte []byte) (*ElasticSearchConfig, error) {
+	var raw struct {
+		Secondary json.RawMessage `json:"Secondary"`
+	}
+	if err := json.Unmarshal(jbyte, &raw); err != nil {
+		return nil, err
+	}
+	var secondary, overrides map[string]json.RawMessage
+	if err := json.Unmarshal(jbyte, &secondary); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw.Secondary, &overrides); err != nil {
+		return nil, fmt.Errorf("Secondary error: %s", err)
+	}
+	if _, ok := overrides["Secondary"]; ok {
+		return nil, errors.New("Secondary error: Secondary can't have a Secondary")
+	}
+	delete(secondary, "Secondary")
+	delete(secondary, "DualWrite")
+	for k, v := range overrides {
+		secondary[k] = v
+	}
+	merged, _ := json.Marshal(secondary)
+	config, err := NewElasticSearchSenderConfig(merged)
+	if err != nil {
+		return nil, fmt.Errorf("Secondary error: %s", err)
+	}
+	return &config, nil
+}
+
 func NewElasticSearchSender(senderConfig *SenderConfig) (*ElasticSearchSender, error) {
 	sender := ElasticSearchSender{}
 	config, ok := senderConfig.Config.(ElasticSearchConfig)
 	if !ok {
 		return &sender, errors.New("New ElasticSearchSender error ")
 	}
+	var socketHosts []string
+	for _, host := range config.Hosts {
+		socketHosts = append(socketHosts, SocketHost(host))
+	}
+	weights := make(map[string]int)
+	for host, weight := range config.HostWeights {
+		weights[SocketHost(host)] = weight
+	}
+	hosts, err := NewHostSelector(config.HostSelection, socketHosts, weights)
+	if err != nil {
+		return &sender, err
+	}
+	index, err := NewIndexTemplate(config.Index)
+	if err != nil {
+		return &sender, err
+	}
+	client, err := NewHttpClient(&config.Http, 10*time.Second)
+	if err != nil {
+		return &sender, err
+	}
 	sender = ElasticSearchSender{
-		config: config,
+		config:     config,
+		index:      index,
+		fieldTypes: getFieldTypes(&config),
+		hosts:      hosts,
+		client:     client,
+		ctx:        context.Background(),
+		httpStat:   newHttpStat(client),
+	}
+	if sender.breakers != nil {
+		// events are held back only when the breakers of all hosts are open
+		hosts.Skip(sender.breakers.Open)
+	}
+	if config.Secondary != nil {
+		secondary, err := NewElasticSearchSender(&SenderConfig{Name: senderConfig.Name, Config: *config.Secondary})
+		if err != nil {
+			return &sender, err
+		}
+		// failures of the Secondary cluster are not retried, its events
+		// are not held back either
+		secondary.hold = false
+		sender.secondary = secondary
+		sender.SetDualWrite(config.DualWrite)
 	}
 	return &sender, nil
 }
 
-func HttpCall(method, url string, bodyString string) {
-	body := ioutil.NopCloser(bytes.NewBuffer([]byte(bodyString)))
+// SetDualWrite starts or stops writing to the Secondary cluster
+func (p *ElasticSearchSender) SetDualWrite(enable bool) error {
+	if p.secondary == nil {
+		return errors.New("no Secondary cluster configured")
+	}
+	var v int32
+	if enable {
+		v = 1
+	}
+	atomic.StoreInt32(&p.dualWrite, v)
+	return nil
+}
+
+// DualWrite reports whether documents are also written to the Secondary
+// cluster
+func (p *ElasticSearchSender) DualWrite() bool {
+	return atomic.LoadInt32(&p.dualWrite) != 0
+}
+
+// BackendStats counts the responses of the hosts of both clusters
+func (p *ElasticSearchSender) BackendStats() map[string]BackendStat {
+	stats := p.httpStat.BackendStats()
+	if p.secondary != nil {
+		for host, stat := range p.secondary.BackendStats() {
+			stats[host] = stat
+		}
+	}
+	return stats
+}
+
+// SecondaryStat is the failure accounting of the Secondary cluster, kept
+// apart from the one of the sender
+func (p *ElasticSearchSender) SecondaryStat() *DualWriteStat {
+	if p.secondary == nil {
+		return nil
+	}
+	stat := &DualWriteStat{
+		Enable:       p.DualWrite(),
+		DroppedTotal: p.secondary.DroppedTotal(),
+		TimeoutTotal: p.secondary.TimeoutTotal(),
+	}
+	if since := p.secondary.FailingSince(); !since.IsZero() {
+		stat.FailingSince = since.Unix()
+	}
+	return stat
+}
+
+const (
+	MappingCheckWarn   = "warn"
+	MappingCheckCoerce = "coerce"
+)
+
+// mappingProperties returns the "properties" of a mapping, with or without
+// the type level of older ElasticSearch
+func mappingProperties(mapping map[string]interface{}, typ string) map[string]interface{} {
+	properties, ok := mapping["properties"].(map[string]interface{})
+	if !ok {
+		if typeMapping, ok := mapping[typ].(map[string]interface{}); ok {
+			properties, _ = typeMapping["properties"].(map[string]interface{})
+		}
+	}
+	return properties
+}
+
+// getFieldTypes collects field types from the "properties" of Mapping,
+// explicit Types entries take precedence
+func getFieldTypes(config *ElasticSearchConfig) map[string]string {
+	fieldTypes := make(map[string]string)
+	for field, property := range mappingProperties(config.Mapping, config.Type) {
+		if p, ok := property.(map[string]interface{}); ok {
+			if t, ok := p["type"].(string); ok {
+				fieldTypes[field] = t
+			}
+		}
+	}
+	for field, t := range config.Types {
+		fieldTypes[field] = t
+	}
+	return fieldTypes
+}
 
-	req, err := http.NewRequest(method, url, body)
+// flattenProperties collects the types of mapping properties, fields of
+// objects are named "object.field"
+func flattenProperties(prefix string, properties map[string]interface{}, fieldTypes map[string]string) {
+	for field, property := range properties {
+		p, ok := property.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if t, ok := p["type"].(string); ok {
+			fieldTypes[prefix+field] = t
+		}
+		if sub, ok := p["properties"].(map[string]interface{}); ok {
+			if _, ok := p["type"]; !ok {
+				fieldTypes[prefix+field] = "object"
+			}
+			flattenProperties(prefix+field+".", sub, fieldTypes)
+		}
+	}
+}
+
+// ParseIndexMapping reads the field types from a GET _mapping response
+func ParseIndexMapping(raw []byte, typ string) (map[string]string, error) {
+	var response map[string]map[string]interface{}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return nil, err
+	}
+	fieldTypes := make(map[string]string)
+	for _, indexMapping := range response {
+		mappings, _ := indexMapping["mappings"].(map[string]interface{})
+		flattenProperties("", mappingProperties(mappings, typ), fieldTypes)
+	}
+	return fieldTypes, nil
+}
+
+// MappingConflict tells whether ElasticSearch rejects value for a field of
+// fieldType, it returns the value to send instead when it can be coerced
+func MappingConflict(value interface{}, fieldType string) (bool, interface{}) {
+	switch strings.ToLower(fieldType) {
+	case "long", "integer", "short", "byte", "float", "double", "half_float", "scaled_float", "boolean":
+		switch v := value.(type) {
+		case string:
+			coerced, err := CoerceValue(v, fieldType)
+			return err != nil, coerced
+		case map[string]interface{}, []interface{}:
+			return true, nil
+		case bool:
+			return fieldType != "boolean", nil
+		}
+		return fieldType == "boolean", nil
+	case "object", "nested":
+		_, ok := value.(map[string]interface{})
+		return !ok, nil
+	case "keyword", "text", "date", "ip":
+		_, ok := value.(map[string]interface{})
+		return ok, nil
+	}
+	return false, nil
+}
+
+// checkMapping warns about fields conflicting with the index mapping, with
+// MappingCheck "coerce" they are coerced or removed so that the document
+// is accepted
+func (p *ElasticSearchSender) checkMapping(fields map[string]interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for field, value := range fields {
+		t, ok := p.indexTypes[field]
+		if !ok {
+			continue
+		}
+		conflict, coerced := MappingConflict(value, t)
+		if p.config.MappingCheck == MappingCheckCoerce {
+			if conflict {
+				delete(fields, field)
+			} else if coerced != nil {
+				fields[field] = coerced
+			}
+		}
+		if conflict && !p.conflicts[field] {
+			p.conflicts[field] = true
+			log.Warnf("[Sender] Field %s value %v conflicts with %s mapping of index %s", field, value, t, p.lastIndexName)
+		}
+	}
+}
+
+// fetchMapping reads the field types of the current index
+func (p *ElasticSearchSender) fetchMapping(host string) error {
+	uri := p.config.Http.Scheme() + "://" + host + "/" + p.lastIndexName + "/_mapping"
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, uri, nil)
 	if err != nil {
-		log.Infof("[Sender] New request error, err[%s]", err)
+		return err
 	}
-	client := &http.Client{Timeout: time.Duration(500) * time.Millisecond}
-	resp, err := client.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
-		log.Infof("[Sender] Put error, err[%s]", err)
-	} else {
-		resp_str, _ := httputil.DumpResponse(resp, true)
-		log.Infof("[Sender] Response %s", resp_str)
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Get mapping status %d", resp.StatusCode)
+	}
+	fieldTypes, err := ParseIndexMapping(raw, p.config.Type)
+	if err != nil {
+		return err
+	}
+	p.indexTypes = fieldTypes
+	p.conflicts = make(map[string]bool)
+	return nil
+}
+
+func (p *ElasticSearchSender) coerceFields(fields map[string]interface{}) {
+	for field, t := range p.fieldTypes {
+		str, ok := fields[field].(string)
+		if !ok {
+			continue
+		}
+		value, err := CoerceValue(str, t)
+		if err != nil {
+			log.Debugf("[Sender] Coerce field %s to %s error, err[%s]", field, t, err)
+			continue
+		}
+		fields[field] = value
 	}
 }
 
-func (p *ElasticSearchSender) GetIndexName() (indexName string) {
+// maxMappedIndices bounds mappings, it is cleared when full
+const maxMappedIndices = 1024
+
+const (
+	mappingMinBackoff = time.Second
+	mappingMaxBackoff = time.Minute
+)
+
+// mappingState is the mapping initialization of an index, it is retried
+// after retry until it succeeds
+type mappingState struct {
+	ready    bool
+	failures int
+	retry    time.Time
+}
+
+// MappingNotReadyError is returned while events are held back, until the
+// ingest pipeline is installed or, with WaitMapping, the index mapping is
+// initialized
+type MappingNotReadyError struct {
+	Index string
+	Retry time.Time
+}
+
+func (e *MappingNotReadyError) Error() string {
+	return fmt.Sprintf("index %s not ready, retry at %s", e.Index, e.Retry.Format(time.RFC3339))
+}
+
+// initMapping runs init for index unless it succeeded or its retry time has
+// not come, failures are retried with exponential backoff
+func (p *ElasticSearchSender) initMapping(index string, now time.Time, init func() error) *mappingState {
+	state := p.mappings[index]
+	if state == nil {
+		if p.mappings == nil || len(p.mappings) >= maxMappedIndices {
+			p.mappings = make(map[string]*mappingState)
+		}
+		state = &mappingState{}
+		p.mappings[index] = state
+	}
+	if state.ready || now.Before(state.retry) {
+		return state
+	}
+	if err := init(); err != nil {
+		p.observe(err)
+		backoff := mappingMinBackoff << uint(min(state.failures, 6))
+		if backoff > mappingMaxBackoff {
+			backoff = mappingMaxBackoff
+		}
+		state.failures++
+		state.retry = now.Add(backoff)
+		log.Warnf("[Sender] Init ElasticSearch mapping of %s error, retry in %s, err[%s]", index, backoff, err)
+		return state
+	}
+	state.ready = true
+	return state
+}
+
+// MappingReady reports whether the mapping of index was initialized
+func (p *ElasticSearchSender) MappingReady(index string) bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	prototype := p.config.Index
-	l, r := "%{+", "}"
-	if !strings.Contains(prototype, l) || !strings.Contains(prototype, r) {
-		indexName = prototype
-	} else {
-		lIndex := strings.Index(prototype, l)
-		rIndex := strings.Index(prototype, r)
-		format := prototype[lIndex+len(l) : rIndex]
-		timeStr := time.Now().Format(format)
-		indexName = prototype[:lIndex] + timeStr + prototype[rIndex+1:]
-	}
+	state := p.mappings[index]
+	return state != nil && state.ready
+}
 
-	if indexName != p.lastIndexName {
+// GetIndexName returns the index of fields, and initializes its mapping
+// when it is new. With WaitMapping, a MappingNotReadyError is returned until
+// the mapping is initialized
+func (p *ElasticSearchSender) GetIndexName(fields map[string]interface{}) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.config.PipelineDefinition != nil {
+		// ":" can't appear in index names
+		pipeline := p.initMapping("pipeline:"+p.config.Pipeline, now, p.putPipeline)
+		if !pipeline.ready {
+			return "", &MappingNotReadyError{Index: p.config.Index, Retry: pipeline.retry}
+		}
+	}
+	indexName := p.config.Index
+	var state *mappingState
+	if p.config.Rollover.IsEnable() {
 		p.lastIndexName = indexName
-		p.InitMapping()
+		state = p.initMapping(indexName, now, func() error {
+			if err := p.createWriteAlias(); err != nil {
+				return err
+			}
+			p.rolloverChecked = now
+			return p.initTimestampMapping()
+		})
+		if state.ready {
+			p.checkRollover(now)
+		}
+	} else {
+		var err error
+		if indexName, err = p.index.Execute(fields, now); err != nil {
+			return "", err
+		}
+		p.lastIndexName = indexName
+		state = p.initMapping(indexName, now, p.InitMapping)
 	}
-
-	return indexName
+	if !state.ready && p.config.WaitMapping {
+		return "", &MappingNotReadyError{Index: indexName, Retry: state.retry}
+	}
+	return indexName, nil
 }
 
+// InitMapping creates the current index with Mapping and maps its
+// Timestamp field
 func (p *ElasticSearchSender) InitMapping() error {
-	host, err := SelectRandom(p.config.Hosts)
+	host, err := p.hosts.Select()
 	if err != nil {
 		return err
 	}
-	uri := "http://" + host + "/" + p.lastIndexName
-	typeUri := uri + "/_mappings/" + p.config.Type
+	uri := p.config.Http.Scheme() + "://" + host + "/" + p.lastIndexName
 
 	// Try init index mapping
-	// indexMapping := `{"mappings":` + p.config.Mapping + `}`
 	indexMapping := map[string]interface{}{
 		"mappings": p.config.Mapping,
 	}
-	raw_data, err := json.Marshal(indexMapping)
 	if p.config.Mapping == nil {
-		raw_data = []byte(`{"mappings":{}}`)
+		indexMapping["mappings"] = map[string]interface{}{}
+	}
+	log.Infof("[Sender] Init ElasticSearch mapping %s %v ", uri, indexMapping)
+	status, raw, err := p.esRequest(http.MethodPut, uri, indexMapping)
+	if err != nil {
+		return err
 	}
-	log.Infof("[Sender] Init ElasticSearch mapping %s %s ", uri, string(raw_data[:]))
-	HttpCall(http.MethodPut, uri, string(raw_data[:]))
+	// the index exists if it was created before or by another agent
+	if status != http.StatusOK && !bytes.Contains(raw, []byte("already_exists")) {
+		return fmt.Errorf("Create index status %d, %s", status, raw)
+	}
+
+	return p.initTimestampMapping()
+}
+
+// putPipeline installs the ingest pipeline Pipeline with
+// PipelineDefinition, replacing a pipeline of the same name
+func (p *ElasticSearchSender) putPipeline() error {
+	host, err := p.hosts.Select()
+	if err != nil {
+		return err
+	}
+	uri := p.config.Http.Scheme() + "://" + host + "/_ingest/pipeline/" + url.PathEscape(p.config.Pipeline)
+	log.Infof("[Sender] Put ElasticSearch pipeline %s %v", uri, p.config.PipelineDefinition)
+	status, raw, err := p.esRequest(http.MethodPut, uri, p.config.PipelineDefinition)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("Put pipeline status %d, %s", status, raw)
+	}
+	return nil
+}
+
+// initTimestampMapping maps the Timestamp field of the current index, and
+// fetches its mapping for MappingCheck
+func (p *ElasticSearchSender) initTimestampMapping() error {
+	host, err := p.hosts.Select()
+	if err != nil {
+		return err
+	}
+	uri := p.config.Http.Scheme() + "://" + host + "/" + p.lastIndexName
+	typeUri := uri + "/_mappings/" + p.config.Type
 
 	// Try init Timestamp Field mapping
 	propString := `{"properties":{"Timestamp":{"type":"date","format":"epoch_millis"}}}`
 	log.Infof("[Sender] Init ElasticSearch mapping %s %s ", uri, propString)
-	HttpCall(http.MethodPut, typeUri, propString)
+	status, raw, err := p.esRequest(http.MethodPut, typeUri, json.RawMessage(propString))
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("Put mapping status %d, %s", status, raw)
+	}
 
+	if p.config.MappingCheck != "" {
+		if err := p.fetchMapping(host); err != nil {
+			log.Infof("[Sender] Get ElasticSearch mapping %s error, err[%s]", uri, err)
+		}
+	}
 	return nil
 }
 
-func (p *ElasticSearchSender) Start() error {
+// esRequest sends body as json and returns the status and the response
+func (p *ElasticSearchSender) esRequest(method, uri string, body interface{}) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(p.ctx, method, uri, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, raw, err
+}
+
+// createWriteAlias creates the first index of the write alias Index, if the
+// alias does not exist yet
+func (p *ElasticSearchSender) createWriteAlias() error {
+	host, err := p.hosts.Select()
+	if err != nil {
+		return err
+	}
+	base := p.config.Http.Scheme() + "://" + host + "/"
+	status, _, err := p.esRequest(http.MethodHead, base+"_alias/"+url.PathEscape(p.config.Index), nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	if status != http.StatusNotFound {
+		return fmt.Errorf("Get alias status %d", status)
+	}
+	index := p.config.Index + "-000001"
+	body := map[string]interface{}{
+		"aliases": map[string]interface{}{
+			p.config.Index: map[string]interface{}{"is_write_index": true},
+		},
+	}
+	if p.config.Mapping != nil {
+		body["mappings"] = p.config.Mapping
+	}
+	log.Infof("[Sender] Create ElasticSearch index %s with write alias %s", index, p.config.Index)
+	status, raw, err := p.esRequest(http.MethodPut, base+url.PathEscape(index), body)
+	if err != nil {
+		return err
+	}
+	// another agent may have created it meanwhile
+	if status != http.StatusOK && !bytes.Contains(raw, []byte("already_exists")) {
+		return fmt.Errorf("Create index status %d, %s", status, raw)
+	}
+	return nil
+}
+
+// rollover rolls the write alias over if a condition is met, it returns the
+// new index or "" if the alias was not rolled over
+func (p *ElasticSearchSender) rollover(host string) (string, error) {
+	conditions, err := p.config.Rollover.Conditions()
+	if err != nil {
+		return "", err
+	}
+	body := map[string]interface{}{"conditions": conditions}
+	if p.config.Mapping != nil {
+		body["mappings"] = p.config.Mapping
+	}
+	uri := p.config.Http.Scheme() + "://" + host + "/" + url.PathEscape(p.config.Index) + "/_rollover"
+	status, raw, err := p.esRequest(http.MethodPost, uri, body)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("Rollover status %d, %s", status, raw)
+	}
+	var response struct {
+		RolledOver bool   `json:"rolled_over"`
+		NewIndex   string `json:"new_index"`
+	}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return "", err
+	}
+	if !response.RolledOver {
+		return "", nil
+	}
+	return response.NewIndex, nil
+}
+
+// checkRollover rolls the write alias over every CheckInterval. Errors are
+// retried at the next check
+func (p *ElasticSearchSender) checkRollover(now time.Time) {
+	interval := time.Duration(p.config.Rollover.CheckInterval) * time.Second
+	if now.Sub(p.rolloverChecked) < interval {
+		return
+	}
+	host, err := p.hosts.Select()
+	if err != nil {
+		return
+	}
+	p.rolloverChecked = now
+	index, err := p.rollover(host)
+	if err != nil {
+		p.observe(err)
+		log.Warnf("[Sender] Rollover ElasticSearch alias %s error, err[%s]", p.config.Index, err)
+		return
+	}
+	if index != "" {
+		log.Infof("[Sender] Rollover ElasticSearch alias %s to %s", p.config.Index, index)
+		if err := p.initTimestampMapping(); err != nil {
+			p.observe(err)
+			log.Warnf("[Sender] Init ElasticSearch mapping of %s error, err[%s]", index, err)
+		}
+	}
+}
+
+// waitIndexName returns the index of fields once its mapping is
+// initialized, or a MappingNotReadyError if the sender is stopped meanwhile
+func (p *ElasticSearchSender) waitIndexName(fields map[string]interface{}) (string, error) {
+	for {
+		indexName, err := p.GetIndexName(fields)
+		notReady, ok := err.(*MappingNotReadyError)
+		if !ok {
+			return indexName, err
+		}
+		timer := time.NewTimer(time.Until(notReady.Retry))
+		select {
+		case <-timer.C:
+		case <-p.ctx.Done():
+			timer.Stop()
+			return "", err
+		}
+	}
+}
+
+func (p *ElasticSearchSender) Start(ctx context.Context) error {
+	p.ctx = ctx
+	if p.secondary != nil {
+		return p.secondary.Start(ctx)
+	}
 	return nil
 }
 
@@ -125,6 +787,34 @@ func (p *ElasticSearchSender) Stop() error {
 }
 
 func (p *ElasticSearchSender) Send(fields map[string]interface{}) {
+	p.sendAck(fields)
+	p.sendSecondary(fields)
+}
+
+// SendAck sends fields, and also to the Secondary cluster while DualWrite is
+// set. Only the primary cluster is acknowledged, fields are sent to the
+// Secondary cluster once it accepted them so that retries are not written
+// twice
+func (p *ElasticSearchSender) SendAck(fields map[string]interface{}) error {
+	err := p.sendAck(fields)
+	if err == nil {
+		p.sendSecondary(fields)
+	}
+	return err
+}
+
+// sendSecondary sends fields to the Secondary cluster while DualWrite is
+// set, its failures are counted apart and not retried
+func (p *ElasticSearchSender) sendSecondary(fields map[string]interface{}) {
+	if p.secondary == nil || !p.DualWrite() {
+		return
+	}
+	if err := p.secondary.sendAck(fields); err != nil {
+		log.Debugf("[Sender] Send to secondary cluster error, err[%s]", err)
+	}
+}
+
+func (p *ElasticSearchSender) sendAck(fields map[string]interface{}) error {
 	defer LogExecTime(time.Now(), "Sender")
 	data := map[string]interface{}{
 		"Host":      GetHost(),
@@ -133,23 +823,58 @@ func (p *ElasticSearchSender) Send(fields map[string]interface{}) {
 	for k, v := range fields {
 		data[k] = v
 	}
-	raw_data, err := json.Marshal(data)
+	if value, ok := fields[p.config.TimestampField]; ok {
+		if ts, err := ParseEventTime(value, p.config.TimestampFormat); err == nil {
+			data["Timestamp"] = ts
+		} else {
+			log.Debugf("[Sender] Parse %s error, err[%s]", p.config.TimestampField, err)
+		}
+	}
+	p.coerceFields(data)
+	indexName, err := p.waitIndexName(data)
+	if _, ok := err.(*MappingNotReadyError); ok {
+		return err
+	}
 	if err != nil {
-		panic(err)
+		log.Errorf("[Sender] %s, drop event", err)
+		p.drop()
+		return nil
 	}
-	host, err := SelectRandom(p.config.Hosts)
+	// selected after the mapping requests, which may open the breaker of
+	// a host
+	host, err := p.hosts.Select()
 	if err != nil {
 		log.Debugf("[Sender] ElasticSearch Host error [%v] ", err)
-		return
+		return err
+	}
+	uri := p.config.Http.Scheme() + "://" + host + "/" + indexName + "/" + p.config.Type
+	if id, ok := fields[p.config.IdField]; ok && p.config.IdField != "" {
+		uri += "/" + url.PathEscape(fmt.Sprint(id))
+	}
+	if p.config.Pipeline != "" {
+		uri += "?pipeline=" + url.QueryEscape(p.config.Pipeline)
+	}
+	if p.config.MappingCheck != "" {
+		p.checkMapping(data)
+	}
+	raw_data, err := json.Marshal(data)
+	if err != nil {
+		log.Errorf("[Sender] Marshal error, drop event, err[%s]", err)
+		p.drop()
+		return nil
 	}
-	uri := "http://" + host + "/" + p.GetIndexName() + "/" + p.config.Type
 	log.Debugf("[Sender] Post ElasticSearch %s content [%s] ", uri, raw_data)
-	body := ioutil.NopCloser(bytes.NewBuffer(raw_data))
-	resp, err := http.Post(uri, "application/json", body)
+	resp, err := p.post(p.ctx, p.client, uri, "application/json", bytes.NewBuffer(raw_data))
 	if err != nil {
+		p.observe(err)
 		log.Infof("[Sender] Post error, err[%s]", err)
-	} else {
-		resp_str, _ := httputil.DumpResponse(resp, true)
-		log.Debugf("[Sender] Response %s", resp_str)
+		if p.ctx.Err() == nil {
+			p.hosts.Fail(host)
+		}
+		return err
 	}
+	defer resp.Body.Close()
+	resp_str, _ := httputil.DumpResponse(resp, true)
+	log.Debugf("[Sender] Response %s", resp_str)
+	return p.accepted(resp)
 }
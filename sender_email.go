@@ -0,0 +1,222 @@
+package logpeck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const defaultEmailSubject = `[logpeck] {{.Count}} events on {{.Host}}`
+const defaultEmailBody = `{{.Count}} events between {{.Start.Format "2006-01-02 15:04:05"}} and {{.End.Format "2006-01-02 15:04:05"}}{{if .Omitted}}, {{.Omitted}} omitted{{end}}
+{{range .Events}}
+{{if .Fields._Log}}{{.Fields._Log}}{{else}}{{.Json}}{{end}}{{end}}
+`
+
+type EmailConfig struct {
+	Server    string   `json:"Server"`
+	Username  string   `json:"Username"`
+	Password  string   `json:"Password"`
+	From      string   `json:"From"`
+	To        []string `json:"To"`
+	Subject   string   `json:"Subject"`
+	Body      string   `json:"Body"`
+	Interval  int64    `json:"Interval"`
+	MaxEvents int      `json:"MaxEvents"`
+}
+
+type EmailEvent struct {
+	Time   time.Time
+	Fields map[string]interface{}
+}
+
+func (e EmailEvent) Json() string {
+	raw, _ := json.Marshal(e.Fields)
+	return string(raw)
+}
+
+// EmailDigest is the data of Subject and Body templates
+type EmailDigest struct {
+	Host    string
+	Count   int
+	Omitted int
+	Start   time.Time
+	End     time.Time
+	Events  []EmailEvent
+}
+
+// EmailSender collects events and mails them as one digest every Interval,
+// at most MaxEvents events are kept in a digest
+type EmailSender struct {
+	config   EmailConfig
+	host     string
+	subject  *template.Template
+	body     *template.Template
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+	mu      sync.Mutex
+	events  []EmailEvent
+	omitted int
+	stop    chan struct{}
+	done    chan struct{}
+	healthStat
+}
+
+func NewEmailSenderConfig(jbyte []byte) (EmailConfig, error) {
+	emailConfig := EmailConfig{}
+	err := json.Unmarshal(jbyte, &emailConfig)
+	if err != nil {
+		return emailConfig, err
+	}
+	if emailConfig.Server == "" || emailConfig.From == "" || len(emailConfig.To) == 0 {
+		return emailConfig, errors.New("Email Server, From and To are required")
+	}
+	if emailConfig.Subject == "" {
+		emailConfig.Subject = defaultEmailSubject
+	}
+	if emailConfig.Body == "" {
+		emailConfig.Body = defaultEmailBody
+	}
+	if _, err := template.New("subject").Parse(emailConfig.Subject); err != nil {
+		return emailConfig, err
+	}
+	if _, err := template.New("body").Parse(emailConfig.Body); err != nil {
+		return emailConfig, err
+	}
+	if emailConfig.Interval <= 0 {
+		emailConfig.Interval = 300
+	}
+	if emailConfig.MaxEvents <= 0 {
+		emailConfig.MaxEvents = 100
+	}
+	log.Infof("[NewEmailSenderConfig]EmailConfig: %v", emailConfig)
+	return emailConfig, nil
+}
+
+func NewEmailSender(senderConfig *SenderConfig) (*EmailSender, error) {
+	sender := EmailSender{}
+	config, ok := senderConfig.Config.(EmailConfig)
+	if !ok {
+		return &sender, errors.New("New EmailSender error ")
+	}
+	sender = EmailSender{
+		config:   config,
+		host:     GetHost(),
+		subject:  template.Must(template.New("subject").Parse(config.Subject)),
+		body:     template.Must(template.New("body").Parse(config.Body)),
+		sendMail: smtp.SendMail,
+	}
+	return &sender, nil
+}
+
+func (p *EmailSender) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil {
+		return nil
+	}
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(time.Duration(p.config.Interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.Flush()
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}(p.stop, p.done)
+	return nil
+}
+
+// Stop ends the digest loop and mails the pending events
+func (p *EmailSender) Stop() error {
+	p.mu.Lock()
+	stop, done := p.stop, p.done
+	p.stop, p.done = nil, nil
+	p.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	p.Flush()
+	return nil
+}
+
+func (p *EmailSender) Send(fields map[string]interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.events) >= p.config.MaxEvents {
+		p.omitted++
+		return
+	}
+	p.events = append(p.events, EmailEvent{Time: time.Now(), Fields: fields})
+}
+
+// Flush mails the pending events as one digest
+func (p *EmailSender) Flush() {
+	p.mu.Lock()
+	events, omitted := p.events, p.omitted
+	p.events, p.omitted = nil, 0
+	p.mu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+	digest := EmailDigest{
+		Host:    p.host,
+		Count:   len(events) + omitted,
+		Omitted: omitted,
+		Start:   events[0].Time,
+		End:     events[len(events)-1].Time,
+		Events:  events,
+	}
+	msg, err := p.message(&digest)
+	if err != nil {
+		log.Infof("[EmailSender] Render digest error, err[%s]", err)
+		return
+	}
+	var auth smtp.Auth
+	if p.config.Username != "" {
+		host, _, _ := net.SplitHostPort(p.config.Server)
+		auth = smtp.PlainAuth("", p.config.Username, p.config.Password, host)
+	}
+	if err := p.sendMail(p.config.Server, auth, p.config.From, p.config.To, msg); err != nil {
+		log.Infof("[EmailSender] Send digest error, err[%s]", err)
+		p.fail(time.Now())
+		return
+	}
+	p.succeed()
+}
+
+func (p *EmailSender) message(digest *EmailDigest) ([]byte, error) {
+	var subject, body bytes.Buffer
+	if err := p.subject.Execute(&subject, digest); err != nil {
+		return nil, err
+	}
+	if err := p.body.Execute(&body, digest); err != nil {
+		return nil, err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", p.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(p.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.Replace(subject.String(), "\n", " ", -1))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.Replace(body.String(), "\n", "\r\n", -1))
+	return msg.Bytes(), nil
+}
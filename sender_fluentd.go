@@ -0,0 +1,169 @@
+package logpeck
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+type FluentdConfig struct {
+	Address       string `json:"Address"`
+	Tag           string `json:"Tag"`
+	BatchSize     int    `json:"BatchSize"`
+	FlushInterval int64  `json:"FlushInterval"`
+	AckTimeout    int64  `json:"AckTimeout"`
+}
+
+type fluentdEntry struct {
+	time   int64
+	record map[string]interface{}
+}
+
+// FluentdSender sends events to fluentd in forward mode over tcp, every
+// batch is retried until fluentd acknowledges its chunk
+type FluentdSender struct {
+	config FluentdConfig
+	ctx    context.Context
+	batch  *Batcher
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	healthStat
+}
+
+func NewFluentdSenderConfig(jbyte []byte) (FluentdConfig, error) {
+	fluentdConfig := FluentdConfig{}
+	err := json.Unmarshal(jbyte, &fluentdConfig)
+	if err != nil {
+		return fluentdConfig, err
+	}
+	if fluentdConfig.Address == "" {
+		return fluentdConfig, errors.New("Fluentd Address is required")
+	}
+	if fluentdConfig.Tag == "" {
+		fluentdConfig.Tag = "logpeck"
+	}
+	if fluentdConfig.FlushInterval <= 0 {
+		fluentdConfig.FlushInterval = 1
+	}
+	if fluentdConfig.AckTimeout <= 0 {
+		fluentdConfig.AckTimeout = 5
+	}
+	log.Infof("[NewFluentdSenderConfig]FluentdConfig: %v", fluentdConfig)
+	return fluentdConfig, nil
+}
+
+func NewFluentdSender(senderConfig *SenderConfig) (*FluentdSender, error) {
+	sender := FluentdSender{}
+	config, ok := senderConfig.Config.(FluentdConfig)
+	if !ok {
+		return &sender, errors.New("New FluentdSender error ")
+	}
+	sender = FluentdSender{
+		config: config,
+		ctx:    context.Background(),
+	}
+	sender.batch = NewBatcher(config.BatchSize, time.Duration(config.FlushInterval)*time.Second, sender.forward)
+	return &sender, nil
+}
+
+func (p *FluentdSender) Start(ctx context.Context) error {
+	p.ctx = ctx
+	p.batch.Start()
+	return nil
+}
+
+func (p *FluentdSender) Stop() error {
+	p.batch.Stop()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.close()
+	return nil
+}
+
+func (p *FluentdSender) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+		p.reader = nil
+	}
+}
+
+func (p *FluentdSender) Send(fields map[string]interface{}) {
+	p.batch.Add(fluentdEntry{time: time.Now().Unix(), record: fields})
+}
+
+// Message encodes entries as a forward mode message
+// [tag, [[time, record], ...], {"chunk": chunk}]
+func (p *FluentdSender) Message(entries []fluentdEntry, chunk string) []byte {
+	events := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		events = append(events, []interface{}{entry.time, entry.record})
+	}
+	var buf bytes.Buffer
+	msgpackEncode(&buf, []interface{}{p.config.Tag, events, map[string]interface{}{"chunk": chunk}})
+	return buf.Bytes()
+}
+
+func (p *FluentdSender) forward(items []interface{}) {
+	entries := make([]fluentdEntry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, item.(fluentdEntry))
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	chunk := base64.StdEncoding.EncodeToString(id)
+	message := p.Message(entries, chunk)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var err error
+	for retry := 0; retry < 2; retry++ {
+		if p.conn == nil {
+			dialer := &net.Dialer{Timeout: 5 * time.Second}
+			conn, err := dialer.DialContext(p.ctx, "tcp", p.config.Address)
+			if err != nil {
+				log.Infof("[FluentdSender] Dial %s error, err[%s]", p.config.Address, err)
+				p.fail(time.Now())
+				return
+			}
+			p.conn = conn
+			p.reader = bufio.NewReader(conn)
+		}
+		if err = p.exchange(message, chunk); err != nil {
+			log.Infof("[FluentdSender] Forward error, err[%s]", err)
+			p.close()
+			continue
+		}
+		p.succeed()
+		return
+	}
+	log.Infof("[FluentdSender] Drop %d events", len(entries))
+	p.fail(time.Now())
+}
+
+// exchange writes message and waits for the ack of chunk
+func (p *FluentdSender) exchange(message []byte, chunk string) error {
+	p.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := p.conn.Write(message); err != nil {
+		return err
+	}
+	p.conn.SetReadDeadline(time.Now().Add(time.Duration(p.config.AckTimeout) * time.Second))
+	resp, err := msgpackDecode(p.reader)
+	if err != nil {
+		return err
+	}
+	if ack, _ := resp.(map[string]interface{}); ack == nil || ack["ack"] != chunk {
+		return errors.New("unexpected ack")
+	}
+	return nil
+}
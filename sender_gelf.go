@@ -0,0 +1,244 @@
+package logpeck
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const gelfMaxChunks = 128
+
+var gelfFieldName *regexp.Regexp = regexp.MustCompile(`[^\w\.\-]`)
+
+type GelfConfig struct {
+	Address      string `json:"Address"`
+	Network      string `json:"Network"`
+	Compression  string `json:"Compression"`
+	ChunkSize    int    `json:"ChunkSize"`
+	Host         string `json:"Host"`
+	MessageField string `json:"MessageField"`
+	LevelField   string `json:"LevelField"`
+}
+
+// GelfSender sends GELF 1.1 messages to graylog, chunked and compressed
+// over udp or null byte delimited over tcp
+type GelfSender struct {
+	config GelfConfig
+	ctx    context.Context
+
+	mu   sync.Mutex
+	conn net.Conn
+	healthStat
+}
+
+func NewGelfSenderConfig(jbyte []byte) (GelfConfig, error) {
+	gelfConfig := GelfConfig{}
+	err := json.Unmarshal(jbyte, &gelfConfig)
+	if err != nil {
+		return gelfConfig, err
+	}
+	if gelfConfig.Address == "" {
+		return gelfConfig, errors.New("Gelf Address is required")
+	}
+	switch gelfConfig.Network {
+	case "":
+		gelfConfig.Network = "udp"
+	case "udp", "tcp":
+	default:
+		return gelfConfig, errors.New("Gelf Network error: " + gelfConfig.Network)
+	}
+	switch gelfConfig.Compression {
+	case "":
+		gelfConfig.Compression = "gzip"
+	case "gzip", "zlib", "none":
+	default:
+		return gelfConfig, errors.New("Gelf Compression error: " + gelfConfig.Compression)
+	}
+	if gelfConfig.Network == "tcp" && gelfConfig.Compression != "none" {
+		// graylog tcp inputs do not accept compressed frames
+		gelfConfig.Compression = "none"
+	}
+	if gelfConfig.ChunkSize <= 12 {
+		gelfConfig.ChunkSize = 1420
+	}
+	if gelfConfig.Host == "" {
+		gelfConfig.Host = GetHost()
+	}
+	if gelfConfig.MessageField == "" {
+		gelfConfig.MessageField = "_Log"
+	}
+	log.Infof("[NewGelfSenderConfig]GelfConfig: %v", gelfConfig)
+	return gelfConfig, nil
+}
+
+func NewGelfSender(senderConfig *SenderConfig) (*GelfSender, error) {
+	sender := GelfSender{}
+	config, ok := senderConfig.Config.(GelfConfig)
+	if !ok {
+		return &sender, errors.New("New GelfSender error ")
+	}
+	sender = GelfSender{
+		config: config,
+		ctx:    context.Background(),
+	}
+	return &sender, nil
+}
+
+func (p *GelfSender) Start(ctx context.Context) error {
+	p.ctx = ctx
+	return nil
+}
+
+func (p *GelfSender) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return nil
+}
+
+// Message converts fields to a GELF message, other fields become
+// additional fields prefixed by "_"
+func (p *GelfSender) Message(fields map[string]interface{}, now time.Time) map[string]interface{} {
+	message := map[string]interface{}{
+		"version":   "1.1",
+		"host":      p.config.Host,
+		"timestamp": float64(now.UnixNano()/int64(time.Millisecond)) / 1000,
+	}
+	if v, ok := fields[p.config.MessageField]; ok {
+		message["short_message"] = fmt.Sprintf("%v", v)
+	} else {
+		raw, _ := json.Marshal(fields)
+		message["short_message"] = string(raw)
+	}
+	if v, ok := fields[p.config.LevelField].(string); ok {
+		if level, ok := syslogSeverities[strings.ToLower(v)]; ok {
+			message["level"] = level
+		}
+	}
+	for k, v := range fields {
+		if k == p.config.MessageField {
+			continue
+		}
+		name := "_" + gelfFieldName.ReplaceAllString(strings.TrimLeft(k, "_"), "_")
+		if name == "_" || name == "_id" {
+			continue
+		}
+		message[name] = v
+	}
+	return message
+}
+
+func (p *GelfSender) compress(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch p.config.Compression {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		w.Write(raw)
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case "zlib":
+		w := zlib.NewWriter(&buf)
+		w.Write(raw)
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return raw, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// chunks splits payload into GELF chunks, each with a 12 bytes header
+func (p *GelfSender) chunks(payload []byte) ([][]byte, error) {
+	if len(payload) <= p.config.ChunkSize {
+		return [][]byte{payload}, nil
+	}
+	size := p.config.ChunkSize - 12
+	count := (len(payload) + size - 1) / size
+	if count > gelfMaxChunks {
+		return nil, errors.New("Gelf message too large")
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk := make([]byte, 0, 12+end-i*size)
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, payload[i*size:end]...)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+func (p *GelfSender) Send(fields map[string]interface{}) {
+	raw, err := json.Marshal(p.Message(fields, time.Now()))
+	if err != nil {
+		log.Infof("[GelfSender] Marshal error, err[%s]", err)
+		return
+	}
+	var frames [][]byte
+	if p.config.Network == "tcp" {
+		frames = [][]byte{append(raw, 0)}
+	} else {
+		payload, err := p.compress(raw)
+		if err != nil {
+			log.Infof("[GelfSender] Compress error, err[%s]", err)
+			return
+		}
+		if frames, err = p.chunks(payload); err != nil {
+			log.Infof("[GelfSender] Chunk error, err[%s]", err)
+			return
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for retry := 0; retry < 2; retry++ {
+		if p.conn == nil {
+			dialer := &net.Dialer{Timeout: 5 * time.Second}
+			conn, err := dialer.DialContext(p.ctx, p.config.Network, p.config.Address)
+			if err != nil {
+				log.Infof("[GelfSender] Dial %s error, err[%s]", p.config.Address, err)
+				p.fail(time.Now())
+				return
+			}
+			p.conn = conn
+		}
+		p.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		for _, frame := range frames {
+			if _, err = p.conn.Write(frame); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Infof("[GelfSender] Write error, err[%s]", err)
+			p.conn.Close()
+			p.conn = nil
+			continue
+		}
+		p.succeed()
+		return
+	}
+	p.fail(time.Now())
+}
@@ -2,22 +2,61 @@ package logpeck
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// InfluxDbConfig writes aggregations to Database. RetentionPolicy is the
+// retention policy written to, the default policy of Database if empty.
+// Precision is the precision of the written timestamps, "s", "ms", "us" or
+// "ns" (default). Writes are authenticated with Username and Password
+// (InfluxDB 1.x), or with Token (InfluxDB 2.x)
 type InfluxDbConfig struct {
-	Hosts    string `json:"Hosts"`
-	Database string `json:"Database"`
+	Hosts           string `json:"Hosts"`
+	Database        string `json:"Database"`
+	RetentionPolicy string `json:"RetentionPolicy"`
+	Precision       string `json:"Precision"`
+	Username        string `json:"Username"`
+	Password        string `json:"Password"`
+	Token           string `json:"Token"`
+
+	// Raw events, not aggregator results, are written as points of
+	// Measurement, "logpeck" by default, which may contain "{field}"
+	// templates. Tags and Fields select the event fields written as tags
+	// and fields, all the fields which are not tags by default. The point
+	// time is TimestampField parsed with TimestampFormat, or the send time
+	Measurement     string   `json:"Measurement"`
+	Tags            []string `json:"Tags"`
+	Fields          []string `json:"Fields"`
+	TimestampField  string   `json:"TimestampField"`
+	TimestampFormat string   `json:"TimestampFormat"`
+
+	Http HttpClientConfig `json:"Http"`
+}
+
+// influxPrecisions are the nanoseconds of each precision, and its name in
+// the write API
+var influxPrecisions = map[string]struct {
+	unit  int64
+	param string
+}{
+	"s":  {1e9, "s"},
+	"ms": {1e6, "ms"},
+	"us": {1e3, "u"},
+	"ns": {1, "n"},
 }
 
 type InfluxDbSender struct {
@@ -25,6 +64,13 @@ type InfluxDbSender struct {
 	mu            sync.Mutex
 	lastIndexName string
 	host          string
+	// coarse is set once a timestamp finer than Precision was truncated
+	coarse      int32
+	measurement *FieldTemplate
+	tags        map[string]bool
+	client      *http.Client
+	ctx         context.Context
+	httpStat
 }
 
 func NewInfluxDbSenderConfig(jbyte []byte) (InfluxDbConfig, error) {
@@ -33,7 +79,26 @@ func NewInfluxDbSenderConfig(jbyte []byte) (InfluxDbConfig, error) {
 	if err != nil {
 		return influxDbConfig, err
 	}
-	log.Infof("[NewInfluxDbSenderConfig]ElasticSearchConfig: %v", influxDbConfig)
+	if err := influxDbConfig.Http.Validate(); err != nil {
+		return influxDbConfig, err
+	}
+	if influxDbConfig.Precision == "" {
+		influxDbConfig.Precision = "ns"
+	}
+	if _, ok := influxPrecisions[influxDbConfig.Precision]; !ok {
+		return influxDbConfig, errors.New("Precision error: " + influxDbConfig.Precision)
+	}
+	if influxDbConfig.Token != "" && influxDbConfig.Username != "" {
+		return influxDbConfig, errors.New("InfluxDb auth error: Token and Username are exclusive")
+	}
+	redacted := influxDbConfig
+	if redacted.Password != "" {
+		redacted.Password = "***"
+	}
+	if redacted.Token != "" {
+		redacted.Token = "***"
+	}
+	log.Infof("[NewInfluxDbSenderConfig]InfluxDbConfig: %v", redacted)
 	return influxDbConfig, nil
 }
 
@@ -43,42 +108,225 @@ func NewInfluxDbSender(senderConfig *SenderConfig) (*InfluxDbSender, error) {
 	if !ok {
 		return &sender, errors.New("New InfluxDbSender error ")
 	}
+	config.Hosts = SocketHost(config.Hosts)
+	if config.Precision == "" {
+		config.Precision = "ns"
+	}
+	if config.Measurement == "" {
+		config.Measurement = "logpeck"
+	}
+	client, err := NewHttpClient(&config.Http, 10*time.Second)
+	if err != nil {
+		return &sender, err
+	}
 	sender = InfluxDbSender{
-		config: config,
+		config:      config,
+		measurement: NewFieldTemplate(config.Measurement),
+		tags:        make(map[string]bool),
+		client:      client,
+		ctx:         context.Background(),
+		httpStat:    newHttpStat(client),
+	}
+	for _, tag := range config.Tags {
+		sender.tags[tag] = true
 	}
 
-	conn, err := net.Dial("udp", "google.com:80")
+	// the address of the default route, no packet is sent
+	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
-		fmt.Println(err.Error())
-		return &sender, errors.New("Get InfluxDbSender host error")
+		log.Infof("[NewInfluxDbSender] Get local address error, use host name, err[%s]", err)
+		sender.host = GetHost()
+		return &sender, nil
 	}
 	defer conn.Close()
 	sender.host = strings.Split(conn.LocalAddr().String(), ":")[0]
 	return &sender, nil
 }
 
-func (p *InfluxDbSender) toInfluxdbLine(fields map[string]interface{}) string {
-	lines := ""
-	timestamp := fields["timestamp"].(int64)
+// timestampUnit returns the nanoseconds of the unit of an epoch timestamp,
+// guessed from its magnitude
+func timestampUnit(timestamp int64) int64 {
+	switch {
+	case timestamp < 1e11:
+		return 1e9
+	case timestamp < 1e14:
+		return 1e6
+	case timestamp < 1e17:
+		return 1e3
+	}
+	return 1
+}
 
-	for k, v := range fields {
-		if k == "timestamp" {
-			continue
+// influxTimestamp converts an epoch timestamp of seconds, milliseconds,
+// microseconds or nanoseconds to precision. It reports whether it was
+// truncated, which makes points of the same series collide
+func influxTimestamp(timestamp int64, precision string) (int64, bool, error) {
+	unit := influxPrecisions[precision].unit
+	if unit == 0 {
+		return 0, false, errors.New("Precision error: " + precision)
+	}
+	if timestamp < 0 {
+		return 0, false, fmt.Errorf("invalid timestamp %d", timestamp)
+	}
+	from := timestampUnit(timestamp)
+	if from >= unit {
+		return timestamp * (from / unit), false, nil
+	}
+	return timestamp / (unit / from), timestamp%(unit/from) != 0, nil
+}
+
+// toInfluxdbLine maps the series of an aggregator output to lines, its
+// tags are added to each line
+func (p *InfluxDbSender) toInfluxdbLine(result *AggregationResult) string {
+	lines := ""
+	timestamp, truncated, err := influxTimestamp(result.Timestamp, p.config.Precision)
+	if err != nil {
+		log.Warnf("[InfluxDbSender] %s, send the points without timestamp", err)
+	}
+	if truncated && atomic.CompareAndSwapInt32(&p.coarse, 0, 1) {
+		log.Warnf("[InfluxDbSender] Timestamp %d is finer than precision %s, points of the same time collide", result.Timestamp, p.config.Precision)
+	}
+	tags := ""
+	names := make([]string, 0, len(result.Tags))
+	for name := range result.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value := influxTagEscaper.Replace(fmt.Sprint(result.Tags[name])); value != "" && name != "host" {
+			tags += "," + influxTagEscaper.Replace(name) + "=" + value
 		}
-		aggregationResults := v.(map[string]float64)
-		line := k + ",host=" + p.host + " "
+	}
+
+	for k, aggregationResults := range result.Series {
+		line := k + ",host=" + p.host + tags + " "
 		for aggregation, result := range aggregationResults {
 			line += aggregation + "=" + strconv.FormatFloat(result, 'f', 3, 64) + ","
 		}
 		length := len(line)
-		line = line[0:length-1] + " " + strconv.FormatInt(timestamp*1000000000, 10) + "\n"
+		line = line[0 : length-1]
+		if err == nil {
+			line += " " + strconv.FormatInt(timestamp, 10)
+		}
+		line += "\n"
 		lines += line
 		log.Infof("[toInfluxdbLine] line is %s", line)
 	}
 	return lines
 }
 
-func (p *InfluxDbSender) Start() error {
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", "\\,", " ", "\\ ", "\n", "\\n")
+	influxTagEscaper         = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ", "\n", "\\n")
+	influxStringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`, "\n", "\\n")
+)
+
+// influxFieldValue formats value as a line protocol field value
+func influxFieldValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), true
+	case int:
+		return strconv.Itoa(v) + "i", true
+	case int64:
+		return strconv.FormatInt(v, 10) + "i", true
+	case int32:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return v.String() + "i", true
+		}
+		return v.String(), true
+	case string:
+		return `"` + influxStringEscaper.Replace(v) + `"`, true
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return `"` + influxStringEscaper.Replace(string(raw)) + `"`, true
+}
+
+// toInfluxdbPoint maps a raw event to a line, "" if no field is written
+func (p *InfluxDbSender) toInfluxdbPoint(fields map[string]interface{}) string {
+	var b strings.Builder
+	b.WriteString(influxMeasurementEscaper.Replace(p.measurement.Execute(fields, map[string]string{"host": p.host})))
+	tags := []string{"host=" + influxTagEscaper.Replace(p.host)}
+	for _, tag := range p.config.Tags {
+		value, ok := fields[tag]
+		if !ok || tag == "host" {
+			continue
+		}
+		str := influxTagEscaper.Replace(fmt.Sprint(value))
+		if str != "" {
+			tags = append(tags, influxTagEscaper.Replace(tag)+"="+str)
+		}
+	}
+	sort.Strings(tags[1:])
+	b.WriteString("," + strings.Join(tags, ","))
+
+	names := p.config.Fields
+	if len(names) == 0 {
+		for name := range fields {
+			if !p.tags[name] && name != p.config.TimestampField {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+	sep := " "
+	for _, name := range names {
+		value, ok := influxFieldValue(fields[name])
+		if !ok {
+			continue
+		}
+		b.WriteString(sep + influxTagEscaper.Replace(name) + "=" + value)
+		sep = ","
+	}
+	if sep == " " {
+		return ""
+	}
+
+	now := time.Now().UnixNano()
+	if value, ok := fields[p.config.TimestampField]; ok && p.config.TimestampField != "" {
+		if ts, err := ParseEventTime(value, p.config.TimestampFormat); err == nil {
+			now = ts * 1e6
+		} else {
+			log.Debugf("[InfluxDbSender] Parse %s error, err[%s]", p.config.TimestampField, err)
+		}
+	}
+	b.WriteString(" " + strconv.FormatInt(now/influxPrecisions[p.config.Precision].unit, 10) + "\n")
+	return b.String()
+}
+
+// authorize sets the credentials of the config on req
+func (p *InfluxDbSender) authorize(req *http.Request) {
+	if p.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+p.config.Token)
+	} else if p.config.Username != "" {
+		req.SetBasicAuth(p.config.Username, p.config.Password)
+	}
+}
+
+// writeQuery returns the query of write requests
+func (p *InfluxDbSender) writeQuery() string {
+	query := url.Values{}
+	query.Set("db", p.config.Database)
+	if p.config.RetentionPolicy != "" {
+		query.Set("rp", p.config.RetentionPolicy)
+	}
+	query.Set("precision", influxPrecisions[p.config.Precision].param)
+	return query.Encode()
+}
+
+func (p *InfluxDbSender) Start(ctx context.Context) error {
+	p.ctx = ctx
 	return nil
 }
 
@@ -87,16 +335,42 @@ func (p *InfluxDbSender) Stop() error {
 }
 
 func (p *InfluxDbSender) Send(fields map[string]interface{}) {
-	lines := p.toInfluxdbLine(fields)
+	p.SendAck(fields)
+}
+
+func (p *InfluxDbSender) SendAck(fields map[string]interface{}) error {
+	var lines string
+	if result, ok := ParseAggregationResult(fields); ok {
+		lines = p.toInfluxdbLine(result)
+	} else {
+		lines = p.toInfluxdbPoint(fields)
+	}
+	if lines == "" {
+		log.Debugf("[InfluxDbSender.Sender] Event without fields, drop it")
+		p.drop()
+		return nil
+	}
 	raw_data := []byte(lines)
-	body := ioutil.NopCloser(bytes.NewBuffer(raw_data))
-	uri := "http://" + p.config.Hosts + "/write?db=" + p.config.Database
-	resp, err := http.Post(uri, "application/json", body)
+	body := bytes.NewBuffer(raw_data)
+	uri := p.config.Http.Scheme() + "://" + p.config.Hosts + "/write?" + p.writeQuery()
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodPost, uri, body)
 	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	p.authorize(req)
+	resp, err := p.do(p.client, req)
+	if err != nil {
+		p.observe(err)
 		log.Infof("[InfluxDbSender.Sender] Post error, err[%s]", err)
-	} else {
-		resp_str, _ := httputil.DumpResponse(resp, true)
-		log.Infof("[InfluxDbSender.Sender] Response %s", resp_str)
+		return err
+	}
+	defer resp.Body.Close()
+	resp_str, _ := httputil.DumpResponse(resp, true)
+	log.Infof("[InfluxDbSender.Sender] Response %s", resp_str)
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		log.Warnf("[InfluxDbSender.Sender] Write to %s not authorized, check Username/Password or Token", p.config.Database)
 	}
 	//p.measurments.MeasurmentRecall(fields)
+	return p.accepted(resp)
 }
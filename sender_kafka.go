@@ -1,8 +1,10 @@
 package logpeck
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/Shopify/sarama"
 	log "github.com/Sirupsen/logrus"
 	sjson "github.com/bitly/go-simplejson"
@@ -22,6 +24,7 @@ type KafkaConfig struct {
 	ReturnErrors    bool                    `json:"ReturnErrors"`
 	Flush           KafkaFlush              `json:"Flush"`
 	Retry           KafkaRetry              `json:"Retry"`
+	KeyField        string                  `json:"KeyField"`
 }
 
 type KafkaFlush struct {
@@ -41,6 +44,7 @@ type KafkaSender struct {
 	mu            sync.Mutex
 	lastIndexName string
 	producer      sarama.SyncProducer
+	healthStat
 }
 
 func NewKafkaSenderConfig(jbyte []byte) (KafkaConfig, error) {
@@ -173,7 +177,7 @@ func GetKafkaConfig(cJson *sjson.Json) (kafkaConfig KafkaConfig, e error) {
 	return kafkaConfig, nil
 }
 
-func (p *KafkaSender) Start() error {
+func (p *KafkaSender) Start(ctx context.Context) error {
 	config := sarama.NewConfig()
 
 	config.Producer.MaxMessageBytes = p.config.MaxMessageBytes
@@ -221,27 +225,39 @@ func (p *KafkaSender) Stop() error {
 }
 
 func (p *KafkaSender) Send(fields map[string]interface{}) {
+	p.SendAck(fields)
+}
+
+func (p *KafkaSender) SendAck(fields map[string]interface{}) (err error) {
 	msg := &sarama.ProducerMessage{
 		Topic:     p.config.Topic,
 		Partition: int32(-1),
 		Key:       sarama.StringEncoder("key"),
 	}
+	if key, ok := fields[p.config.KeyField]; ok && p.config.KeyField != "" {
+		msg.Key = sarama.StringEncoder(fmt.Sprint(key))
+	}
 	value, err := json.Marshal(fields)
 	if err != nil {
 		log.Error("[Send] fields Marshal err:%v", err)
-		return
+		return nil
 	}
 	msg.Value = sarama.ByteEncoder(value)
 	defer func(){
-		if err:=recover();err!=nil{
-			log.Info("[KafkaSender]error:%v",err)
+		if r:=recover();r!=nil{
+			log.Info("[KafkaSender]error:%v",r)
+			err = fmt.Errorf("%v", r)
 		}
 	}()
 	paritition, offset, err := p.producer.SendMessage(msg)
 	if err != nil {
 		log.Error("Send Message Fail")
+		p.fail(time.Now())
+	} else {
+		p.succeed()
 	}
 
 	log.Debug("[Send]Partion = %d, offset = %d, value = %v \n", paritition, offset, fields)
 	//p.measurments.MeasurmentRecall(fields)
+	return err
 }
@@ -0,0 +1,228 @@
+package logpeck
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+type LumberjackConfig struct {
+	Address          string    `json:"Address"`
+	TLS              TLSConfig `json:"TLS"`
+	BatchSize        int       `json:"BatchSize"`
+	FlushInterval    int64     `json:"FlushInterval"`
+	CompressionLevel int       `json:"CompressionLevel"`
+	AckTimeout       int64     `json:"AckTimeout"`
+	MessageField     string    `json:"MessageField"`
+}
+
+// LumberjackSender sends events to a Logstash beats input with the
+// lumberjack v2 protocol, a batch is one window which Logstash acknowledges
+type LumberjackSender struct {
+	config    LumberjackConfig
+	tlsConfig *tls.Config
+	host      string
+	ctx       context.Context
+	batch     *Batcher
+
+	mu   sync.Mutex
+	conn net.Conn
+	healthStat
+}
+
+func NewLumberjackSenderConfig(jbyte []byte) (LumberjackConfig, error) {
+	lumberjackConfig := LumberjackConfig{}
+	err := json.Unmarshal(jbyte, &lumberjackConfig)
+	if err != nil {
+		return lumberjackConfig, err
+	}
+	if lumberjackConfig.Address == "" {
+		return lumberjackConfig, errors.New("Lumberjack Address is required")
+	}
+	if lumberjackConfig.TLS.Enable {
+		if _, err := lumberjackConfig.TLS.Load(); err != nil {
+			return lumberjackConfig, err
+		}
+	}
+	if lumberjackConfig.CompressionLevel < 0 || lumberjackConfig.CompressionLevel > 9 {
+		return lumberjackConfig, fmt.Errorf("Lumberjack CompressionLevel error: %d", lumberjackConfig.CompressionLevel)
+	}
+	if lumberjackConfig.FlushInterval <= 0 {
+		lumberjackConfig.FlushInterval = 1
+	}
+	if lumberjackConfig.AckTimeout <= 0 {
+		lumberjackConfig.AckTimeout = 30
+	}
+	if lumberjackConfig.MessageField == "" {
+		lumberjackConfig.MessageField = "_Log"
+	}
+	log.Infof("[NewLumberjackSenderConfig]LumberjackConfig: %v", lumberjackConfig)
+	return lumberjackConfig, nil
+}
+
+func NewLumberjackSender(senderConfig *SenderConfig) (*LumberjackSender, error) {
+	sender := LumberjackSender{}
+	config, ok := senderConfig.Config.(LumberjackConfig)
+	if !ok {
+		return &sender, errors.New("New LumberjackSender error ")
+	}
+	sender = LumberjackSender{
+		config: config,
+		host:   GetHost(),
+		ctx:    context.Background(),
+	}
+	if config.TLS.Enable {
+		tlsConfig, err := config.TLS.Load()
+		if err != nil {
+			return &sender, err
+		}
+		sender.tlsConfig = tlsConfig
+	}
+	sender.batch = NewBatcher(config.BatchSize, time.Duration(config.FlushInterval)*time.Second, sender.publish)
+	return &sender, nil
+}
+
+func (p *LumberjackSender) Start(ctx context.Context) error {
+	p.ctx = ctx
+	p.batch.Start()
+	return nil
+}
+
+func (p *LumberjackSender) Stop() error {
+	p.batch.Stop()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return nil
+}
+
+func (p *LumberjackSender) Send(fields map[string]interface{}) {
+	p.batch.Add(p.Event(fields, time.Now()))
+}
+
+// Event converts fields to a beats event, MessageField becomes "message"
+func (p *LumberjackSender) Event(fields map[string]interface{}, now time.Time) map[string]interface{} {
+	event := map[string]interface{}{
+		"@timestamp": now.UTC().Format("2006-01-02T15:04:05.000Z"),
+		"@metadata":  map[string]interface{}{"beat": "logpeck"},
+		"host":       map[string]interface{}{"name": p.host},
+	}
+	for k, v := range fields {
+		if k == p.config.MessageField {
+			event["message"] = v
+			continue
+		}
+		event[k] = v
+	}
+	return event
+}
+
+// Frames encodes events as a window frame followed by json data frames,
+// compressed into one frame if CompressionLevel is set
+func (p *LumberjackSender) Frames(events []interface{}) ([]byte, error) {
+	var data bytes.Buffer
+	for i, event := range events {
+		raw, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		data.Write([]byte{'2', 'J'})
+		binary.Write(&data, binary.BigEndian, uint32(i+1))
+		binary.Write(&data, binary.BigEndian, uint32(len(raw)))
+		data.Write(raw)
+	}
+	var frames bytes.Buffer
+	frames.Write([]byte{'2', 'W'})
+	binary.Write(&frames, binary.BigEndian, uint32(len(events)))
+	if p.config.CompressionLevel == 0 {
+		frames.Write(data.Bytes())
+		return frames.Bytes(), nil
+	}
+	var compressed bytes.Buffer
+	w, _ := zlib.NewWriterLevel(&compressed, p.config.CompressionLevel)
+	w.Write(data.Bytes())
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	frames.Write([]byte{'2', 'C'})
+	binary.Write(&frames, binary.BigEndian, uint32(compressed.Len()))
+	frames.Write(compressed.Bytes())
+	return frames.Bytes(), nil
+}
+
+func (p *LumberjackSender) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if p.tlsConfig != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: p.tlsConfig}
+		return tlsDialer.DialContext(p.ctx, "tcp", p.config.Address)
+	}
+	return dialer.DialContext(p.ctx, "tcp", p.config.Address)
+}
+
+func (p *LumberjackSender) publish(events []interface{}) {
+	frames, err := p.Frames(events)
+	if err != nil {
+		log.Infof("[LumberjackSender] Encode error, err[%s]", err)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for retry := 0; retry < 2; retry++ {
+		if p.conn == nil {
+			conn, err := p.dial()
+			if err != nil {
+				log.Infof("[LumberjackSender] Dial %s error, err[%s]", p.config.Address, err)
+				p.fail(time.Now())
+				return
+			}
+			p.conn = conn
+		}
+		if err = p.exchange(frames, uint32(len(events))); err != nil {
+			log.Infof("[LumberjackSender] Publish error, err[%s]", err)
+			p.conn.Close()
+			p.conn = nil
+			continue
+		}
+		p.succeed()
+		return
+	}
+	log.Infof("[LumberjackSender] Drop %d events", len(events))
+	p.fail(time.Now())
+}
+
+// exchange writes the frames of a window and waits until its last event is
+// acknowledged. Logstash acks a lower sequence as keepalive while it is
+// still processing the window, which extends the deadline
+func (p *LumberjackSender) exchange(frames []byte, count uint32) error {
+	p.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := p.conn.Write(frames); err != nil {
+		return err
+	}
+	ack := make([]byte, 6)
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(time.Duration(p.config.AckTimeout) * time.Second))
+		if _, err := io.ReadFull(p.conn, ack); err != nil {
+			return err
+		}
+		if ack[0] != '2' || ack[1] != 'A' {
+			return fmt.Errorf("unexpected frame %q", ack[:2])
+		}
+		if binary.BigEndian.Uint32(ack[2:]) >= count {
+			return nil
+		}
+	}
+}
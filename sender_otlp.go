@@ -0,0 +1,236 @@
+package logpeck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OtlpProtocolHttpJson is the only Protocol supported
+const OtlpProtocolHttpJson = "http/json"
+
+type OtlpConfig struct {
+	Endpoint           string            `json:"Endpoint"`
+	Protocol           string            `json:"Protocol"`
+	Headers            map[string]string `json:"Headers"`
+	ServiceName        string            `json:"ServiceName"`
+	ResourceAttributes map[string]string `json:"ResourceAttributes"`
+	BatchSize          int               `json:"BatchSize"`
+	FlushInterval      int64             `json:"FlushInterval"`
+
+	Http HttpClientConfig `json:"Http"`
+}
+
+// OtlpSender exports events as OpenTelemetry LogRecords and aggregator
+// output as gauge metrics, with the OTLP/HTTP json encoding
+type OtlpSender struct {
+	config   OtlpConfig
+	client   *http.Client
+	ctx      context.Context
+	resource map[string]interface{}
+	logs     *Batcher
+	metrics  *Batcher
+	httpStat
+}
+
+func NewOtlpSenderConfig(jbyte []byte) (OtlpConfig, error) {
+	otlpConfig := OtlpConfig{}
+	err := json.Unmarshal(jbyte, &otlpConfig)
+	if err != nil {
+		return otlpConfig, err
+	}
+	if err := otlpConfig.Http.Validate(); err != nil {
+		return otlpConfig, err
+	}
+	if otlpConfig.Endpoint == "" {
+		otlpConfig.Endpoint = "http://localhost:4318"
+	}
+	if otlpConfig.Protocol == "" {
+		otlpConfig.Protocol = OtlpProtocolHttpJson
+	}
+	if otlpConfig.Protocol != OtlpProtocolHttpJson {
+		return otlpConfig, errors.New("OTLP protocol not supported: " + otlpConfig.Protocol)
+	}
+	if otlpConfig.ServiceName == "" {
+		otlpConfig.ServiceName = "logpeck"
+	}
+	log.Infof("[NewOtlpSenderConfig]OtlpConfig: %v", otlpConfig)
+	return otlpConfig, nil
+}
+
+func NewOtlpSender(senderConfig *SenderConfig) (*OtlpSender, error) {
+	sender := OtlpSender{}
+	config, ok := senderConfig.Config.(OtlpConfig)
+	if !ok {
+		return &sender, errors.New("New OtlpSender error ")
+	}
+	attributes := map[string]interface{}{
+		"service.name": config.ServiceName,
+		"host.name":    GetHost(),
+	}
+	for k, v := range config.ResourceAttributes {
+		attributes[k] = v
+	}
+	if strings.HasPrefix(config.Endpoint, unixSocketScheme) {
+		config.Endpoint = "http://" + SocketHost(config.Endpoint)
+	}
+	client, err := NewHttpClient(&config.Http, 10*time.Second)
+	if err != nil {
+		return &sender, err
+	}
+	sender = OtlpSender{
+		config:   config,
+		client:   client,
+		ctx:      context.Background(),
+		httpStat: newHttpStat(client),
+		resource: map[string]interface{}{"attributes": otlpAttributes(attributes)},
+	}
+	interval := time.Duration(config.FlushInterval) * time.Second
+	sender.logs = NewBatcher(config.BatchSize, interval, sender.postLogs)
+	sender.metrics = NewBatcher(config.BatchSize, interval, sender.postMetrics)
+	return &sender, nil
+}
+
+func otlpValue(v interface{}) map[string]interface{} {
+	switch value := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": value}
+	case bool:
+		return map[string]interface{}{"boolValue": value}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(value)}
+	case float64:
+		return map[string]interface{}{"doubleValue": value}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprintf("%v", value)}
+	}
+}
+
+func otlpAttributes(fields map[string]interface{}) []map[string]interface{} {
+	attributes := []map[string]interface{}{}
+	for k, v := range fields {
+		attributes = append(attributes, map[string]interface{}{"key": k, "value": otlpValue(v)})
+	}
+	return attributes
+}
+
+func (p *OtlpSender) Start(ctx context.Context) error {
+	p.ctx = ctx
+	p.logs.Start()
+	p.metrics.Start()
+	return nil
+}
+
+func (p *OtlpSender) Stop() error {
+	p.logs.Stop()
+	p.metrics.Stop()
+	return nil
+}
+
+func (p *OtlpSender) Send(fields map[string]interface{}) {
+	result, ok := ParseAggregationResult(fields)
+	if !ok {
+		now := strconv.FormatInt(time.Now().UnixNano(), 10)
+		attributes := map[string]interface{}{}
+		var body interface{}
+		for k, v := range fields {
+			if k == "_Log" {
+				body = v
+				continue
+			}
+			attributes[k] = v
+		}
+		if body == nil {
+			raw, _ := json.Marshal(fields)
+			body = string(raw)
+		}
+		p.logs.Add(map[string]interface{}{
+			"timeUnixNano":         now,
+			"observedTimeUnixNano": now,
+			"body":                 otlpValue(body),
+			"attributes":           otlpAttributes(attributes),
+		})
+		return
+	}
+	timeUnixNano := strconv.FormatInt(result.Timestamp*int64(time.Second), 10)
+	for series, aggregations := range result.Series {
+		measurement, tags := splitSeries(series)
+		attributes := map[string]interface{}{}
+		for k, v := range tags {
+			attributes[k] = v
+		}
+		for aggregation, value := range aggregations {
+			p.metrics.Add(map[string]interface{}{
+				"name": measurement + "." + aggregation,
+				"gauge": map[string]interface{}{
+					"dataPoints": []map[string]interface{}{{
+						"timeUnixNano": timeUnixNano,
+						"asDouble":     value,
+						"attributes":   otlpAttributes(attributes),
+					}},
+				},
+			})
+		}
+	}
+}
+
+func (p *OtlpSender) post(path string, body interface{}) {
+	raw_data, err := json.Marshal(body)
+	if err != nil {
+		log.Errorf("[OtlpSender] Marshal error, err[%s]", err)
+		return
+	}
+	url := strings.TrimRight(p.config.Endpoint, "/") + path
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodPost, url, bytes.NewBuffer(raw_data))
+	if err != nil {
+		log.Infof("[OtlpSender] New request error, err[%s]", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range p.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.do(p.client, req)
+	if err != nil {
+		p.observe(err)
+		log.Infof("[OtlpSender] Post error, err[%s]", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		resp_str, _ := ioutil.ReadAll(resp.Body)
+		log.Infof("[OtlpSender] Post %s status %d, response %s", url, resp.StatusCode, resp_str)
+	}
+}
+
+func (p *OtlpSender) scope() map[string]interface{} {
+	return map[string]interface{}{"name": "logpeck", "version": VersionString}
+}
+
+func (p *OtlpSender) postLogs(items []interface{}) {
+	p.post("/v1/logs", map[string]interface{}{
+		"resourceLogs": []interface{}{map[string]interface{}{
+			"resource":  p.resource,
+			"scopeLogs": []interface{}{map[string]interface{}{"scope": p.scope(), "logRecords": items}},
+		}},
+	})
+}
+
+func (p *OtlpSender) postMetrics(items []interface{}) {
+	p.post("/v1/metrics", map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource":     p.resource,
+			"scopeMetrics": []interface{}{map[string]interface{}{"scope": p.scope(), "metrics": items}},
+		}},
+	})
+}
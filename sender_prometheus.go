@@ -0,0 +1,25 @@
+package logpeck
+
+import (
+	"context"
+)
+
+// PrometheusSender pushes nothing, the latest aggregation results of the
+// task are scraped from /metrics/tasks instead
+type PrometheusSender struct {
+}
+
+func NewPrometheusSender(senderConfig *SenderConfig) (*PrometheusSender, error) {
+	return &PrometheusSender{}, nil
+}
+
+func (p *PrometheusSender) Start(ctx context.Context) error {
+	return nil
+}
+
+func (p *PrometheusSender) Stop() error {
+	return nil
+}
+
+func (p *PrometheusSender) Send(fields map[string]interface{}) {
+}
@@ -0,0 +1,238 @@
+package logpeck
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var syslogFacilities map[string]int = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities map[string]int = map[string]int{
+	"emerg": 0, "panic": 0, "fatal": 0, "alert": 1, "crit": 2, "critical": 2,
+	"err": 3, "error": 3, "warn": 4, "warning": 4, "notice": 5,
+	"info": 6, "informational": 6, "debug": 7, "trace": 7,
+}
+
+type SyslogConfig struct {
+	Address       string            `json:"Address"`
+	Network       string            `json:"Network"`
+	AppName       string            `json:"AppName"`
+	Facility      string            `json:"Facility"`
+	FacilityField string            `json:"FacilityField"`
+	SeverityField string            `json:"SeverityField"`
+	SeverityMap   map[string]string `json:"SeverityMap"`
+	MessageField  string            `json:"MessageField"`
+	CAFile        string            `json:"CAFile"`
+	SkipVerify    bool              `json:"SkipVerify"`
+}
+
+// SyslogSender forwards events as RFC5424 messages, with octet counting
+// framing (RFC6587) over tcp and tls
+type SyslogSender struct {
+	config    SyslogConfig
+	host      string
+	tlsConfig *tls.Config
+	ctx       context.Context
+
+	mu   sync.Mutex
+	conn net.Conn
+	healthStat
+}
+
+func NewSyslogSenderConfig(jbyte []byte) (SyslogConfig, error) {
+	syslogConfig := SyslogConfig{}
+	err := json.Unmarshal(jbyte, &syslogConfig)
+	if err != nil {
+		return syslogConfig, err
+	}
+	if syslogConfig.Address == "" {
+		return syslogConfig, errors.New("Syslog Address is required")
+	}
+	switch syslogConfig.Network {
+	case "":
+		syslogConfig.Network = "tcp"
+	case "tcp", "tls", "udp":
+	default:
+		return syslogConfig, errors.New("Syslog Network error: " + syslogConfig.Network)
+	}
+	if syslogConfig.Facility == "" {
+		syslogConfig.Facility = "local0"
+	}
+	if _, ok := syslogFacilities[syslogConfig.Facility]; !ok {
+		return syslogConfig, errors.New("Syslog Facility error: " + syslogConfig.Facility)
+	}
+	if syslogConfig.AppName == "" {
+		syslogConfig.AppName = "logpeck"
+	}
+	if syslogConfig.MessageField == "" {
+		syslogConfig.MessageField = "_Log"
+	}
+	log.Infof("[NewSyslogSenderConfig]SyslogConfig: %v", syslogConfig)
+	return syslogConfig, nil
+}
+
+func NewSyslogSender(senderConfig *SenderConfig) (*SyslogSender, error) {
+	sender := SyslogSender{}
+	config, ok := senderConfig.Config.(SyslogConfig)
+	if !ok {
+		return &sender, errors.New("New SyslogSender error ")
+	}
+	sender = SyslogSender{
+		config: config,
+		host:   GetHost(),
+		ctx:    context.Background(),
+	}
+	if config.Network == "tls" {
+		sender.tlsConfig = &tls.Config{InsecureSkipVerify: config.SkipVerify}
+		if config.CAFile != "" {
+			pem, err := ioutil.ReadFile(config.CAFile)
+			if err != nil {
+				return &sender, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return &sender, errors.New("Syslog CAFile has no certificate")
+			}
+			sender.tlsConfig.RootCAs = pool
+		}
+	}
+	return &sender, nil
+}
+
+func (p *SyslogSender) Start(ctx context.Context) error {
+	p.ctx = ctx
+	return nil
+}
+
+func (p *SyslogSender) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return nil
+}
+
+func (p *SyslogSender) priority(fields map[string]interface{}) int {
+	facility := syslogFacilities[p.config.Facility]
+	if v, ok := fields[p.config.FacilityField].(string); ok {
+		if f, ok := syslogFacilities[strings.ToLower(v)]; ok {
+			facility = f
+		}
+	}
+	severity := syslogSeverities["info"]
+	if v, ok := fields[p.config.SeverityField].(string); ok {
+		if mapped, ok := p.config.SeverityMap[v]; ok {
+			v = mapped
+		}
+		if s, ok := syslogSeverities[strings.ToLower(v)]; ok {
+			severity = s
+		}
+	}
+	return facility*8 + severity
+}
+
+func escapeSDParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// sdName keeps the printable ascii allowed in a structured data name
+func sdName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c <= 32 || c >= 127 || c == '=' || c == ']' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 32 {
+		b = b[:32]
+	}
+	return string(b)
+}
+
+// Format returns the RFC5424 message of fields
+func (p *SyslogSender) Format(fields map[string]interface{}, now time.Time) string {
+	message := ""
+	if v, ok := fields[p.config.MessageField]; ok {
+		message = fmt.Sprintf("%v", v)
+	} else {
+		raw, _ := json.Marshal(fields)
+		message = string(raw)
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if k != p.config.MessageField {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	sd := "-"
+	if len(keys) > 0 {
+		sd = "[logpeck@32473"
+		for _, k := range keys {
+			sd += fmt.Sprintf(` %s="%s"`, sdName(k), escapeSDParam(fmt.Sprintf("%v", fields[k])))
+		}
+		sd += "]"
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - - %s %s", p.priority(fields),
+		now.Format("2006-01-02T15:04:05.000000Z07:00"), p.host, p.config.AppName, sd, message)
+}
+
+func (p *SyslogSender) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	switch p.config.Network {
+	case "tls":
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: p.tlsConfig}
+		return tlsDialer.DialContext(p.ctx, "tcp", p.config.Address)
+	default:
+		return dialer.DialContext(p.ctx, p.config.Network, p.config.Address)
+	}
+}
+
+func (p *SyslogSender) Send(fields map[string]interface{}) {
+	message := p.Format(fields, time.Now())
+	if p.config.Network != "udp" {
+		message = strconv.Itoa(len(message)) + " " + message
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for retry := 0; retry < 2; retry++ {
+		if p.conn == nil {
+			conn, err := p.dial()
+			if err != nil {
+				log.Infof("[SyslogSender] Dial %s error, err[%s]", p.config.Address, err)
+				p.fail(time.Now())
+				return
+			}
+			p.conn = conn
+		}
+		p.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := p.conn.Write([]byte(message)); err != nil {
+			log.Infof("[SyslogSender] Write error, err[%s]", err)
+			p.conn.Close()
+			p.conn = nil
+			continue
+		}
+		p.succeed()
+		return
+	}
+	p.fail(time.Now())
+}
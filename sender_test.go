@@ -1,8 +1,28 @@
 package logpeck
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestGetIndexName(*testing.T) {
@@ -22,7 +42,7 @@ func TestGetIndexName(*testing.T) {
 		}
 		proto := "logpeck"
 		Esender := sender.(*ElasticSearchSender)
-		if proto != Esender.GetIndexName() {
+		if indexName, _ := Esender.GetIndexName(nil); proto != indexName {
 			//panic(proto)
 		}
 	}
@@ -42,10 +62,901 @@ func TestGetIndexName(*testing.T) {
 			fmt.Printf("New sender error")
 		}
 		Esender := sender.(*ElasticSearchSender)
-		indexName := Esender.GetIndexName()
+		indexName, _ := Esender.GetIndexName(nil)
 		fmt.Printf("proto: %s, indexName: %s\n", config.Config.(ElasticSearchConfig).Index, indexName)
 		if len(indexName) != 18 {
 			panic(indexName)
 		}
 	}
 }
+
+func TestGetFieldTypes(*testing.T) {
+	config := ElasticSearchConfig{
+		Type: "perf",
+		Mapping: map[string]interface{}{
+			"perf": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"cost":   map[string]interface{}{"type": "long"},
+					"module": map[string]interface{}{"type": "keyword"},
+				},
+			},
+		},
+		Types: map[string]string{"module": "integer"},
+	}
+	fieldTypes := getFieldTypes(&config)
+	if fieldTypes["cost"] != "long" || fieldTypes["module"] != "integer" {
+		panic(fieldTypes)
+	}
+}
+
+func TestIndexMappingConflict(*testing.T) {
+	fieldTypes, err := ParseIndexMapping([]byte(`{"logs-1": {"mappings": {"properties": {
+		"cost": {"type": "long"},
+		"ok": {"type": "boolean"},
+		"user": {"properties": {"name": {"type": "keyword"}}}
+	}}}}`), "")
+	if err != nil || fieldTypes["cost"] != "long" || fieldTypes["user"] != "object" || fieldTypes["user.name"] != "keyword" {
+		panic(fieldTypes)
+	}
+	sender := &ElasticSearchSender{
+		config:     ElasticSearchConfig{MappingCheck: MappingCheckCoerce},
+		indexTypes: fieldTypes,
+		conflicts:  make(map[string]bool),
+	}
+	fields := map[string]interface{}{"cost": "12", "ok": "maybe", "user": "bob", "other": "x"}
+	sender.checkMapping(fields)
+	if fields["cost"] != int64(12) || len(fields) != 2 || !sender.conflicts["ok"] || !sender.conflicts["user"] {
+		panic(fields)
+	}
+	sender.config.MappingCheck = MappingCheckWarn
+	fields = map[string]interface{}{"cost": "abc"}
+	sender.checkMapping(fields)
+	if fields["cost"] != "abc" || !sender.conflicts["cost"] {
+		panic(fields)
+	}
+}
+
+func TestWritePrometheusMetrics(*testing.T) {
+	aggregations := map[string]map[string]interface{}{
+		"nginx": {
+			"timestamp":                        int64(30),
+			`nginx_cost,url=/a\,b,upstream=u1`: map[string]float64{"cnt": 3, "p99": 1.5},
+		},
+	}
+	var buf bytes.Buffer
+	WritePrometheusMetrics(&buf, aggregations)
+	expected := `# HELP logpeck_aggregation Latest aggregation results of logpeck tasks.
+# TYPE logpeck_aggregation gauge
+logpeck_aggregation{task="nginx",measurement="nginx_cost",upstream="u1",url="/a,b",aggregation="cnt"} 3
+logpeck_aggregation{task="nginx",measurement="nginx_cost",upstream="u1",url="/a,b",aggregation="p99"} 1.5
+`
+	if buf.String() != expected {
+		panic(buf.String())
+	}
+}
+
+func TestDatadogSender(*testing.T) {
+	config := SenderConfig{
+		Name:   "datadog",
+		Config: DatadogConfig{ApiKey: "key", Site: "datadoghq.com", Tags: []string{"env:test"}, MetricPrefix: "logpeck."},
+	}
+	sender, err := NewSender(&config)
+	if err != nil {
+		panic(err)
+	}
+	ddSender := sender.(*DatadogSender)
+	ddSender.Send(map[string]interface{}{"_Log": "hello"})
+	ddSender.Send(map[string]interface{}{
+		"timestamp":           int64(30),
+		"nginx_cost,api=/get": map[string]float64{"cnt": 3},
+	})
+	if len(ddSender.logs.items) != 1 || len(ddSender.metrics.items) != 1 {
+		panic(ddSender)
+	}
+	entry := ddSender.logs.items[0].(map[string]interface{})
+	if entry["message"] != "hello" || entry["ddtags"] != "env:test" {
+		panic(entry)
+	}
+	series := ddSender.metrics.items[0].(map[string]interface{})
+	if series["metric"] != "logpeck.nginx_cost.cnt" || len(series["tags"].([]string)) != 2 {
+		panic(series)
+	}
+}
+
+func TestOtlpSender(*testing.T) {
+	received := make(map[string]map[string]interface{})
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make(map[string]interface{})
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		received[r.URL.Path] = body
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	jbyte := []byte(`{"Endpoint":"` + server.URL + `","ServiceName":"test"}`)
+	otlpConfig, err := NewOtlpSenderConfig(jbyte)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := NewOtlpSenderConfig([]byte(`{"Protocol":"grpc"}`)); err == nil {
+		panic("only http/json is supported")
+	}
+	sender, err := NewSender(&SenderConfig{Name: "otlp", Config: otlpConfig})
+	if err != nil {
+		panic(err)
+	}
+	sender.Start(context.Background())
+	sender.Send(map[string]interface{}{"_Log": "hello", "level": "ERROR"})
+	sender.Send(map[string]interface{}{
+		"timestamp":           int64(30),
+		"nginx_cost,api=/get": map[string]float64{"p99": 1.5},
+	})
+	sender.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	logs, _ := json.Marshal(received["/v1/logs"])
+	if !strings.Contains(string(logs), `"body":{"stringValue":"hello"}`) ||
+		!strings.Contains(string(logs), `"key":"service.name","value":{"stringValue":"test"}`) {
+		panic(string(logs))
+	}
+	metrics, _ := json.Marshal(received["/v1/metrics"])
+	if !strings.Contains(string(metrics), `"name":"nginx_cost.p99"`) ||
+		!strings.Contains(string(metrics), `"asDouble":1.5`) ||
+		!strings.Contains(string(metrics), `"timeUnixNano":"30000000000"`) {
+		panic(string(metrics))
+	}
+}
+
+func TestZabbixSender(*testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer ln.Close()
+	requests := make(chan map[string]interface{}, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, 13)
+		io.ReadFull(conn, header)
+		body := make([]byte, binary.LittleEndian.Uint64(header[5:]))
+		io.ReadFull(conn, body)
+		request := make(map[string]interface{})
+		json.Unmarshal(body, &request)
+		requests <- request
+		resp := []byte(`{"response":"success","info":"processed: 1; failed: 0"}`)
+		length := make([]byte, 8)
+		binary.LittleEndian.PutUint64(length, uint64(len(resp)))
+		conn.Write(append(append([]byte("ZBXD\x01"), length...), resp...))
+	}()
+
+	zabbixConfig, err := NewZabbixSenderConfig([]byte(`{"Server":"` + ln.Addr().String() + `","Host":"web","KeyPrefix":"logpeck."}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, err := NewSender(&SenderConfig{Name: "zabbix", Config: zabbixConfig})
+	if err != nil {
+		panic(err)
+	}
+	sender.Send(map[string]interface{}{
+		"timestamp":                 int64(30),
+		"nginx_cost,api=/get,dc=bj": map[string]float64{"p99": 1.5},
+	})
+	request := <-requests
+	items := request["data"].([]interface{})
+	item := items[0].(map[string]interface{})
+	if request["request"] != "sender data" || item["key"] != "logpeck.nginx_cost.p99[/get,bj]" ||
+		item["value"] != "1.5" || item["host"] != "web" || item["clock"] != float64(30) {
+		panic(request)
+	}
+}
+
+func TestSyslogSender(*testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer ln.Close()
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		length, _ := reader.ReadString(' ')
+		n, _ := strconv.Atoi(strings.TrimSpace(length))
+		message := make([]byte, n)
+		io.ReadFull(reader, message)
+		messages <- string(message)
+	}()
+
+	syslogConfig, err := NewSyslogSenderConfig([]byte(`{"Address":"` + ln.Addr().String() + `","SeverityField":"level","SeverityMap":{"E":"err"}}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, err := NewSender(&SenderConfig{Name: "syslog", Config: syslogConfig})
+	if err != nil {
+		panic(err)
+	}
+	defer sender.Stop()
+	sender.Send(map[string]interface{}{"_Log": "disk full", "level": "E", "path": `/a"b]`})
+	message := <-messages
+	// local0(16) * 8 + err(3)
+	if !strings.HasPrefix(message, "<131>1 ") ||
+		!strings.HasSuffix(message, ` logpeck - - [logpeck@32473 level="E" path="/a\"b\]"] disk full`) {
+		panic(message)
+	}
+}
+
+func TestGelfSender(*testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	gelfConfig, err := NewGelfSenderConfig([]byte(`{"Address":"` + conn.LocalAddr().String() + `","ChunkSize":64,"Host":"h1","LevelField":"level"}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, err := NewSender(&SenderConfig{Name: "gelf", Config: gelfConfig})
+	if err != nil {
+		panic(err)
+	}
+	defer sender.Stop()
+	sender.Send(map[string]interface{}{"_Log": strings.Repeat("disk full ", 30), "level": "warn", "path": "/a"})
+
+	var payload []byte
+	buf := make([]byte, 2048)
+	for count, seen := 1, 0; seen < count; seen++ {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			panic(err)
+		}
+		if buf[0] != 0x1e || buf[1] != 0x0f || int(buf[10]) != seen {
+			panic(buf[:12])
+		}
+		count = int(buf[11])
+		payload = append(payload, buf[12:n]...)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		panic(err)
+	}
+	message := map[string]interface{}{}
+	if err := json.NewDecoder(reader).Decode(&message); err != nil {
+		panic(err)
+	}
+	if message["version"] != "1.1" || message["host"] != "h1" || message["level"] != float64(4) ||
+		message["_path"] != "/a" || message["_level"] != "warn" || !strings.HasPrefix(message["short_message"].(string), "disk full") {
+		panic(message)
+	}
+}
+
+func TestFluentdSender(*testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+	messages := make(chan []interface{}, 2)
+	go func() {
+		for acked := false; !acked; {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			message, err := msgpackDecode(bufio.NewReader(conn))
+			if err != nil {
+				panic(err)
+			}
+			messages <- message.([]interface{})
+			// the first message is not acknowledged
+			if len(messages) == 2 {
+				chunk := message.([]interface{})[2].(map[string]interface{})["chunk"]
+				var buf bytes.Buffer
+				msgpackEncode(&buf, map[string]interface{}{"ack": chunk})
+				conn.Write(buf.Bytes())
+				acked = true
+			}
+			conn.Close()
+		}
+	}()
+
+	fluentdConfig, err := NewFluentdSenderConfig([]byte(`{"Address":"` + listener.Addr().String() + `","Tag":"app.log","BatchSize":2}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, err := NewSender(&SenderConfig{Name: "fluentd", Config: fluentdConfig})
+	if err != nil {
+		panic(err)
+	}
+	sender.Start(context.Background())
+	sender.Send(map[string]interface{}{"_Log": "a", "code": 500, "cost": 1.5})
+	sender.Send(map[string]interface{}{"_Log": "b", "tags": []string{"x"}, "up": true})
+	sender.Stop()
+
+	first, second := <-messages, <-messages
+	if first[0] != "app.log" || first[2].(map[string]interface{})["chunk"] != second[2].(map[string]interface{})["chunk"] {
+		panic(fmt.Sprint(first, second))
+	}
+	events := second[1].([]interface{})
+	if len(events) != 2 {
+		panic(events)
+	}
+	a := events[0].([]interface{})[1].(map[string]interface{})
+	b := events[1].([]interface{})[1].(map[string]interface{})
+	if a["_Log"] != "a" || a["code"] != int64(500) || a["cost"] != 1.5 ||
+		b["_Log"] != "b" || b["tags"].([]interface{})[0] != "x" || b["up"] != true {
+		panic(fmt.Sprint(a, b))
+	}
+	if !sender.(*FluentdSender).FailingSince().IsZero() {
+		panic(sender.(*FluentdSender).FailingSince())
+	}
+}
+
+func TestLumberjackSender(*testing.T) {
+	dir, _ := ioutil.TempDir("", "logpeck_tls")
+	defer os.RemoveAll(dir)
+	ca, caKey := writeTestCert(dir, "ca", nil, nil, true)
+	writeTestCert(dir, "server", ca, caKey, false)
+	serverCert, err := tls.LoadX509KeyPair(dir+"/server.pem", dir+"/server-key.pem")
+	if err != nil {
+		panic(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	if err != nil {
+		panic(err)
+	}
+	defer listener.Close()
+	windows := make(chan []map[string]interface{}, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, 6)
+		io.ReadFull(conn, header)
+		if string(header[:2]) != "2W" {
+			panic(header)
+		}
+		count := binary.BigEndian.Uint32(header[2:])
+		io.ReadFull(conn, header)
+		if string(header[:2]) != "2C" {
+			panic(header)
+		}
+		reader, err := zlib.NewReader(io.LimitReader(conn, int64(binary.BigEndian.Uint32(header[2:]))))
+		if err != nil {
+			panic(err)
+		}
+		var events []map[string]interface{}
+		for i := uint32(1); i <= count; i++ {
+			frame := make([]byte, 10)
+			io.ReadFull(reader, frame)
+			if string(frame[:2]) != "2J" || binary.BigEndian.Uint32(frame[2:]) != i {
+				panic(frame)
+			}
+			raw := make([]byte, binary.BigEndian.Uint32(frame[6:]))
+			io.ReadFull(reader, raw)
+			event := map[string]interface{}{}
+			json.Unmarshal(raw, &event)
+			events = append(events, event)
+			conn.Write([]byte{'2', 'A', 0, 0, 0, byte(i)})
+		}
+		windows <- events
+	}()
+
+	lumberjackConfig, err := NewLumberjackSenderConfig([]byte(`{"Address":"` + listener.Addr().String() + `","CompressionLevel":3,"BatchSize":2,"TLS":{"Enable":true,"CAFile":"` + dir + `/ca.pem"}}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, err := NewSender(&SenderConfig{Name: "lumberjack", Config: lumberjackConfig})
+	if err != nil {
+		panic(err)
+	}
+	sender.Start(context.Background())
+	sender.Send(map[string]interface{}{"_Log": "a", "code": 500})
+	sender.Send(map[string]interface{}{"_Log": "b"})
+	events := <-windows
+	sender.Stop()
+	if len(events) != 2 || events[0]["message"] != "a" || events[0]["code"] != float64(500) || events[1]["message"] != "b" ||
+		events[0]["@metadata"].(map[string]interface{})["beat"] != "logpeck" || events[0]["@timestamp"] == nil {
+		panic(events)
+	}
+	if !sender.(*LumberjackSender).FailingSince().IsZero() {
+		panic(sender.(*LumberjackSender).FailingSince())
+	}
+}
+
+func TestEmailSender(*testing.T) {
+	emailConfig, err := NewEmailSenderConfig([]byte(`{"Server":"127.0.0.1:25","From":"a@example.com","To":["b@example.com"],"MaxEvents":2,"Interval":3600}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, err := NewEmailSender(&SenderConfig{Name: "email", Config: emailConfig})
+	if err != nil {
+		panic(err)
+	}
+	var mails []string
+	sender.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		if addr != "127.0.0.1:25" || from != "a@example.com" || to[0] != "b@example.com" {
+			panic(addr)
+		}
+		mails = append(mails, string(msg))
+		return nil
+	}
+	sender.Start(context.Background())
+	sender.Send(map[string]interface{}{"_Log": "panic: one"})
+	sender.Send(map[string]interface{}{"_Log": "panic: two"})
+	sender.Send(map[string]interface{}{"_Log": "panic: three"})
+	sender.Flush()
+	sender.Flush()
+	sender.Stop()
+	if len(mails) != 1 {
+		panic(mails)
+	}
+	if !strings.Contains(mails[0], "Subject: [logpeck] 3 events on ") ||
+		!strings.Contains(mails[0], "1 omitted") ||
+		!strings.Contains(mails[0], "\r\npanic: one\r\npanic: two\r\n") ||
+		strings.Contains(mails[0], "three") {
+		panic(mails[0])
+	}
+}
+
+func TestChatSender(*testing.T) {
+	var texts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["channel"] != "#ops" {
+			panic(body)
+		}
+		texts = append(texts, body["text"])
+	}))
+	defer server.Close()
+
+	chatConfig, err := NewChatSenderConfig([]byte(`{"Url":"` + server.URL + `","Channel":"#ops","Template":"{{.level}} {{._Log}}","RateLimit":2}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, err := NewSender(&SenderConfig{Name: "chat", Config: chatConfig})
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 4; i++ {
+		sender.Send(map[string]interface{}{"_Log": fmt.Sprintf("panic %d", i), "level": "E"})
+	}
+	if len(texts) != 2 || texts[0] != "E panic 0" || texts[1] != "E panic 1" {
+		panic(texts)
+	}
+
+	chat := sender.(*ChatSender)
+	if ok, suppressed := chat.allow(time.Now().Add(time.Minute)); !ok || suppressed != 2 {
+		panic(suppressed)
+	}
+}
+
+func TestElasticSearchIdField(*testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/test/log/") {
+			return
+		}
+		paths = append(paths, r.URL.EscapedPath())
+		if len(paths) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	esConfig, err := NewElasticSearchSenderConfig([]byte(`{"Hosts":["` + host + `"],"Index":"test","Type":"log","IdField":"_seq"}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, err := NewElasticSearchSender(&SenderConfig{Name: "elasticsearch", Config: esConfig})
+	if err != nil {
+		panic(err)
+	}
+	sender.Start(context.Background())
+	event := map[string]interface{}{"_Log": "hello", "_seq": "h1-12-0"}
+	if err := sender.SendAck(event); err == nil {
+		panic("503 confirmed")
+	}
+	if err := sender.SendAck(event); err != nil {
+		panic(err)
+	}
+	if len(paths) != 2 || paths[0] != paths[1] || paths[1] != "/test/log/h1-12-0" {
+		panic(paths)
+	}
+}
+
+func TestSenderCancel(*testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	esConfig, err := NewElasticSearchSenderConfig([]byte(`{"Hosts":["` + host + `"],"Index":"test","Type":"log"}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, err := NewElasticSearchSender(&SenderConfig{Name: "elasticsearch", Config: esConfig})
+	if err != nil {
+		panic(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sender.Start(ctx)
+	done := make(chan struct{})
+	go func() {
+		sender.Send(map[string]interface{}{"_Log": "hello"})
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		panic("send not canceled")
+	}
+	if sender.DroppedTotal() == 0 || sender.BreakerStates()[host] != BreakerClosed {
+		panic(fmt.Sprintf("%d %v", sender.DroppedTotal(), sender.BreakerStates()))
+	}
+}
+
+func TestElasticSearchRollover(*testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	var conditions map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/_alias/test":
+			w.WriteHeader(http.StatusNotFound)
+		case "/test/_rollover":
+			var body map[string]map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			conditions = body["conditions"]
+			w.Write([]byte(`{"rolled_over":true,"new_index":"test-000002"}`))
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	if _, err := NewElasticSearchSenderConfig([]byte(`{"Hosts":["` + host + `"],"Index":"test-%{+2006.01.02}","Type":"log","Rollover":{"MaxDocs":10}}`)); err == nil {
+		panic("date index accepted")
+	}
+	esConfig, err := NewElasticSearchSenderConfig([]byte(`{"Hosts":["` + host + `"],"Index":"test","Type":"log","Rollover":{"MaxSize":"1GB","MaxDocs":1000,"MaxAge":86400}}`))
+	if err != nil {
+		panic(err)
+	}
+	if esConfig.Rollover.CheckInterval != 60 {
+		panic(esConfig.Rollover)
+	}
+	sender, err := NewElasticSearchSender(&SenderConfig{Name: "elasticsearch", Config: esConfig})
+	if err != nil {
+		panic(err)
+	}
+	sender.Start(context.Background())
+	sender.Send(map[string]interface{}{"_Log": "hello"})
+	sender.Send(map[string]interface{}{"_Log": "hello"})
+	expected := []string{"HEAD /_alias/test", "PUT /test-000001", "PUT /test/_mappings/log", "POST /test/log", "POST /test/log"}
+	if fmt.Sprint(requests) != fmt.Sprint(expected) {
+		panic(requests)
+	}
+
+	sender.rolloverChecked = time.Now().Add(-time.Hour)
+	requests = nil
+	sender.Send(map[string]interface{}{"_Log": "hello"})
+	expected = []string{"POST /test/_rollover", "PUT /test/_mappings/log", "POST /test/log"}
+	if fmt.Sprint(requests) != fmt.Sprint(expected) {
+		panic(requests)
+	}
+	if conditions["max_size"] != "1073741824b" || conditions["max_docs"] != 1000.0 || conditions["max_age"] != "86400s" {
+		panic(conditions)
+	}
+}
+
+func TestElasticSearchWaitMapping(*testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == http.MethodPut && len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else if r.URL.Path == "/test" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"type":"resource_already_exists_exception"}}`))
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	esConfig, err := NewElasticSearchSenderConfig([]byte(`{"Hosts":["` + host + `"],"Index":"test","Type":"log","WaitMapping":true}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, err := NewElasticSearchSender(&SenderConfig{Name: "elasticsearch", Config: esConfig})
+	if err != nil {
+		panic(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sender.Start(ctx)
+	if _, err := sender.GetIndexName(nil); err == nil || sender.MappingReady("test") {
+		panic(err)
+	}
+	if _, err := sender.GetIndexName(nil); err == nil || len(requests) != 1 {
+		panic(requests)
+	}
+
+	start := time.Now()
+	if err := sender.SendAck(map[string]interface{}{"_Log": "hello"}); err != nil {
+		panic(err)
+	}
+	if time.Since(start) < 500*time.Millisecond || !sender.MappingReady("test") {
+		panic(time.Since(start))
+	}
+	expected := []string{"PUT /test", "PUT /test", "PUT /test/_mappings/log", "POST /test/log"}
+	if fmt.Sprint(requests) != fmt.Sprint(expected) {
+		panic(requests)
+	}
+
+	sender.mappings["test"] = &mappingState{retry: time.Now().Add(time.Hour)}
+	cancel()
+	if _, ok := sender.SendAck(map[string]interface{}{"_Log": "hello"}).(*MappingNotReadyError); !ok {
+		panic("send not held back")
+	}
+}
+
+func TestElasticSearchPipeline(*testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	var definition map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		if r.URL.Path == "/_ingest/pipeline/parse" {
+			json.NewDecoder(r.Body).Decode(&definition)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	if _, err := NewElasticSearchSenderConfig([]byte(`{"Hosts":["` + host + `"],"Index":"test","PipelineDefinition":{}}`)); err == nil {
+		panic("definition without name accepted")
+	}
+	esConfig, err := NewElasticSearchSenderConfig([]byte(`{"Hosts":["` + host + `"],"Index":"test","Type":"log","Pipeline":"parse",
+		"PipelineDefinition":{"processors":[{"grok":{"field":"_Log","patterns":["%{IP:client}"]}}]}}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, err := NewElasticSearchSender(&SenderConfig{Name: "elasticsearch", Config: esConfig})
+	if err != nil {
+		panic(err)
+	}
+	sender.Start(context.Background())
+	sender.Send(map[string]interface{}{"_Log": "127.0.0.1"})
+	sender.Send(map[string]interface{}{"_Log": "127.0.0.1"})
+	expected := []string{"PUT /_ingest/pipeline/parse", "PUT /test", "PUT /test/_mappings/log", "POST /test/log?pipeline=parse", "POST /test/log?pipeline=parse"}
+	if fmt.Sprint(requests) != fmt.Sprint(expected) {
+		panic(requests)
+	}
+	if processors, _ := definition["processors"].([]interface{}); len(processors) != 1 {
+		panic(definition)
+	}
+}
+
+func TestElasticSearchDualWrite(*testing.T) {
+	var mu sync.Mutex
+	docs := make(map[string]int)
+	handler := func(cluster string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if r.Method == http.MethodPost {
+				docs[cluster+" "+r.URL.Path]++
+			}
+		}
+	}
+	primary := httptest.NewServer(handler("primary"))
+	defer primary.Close()
+	secondary := httptest.NewServer(handler("secondary"))
+
+	host := strings.TrimPrefix(primary.URL, "http://")
+	secondaryHost := strings.TrimPrefix(secondary.URL, "http://")
+	if _, err := NewElasticSearchSenderConfig([]byte(`{"Hosts":["` + host + `"],"Index":"test","Secondary":{"Index":"a/b"}}`)); err == nil {
+		panic("invalid secondary accepted")
+	}
+	esConfig, err := NewElasticSearchSenderConfig([]byte(`{"Hosts":["` + host + `"],"Index":"test","Type":"log","Secondary":{"Hosts":["` + secondaryHost + `"]}}`))
+	if err != nil {
+		panic(err)
+	}
+	if esConfig.Secondary.Index != "test" || esConfig.Secondary.Hosts[0] != secondaryHost {
+		panic(esConfig.Secondary)
+	}
+	sender, err := NewElasticSearchSender(&SenderConfig{Name: "elasticsearch", Config: esConfig})
+	if err != nil {
+		panic(err)
+	}
+	sender.Start(context.Background())
+	sender.SendAck(map[string]interface{}{"_Log": "hello"})
+	if docs["primary /test/log"] != 1 || docs["secondary /test/log"] != 0 || sender.SecondaryStat().Enable {
+		panic(docs)
+	}
+
+	sender.SetDualWrite(true)
+	sender.SendAck(map[string]interface{}{"_Log": "hello"})
+	if docs["primary /test/log"] != 2 || docs["secondary /test/log"] != 1 {
+		panic(docs)
+	}
+
+	secondary.Close()
+	if err := sender.SendAck(map[string]interface{}{"_Log": "hello"}); err != nil {
+		panic(err)
+	}
+	if stat := sender.SecondaryStat(); stat.FailingSince == 0 || !sender.FailingSince().IsZero() {
+		panic(stat)
+	}
+	if _, ok := sender.BackendStats()[secondaryHost]; !ok {
+		panic(sender.BackendStats())
+	}
+}
+
+func TestInfluxDbPrecision(*testing.T) {
+	if _, err := NewInfluxDbSenderConfig([]byte(`{"Hosts":"127.0.0.1:8086","Database":"db","Precision":"m"}`)); err == nil {
+		panic("unknown precision accepted")
+	}
+	for _, c := range []struct {
+		timestamp int64
+		precision string
+		expected  int64
+		truncated bool
+	}{
+		{1500000000, "ns", 1500000000000000000, false},
+		{1500000000, "s", 1500000000, false},
+		{1500000000, "ms", 1500000000000, false},
+		{1500000000123, "s", 1500000000, true},
+		{1500000000123, "us", 1500000000123000, false},
+		{1500000000000000000, "ms", 1500000000000, false},
+	} {
+		timestamp, truncated, err := influxTimestamp(c.timestamp, c.precision)
+		if err != nil || timestamp != c.expected || truncated != c.truncated {
+			panic(fmt.Sprintf("%v %d %v %v", c, timestamp, truncated, err))
+		}
+	}
+
+	config, err := NewInfluxDbSenderConfig([]byte(`{"Hosts":"127.0.0.1:8086","Database":"db","RetentionPolicy":"one week","Precision":"s"}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, _ := NewInfluxDbSender(&SenderConfig{Name: "influxdb", Config: config})
+	if query := sender.writeQuery(); query != "db=db&precision=s&rp=one+week" {
+		panic(query)
+	}
+	result, _ := ParseAggregationResult(map[string]interface{}{"timestamp": int64(1500000000), "api_cost": map[string]float64{"cnt": 1}})
+	line := sender.toInfluxdbLine(result)
+	if !strings.HasSuffix(line, " cnt=1.000 1500000000\n") {
+		panic(line)
+	}
+}
+
+func TestInfluxDbAuth(*testing.T) {
+	var auth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		if user, password, ok := r.BasicAuth(); ok && (user != "logpeck" || password != "secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	if _, err := NewInfluxDbSenderConfig([]byte(`{"Hosts":"` + host + `","Database":"db","Username":"a","Token":"t"}`)); err == nil {
+		panic("token and username accepted")
+	}
+	event := map[string]interface{}{"timestamp": int64(1500000000), "api_cost": map[string]float64{"cnt": 1}}
+	for _, c := range []struct {
+		config   string
+		expected string
+	}{
+		{`"Username":"logpeck","Password":"secret"`, "Basic bG9ncGVjazpzZWNyZXQ="},
+		{`"Token":"t0k3n"`, "Token t0k3n"},
+		{`"Username":"logpeck"`, "Basic bG9ncGVjazo="},
+	} {
+		config, err := NewInfluxDbSenderConfig([]byte(`{"Hosts":"` + host + `","Database":"db",` + c.config + `}`))
+		if err != nil {
+			panic(err)
+		}
+		sender, _ := NewInfluxDbSender(&SenderConfig{Name: "influxdb", Config: config})
+		sender.Start(context.Background())
+		if err := sender.SendAck(event); err != nil {
+			panic(err)
+		}
+		if auth[len(auth)-1] != c.expected {
+			panic(auth)
+		}
+	}
+}
+
+func TestInfluxDbRawEvents(*testing.T) {
+	config, err := NewInfluxDbSenderConfig([]byte(`{"Hosts":"127.0.0.1:8086","Database":"db","Precision":"ms",
+		"Measurement":"req_{app}","Tags":["status","region"],"TimestampField":"ts","TimestampFormat":"epoch_s"}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, _ := NewInfluxDbSender(&SenderConfig{Name: "influxdb", Config: config})
+	sender.host = "web 1"
+	line := sender.toInfluxdbPoint(map[string]interface{}{
+		"app": "api", "status": "200", "region": "eu,west", "ts": "1500000000",
+		"cost": 1.5, "bytes": int64(512), "ok": true, "msg": `say "hi"`,
+	})
+	expected := `req_api,host=web\ 1,region=eu\,west,status=200 app="api",bytes=512i,cost=1.5,msg="say \"hi\"",ok=true 1500000000000` + "\n"
+	if line != expected {
+		panic(line)
+	}
+
+	config.Fields = []string{"cost", "missing"}
+	sender, _ = NewInfluxDbSender(&SenderConfig{Name: "influxdb", Config: config})
+	if line = sender.toInfluxdbPoint(map[string]interface{}{"app": "api", "cost": 2.0}); !strings.HasPrefix(line, "req_api,host=") || !strings.Contains(line, " cost=2 ") {
+		panic(line)
+	}
+	if line = sender.toInfluxdbPoint(map[string]interface{}{"app": "api"}); line != "" {
+		panic(line)
+	}
+}
+
+func TestSenderElasticSearchDeadHost(*testing.T) {
+	var docs int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/test/log") {
+			atomic.AddInt32(&docs, 1)
+		}
+	}))
+	defer server.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	dead := listener.Addr().String()
+	listener.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	esConfig, err := NewElasticSearchSenderConfig([]byte(`{"Hosts":["` + dead + `","` + host + `"],"HostSelection":"roundrobin",
+		"Index":"test","Type":"log","Http":{"BreakerThreshold":1,"BreakerCooldown":30}}`))
+	if err != nil {
+		panic(err)
+	}
+	sender, err := NewElasticSearchSender(&SenderConfig{Name: "elasticsearch", Config: esConfig})
+	if err != nil {
+		panic(err)
+	}
+	sender.Start(context.Background())
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		sender.Send(map[string]interface{}{"_Log": "hello"})
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		panic(elapsed)
+	}
+	if atomic.LoadInt32(&docs) < 5 {
+		panic(docs)
+	}
+}
@@ -0,0 +1,191 @@
+package logpeck
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var zabbixHeader []byte = []byte("ZBXD\x01")
+
+type ZabbixConfig struct {
+	Server    string `json:"Server"`
+	Host      string `json:"Host"`
+	KeyPrefix string `json:"KeyPrefix"`
+	Timeout   int64  `json:"Timeout"`
+}
+
+// ZabbixSender sends aggregator output to a zabbix server as trapper items,
+// like zabbix_sender does
+type ZabbixSender struct {
+	config  ZabbixConfig
+	timeout time.Duration
+	ctx     context.Context
+	healthStat
+}
+
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+func NewZabbixSenderConfig(jbyte []byte) (ZabbixConfig, error) {
+	zabbixConfig := ZabbixConfig{}
+	err := json.Unmarshal(jbyte, &zabbixConfig)
+	if err != nil {
+		return zabbixConfig, err
+	}
+	if zabbixConfig.Server == "" {
+		return zabbixConfig, errors.New("Zabbix Server is required")
+	}
+	if !strings.Contains(zabbixConfig.Server, ":") {
+		zabbixConfig.Server += ":10051"
+	}
+	log.Infof("[NewZabbixSenderConfig]ZabbixConfig: %v", zabbixConfig)
+	return zabbixConfig, nil
+}
+
+func NewZabbixSender(senderConfig *SenderConfig) (*ZabbixSender, error) {
+	sender := ZabbixSender{}
+	config, ok := senderConfig.Config.(ZabbixConfig)
+	if !ok {
+		return &sender, errors.New("New ZabbixSender error ")
+	}
+	if config.Host == "" {
+		config.Host = GetHost()
+	}
+	sender = ZabbixSender{
+		config:  config,
+		timeout: time.Duration(config.Timeout) * time.Second,
+		ctx:     context.Background(),
+	}
+	if sender.timeout <= 0 {
+		sender.timeout = 3 * time.Second
+	}
+	return &sender, nil
+}
+
+func (p *ZabbixSender) Start(ctx context.Context) error {
+	p.ctx = ctx
+	return nil
+}
+
+func (p *ZabbixSender) Stop() error {
+	return nil
+}
+
+// zabbixKey returns "<KeyPrefix><measurement>.<aggregation>[tag values]",
+// tag values are ordered by tag name
+func (p *ZabbixSender) zabbixKey(series, aggregation string) string {
+	measurement, tags := splitSeries(series)
+	key := p.config.KeyPrefix + measurement + "." + aggregation
+	if len(tags) == 0 {
+		return key
+	}
+	names := make([]string, 0, len(tags))
+	for k := range tags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	params := make([]string, 0, len(names))
+	for _, k := range names {
+		v := tags[k]
+		if strings.ContainsAny(v, ",]\" ") {
+			v = `"` + strings.Replace(v, `"`, `\"`, -1) + `"`
+		}
+		params = append(params, v)
+	}
+	return key + "[" + strings.Join(params, ",") + "]"
+}
+
+func (p *ZabbixSender) Send(fields map[string]interface{}) {
+	result, ok := ParseAggregationResult(fields)
+	if !ok {
+		log.Debugf("[ZabbixSender] Only aggregation results can be sent, drop %v", fields)
+		return
+	}
+	clock := result.Timestamp
+	var items []zabbixItem
+	for series, aggregations := range result.Series {
+		for aggregation, value := range aggregations {
+			items = append(items, zabbixItem{
+				Host:  p.config.Host,
+				Key:   p.zabbixKey(series, aggregation),
+				Value: strconv.FormatFloat(value, 'f', -1, 64),
+				Clock: clock,
+			})
+		}
+	}
+	if len(items) == 0 {
+		return
+	}
+	if err := p.send(items, clock); err != nil {
+		log.Infof("[ZabbixSender] Send error, err[%s]", err)
+		p.fail(time.Now())
+		return
+	}
+	p.succeed()
+}
+
+func (p *ZabbixSender) send(items []zabbixItem, clock int64) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"request": "sender data",
+		"data":    items,
+		"clock":   clock,
+	})
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{Timeout: p.timeout}
+	conn, err := dialer.DialContext(p.ctx, "tcp", p.config.Server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(p.timeout))
+
+	packet := make([]byte, 0, len(zabbixHeader)+8+len(data))
+	packet = append(packet, zabbixHeader...)
+	length := make([]byte, 8)
+	binary.LittleEndian.PutUint64(length, uint64(len(data)))
+	packet = append(packet, length...)
+	packet = append(packet, data...)
+	if _, err := conn.Write(packet); err != nil {
+		return err
+	}
+
+	header := make([]byte, len(zabbixHeader)+8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if string(header[:len(zabbixHeader)]) != string(zabbixHeader) {
+		return errors.New("zabbix response header error")
+	}
+	body := make([]byte, binary.LittleEndian.Uint64(header[len(zabbixHeader):]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return err
+	}
+	var resp struct {
+		Response string `json:"response"`
+		Info     string `json:"info"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return err
+	}
+	if resp.Response != "success" {
+		return fmt.Errorf("zabbix response %s, info %s", resp.Response, resp.Info)
+	}
+	log.Debugf("[ZabbixSender] Response %s", resp.Info)
+	return nil
+}
@@ -0,0 +1,111 @@
+package logpeck
+
+import (
+	"errors"
+	"hash/fnv"
+	"regexp"
+	"sync"
+)
+
+// ShardConfig spreads the filtering and extraction of lines over Workers
+// goroutines. Lines go to a worker by the hash of their Key, the first
+// submatch (or the match) of a regexp, or of the whole line if Key is empty,
+// so the lines of a key keep their order. QueueSize lines wait per worker
+type ShardConfig struct {
+	Workers   int    `json:"Workers"`
+	Key       string `json:"Key"`
+	QueueSize int    `json:"QueueSize"`
+}
+
+type shardLine struct {
+	path    string
+	content string
+	seq     string
+}
+
+// Sharder dispatches the lines of a task to its workers
+type Sharder struct {
+	config ShardConfig
+	key    *regexp.Regexp
+
+	mu     sync.RWMutex
+	queues []chan shardLine
+	done   sync.WaitGroup
+}
+
+func NewSharder(config *ShardConfig) (*Sharder, error) {
+	sharder := &Sharder{config: *config}
+	if sharder.config.QueueSize <= 0 {
+		sharder.config.QueueSize = 1000
+	}
+	if config.Key != "" {
+		key, err := regexp.Compile(config.Key)
+		if err != nil {
+			return nil, errors.New("Shard Key error: " + err.Error())
+		}
+		sharder.key = key
+	}
+	return sharder, nil
+}
+
+func (p *Sharder) IsEnable() bool {
+	return p.config.Workers > 1
+}
+
+// Start runs a worker for each function of workers
+func (p *Sharder) Start(workers []func(path, content, seq string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.queues != nil {
+		return
+	}
+	for _, worker := range workers {
+		queue := make(chan shardLine, p.config.QueueSize)
+		p.queues = append(p.queues, queue)
+		p.done.Add(1)
+		go func(worker func(path, content, seq string)) {
+			defer p.done.Done()
+			for line := range queue {
+				worker(line.path, line.content, line.seq)
+			}
+		}(worker)
+	}
+}
+
+// Stop waits until the workers processed the queued lines
+func (p *Sharder) Stop() {
+	p.mu.Lock()
+	queues := p.queues
+	p.queues = nil
+	p.mu.Unlock()
+	for _, queue := range queues {
+		close(queue)
+	}
+	p.done.Wait()
+}
+
+func (p *Sharder) shard(content string) int {
+	key := content
+	if p.key != nil {
+		if match := p.key.FindStringSubmatch(content); len(match) > 1 {
+			key = match[1]
+		} else if len(match) == 1 {
+			key = match[0]
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// Dispatch queues a line read from path to its worker, waiting while the
+// queue is full. It returns false if the workers are not running
+func (p *Sharder) Dispatch(path, content, seq string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.queues == nil {
+		return false
+	}
+	p.queues[p.shard(content)] <- shardLine{path: path, content: content, seq: seq}
+	return true
+}
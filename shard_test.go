@@ -0,0 +1,103 @@
+package logpeck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSharder(*testing.T) {
+	if _, err := NewSharder(&ShardConfig{Workers: 2, Key: "("}); err == nil {
+		panic("bad Key accepted")
+	}
+	sharder, err := NewSharder(&ShardConfig{Workers: 4, Key: `user=(\w+)`})
+	if err != nil {
+		panic(err)
+	}
+	if sharder.Dispatch("", "user=a 1", "") {
+		panic("dispatched before start")
+	}
+	seen := make([][]string, 4)
+	workers := make([]func(path, content, seq string), 4)
+	for i := range workers {
+		i := i
+		workers[i] = func(path, content, seq string) {
+			seen[i] = append(seen[i], content)
+		}
+	}
+	sharder.Start(workers)
+	for n := 0; n < 100; n++ {
+		sharder.Dispatch("", fmt.Sprintf("user=u%d %d", n%7, n), "")
+	}
+	sharder.Stop()
+
+	total := 0
+	for _, lines := range seen {
+		total += len(lines)
+	}
+	if total != 100 {
+		panic(seen)
+	}
+	// the lines of a user are on one worker in order
+	for user := 0; user < 7; user++ {
+		prefix := fmt.Sprintf("user=u%d ", user)
+		workers, last := 0, -1
+		for _, lines := range seen {
+			found := false
+			for _, line := range lines {
+				if !strings.HasPrefix(line, prefix) {
+					continue
+				}
+				var n int
+				fmt.Sscanf(strings.TrimPrefix(line, prefix), "%d", &n)
+				if n <= last {
+					panic(lines)
+				}
+				last, found = n, true
+			}
+			if found {
+				workers++
+			}
+		}
+		if workers != 1 {
+			panic(seen)
+		}
+	}
+}
+
+func TestPeckTaskShard(*testing.T) {
+	config := &PeckTaskConfig{}
+	err := config.Unmarshal([]byte(`{
+		"Name": "ShardLog",
+		"Extractor": {"Name": "text", "Config": {"Fields": [{"Name": "user", "Value": "$1"}, {"Name": "n", "Value": "$2"}]}},
+		"Sender": {"Name": "prometheus"},
+		"Shard": {"Workers": 3, "Key": "^(\\S+)"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	task, err := NewPeckTask(config, &PeckTaskStat{Name: config.Name})
+	if err != nil {
+		panic(err)
+	}
+	sender := &eventSender{}
+	task.sender = sender
+	task.Start(context.Background())
+	for n := 0; n < 60; n++ {
+		task.Process(fmt.Sprintf("u%d %d", n%5, n))
+	}
+	task.Stop()
+	if len(sender.events) != 60 {
+		panic(len(sender.events))
+	}
+	last := map[interface{}]int{}
+	for _, event := range sender.events {
+		var n int
+		fmt.Sscanf(event["n"].(string), "%d", &n)
+		if prev, ok := last[event["user"]]; ok && n <= prev {
+			panic(sender.events)
+		}
+		last[event["user"]] = n
+	}
+}
@@ -0,0 +1,59 @@
+package logpeck
+
+import (
+	"sync"
+	"time"
+)
+
+// statPersistInterval is how often changed stat counters are written
+var statPersistInterval = 5 * time.Second
+
+// StatPersister batches the counter updates of task stats, only the last
+// update of each task is kept until Flush writes them in one transaction
+type StatPersister struct {
+	db *DB
+
+	mu    sync.Mutex
+	dirty map[string]PeckTaskStat
+}
+
+func NewStatPersister(db *DB) *StatPersister {
+	return &StatPersister{
+		db:    db,
+		dirty: make(map[string]PeckTaskStat),
+	}
+}
+
+// Mark records the latest counters of a task
+func (p *StatPersister) Mark(stat PeckTaskStat) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dirty[stat.Name] = stat
+}
+
+// Flush writes the marked counters, they are kept for the next flush if
+// writing failed
+func (p *StatPersister) Flush() error {
+	p.mu.Lock()
+	dirty := p.dirty
+	p.dirty = make(map[string]PeckTaskStat)
+	p.mu.Unlock()
+	if len(dirty) == 0 {
+		return nil
+	}
+	counters := make([]PeckTaskStat, 0, len(dirty))
+	for _, stat := range dirty {
+		counters = append(counters, stat)
+	}
+	err := p.db.SaveCounters(counters)
+	if err != nil {
+		p.mu.Lock()
+		for name, stat := range dirty {
+			if _, ok := p.dirty[name]; !ok {
+				p.dirty[name] = stat
+			}
+		}
+		p.mu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package logpeck
+
+import (
+	"sync"
+	"time"
+)
+
+// statsHistoryInterval and statsHistorySize keep the last hour of stats at
+// 10 seconds resolution
+var (
+	statsHistoryInterval = 10 * time.Second
+	statsHistorySize     = 360
+)
+
+// StatsSample is a point of the stats history of a task
+type StatsSample struct {
+	Timestamp    int64
+	LinesPerSec  int64
+	BytesPerSec  int64
+	LagBytes     int64
+	TimeoutTotal int64
+	DroppedTotal int64
+	PanicTotal   int64
+}
+
+type statsRing struct {
+	samples []StatsSample
+	next    int
+	full    bool
+}
+
+func (p *statsRing) add(sample StatsSample) {
+	p.samples[p.next] = sample
+	p.next = (p.next + 1) % len(p.samples)
+	if p.next == 0 {
+		p.full = true
+	}
+}
+
+// since returns the samples not older than since, oldest first
+func (p *statsRing) since(since int64) []StatsSample {
+	var ordered []StatsSample
+	if p.full {
+		ordered = append(ordered, p.samples[p.next:]...)
+	}
+	ordered = append(ordered, p.samples[:p.next]...)
+	res := []StatsSample{}
+	for _, sample := range ordered {
+		if sample.Timestamp >= since {
+			res = append(res, sample)
+		}
+	}
+	return res
+}
+
+// StatsHistory keeps a fixed number of recent stats samples per task in
+// memory
+type StatsHistory struct {
+	size int
+
+	mu    sync.Mutex
+	tasks map[string]*statsRing
+}
+
+func NewStatsHistory(size int) *StatsHistory {
+	return &StatsHistory{
+		size:  size,
+		tasks: make(map[string]*statsRing),
+	}
+}
+
+// Record appends a sample of each stat, history of tasks missing in stats
+// is forgotten
+func (p *StatsHistory) Record(stats []PeckTaskStat, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seen := make(map[string]bool)
+	for _, stat := range stats {
+		seen[stat.Name] = true
+		ring, ok := p.tasks[stat.Name]
+		if !ok {
+			ring = &statsRing{samples: make([]StatsSample, p.size)}
+			p.tasks[stat.Name] = ring
+		}
+		ring.add(StatsSample{
+			Timestamp:    now.Unix(),
+			LinesPerSec:  stat.LinesPerSec,
+			BytesPerSec:  stat.BytesPerSec,
+			LagBytes:     stat.LagBytes,
+			TimeoutTotal: stat.TimeoutTotal,
+			DroppedTotal: stat.DroppedTotal,
+			PanicTotal:   stat.PanicTotal,
+		})
+	}
+	for name := range p.tasks {
+		if !seen[name] {
+			delete(p.tasks, name)
+		}
+	}
+}
+
+// Get returns the samples taken at or after since (unix seconds) of task
+// name, or of all tasks if name is empty
+func (p *StatsHistory) Get(name string, since int64) map[string][]StatsSample {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	res := make(map[string][]StatsSample)
+	for task, ring := range p.tasks {
+		if name == "" || name == task {
+			res[task] = ring.since(since)
+		}
+	}
+	return res
+}
@@ -0,0 +1,34 @@
+package logpeck
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsHistory(*testing.T) {
+	history := NewStatsHistory(3)
+	now := time.Unix(1000, 0)
+	for i := 0; i < 5; i++ {
+		stats := []PeckTaskStat{{Name: "a", LinesPerSec: int64(i)}}
+		if i < 2 {
+			stats = append(stats, PeckTaskStat{Name: "b"})
+		}
+		history.Record(stats, now.Add(time.Duration(i)*10*time.Second))
+	}
+
+	all := history.Get("", 0)
+	if len(all) != 1 {
+		panic(all)
+	}
+	samples := all["a"]
+	if len(samples) != 3 || samples[0].LinesPerSec != 2 || samples[2].LinesPerSec != 4 {
+		panic(samples)
+	}
+	samples = history.Get("a", 1030)["a"]
+	if len(samples) != 2 || samples[0].Timestamp != 1030 {
+		panic(samples)
+	}
+	if samples, ok := history.Get("b", 0)["b"]; ok {
+		panic(samples)
+	}
+}
@@ -0,0 +1,108 @@
+package logpeck
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// statsStreamInterval is how often stats are published to the stream
+// subscribers
+var statsStreamInterval = time.Second
+
+// StatsDelta is a message of the stats stream, the stats of the tasks
+// which changed since the previous message and the names of removed tasks
+type StatsDelta struct {
+	Timestamp int64
+	Changed   []PeckTaskStat
+	Removed   []string
+}
+
+// StatsHub publishes the task stats to the subscribers of the stream, they
+// are only read while there are subscribers
+type StatsHub struct {
+	mu          sync.Mutex
+	subscribers map[chan []PeckTaskStat]struct{}
+}
+
+func NewStatsHub() *StatsHub {
+	return &StatsHub{subscribers: make(map[chan []PeckTaskStat]struct{})}
+}
+
+// Subscribe returns a channel receiving the latest stats
+func (p *StatsHub) Subscribe() chan []PeckTaskStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := make(chan []PeckTaskStat, 1)
+	p.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (p *StatsHub) Unsubscribe(ch chan []PeckTaskStat) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.subscribers, ch)
+}
+
+func (p *StatsHub) Active() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.subscribers) > 0
+}
+
+// Publish hands stats to every subscriber, a slow subscriber only gets the
+// latest stats
+func (p *StatsHub) Publish(stats []PeckTaskStat) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ch := range p.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- stats
+	}
+}
+
+// StatsDiffer computes the deltas of the stats of a subscriber, only the
+// tasks of names if not empty
+type StatsDiffer struct {
+	names map[string]bool
+	last  map[string]PeckTaskStat
+}
+
+func NewStatsDiffer(names []string) *StatsDiffer {
+	d := &StatsDiffer{last: make(map[string]PeckTaskStat)}
+	if len(names) > 0 {
+		d.names = make(map[string]bool)
+		for _, name := range names {
+			d.names[name] = true
+		}
+	}
+	return d
+}
+
+// Diff returns the delta from the previous stats, ok is false if nothing
+// changed
+func (d *StatsDiffer) Diff(stats []PeckTaskStat, now time.Time) (delta StatsDelta, ok bool) {
+	seen := make(map[string]bool, len(stats))
+	for _, stat := range stats {
+		if d.names != nil && !d.names[stat.Name] {
+			continue
+		}
+		seen[stat.Name] = true
+		if last, found := d.last[stat.Name]; found && reflect.DeepEqual(last, stat) {
+			continue
+		}
+		d.last[stat.Name] = stat
+		delta.Changed = append(delta.Changed, stat)
+	}
+	for name := range d.last {
+		if !seen[name] {
+			delete(d.last, name)
+			delta.Removed = append(delta.Removed, name)
+		}
+	}
+	delta.Timestamp = now.Unix()
+	return delta, len(delta.Changed) > 0 || len(delta.Removed) > 0
+}
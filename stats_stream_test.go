@@ -0,0 +1,69 @@
+package logpeck
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsDiffer(*testing.T) {
+	differ := NewStatsDiffer(nil)
+	now := time.Now()
+	delta, ok := differ.Diff([]PeckTaskStat{{Name: "a"}, {Name: "b"}}, now)
+	if !ok || len(delta.Changed) != 2 {
+		panic(delta)
+	}
+	if delta, ok = differ.Diff([]PeckTaskStat{{Name: "a"}, {Name: "b"}}, now); ok {
+		panic(delta)
+	}
+	delta, ok = differ.Diff([]PeckTaskStat{{Name: "a", LinesPerSec: 10}}, now)
+	if !ok || len(delta.Changed) != 1 || delta.Changed[0].LinesPerSec != 10 || len(delta.Removed) != 1 || delta.Removed[0] != "b" {
+		panic(delta)
+	}
+
+	differ = NewStatsDiffer([]string{"b"})
+	delta, _ = differ.Diff([]PeckTaskStat{{Name: "a"}, {Name: "b"}}, now)
+	if len(delta.Changed) != 1 || delta.Changed[0].Name != "b" {
+		panic(delta)
+	}
+}
+
+func TestStatsStreamHandler(*testing.T) {
+	pecker := &Pecker{statsHub: NewStatsHub()}
+	server := httptest.NewServer(NewStatsStreamHandler(pecker))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"?name=a", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		panic(resp.Header)
+	}
+	for !pecker.statsHub.Active() {
+		time.Sleep(time.Millisecond)
+	}
+	pecker.statsHub.Publish([]PeckTaskStat{{Name: "a", LinesPerSec: 5}, {Name: "b"}})
+
+	reader := bufio.NewReader(resp.Body)
+	event, _ := reader.ReadString('\n')
+	data, _ := reader.ReadString('\n')
+	if event != "event: stats\n" || !strings.HasPrefix(data, "data: ") {
+		panic(event + data)
+	}
+	var delta StatsDelta
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &delta); err != nil {
+		panic(err)
+	}
+	if len(delta.Changed) != 1 || delta.Changed[0].Name != "a" || delta.Changed[0].LinesPerSec != 5 {
+		panic(delta)
+	}
+}
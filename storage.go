@@ -6,15 +6,37 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/boltdb/bolt"
+	"io"
 	"os"
 	"strings"
+	"time"
 )
 
 const configBucket string = "config"
 const statBucket string = "stat"
+const offsetBucket string = "offset"
+
+// LogOffset is how far a log file was read, it is resumed only if the file
+// still has the same inode
+type LogOffset struct {
+	LogPath string
+	Inode   uint64
+	Offset  int64
+}
 
 type DB struct {
-	boltdb *bolt.DB
+	boltdb   *bolt.DB
+	recovery *DBRecovery
+}
+
+// DBRecovery reports that the database file was corrupt when opened. It was
+// moved to Backup and the agent started with an empty database, tasks have
+// to be added again
+type DBRecovery struct {
+	Time   int64
+	Path   string
+	Backup string
+	Error  string
 }
 
 var db *DB
@@ -26,27 +48,93 @@ func GetDBHandler() *DB {
 	return db
 }
 
-func OpenDB(path string) (err error) {
-	boltdb, e := bolt.Open(path, 0600, nil)
-	if e != nil {
-		fmt.Fprintf(os.Stderr, "Open database error: %s.", e)
-		return e
+// errDBCorrupt wraps the errors of a database file which can not be used
+type errDBCorrupt struct {
+	err error
+}
+
+func (e errDBCorrupt) Error() string {
+	return e.err.Error()
+}
+
+// isBoltCorrupt reports whether err of bolt means the database file is
+// corrupt, other errors such as a full disk say nothing about the file
+func isBoltCorrupt(err error) bool {
+	return errors.Is(err, bolt.ErrInvalid) || errors.Is(err, bolt.ErrVersionMismatch) || errors.Is(err, bolt.ErrChecksum)
+}
+
+// openBoltDB opens path and checks that the buckets are readable, bolt
+// panics on some corrupt pages
+func openBoltDB(path string) (boltdb *bolt.DB, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if boltdb != nil {
+				boltdb.Close()
+			}
+			boltdb, err = nil, errDBCorrupt{fmt.Errorf("%v", r)}
+		}
+	}()
+	boltdb, err = bolt.Open(path, 0600, nil)
+	if err != nil {
+		if isBoltCorrupt(err) {
+			err = errDBCorrupt{err}
+		}
+		return nil, err
 	}
 	err = boltdb.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(configBucket))
-		if err != nil {
-			return fmt.Errorf("create bucket(%s): %s", configBucket, err)
-		}
-		_, err = tx.CreateBucketIfNotExists([]byte(statBucket))
-		if err != nil {
-			return fmt.Errorf("create bucket(%s): %s", statBucket, err)
+		for _, bucket := range []string{configBucket, statBucket, offsetBucket} {
+			b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+			if err != nil {
+				return fmt.Errorf("create bucket(%s): %w", bucket, err)
+			}
+			b.ForEach(func(k, v []byte) error {
+				return nil
+			})
 		}
 		return nil
 	})
-	db = &DB{boltdb: boltdb}
+	if err != nil {
+		boltdb.Close()
+		if isBoltCorrupt(err) {
+			err = errDBCorrupt{err}
+		}
+		return nil, err
+	}
+	return boltdb, nil
+}
+
+// OpenDB opens the database file path. A corrupt file is moved aside with a
+// ".corrupt" suffix and an empty database is created, see Recovery
+func OpenDB(path string) (err error) {
+	boltdb, e := openBoltDB(path)
+	var recovery *DBRecovery
+	if corrupt, ok := e.(errDBCorrupt); ok {
+		now := time.Now()
+		recovery = &DBRecovery{
+			Time:   now.Unix(),
+			Path:   path,
+			Backup: fmt.Sprintf("%s.corrupt.%s", path, now.Format("20060102150405")),
+			Error:  corrupt.Error(),
+		}
+		log.Errorf("[Storage] Database %s is corrupt, %s, move it to %s and start with an empty database", path, corrupt, recovery.Backup)
+		if e = os.Rename(path, recovery.Backup); e == nil {
+			boltdb, e = openBoltDB(path)
+		}
+	}
+	if e != nil {
+		fmt.Fprintf(os.Stderr, "Open database error: %s.", e)
+		return e
+	}
+	db = &DB{boltdb: boltdb, recovery: recovery}
 	return nil
 }
 
+// Recovery returns how the database was recovered when opened, nil if it
+// was not corrupt
+func (p *DB) Recovery() *DBRecovery {
+	return p.recovery
+}
+
 func (p *DB) Close() error {
 	e := p.boltdb.Close()
 	if e != nil {
@@ -55,6 +143,18 @@ func (p *DB) Close() error {
 	return e
 }
 
+// Ping checks that the database is open and readable
+func (p *DB) Ping() error {
+	return p.boltdb.View(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{configBucket, statBucket, offsetBucket} {
+			if tx.Bucket([]byte(bucket)) == nil {
+				return fmt.Errorf("bucket %s not found", bucket)
+			}
+		}
+		return nil
+	})
+}
+
 func (p *DB) makeConfigRawKey(logPath, name string) string {
 	return logPath + "#" + name
 }
@@ -93,11 +193,25 @@ func (p *DB) RemoveConfig(name string) error {
 	return nil
 }
 
-func (p *DB) GetAllConfigs() (configs []PeckTaskConfig, err error) {
-	rawKV, err := p.scan(configBucket)
+func (p *DB) GetAllConfigs() ([]PeckTaskConfig, error) {
+	configs, broken, err := p.LoadAllConfigs()
 	if err != nil {
 		return nil, err
 	}
+	for _, err := range broken {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// LoadAllConfigs returns the configs which can be parsed, and the parse
+// error of each other config by task name
+func (p *DB) LoadAllConfigs() (configs []PeckTaskConfig, broken map[string]error, err error) {
+	rawKV, err := p.scan(configBucket)
+	if err != nil {
+		return nil, nil, err
+	}
+	broken = make(map[string]error)
 	log.Debugf("[Storage] Get all configs %#v", rawKV)
 	//	fmt.Println(rawKV)
 	for k, v := range rawKV {
@@ -106,12 +220,13 @@ func (p *DB) GetAllConfigs() (configs []PeckTaskConfig, err error) {
 			nk := k[strings.Index(k, "#")+1:]
 			p.remove(configBucket, k)
 			p.put(configBucket, nk, v)
+			k = nk
 		}
 		//
 		config := &PeckTaskConfig{}
-		err = config.Unmarshal([]byte(v))
-		if err != nil {
-			panic(fmt.Errorf("raw[%s], err[%s]", string(v[:]), err))
+		if e := config.Unmarshal([]byte(v)); e != nil {
+			broken[k] = fmt.Errorf("raw[%s], err[%s]", string(v[:]), e)
+			continue
 		}
 		configs = append(configs, *config)
 	}
@@ -154,6 +269,127 @@ func (p *DB) RemoveStat(name string) error {
 	return nil
 }
 
+// RenameTask replaces the config and stat of task oldName by the renamed
+// ones and saves the offsets of its log in one transaction, it fails if
+// the new name is taken
+func (p *DB) RenameTask(oldName string, config *PeckTaskConfig, stat *PeckTaskStat, offsets []LogOffset) error {
+	rawConfig, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	rawStat, err := json.Marshal(stat)
+	if err != nil {
+		return err
+	}
+	return p.boltdb.Update(func(tx *bolt.Tx) error {
+		configs := tx.Bucket([]byte(configBucket))
+		stats := tx.Bucket([]byte(statBucket))
+		if configs.Get([]byte(config.Name)) != nil {
+			return fmt.Errorf("Task %s already exist", config.Name)
+		}
+		if configs.Get([]byte(oldName)) == nil {
+			return fmt.Errorf("Task %s not exist", oldName)
+		}
+		if err := configs.Delete([]byte(oldName)); err != nil {
+			return err
+		}
+		if err := stats.Delete([]byte(oldName)); err != nil {
+			return err
+		}
+		if err := configs.Put([]byte(config.Name), rawConfig); err != nil {
+			return err
+		}
+		if err := stats.Put([]byte(stat.Name), rawStat); err != nil {
+			return err
+		}
+		return putOffsets(tx, offsets)
+	})
+}
+
+// SaveCounters updates the counters of saved stats in one transaction, other
+// fields are left as saved. Stats of removed tasks are skipped
+func (p *DB) SaveCounters(counters []PeckTaskStat) error {
+	return p.boltdb.Update(func(tx *bolt.Tx) error {
+		stats := tx.Bucket([]byte(statBucket))
+		for _, counter := range counters {
+			raw := stats.Get([]byte(counter.Name))
+			if raw == nil {
+				continue
+			}
+			var stat PeckTaskStat
+			if err := json.Unmarshal(raw, &stat); err != nil {
+				return err
+			}
+			stat.LinesTotal = counter.LinesTotal
+			stat.BytesTotal = counter.BytesTotal
+			stat.TruncatedTotal = counter.TruncatedTotal
+			stat.PanicTotal = counter.PanicTotal
+			raw, err := json.Marshal(&stat)
+			if err != nil {
+				return err
+			}
+			if err := stats.Put([]byte(stat.Name), raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveOffsets saves the offsets of logs in one transaction
+func (p *DB) SaveOffsets(offsets []LogOffset) error {
+	return p.boltdb.Update(func(tx *bolt.Tx) error {
+		return putOffsets(tx, offsets)
+	})
+}
+
+func putOffsets(tx *bolt.Tx, offsets []LogOffset) error {
+	b := tx.Bucket([]byte(offsetBucket))
+	for _, offset := range offsets {
+		raw, err := json.Marshal(&offset)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(offset.LogPath), raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *DB) GetOffset(logPath string) (*LogOffset, error) {
+	rawValue := p.get(offsetBucket, logPath)
+	if len(rawValue) == 0 {
+		return nil, errors.New("Offset not exist")
+	}
+	var result LogOffset
+	if err := json.Unmarshal([]byte(rawValue), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetAllOffsets returns the saved offsets of all files
+func (p *DB) GetAllOffsets() ([]LogOffset, error) {
+	rawKV, err := p.scan(offsetBucket)
+	if err != nil {
+		return nil, err
+	}
+	offsets := make([]LogOffset, 0, len(rawKV))
+	for _, v := range rawKV {
+		var offset LogOffset
+		if err := json.Unmarshal([]byte(v), &offset); err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets, nil
+}
+
+func (p *DB) RemoveOffset(logPath string) error {
+	return p.remove(offsetBucket, logPath)
+}
+
 func (p *DB) GetAllStats() (stats []PeckTaskStat, err error) {
 	rawKV, err := p.scan(statBucket)
 	if err != nil {
@@ -171,13 +407,54 @@ func (p *DB) GetAllStats() (stats []PeckTaskStat, err error) {
 		stat := &PeckTaskStat{}
 		err = json.Unmarshal([]byte(v), stat)
 		if err != nil {
-			panic(fmt.Errorf("raw[%s], err[%s]", string(v[:]), err))
+			return nil, fmt.Errorf("raw[%s], err[%s]", string(v[:]), err)
 		}
 		stats = append(stats, *stat)
 	}
 	return
 }
 
+// WriteSnapshot writes a consistent copy of the database to w, tasks keep
+// running meanwhile
+func (p *DB) WriteSnapshot(w io.Writer) (int64, error) {
+	var n int64
+	err := p.boltdb.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// RestoreDB replaces the database file path by snapshot before it is
+// opened, the replaced file is kept as path.bak
+func RestoreDB(snapshot, path string) error {
+	check, err := bolt.Open(snapshot, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("Open snapshot %s error: %s", snapshot, err)
+	}
+	err = check.View(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{configBucket, statBucket} {
+			if tx.Bucket([]byte(bucket)) == nil {
+				return fmt.Errorf("Snapshot %s has no bucket %s", snapshot, bucket)
+			}
+		}
+		return tx.CopyFile(path+".restore", 0600)
+	})
+	check.Close()
+	if err != nil {
+		os.Remove(path + ".restore")
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		if err := os.Rename(path, path+".bak"); err != nil {
+			return err
+		}
+	}
+	log.Infof("[Storage] Restore database %s from snapshot %s", path, snapshot)
+	return os.Rename(path+".restore", path)
+}
+
 func (p *DB) put(bucket string, key string, value string) error {
 	err := p.boltdb.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
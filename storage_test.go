@@ -1,10 +1,16 @@
 package logpeck
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/boltdb/bolt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -367,3 +373,263 @@ func TestStatCompat(*testing.T) {
 	}
 
 }
+
+func TestRenameTask(*testing.T) {
+	err := OpenDB(kTestDBPath)
+	if err != nil {
+		panic(err)
+	}
+	db := GetDBHandler()
+	defer CleanTestDB(db)
+
+	for _, name := range []string{"old", "other"} {
+		db.SaveConfig(&PeckTaskConfig{Name: name, LogPath: "./test.log"})
+		db.SaveStat(&PeckTaskStat{Name: name, BackfillDone: true})
+	}
+	defer db.RemoveOffset("./test.log")
+	config := &PeckTaskConfig{Name: "other", LogPath: "./test.log"}
+	stat := &PeckTaskStat{Name: "other", BackfillDone: true}
+	offsets := []LogOffset{{LogPath: "./test.log", Offset: 42}}
+	if err := db.RenameTask("old", config, stat, offsets); err == nil {
+		panic("rename to a taken name should fail")
+	}
+	if _, err := db.GetOffset("./test.log"); err == nil {
+		panic("offset saved by a failed rename")
+	}
+	config.Name, stat.Name = "new", "new"
+	if err := db.RenameTask("old", config, stat, offsets); err != nil {
+		panic(err)
+	}
+	if offset, err := db.GetOffset("./test.log"); err != nil || offset.Offset != 42 {
+		panic(offset)
+	}
+	if _, err := db.GetConfig("old"); err == nil {
+		panic("old config not removed")
+	}
+	if _, err := db.GetStat("old"); err == nil {
+		panic("old stat not removed")
+	}
+	renamed, err := db.GetStat("new")
+	if err != nil || !renamed.BackfillDone {
+		panic(renamed)
+	}
+}
+
+func TestRestoreErrorIsolation(*testing.T) {
+	err := OpenDB(kTestDBPath)
+	if err != nil {
+		panic(err)
+	}
+	db := GetDBHandler()
+	defer CleanTestDB(db)
+
+	newConfig := func(name, schedule string) *PeckTaskConfig {
+		config := &PeckTaskConfig{}
+		err := config.Unmarshal([]byte(`{
+			"Name": "` + name + `",
+			"LogPath": "./test.log",
+			"Extractor": {"Name": "text", "Config": {"Fields": []}},
+			"Sender": {"Name": "prometheus"},
+			"Schedule": {"Windows": [` + schedule + `]}
+		}`))
+		if err != nil {
+			panic(err)
+		}
+		return config
+	}
+	db.SaveConfig(newConfig("good", ""))
+	db.SaveConfig(newConfig("badschedule", `"25:00-26:00"`))
+	db.put(configBucket, "badextractor", `{"Name":"badextractor","Extractor":{"Name":"nope","Config":{}}}`)
+	for _, name := range []string{"good", "badschedule", "badextractor"} {
+		db.SaveStat(&PeckTaskStat{Name: name, Stop: true})
+	}
+
+	pecker, err := NewPecker(db)
+	if err != nil {
+		panic(err)
+	}
+	stats, err := pecker.ListTaskStats(nil)
+	if err != nil {
+		panic(err)
+	}
+	failed := map[string]bool{}
+	for _, stat := range stats {
+		if stat.Failed && strings.HasPrefix(stat.Error, "restore:") {
+			failed[stat.Name] = true
+		}
+	}
+	if len(stats) != 3 || len(failed) != 2 || failed["good"] {
+		panic(stats)
+	}
+
+	if err := pecker.UpdatePeckTask(newConfig("badschedule", "")); err != nil {
+		panic(err)
+	}
+	if err := pecker.RemovePeckTask(&PeckTaskConfig{Name: "badextractor"}); err != nil {
+		panic(err)
+	}
+	stats, err = pecker.ListTaskStats(nil)
+	if err != nil {
+		panic(err)
+	}
+	for _, stat := range stats {
+		if stat.Failed {
+			panic(stat)
+		}
+	}
+	if len(stats) != 2 {
+		panic(stats)
+	}
+}
+
+func TestStatPersister(*testing.T) {
+	err := OpenDB(kTestDBPath)
+	if err != nil {
+		panic(err)
+	}
+	db := GetDBHandler()
+	defer CleanTestDB(db)
+
+	db.SaveStat(&PeckTaskStat{Name: "nginx", Stop: false, BackfillDone: true})
+	persister := NewStatPersister(db)
+	persister.Mark(PeckTaskStat{Name: "nginx", LinesTotal: 1, Stop: true})
+	persister.Mark(PeckTaskStat{Name: "nginx", LinesTotal: 5, BytesTotal: 50, PanicTotal: 1, Stop: true})
+	persister.Mark(PeckTaskStat{Name: "removed", LinesTotal: 3})
+	if err := persister.Flush(); err != nil {
+		panic(err)
+	}
+	stat, err := db.GetStat("nginx")
+	if err != nil {
+		panic(err)
+	}
+	if stat.LinesTotal != 5 || stat.BytesTotal != 50 || stat.PanicTotal != 1 || stat.Stop || !stat.BackfillDone {
+		panic(stat)
+	}
+	if _, err := db.GetStat("removed"); err == nil {
+		panic("removed task stat should not be created")
+	}
+
+	task, err := NewPeckTask(&PeckTaskConfig{Name: "nginx", Extractor: ExtractorConfig{Name: "text", Config: TextExtractorConfig{}},
+		Sender: SenderConfig{Name: "prometheus"}}, stat)
+	if err != nil {
+		panic(err)
+	}
+	if _, ok := task.takeCounters(); ok {
+		panic("counters not changed yet")
+	}
+	task.Stat.Stop = false
+	task.Process("hello")
+	counters, ok := task.takeCounters()
+	if !ok || counters.LinesTotal != 6 || counters.BytesTotal != 55 {
+		panic(counters)
+	}
+}
+
+func TestSnapshot(*testing.T) {
+	err := OpenDB(kTestDBPath)
+	if err != nil {
+		panic(err)
+	}
+	db := GetDBHandler()
+	defer CleanTestDB(db)
+
+	db.SaveConfig(&PeckTaskConfig{Name: "nginx", LogPath: "./test.log"})
+	db.SaveStat(&PeckTaskStat{Name: "nginx", BackfillDone: true})
+	snapshot := kTestDBPath + ".snapshot"
+	restored := kTestDBPath + ".restored"
+	defer os.Remove(snapshot)
+	defer os.Remove(restored)
+	defer os.Remove(restored + ".bak")
+	var buf bytes.Buffer
+	if n, err := db.WriteSnapshot(&buf); err != nil || n == 0 {
+		panic(err)
+	}
+	ioutil.WriteFile(snapshot, buf.Bytes(), 0600)
+
+	ioutil.WriteFile(restored, []byte("old"), 0600)
+	if err := RestoreDB(snapshot, restored); err != nil {
+		panic(err)
+	}
+	if old, _ := ioutil.ReadFile(restored + ".bak"); string(old) != "old" {
+		panic("replaced database not kept")
+	}
+	check, err := bolt.Open(restored, 0600, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer check.Close()
+	check.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(statBucket)).Get([]byte("nginx")) == nil {
+			panic("stat not restored")
+		}
+		return nil
+	})
+	if err := RestoreDB(restored+".bak", restored); err == nil {
+		panic("invalid snapshot should fail")
+	}
+}
+
+func TestSnapshotHandlerWriteTimeout(t *testing.T) {
+	opened := db
+	defer func() { db = opened }()
+	if err := OpenDB(filepath.Join(t.TempDir(), "snapshot.db")); err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	pecker, err := NewPecker(db)
+	if err != nil {
+		panic(err)
+	}
+	db.SaveConfig(&PeckTaskConfig{Name: "nginx", LogPath: "./test.log"})
+
+	// the write timeout expires before the snapshot is written
+	server := httptest.NewUnstartedServer(NewSnapshotHandler(pecker))
+	server.Config.WriteTimeout = time.Nanosecond
+	server.Start()
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK || len(body) == 0 {
+		panic(fmt.Sprintf("%d %d %v", resp.StatusCode, len(body), err))
+	}
+}
+
+func TestOpenDBRecovery(t *testing.T) {
+	opened := db
+	defer func() { db = opened }()
+	path := t.TempDir() + "/corrupt.db"
+	if err := ioutil.WriteFile(path, bytes.Repeat([]byte("corrupt!"), 1024), 0600); err != nil {
+		panic(err)
+	}
+	if err := OpenDB(path); err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	recovery := db.Recovery()
+	if recovery == nil || recovery.Path != path || !strings.HasPrefix(recovery.Backup, path+".corrupt.") {
+		panic(recovery)
+	}
+	if raw, err := ioutil.ReadFile(recovery.Backup); err != nil || len(raw) != 8192 {
+		panic(err)
+	}
+	if err := db.Ping(); err != nil {
+		panic(err)
+	}
+	if configs, err := db.GetAllConfigs(); err != nil || len(configs) != 0 {
+		panic(configs)
+	}
+
+	// errors which are not corruption are returned, the file is kept
+	unusable := t.TempDir() + "/dir.db"
+	os.Mkdir(unusable, 0700)
+	if err := OpenDB(unusable); err == nil {
+		panic("directory opened as database")
+	}
+	if info, err := os.Stat(unusable); err != nil || !info.IsDir() {
+		panic("unusable database moved aside")
+	}
+}
@@ -0,0 +1,158 @@
+package logpeck
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// UnknownFieldsError lists the keys of a task config which are no config
+// fields, Unmarshal ignores them
+type UnknownFieldsError struct {
+	Fields []string
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return "unknown fields: " + strings.Join(e.Fields, ", ")
+}
+
+// UnmarshalStrict is Unmarshal rejecting keys which are no config fields,
+// such as misspelled ones, with an UnknownFieldsError
+func (p *PeckTaskConfig) UnmarshalStrict(raw []byte) error {
+	if !isJSONObject(raw) {
+		var err error
+		if raw, err = YAMLToJSON(raw); err != nil {
+			return err
+		}
+	}
+	if err := p.Unmarshal(raw); err != nil {
+		return err
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+	var unknown []string
+	unknownFields("", v, reflect.ValueOf(p).Elem(), &unknown)
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return &UnknownFieldsError{Fields: unknown}
+	}
+	return nil
+}
+
+var (
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	// exactConfigTypes are parsed with simplejson, which matches keys case
+	// sensitively, unlike encoding/json
+	exactConfigTypes = map[reflect.Type]bool{
+		reflect.TypeOf(PeckTaskConfig{}):  true,
+		reflect.TypeOf(ExtractorConfig{}): true,
+		reflect.TypeOf(SenderConfig{}):    true,
+		reflect.TypeOf(TestModule{}):      true,
+	}
+)
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// jsonFields returns the struct fields of t by their json name, the fields
+// of embedded structs included
+func jsonFields(t reflect.Type, fields map[string]reflect.StructField, index []int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		f.Index = append(append([]int{}, index...), i)
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			jsonFields(f.Type, fields, f.Index)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+}
+
+// unknownFields appends the paths of the keys of raw which have no field in
+// v, the parsed config, interfaces are checked against their parsed type
+func unknownFields(path string, raw interface{}, v reflect.Value, unknown *[]string) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Type().Implements(unmarshalerType) || reflect.PtrTo(v.Type()).Implements(unmarshalerType) {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		object, ok := raw.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := make(map[string]reflect.StructField)
+		jsonFields(v.Type(), fields, nil)
+		exact := exactConfigTypes[v.Type()]
+		for key, value := range object {
+			f, ok := fields[key]
+			if !ok && !exact {
+				for name, field := range fields {
+					if strings.EqualFold(name, key) {
+						f, ok = field, true
+						break
+					}
+				}
+			}
+			if !ok {
+				*unknown = append(*unknown, joinPath(path, key))
+				continue
+			}
+			field := v.FieldByIndex(f.Index)
+			if field.Kind() == reflect.Interface && field.IsNil() && f.Name == "Config" && exact {
+				// no Config is parsed for this extractor or sender
+				if config, ok := value.(map[string]interface{}); ok {
+					for k := range config {
+						*unknown = append(*unknown, joinPath(joinPath(path, key), k))
+					}
+				}
+				continue
+			}
+			unknownFields(joinPath(path, key), value, field, unknown)
+		}
+	case reflect.Map:
+		object, ok := raw.(map[string]interface{})
+		if !ok || v.Type().Key().Kind() != reflect.String {
+			return
+		}
+		for key, value := range object {
+			elem := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+			if !elem.IsValid() {
+				elem = reflect.Zero(v.Type().Elem())
+			}
+			unknownFields(joinPath(path, key), value, elem, unknown)
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := raw.([]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range items {
+			elem := reflect.Zero(v.Type().Elem())
+			if i < v.Len() {
+				elem = v.Index(i)
+			}
+			unknownFields(path+"["+strconv.Itoa(i)+"]", item, elem, unknown)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package logpeck
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshalStrict(*testing.T) {
+	raw := []byte(`{
+		"Name": "StrictLog",
+		"LogPath": "./test.log",
+		"keywords": "error",
+		"Extractor": {"Name": "text", "Config": {"Delimeters": " ", "fields": [{"Name": "a", "Value": "$1", "Type": "int"}]}},
+		"Sender": {"Name": "elasticsearch", "Config": {"Hosts": ["127.0.0.1:9200"], "Index": "log", "IdFeild": "_seq"}},
+		"Aggregator": {"Enable": false, "Interval": 30},
+		"Labels": {"team": "web"},
+		"Health": {"Enable": true, "Sender": {"Name": "prometheus", "Config": {"Port": 1}}},
+		"Test": {"TestNum": 2}
+	}`)
+	config := &PeckTaskConfig{}
+	if err := config.Unmarshal(raw); err != nil {
+		panic(err)
+	}
+	err := (&PeckTaskConfig{}).UnmarshalStrict(raw)
+	unknown, ok := err.(*UnknownFieldsError)
+	if !ok {
+		panic(err)
+	}
+	want := []string{
+		"Extractor.Config.Delimeters",
+		"Extractor.Config.fields[0].Type",
+		"Health.Sender.Config.Port",
+		"Sender.Config.IdFeild",
+		"keywords",
+	}
+	if !reflect.DeepEqual(unknown.Fields, want) {
+		panic(unknown.Fields)
+	}
+
+	err = (&PeckTaskConfig{}).UnmarshalStrict([]byte(`
+Name: StrictLog
+Extractor:
+  Name: json
+  Config:
+    Fields: [{Name: a, Value: a}]
+Sender: {Name: kafka, Config: {Brokers: ["127.0.0.1:9092"], Topic: log}}
+Shard: {Workers: 2}
+`))
+	if err != nil {
+		panic(err)
+	}
+}
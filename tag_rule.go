@@ -0,0 +1,74 @@
+package logpeck
+
+import (
+	"regexp"
+	"strings"
+)
+
+const defaultOtherTagValue string = "other"
+
+// TagRule normalizes a tag value before bucketing, to keep the number of
+// series small
+type TagRule struct {
+	Lowercase bool         `json:"Lowercase"`
+	Replace   []TagReplace `json:"Replace"`
+	Allow     []string     `json:"Allow"`
+	Other     string       `json:"Other"`
+}
+
+type TagReplace struct {
+	Pattern     string `json:"Pattern"`
+	Replacement string `json:"Replacement"`
+}
+
+type tagNormalizer struct {
+	rule     TagRule
+	patterns []*regexp.Regexp
+	allow    map[string]bool
+}
+
+func newTagNormalizer(rule TagRule) (*tagNormalizer, error) {
+	n := &tagNormalizer{rule: rule}
+	if n.rule.Other == "" {
+		n.rule.Other = defaultOtherTagValue
+	}
+	for _, r := range rule.Replace {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		n.patterns = append(n.patterns, pattern)
+	}
+	if len(rule.Allow) > 0 {
+		n.allow = make(map[string]bool)
+		for _, v := range rule.Allow {
+			n.allow[v] = true
+		}
+	}
+	return n, nil
+}
+
+func (n *tagNormalizer) Normalize(value string) string {
+	if n.rule.Lowercase {
+		value = strings.ToLower(value)
+	}
+	for i, pattern := range n.patterns {
+		value = pattern.ReplaceAllString(value, n.rule.Replace[i].Replacement)
+	}
+	if n.allow != nil && !n.allow[value] {
+		return n.rule.Other
+	}
+	return value
+}
+
+func newTagNormalizers(rules map[string]TagRule) (map[string]*tagNormalizer, error) {
+	normalizers := make(map[string]*tagNormalizer)
+	for tag, rule := range rules {
+		n, err := newTagNormalizer(rule)
+		if err != nil {
+			return nil, err
+		}
+		normalizers[tag] = n
+	}
+	return normalizers, nil
+}
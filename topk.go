@@ -0,0 +1,65 @@
+package logpeck
+
+import (
+	"sort"
+)
+
+type ssCounter struct {
+	Value string
+	Count int64
+	Error int64
+}
+
+// SpaceSaving tracks approximately the most frequent values with a fixed
+// number of counters (Metwally et al., space-saving algorithm)
+type SpaceSaving struct {
+	capacity int
+	counters map[string]*ssCounter
+}
+
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &SpaceSaving{
+		capacity: capacity,
+		counters: make(map[string]*ssCounter),
+	}
+}
+
+func (p *SpaceSaving) Offer(value string) {
+	if c, ok := p.counters[value]; ok {
+		c.Count++
+		return
+	}
+	if len(p.counters) < p.capacity {
+		p.counters[value] = &ssCounter{Value: value, Count: 1}
+		return
+	}
+	var min *ssCounter
+	for _, c := range p.counters {
+		if min == nil || c.Count < min.Count {
+			min = c
+		}
+	}
+	delete(p.counters, min.Value)
+	p.counters[value] = &ssCounter{Value: value, Count: min.Count + 1, Error: min.Count}
+}
+
+// Top returns at most k counters ordered by count desc
+func (p *SpaceSaving) Top(k int) []ssCounter {
+	top := make([]ssCounter, 0, len(p.counters))
+	for _, c := range p.counters {
+		top = append(top, *c)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Value < top[j].Value
+	})
+	if len(top) > k {
+		top = top[:k]
+	}
+	return top
+}
@@ -0,0 +1,64 @@
+package logpeck
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceStep is a stage a traced line went through, Timestamp is in unix
+// nanoseconds
+type TraceStep struct {
+	Stage     string
+	Timestamp int64
+	Detail    string
+}
+
+// Tracer records the stages of the line containing marker in a task
+type Tracer struct {
+	marker string
+
+	mu    sync.Mutex
+	steps []TraceStep
+	done  chan struct{}
+	once  sync.Once
+}
+
+func NewTracer(marker string) *Tracer {
+	return &Tracer{
+		marker: marker,
+		done:   make(chan struct{}),
+	}
+}
+
+func (p *Tracer) Matches(line string) bool {
+	return strings.Contains(line, p.marker)
+}
+
+func (p *Tracer) Step(stage, detail string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.steps = append(p.steps, TraceStep{Stage: stage, Timestamp: time.Now().UnixNano(), Detail: detail})
+}
+
+// Finish marks the traced line processed
+func (p *Tracer) Finish() {
+	p.once.Do(func() { close(p.done) })
+}
+
+// Wait waits until the traced line is processed, it returns false on
+// timeout
+func (p *Tracer) Wait(timeout time.Duration) bool {
+	select {
+	case <-p.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (p *Tracer) Steps() []TraceStep {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]TraceStep{}, p.steps...)
+}
@@ -0,0 +1,46 @@
+package logpeck
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeckTaskTrace(*testing.T) {
+	h, err := NewHarness([]byte(`{
+		"Name": "TraceLog",
+		"Keywords": "^skip",
+		"Extractor": {"Name": "text", "Config": {"Fields": []}},
+		"Sender": {"Name": "prometheus"}
+	}`))
+	if err != nil {
+		panic(err)
+	}
+	h.Task.sender = &eventSender{}
+
+	tracer := NewTracer("marker-1")
+	h.Task.Trace(tracer)
+	h.Feed("other line")
+	if tracer.Wait(10 * time.Millisecond) {
+		panic(tracer.Steps())
+	}
+	h.Feed("a marker-1 line")
+	if !tracer.Wait(time.Second) {
+		panic(tracer.Steps())
+	}
+	var stages []string
+	for _, step := range tracer.Steps() {
+		stages = append(stages, step.Stage)
+	}
+	if strings.Join(stages, ",") != "received,filter,extractor,sender" {
+		panic(stages)
+	}
+
+	tracer = NewTracer("marker-2")
+	h.Task.Trace(tracer)
+	h.Feed("skip marker-2")
+	steps := tracer.Steps()
+	if !tracer.Wait(time.Second) || len(steps) != 2 || steps[1].Detail != "dropped by ^skip" {
+		panic(steps)
+	}
+}
@@ -0,0 +1,51 @@
+package logpeck
+
+const defaultTruncateMarker string = "...[truncated]"
+
+type TruncateConfig struct {
+	MaxLineLength  int    `json:"MaxLineLength"`
+	MaxFieldLength int    `json:"MaxFieldLength"`
+	Marker         string `json:"Marker"`
+}
+
+type Truncator struct {
+	config TruncateConfig
+}
+
+func NewTruncator(config *TruncateConfig) *Truncator {
+	truncator := &Truncator{config: *config}
+	if truncator.config.Marker == "" {
+		truncator.config.Marker = defaultTruncateMarker
+	}
+	return truncator
+}
+
+func (p *Truncator) IsEnable() bool {
+	return p.config.MaxLineLength > 0 || p.config.MaxFieldLength > 0
+}
+
+// TruncateLine returns the line cut to MaxLineLength with a marker appended,
+// and whether it was truncated
+func (p *Truncator) TruncateLine(content string) (string, bool) {
+	if p.config.MaxLineLength <= 0 || len(content) <= p.config.MaxLineLength {
+		return content, false
+	}
+	return TruncateString(content, p.config.MaxLineLength) + p.config.Marker, true
+}
+
+// TruncateFields cuts every string field longer than MaxFieldLength in place
+func (p *Truncator) TruncateFields(fields map[string]interface{}) bool {
+	if p.config.MaxFieldLength <= 0 {
+		return false
+	}
+	truncated := false
+	for k, v := range fields {
+		str, ok := v.(string)
+		if !ok || len(str) <= p.config.MaxFieldLength {
+			continue
+		}
+		fields[k] = TruncateString(str, p.config.MaxFieldLength) + p.config.Marker
+		truncated = true
+	}
+	return truncated
+}
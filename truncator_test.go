@@ -0,0 +1,35 @@
+package logpeck
+
+import (
+	"testing"
+)
+
+func TestTruncateLine(*testing.T) {
+	truncator := NewTruncator(&TruncateConfig{MaxLineLength: 5})
+	if line, ok := truncator.TruncateLine("hello"); ok || line != "hello" {
+		panic(line)
+	}
+	if line, ok := truncator.TruncateLine("hello logpeck"); !ok || line != "hello"+defaultTruncateMarker {
+		panic(line)
+	}
+
+	truncator = NewTruncator(&TruncateConfig{MaxLineLength: 4, Marker: "~"})
+	if line, ok := truncator.TruncateLine("日志"); !ok || line != "日~" {
+		panic(line)
+	}
+}
+
+func TestTruncateFields(*testing.T) {
+	truncator := NewTruncator(&TruncateConfig{MaxFieldLength: 3, Marker: "~"})
+	fields := map[string]interface{}{
+		"short": "abc",
+		"long":  "abcdef",
+		"num":   12345,
+	}
+	if !truncator.TruncateFields(fields) {
+		panic(fields)
+	}
+	if fields["short"] != "abc" || fields["long"] != "abc~" || fields["num"] != 12345 {
+		panic(fields)
+	}
+}
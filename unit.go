@@ -0,0 +1,72 @@
+package logpeck
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var durationUnits map[string]float64 = map[string]float64{
+	"ns": 1,
+	"us": 1e3,
+	"µs": 1e3,
+	"ms": 1e6,
+	"s":  1e9,
+	"m":  60 * 1e9,
+	"h":  3600 * 1e9,
+}
+
+var sizeUnits map[string]float64 = map[string]float64{
+	"b":   1,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+	"tb":  1 << 40,
+	"tib": 1 << 40,
+}
+
+func lookupUnit(unit string) (float64, map[string]float64, bool) {
+	if v, ok := durationUnits[unit]; ok {
+		return v, durationUnits, true
+	}
+	if v, ok := sizeUnits[strings.ToLower(unit)]; ok {
+		return v, sizeUnits, true
+	}
+	return 0, nil, false
+}
+
+// ParseValueWithUnit parses values like "12ms", "3.4s" or "2KB" and converts
+// them to baseUnit, values without suffix are taken as baseUnit already
+func ParseValueWithUnit(value string, baseUnit string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if baseUnit == "" {
+		return strconv.ParseFloat(value, 64)
+	}
+	baseFactor, units, ok := lookupUnit(baseUnit)
+	if !ok {
+		return 0, errors.New("unknown unit " + baseUnit)
+	}
+	i := len(value)
+	for i > 0 && !(value[i-1] >= '0' && value[i-1] <= '9' || value[i-1] == '.') {
+		i--
+	}
+	number, err := strconv.ParseFloat(value[:i], 64)
+	if err != nil {
+		return 0, err
+	}
+	suffix := strings.TrimSpace(value[i:])
+	if suffix == "" {
+		return number, nil
+	}
+	factor, ok := units[suffix]
+	if !ok {
+		factor, ok = units[strings.ToLower(suffix)]
+	}
+	if !ok {
+		return 0, errors.New("unit " + suffix + " can't convert to " + baseUnit)
+	}
+	return number * factor / baseFactor, nil
+}
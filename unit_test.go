@@ -0,0 +1,35 @@
+package logpeck
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseValueWithUnit(*testing.T) {
+	cases := []struct {
+		value    string
+		unit     string
+		expected float64
+	}{
+		{"12", "", 12},
+		{"12ms", "ms", 12},
+		{"3.4s", "ms", 3400},
+		{"1500us", "ms", 1.5},
+		{"2m", "s", 120},
+		{"2KB", "B", 2048},
+		{"1.5 MB", "KB", 1536},
+		{"7", "ms", 7},
+	}
+	for _, c := range cases {
+		v, err := ParseValueWithUnit(c.value, c.unit)
+		if err != nil || v != c.expected {
+			panic(fmt.Sprintf("%v %v %v", c, v, err))
+		}
+	}
+	if _, err := ParseValueWithUnit("2KB", "ms"); err == nil {
+		panic("KB is not a duration")
+	}
+	if _, err := ParseValueWithUnit("abc", "ms"); err == nil {
+		panic("abc is not a value")
+	}
+}
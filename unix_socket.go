@@ -0,0 +1,46 @@
+package logpeck
+
+import (
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+const (
+	unixSocketScheme = "unix://"
+	unixHostSuffix   = ".unix"
+)
+
+// SocketHost maps a "unix:///path" sender host to a host name usable in
+// urls, which the http client dials as the socket path. Other hosts are
+// returned as they are
+func SocketHost(host string) string {
+	if !strings.HasPrefix(host, unixSocketScheme) {
+		return host
+	}
+	return hex.EncodeToString([]byte(strings.TrimPrefix(host, unixSocketScheme))) + unixHostSuffix
+}
+
+// unixSocketPath returns the socket path of a host (with or without port)
+// made by SocketHost
+func unixSocketPath(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if !strings.HasSuffix(host, unixHostSuffix) {
+		return "", false
+	}
+	path, err := hex.DecodeString(strings.TrimSuffix(host, unixHostSuffix))
+	if err != nil {
+		return "", false
+	}
+	return string(path), true
+}
+
+// displayHost reverts SocketHost for stats
+func displayHost(host string) string {
+	if path, ok := unixSocketPath(host); ok {
+		return unixSocketScheme + path
+	}
+	return host
+}
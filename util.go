@@ -2,11 +2,13 @@ package logpeck
 
 import (
 	"errors"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"math/rand"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 func LogExecTime(start time.Time, prefix string) {
@@ -17,20 +19,12 @@ func LogExecTime(start time.Time, prefix string) {
 func GetHost() string {
 	host, err := os.Hostname()
 	if err != nil {
-		panic(err)
+		log.Errorf("Get host name error, err[%s]", err)
+		return "unknown"
 	}
 	return host
 }
 
-func SelectRandom(candidates []string) (string, error) {
-	candi_len := len(candidates)
-	if candi_len <= 0 {
-		return "", errors.New("none candidates")
-	}
-	ret := candidates[rand.Intn(candi_len)]
-	return ret, nil
-}
-
 func SplitString(content, delims string) []string {
 	if len(delims) == 0 {
 		delims = "\t\r\n "
@@ -45,3 +39,90 @@ func SplitString(content, delims string) []string {
 	}
 	return strings.FieldsFunc(content, splitFunc)
 }
+
+// TruncateString cuts content to at most maxLen bytes without splitting a
+// utf8 character
+func TruncateString(content string, maxLen int) string {
+	if maxLen <= 0 || len(content) <= maxLen {
+		return content
+	}
+	for maxLen > 0 && !utf8.RuneStart(content[maxLen]) {
+		maxLen--
+	}
+	return content[:maxLen]
+}
+
+// CoerceValue converts a string value to the go type of an ElasticSearch
+// field type, unknown types are returned unchanged
+func CoerceValue(value string, fieldType string) (interface{}, error) {
+	switch strings.ToLower(fieldType) {
+	case "long", "integer", "short", "byte":
+		return strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	case "float", "double", "half_float", "scaled_float":
+		return strconv.ParseFloat(strings.TrimSpace(value), 64)
+	case "boolean":
+		return strconv.ParseBool(strings.TrimSpace(value))
+	case "date":
+		if ts, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+			return ts, nil
+		}
+		for _, layout := range FormatTime {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t.UnixNano() / 1000000, nil
+			}
+		}
+		return value, nil
+	}
+	return value, nil
+}
+
+// eventTimeLayouts are tried in order when parsing an event time without
+// an explicit layout
+var eventTimeLayouts []string = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"02/Jan/2006:15:04:05 -0700",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RubyDate,
+	time.UnixDate,
+	time.ANSIC,
+}
+
+// ParseEventTime returns the epoch milliseconds of an event time. layout is
+// a go time layout, "epoch_s", "epoch_ms" or empty to guess from the value.
+// Times without a zone are local times
+func ParseEventTime(value interface{}, layout string) (int64, error) {
+	str := strings.TrimSpace(fmt.Sprintf("%v", value))
+	switch layout {
+	case "epoch_s", "epoch_ms":
+		ts, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return 0, err
+		}
+		if layout == "epoch_s" {
+			ts *= 1000
+		}
+		return int64(ts), nil
+	case "":
+		if ts, err := strconv.ParseFloat(str, 64); err == nil {
+			// seconds until year 2286, milliseconds after
+			if ts < 1e10 {
+				ts *= 1000
+			}
+			return int64(ts), nil
+		}
+		for _, l := range eventTimeLayouts {
+			if t, err := time.ParseInLocation(l, str, time.Local); err == nil {
+				return t.UnixNano() / 1000000, nil
+			}
+		}
+		return 0, errors.New("Unknown time format: " + str)
+	}
+	t, err := time.ParseInLocation(layout, str, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixNano() / 1000000, nil
+}
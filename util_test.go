@@ -3,6 +3,7 @@ package logpeck
 import (
 	"log"
 	"testing"
+	"time"
 )
 
 func TestGetHost(t *testing.T) {
@@ -35,3 +36,51 @@ func TestSplitString(t *testing.T) {
 		panic(len(arr))
 	}
 }
+
+func TestCoerceValue(t *testing.T) {
+	if v, err := CoerceValue("12", "long"); err != nil || v.(int64) != 12 {
+		panic(v)
+	}
+	if v, err := CoerceValue("1.5", "float"); err != nil || v.(float64) != 1.5 {
+		panic(v)
+	}
+	if v, err := CoerceValue("true", "boolean"); err != nil || v.(bool) != true {
+		panic(v)
+	}
+	if v, err := CoerceValue("2017-01-02T15:04:05Z", "date"); err != nil || v.(int64) != 1483369445000 {
+		panic(v)
+	}
+	if v, err := CoerceValue("abc", "keyword"); err != nil || v.(string) != "abc" {
+		panic(v)
+	}
+	if _, err := CoerceValue("abc", "long"); err == nil {
+		panic("abc is not a long")
+	}
+}
+
+func TestParseEventTime(*testing.T) {
+	utc := time.Date(2018, 3, 4, 5, 6, 7, 800000000, time.UTC)
+	local := time.Date(2018, 3, 4, 5, 6, 7, 0, time.Local)
+	cases := []struct {
+		value  interface{}
+		layout string
+		millis int64
+	}{
+		{"1520139967", "", utc.Unix() * 1000},
+		{"1520139967800", "", utc.UnixNano() / 1000000},
+		{int64(1520139967), "epoch_s", utc.Unix() * 1000},
+		{"2018-03-04T05:06:07.8Z", "", utc.UnixNano() / 1000000},
+		{"04/Mar/2018:05:06:07 +0000", "", utc.Unix() * 1000},
+		{"2018-03-04 05:06:07", "", local.Unix() * 1000},
+		{"20180304 050607", "20060102 150405", local.Unix() * 1000},
+	}
+	for _, c := range cases {
+		millis, err := ParseEventTime(c.value, c.layout)
+		if err != nil || millis != c.millis {
+			log.Panicf("%v %s: %d %v", c.value, c.layout, millis, err)
+		}
+	}
+	if _, err := ParseEventTime("yesterday", ""); err == nil {
+		panic(err)
+	}
+}
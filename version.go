@@ -1,3 +1,147 @@
 package logpeck
 
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
 const VersionString string = "0.5.0"
+
+// GitCommit and BuildDate are set at build time, e.g.
+//
+//	go build -ldflags "-X github.com/opera/logpeck.GitCommit=$(git rev-parse HEAD) -X github.com/opera/logpeck.BuildDate=$(date -u +%FT%TZ)"
+var (
+	GitCommit string
+	BuildDate string
+)
+
+// releaseCheckInterval is how often the release feed is fetched
+var releaseCheckInterval = time.Hour
+
+// VersionInfo describes the running agent, LatestVersion and Outdated are
+// only set when a release feed is configured and was fetched
+type VersionInfo struct {
+	Version    string
+	GitCommit  string
+	BuildDate  string
+	GoVersion  string
+	Senders    []string
+	Extractors []string
+
+	LatestVersion string
+	Outdated      bool
+}
+
+var latestRelease struct {
+	mu      sync.Mutex
+	version string
+}
+
+func GetVersionInfo() VersionInfo {
+	latestRelease.mu.Lock()
+	latest := latestRelease.version
+	latestRelease.mu.Unlock()
+	return VersionInfo{
+		Version:   VersionString,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Senders: []string{SenderTypeES, SenderTypeInfluxDb, SenderTypeKafka, SenderTypePrometheus,
+			SenderTypeDatadog, SenderTypeOtlp, SenderTypeZabbix, SenderTypeSyslog, SenderTypeGelf,
+			SenderTypeFluentd, SenderTypeLumberjack, SenderTypeEmail, SenderTypeChat},
+		Extractors:    []string{ExTypeText, ExTypeJson, ExTypeLua, ExTypeLogrus},
+		LatestVersion: latest,
+		Outdated:      latest != "" && CompareVersion(VersionString, latest) < 0,
+	}
+}
+
+// CompareVersion compares dotted versions like "0.5.0" or "v0.6", missing
+// parts are 0 and a leading "v" is ignored
+func CompareVersion(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// FetchLatestRelease reads the latest version from feed, a json object with
+// "Version" or "tag_name" (as GitHub latest release API)
+func FetchLatestRelease(ctx context.Context, client *http.Client, feed string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", feed, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Release feed status %d", resp.StatusCode)
+	}
+	var release struct {
+		Version string
+		TagName string `json:"tag_name"`
+	}
+	if err := json.Unmarshal(raw, &release); err != nil {
+		return "", err
+	}
+	if release.Version == "" {
+		release.Version = release.TagName
+	}
+	if release.Version == "" {
+		return "", fmt.Errorf("Release feed has no version: %s", raw)
+	}
+	return release.Version, nil
+}
+
+// RunReleaseCheck fetches feed periodically until ctx is done, the latest
+// version is reported by GetVersionInfo
+func RunReleaseCheck(ctx context.Context, feed string) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for {
+		version, err := FetchLatestRelease(ctx, client, feed)
+		if err != nil {
+			log.Infof("[Version] Fetch release feed %s error, err[%s]", feed, err)
+		} else {
+			latestRelease.mu.Lock()
+			latestRelease.version = version
+			latestRelease.mu.Unlock()
+			if CompareVersion(VersionString, version) < 0 {
+				log.Warnf("[Version] LogPeck %s is outdated, latest release is %s", VersionString, version)
+			}
+		}
+		select {
+		case <-time.After(releaseCheckInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
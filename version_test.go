@@ -0,0 +1,45 @@
+package logpeck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompareVersion(*testing.T) {
+	cases := []struct {
+		a, b string
+		res  int
+	}{
+		{"0.5.0", "0.5.0", 0},
+		{"0.5.0", "v0.5", 0},
+		{"0.5.0", "0.10.0", -1},
+		{"1.0", "0.9.9", 1},
+	}
+	for _, c := range cases {
+		if res := CompareVersion(c.a, c.b); res != c.res {
+			panic(c)
+		}
+	}
+}
+
+func TestFetchLatestRelease(*testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tag_name":"v9.0.0","name":"logpeck 9"}`))
+	}))
+	defer server.Close()
+	version, err := FetchLatestRelease(context.Background(), server.Client(), server.URL)
+	if err != nil {
+		panic(err)
+	}
+	if version != "v9.0.0" {
+		panic(version)
+	}
+
+	latestRelease.version = version
+	defer func() { latestRelease.version = "" }()
+	if info := GetVersionInfo(); !info.Outdated || info.LatestVersion != "v9.0.0" {
+		panic(info)
+	}
+}
@@ -0,0 +1,456 @@
+package logpeck
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// YAMLToJSON converts a YAML document to JSON. It supports the subset of
+// YAML used by hand-written configs: block mappings and sequences, plain,
+// quoted and block ("|", ">") scalars, flow sequences and mappings, and
+// comments. Anchors, tags and multiple documents are not supported
+func YAMLToJSON(data []byte) ([]byte, error) {
+	p := &yamlParser{}
+	for _, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		if strings.TrimSpace(line) == "---" && len(p.lines) == 0 {
+			continue
+		}
+		if strings.TrimSpace(line) == "..." {
+			break
+		}
+		if strings.HasPrefix(strings.TrimLeft(line, " "), "\t") {
+			return nil, fmt.Errorf("yaml line %d: tabs are not allowed in indentation", len(p.lines)+1)
+		}
+		p.lines = append(p.lines, yamlLine{
+			raw:    line,
+			indent: len(line) - len(strings.TrimLeft(line, " ")),
+			text:   strings.TrimSpace(yamlStripComment(line)),
+		})
+	}
+	p.skip()
+	var value interface{}
+	if p.i < len(p.lines) {
+		var err error
+		value, err = p.node(p.lines[p.i].indent)
+		if err != nil {
+			return nil, err
+		}
+		if p.skip(); p.i < len(p.lines) {
+			return nil, p.errorf("unexpected indentation")
+		}
+	}
+	return json.Marshal(value)
+}
+
+type yamlLine struct {
+	raw    string
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("yaml line %d: %s", p.i+1, fmt.Sprintf(format, args...))
+}
+
+// skip moves to the next line with content
+func (p *yamlParser) skip() {
+	for p.i < len(p.lines) && p.lines[p.i].text == "" {
+		p.i++
+	}
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// node parses the block node starting at the current line
+func (p *yamlParser) node(indent int) (interface{}, error) {
+	line := p.lines[p.i]
+	if isYAMLItem(line.text) {
+		return p.sequence(indent)
+	}
+	if _, _, ok := yamlSplitKey(line.text); ok {
+		return p.mapping(indent)
+	}
+	p.i++
+	return yamlInline(line.text)
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for p.skip(); p.i < len(p.lines); p.skip() {
+		line := p.lines[p.i]
+		if line.indent < indent || (line.indent == indent && !isYAMLItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		if rest == "" {
+			p.i++
+			item, err := p.child(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		// the content after "- " is a node indented to its column
+		column := indent + strings.Index(line.raw[indent+1:], rest) + 1
+		p.lines[p.i] = yamlLine{raw: line.raw, indent: column, text: rest}
+		var item interface{}
+		var err error
+		if yamlBlockScalar(rest) {
+			item, err = p.blockScalar(indent, rest)
+		} else {
+			item, err = p.node(column)
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.skip(); p.i < len(p.lines); p.skip() {
+		line := p.lines[p.i]
+		if line.indent < indent || (line.indent == indent && isYAMLItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		key, value, ok := yamlSplitKey(line.text)
+		if !ok {
+			return nil, p.errorf("expected key: value, got %q", line.text)
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		var v interface{}
+		var err error
+		switch {
+		case value == "":
+			p.i++
+			v, err = p.child(indent, true)
+		case yamlBlockScalar(value):
+			v, err = p.blockScalar(indent, value)
+		default:
+			if v, err = yamlInline(value); err != nil {
+				return nil, p.errorf("%s: %s", key, err)
+			}
+			p.i++
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// child parses the node under a key or item without inline value, a key
+// may have its sequence at its own indentation
+func (p *yamlParser) child(indent int, key bool) (interface{}, error) {
+	p.skip()
+	if p.i >= len(p.lines) {
+		return nil, nil
+	}
+	line := p.lines[p.i]
+	if line.indent > indent || (key && line.indent == indent && isYAMLItem(line.text)) {
+		return p.node(line.indent)
+	}
+	return nil, nil
+}
+
+func yamlBlockScalar(value string) bool {
+	return value != "" && (value[0] == '|' || value[0] == '>') && strings.Trim(value[1:], "+-0123456789") == ""
+}
+
+// blockScalar parses a literal ("|") or folded (">") scalar whose lines
+// are indented more than indent
+func (p *yamlParser) blockScalar(indent int, header string) (interface{}, error) {
+	p.i++
+	var lines []string
+	blockIndent := -1
+	for ; p.i < len(p.lines); p.i++ {
+		line := p.lines[p.i]
+		if strings.TrimSpace(line.raw) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if line.indent <= indent {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = line.indent
+		}
+		if line.indent < blockIndent {
+			return nil, p.errorf("block scalar less indented than its first line")
+		}
+		lines = append(lines, line.raw[blockIndent:])
+	}
+	// trailing empty lines belong to the chomping
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+	var text string
+	if header[0] == '|' {
+		text = strings.Join(lines, "\n")
+	} else {
+		for i, line := range lines {
+			switch {
+			case i == 0:
+			case line == "" || lines[i-1] == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(lines[i-1], " "):
+				text += "\n"
+			default:
+				text += " "
+			}
+			text += line
+		}
+		text = strings.ReplaceAll(text, "\n\n", "\n")
+	}
+	switch {
+	case strings.Contains(header, "-"):
+	case strings.Contains(header, "+"):
+		text += strings.Repeat("\n", trailing+1)
+	case len(lines) > 0:
+		text += "\n"
+	}
+	return text, nil
+}
+
+// yamlStripComment removes a "#" comment outside of quotes
+func yamlStripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" \t[{,:-", rune(line[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// yamlSplitKey splits "key: value" at the first ": " outside of quotes and
+// flow collections
+func yamlSplitKey(text string) (string, string, bool) {
+	if text == "" || text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+	start, end := 0, -1
+	if text[0] == '"' || text[0] == '\'' {
+		_, n, err := yamlQuoted(text)
+		if err != nil {
+			return "", "", false
+		}
+		start, end = n, n
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			key := strings.TrimSpace(text[:i])
+			if end >= 0 {
+				if strings.TrimSpace(text[end:i]) != "" {
+					return "", "", false
+				}
+				key, _, _ = yamlQuoted(text)
+			}
+			return key, strings.TrimSpace(text[i+1:]), true
+		}
+		if end >= 0 && text[i] != ' ' {
+			return "", "", false
+		}
+	}
+	return "", "", false
+}
+
+// yamlQuoted parses the quoted scalar at the start of text and returns it
+// with the length it takes in text
+func yamlQuoted(text string) (string, int, error) {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		if quote == '"' && text[i] == '\\' {
+			i++
+			continue
+		}
+		if text[i] != quote {
+			continue
+		}
+		if quote == '\'' {
+			if i+1 < len(text) && text[i+1] == '\'' {
+				i++
+				continue
+			}
+			return strings.ReplaceAll(text[1:i], "''", "'"), i + 1, nil
+		}
+		s, err := strconv.Unquote(strings.ReplaceAll(text[:i+1], `\/`, "/"))
+		if err != nil {
+			return "", 0, fmt.Errorf("bad quoted string %s", text[:i+1])
+		}
+		return s, i + 1, nil
+	}
+	return "", 0, fmt.Errorf("unterminated string %s", text)
+}
+
+// yamlInline parses a value on one line, a scalar or a flow collection
+func yamlInline(text string) (interface{}, error) {
+	f := &yamlFlow{text: text}
+	v, err := f.value(false)
+	if err != nil {
+		return nil, err
+	}
+	if f.space(); f.i < len(f.text) {
+		return nil, fmt.Errorf("unexpected %q", f.text[f.i:])
+	}
+	return v, nil
+}
+
+type yamlFlow struct {
+	text string
+	i    int
+}
+
+func (f *yamlFlow) space() {
+	for f.i < len(f.text) && f.text[f.i] == ' ' {
+		f.i++
+	}
+}
+
+func (f *yamlFlow) value(inFlow bool) (interface{}, error) {
+	f.space()
+	if f.i >= len(f.text) {
+		return nil, nil
+	}
+	switch f.text[f.i] {
+	case '[':
+		return f.sequence()
+	case '{':
+		return f.mapping()
+	case '"', '\'':
+		s, n, err := yamlQuoted(f.text[f.i:])
+		f.i += n
+		return s, err
+	}
+	start := f.i
+	if inFlow {
+		for f.i < len(f.text) && !strings.ContainsRune(",]}", rune(f.text[f.i])) &&
+			!(f.text[f.i] == ':' && (f.i+1 == len(f.text) || f.text[f.i+1] == ' ')) {
+			f.i++
+		}
+	} else {
+		f.i = len(f.text)
+	}
+	return yamlScalar(strings.TrimSpace(f.text[start:f.i])), nil
+}
+
+func (f *yamlFlow) sequence() (interface{}, error) {
+	f.i++
+	items := []interface{}{}
+	for {
+		if f.space(); f.i < len(f.text) && f.text[f.i] == ']' {
+			f.i++
+			return items, nil
+		}
+		item, err := f.value(true)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if err := f.next(']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *yamlFlow) mapping() (interface{}, error) {
+	f.i++
+	m := map[string]interface{}{}
+	for {
+		if f.space(); f.i < len(f.text) && f.text[f.i] == '}' {
+			f.i++
+			return m, nil
+		}
+		key, err := f.value(true)
+		if err != nil {
+			return nil, err
+		}
+		if f.space(); f.i >= len(f.text) || f.text[f.i] != ':' {
+			return nil, fmt.Errorf("expected ':' in %s", f.text)
+		}
+		f.i++
+		value, err := f.value(true)
+		if err != nil {
+			return nil, err
+		}
+		m[fmt.Sprint(key)] = value
+		if err := f.next('}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// next consumes the separator after a flow item, leaving end to the caller
+func (f *yamlFlow) next(end byte) error {
+	f.space()
+	if f.i < len(f.text) && f.text[f.i] == ',' {
+		f.i++
+		return nil
+	}
+	if f.i < len(f.text) && f.text[f.i] == end {
+		return nil
+	}
+	return fmt.Errorf("expected ',' or '%c' in %s", end, f.text)
+}
+
+// yamlScalar resolves a plain scalar to null, a bool, a number or a string
+func yamlScalar(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if c := s[0]; (c >= '0' && c <= '9') || c == '-' || c == '+' || c == '.' {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !strings.ContainsAny(s, "xXpP_") {
+			return f
+		}
+	}
+	return s
+}
+
+// isJSONObject reports whether data looks like a JSON object rather than
+// YAML
+func isJSONObject(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && data[0] == '{'
+}
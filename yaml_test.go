@@ -0,0 +1,103 @@
+package logpeck
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestYAMLToJSON(*testing.T) {
+	raw, err := YAMLToJSON([]byte(`---
+# access log of nginx
+Name: AccessLog
+LogPath: "/var/log/nginx/access.log"   # quoted
+Keywords: 'GET|^health'
+Labels: {team: web, env: "prod"}
+Extractor:
+  Name: text
+  Config:
+    Delimiters: " "
+    Fields:
+    - Name: method
+      Value: $1
+    - {Name: code, Value: "$3"}
+Sender:
+  Name: elasticsearch
+  Config:
+    Hosts: [127.0.0.1:9200, "127.0.0.2:9200"]
+    Index: nginx
+Shard:
+  Workers: 4
+  Key: '^(\S+) it''s'
+Script: |
+  function extract(s)
+    return {}
+  end
+Folded: >-
+  one
+  two
+
+  three
+Empty:
+Numbers: [1, -2, 1.5, 007, 1e3, true, ~, 0x10]
+`))
+	if err != nil {
+		panic(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		panic(err)
+	}
+	var want map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"Name": "AccessLog",
+		"LogPath": "/var/log/nginx/access.log",
+		"Keywords": "GET|^health",
+		"Labels": {"team": "web", "env": "prod"},
+		"Extractor": {"Name": "text", "Config": {"Delimiters": " ", "Fields": [
+			{"Name": "method", "Value": "$1"}, {"Name": "code", "Value": "$3"}]}},
+		"Sender": {"Name": "elasticsearch", "Config": {"Hosts": ["127.0.0.1:9200", "127.0.0.2:9200"], "Index": "nginx"}},
+		"Shard": {"Workers": 4, "Key": "^(\\S+) it's"},
+		"Script": "function extract(s)\n  return {}\nend\n",
+		"Folded": "one two\nthree",
+		"Empty": null,
+		"Numbers": [1, -2, 1.5, 7, 1000, true, null, "0x10"]
+	}`), &want)
+	if !reflect.DeepEqual(got, want) {
+		panic(string(raw))
+	}
+
+	for _, bad := range []string{
+		"a: 1\n  b: 2",
+		"a: 1\na: 2",
+		"a: [1, 2",
+		"a: \"open",
+		"a:\n\t- 1",
+	} {
+		if _, err := YAMLToJSON([]byte(bad)); err == nil {
+			panic(bad)
+		}
+	}
+}
+
+func TestPeckTaskConfigYAML(*testing.T) {
+	config := &PeckTaskConfig{}
+	err := config.Unmarshal([]byte(`
+Name: YAMLLog
+LogPath: ./test.log
+Keywords: error|warn
+Extractor:
+  Name: text
+  Config:
+    Fields:
+    - {Name: level, Value: $1}
+Sender:
+  Name: prometheus
+`))
+	if err != nil {
+		panic(err)
+	}
+	if config.Name != "YAMLLog" || config.Keywords != "error|warn" || config.Extractor.Name != "text" || config.Sender.Name != "prometheus" {
+		panic(config)
+	}
+}